@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/cfg"
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/boltdb/bolt"
+	"github.com/spf13/cobra"
+)
+
+// ErrMissingArchive informs a user that the backup to restore must be specified
+var ErrMissingArchive = errors.New("To execute 'ponzu restore', " +
+	"you must specify the snapshot directory or archive to restore.")
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <archive>",
+	Short: "restores the database, uploads and search indexes from a backup.",
+	Long: `Restores the database, uploads and search indexes of a Ponzu project from
+a snapshot directory written by scheduled backups, or an archive of one
+downloaded from the Admin. The snapshot is verified against the checksums
+in its manifest first, and is refused if it was made by an incompatible
+version of Ponzu. Must be called from within a Ponzu project directory,
+after it is built, while its server isn't running.`,
+	Example: `$ ponzu restore backups/20240502-030000
+(or)
+$ ponzu restore ~/Downloads/ponzu-20240502-030000.tar.gz`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return ErrMissingArchive
+		}
+
+		src, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+
+		// the project's server is built with its content types, which are
+		// needed to rebuild the sorted content and indexes
+		name := buildOutputName()
+		buildPathName := strings.Join([]string{".", name}, string(filepath.Separator))
+		restore := exec.Command(buildPathName, "restore-data", src)
+		restore.Stderr = os.Stderr
+		restore.Stdout = os.Stdout
+
+		return restore.Run()
+	},
+}
+
+var restoreDataCmd = &cobra.Command{
+	Use:    "restore-data <archive>",
+	Short:  "restore a backup (restore-data is wrapped by the restore command)",
+	Hidden: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return ErrMissingArchive
+		}
+
+		// the database is locked by a running server, which db.Init waits for
+		systemDb := filepath.Join(cfg.DataDir(), "system.db")
+		store, err := bolt.Open(systemDb, 0666, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return fmt.Errorf("Failed to open %s, stop the server or restore from the Admin: %v", systemDb, err)
+		}
+		store.Close()
+
+		db.Init()
+		defer db.Close()
+
+		fmt.Println("Restoring", args[0], "...")
+		m, err := db.Restore(context.Background(), args[0])
+		if err != nil {
+			return err
+		}
+
+		err = db.Audit(db.AuditEntry{
+			Actor:  "cli",
+			Action: "backup.restore",
+			Target: "backup:" + m.Name,
+			Detail: fmt.Sprintf("Ponzu v%s, %d uploads", m.Version, len(m.Uploads)),
+		})
+		if err != nil {
+			fmt.Println("Failed to add audit entry:", err)
+		}
+
+		fmt.Printf("Restored snapshot %s, made %s with Ponzu v%s.\n", m.Name,
+			time.Unix(0, m.Created*int64(time.Millisecond)).Format(time.RFC1123), m.Version)
+
+		return nil
+	},
+}
+
+func init() {
+	RegisterCmdlineCommand(restoreCmd)
+	RegisterCmdlineCommand(restoreDataCmd)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	migrateFrom   string
+	migrateTo     string
+	migrateDryRun bool
+)
+
+var migrateUploadsCmd = &cobra.Command{
+	Use:   "migrate-uploads",
+	Short: "copies uploaded files from one upload storage to another.",
+	Long: `Copies the uploaded files of a Ponzu project from one upload storage to
+another, i.e. from the local upload directory to an S3-compatible object
+store, keeping their paths so the URLs stored in content still work. Files
+which are already stored at the destination with the same size are skipped,
+so an interrupted migration can be run again. The storage is configured by
+the same environment variables as the server. Must be called from within a
+Ponzu project directory.`,
+	Example: `$ PONZU_S3_BUCKET=my-uploads ponzu migrate-uploads --from=local --to=s3
+(or)
+$ ponzu migrate-uploads --from=s3 --to=local --dry-run`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateFrom == migrateTo {
+			return errors.New("The storage to migrate uploads from and to must differ.")
+		}
+
+		src, err := storage.New(migrateFrom)
+		if err != nil {
+			return err
+		}
+
+		dst, err := storage.New(migrateTo)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		if migrateDryRun {
+			dst = dryRun{dst}
+		}
+
+		var copied, skipped int
+		err = storage.Copy(ctx, dst, src, func(obj *storage.Object, ok bool) {
+			if !ok {
+				skipped++
+				return
+			}
+
+			copied++
+			fmt.Println(obj.Name)
+		})
+		if err != nil {
+			return err
+		}
+
+		if migrateDryRun {
+			fmt.Printf("Would copy %d uploads from %s to %s, %d already there.\n", copied, migrateFrom, migrateTo, skipped)
+			return nil
+		}
+
+		fmt.Printf("Copied %d uploads from %s to %s, %d already there.\n", copied, migrateFrom, migrateTo, skipped)
+		return nil
+	},
+}
+
+// dryRun is storage which doesn't store files put in it
+type dryRun struct {
+	storage.Storage
+}
+
+func (d dryRun) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	return nil
+}
+
+func init() {
+	migrateUploadsCmd.Flags().StringVar(&migrateFrom, "from", "local", "storage to copy uploads from, local or s3")
+	migrateUploadsCmd.Flags().StringVar(&migrateTo, "to", "s3", "storage to copy uploads to, local or s3")
+	migrateUploadsCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "list the uploads which would be copied without copying them")
+
+	RegisterCmdlineCommand(migrateUploadsCmd)
+}
@@ -110,6 +110,21 @@ func Form(post Editable, fields ...Field) ([]byte, error) {
 </div>
 	`
 
+	// optional times to publish and expire the content, copied into the hidden
+	// publish_at and expire_at inputs added with the default fields
+	publishTime += `
+<div class="row content-only schedule __ponzu">
+	<div class="input-field col s12">
+		<label class="active">Publish At (optional)</label>
+		<input value="" class="schedule-date __ponzu" data-field="publish_at" type="datetime-local" />
+	</div>
+	<div class="input-field col s12">
+		<label class="active">Expire At (optional)</label>
+		<input value="" class="schedule-date __ponzu" data-field="expire_at" type="datetime-local" />
+	</div>
+</div>
+	`
+
 	_, err = editor.ViewBuf.WriteString(publishTime)
 	if err != nil {
 		log.Println("Error writing HTML string to editor Form buffer")
@@ -124,8 +139,16 @@ func Form(post Editable, fields ...Field) ([]byte, error) {
 	submit := `
 <div class="input-field post-controls">
 	<button class="right waves-effect waves-light btn green save-post" type="submit">Save</button>
+	<button class="right waves-effect waves-light btn grey save-draft" type="submit">Save Draft</button>
 	<button class="right waves-effect waves-light btn red delete-post" type="submit">Delete</button>
 </div>
+<div class="row draft post-controls">
+	<div class="col s12 input-field">
+		<button class="right waves-effect waves-light btn blue publish-draft" type="submit">Publish</button>
+		<button class="right waves-effect waves-light btn grey darken-2 preview-post" type="submit">Preview</button>
+	</div>
+	<label class="draft-details right-align col s12">Preview creates a link to read the saved version of this content from the API before it is published.</label>
+</div>
 `
 	_, ok := post.(Mergeable)
 	if ok {
@@ -148,6 +171,10 @@ func Form(post Editable, fields ...Field) ([]byte, error) {
 			save = form.find('button.save-post'),
 			del = form.find('button.delete-post'),
 			external = form.find('.post-controls.external'),
+			saveDraft = form.find('button.save-draft'),
+			draft = form.find('.post-controls.draft'),
+			publish = draft.find('button.publish-draft'),
+			preview = draft.find('button.preview-post'),
 			id = form.find('input[name=id]'),
 			timestamp = $('.__ponzu.content-only'),
 			slug = $('input[name=slug]');
@@ -163,6 +190,25 @@ func Form(post Editable, fields ...Field) ([]byte, error) {
 			external.hide();
 		} 
 
+		// drafts are only saved from the content editor, and not for pending
+		// content items
+		if (form.attr('action') !== '/admin/edit' || getParam('status') === 'pending') {
+			saveDraft.hide();
+		}
+
+		// publish only drafts, and preview only saved drafts or scheduled items
+		if (getParam('status') !== 'draft') {
+			publish.hide();
+		}
+
+		if (id.val() === '-1' || (getParam('status') !== 'draft' && getParam('status') !== 'scheduled')) {
+			preview.hide();
+		}
+
+		if (publish.is(':hidden') && preview.is(':hidden')) {
+			draft.hide();
+		}
+
 		// no timestamp, slug visible on addons
 		if (form.attr('action') === '/admin/addon') {
 			timestamp.hide();
@@ -172,21 +218,46 @@ func Form(post Editable, fields ...Field) ([]byte, error) {
 		save.on('click', function(e) {
 			e.preventDefault();
 
-			if (getParam('status') === 'pending') {
+			var status = getParam('status');
+			if (status === 'pending' || status === 'draft') {
 				var action = form.attr('action');
-				form.attr('action', action + '?status=pending')
+				form.attr('action', action + '?status=' + status)
 			}
 
 			form.submit();
 		});
 
+		saveDraft.on('click', function(e) {
+			e.preventDefault();
+			var action = form.attr('action');
+			form.attr('action', action + '?status=draft');
+
+			form.submit();
+		});
+
+		publish.on('click', function(e) {
+			e.preventDefault();
+			form.attr('action', '/admin/edit/publish');
+
+			if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to publish this draft?\nAny unsaved changes will be lost.")) {
+				form.submit();
+			}
+		});
+
+		preview.on('click', function(e) {
+			e.preventDefault();
+			form.attr('action', '/admin/edit/preview');
+
+			form.submit();
+		});
+
 		del.on('click', function(e) {
 			e.preventDefault();
 			var action = form.attr('action');
 			action = action + '/delete';
 			form.attr('action', action);
 			
-			if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nThis cannot be undone.")) {
+			if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nPublic posts are moved to the Trash.")) {
 				form.submit();
 			}
 		});
@@ -254,6 +325,18 @@ func addPostDefaultFieldsToEditorView(p Editable, e *Editor) error {
 				"class": "updated __ponzu",
 			}),
 		},
+		{
+			View: Timestamp("PublishAt", p, map[string]string{
+				"type":  "hidden",
+				"class": "publish_at __ponzu",
+			}),
+		},
+		{
+			View: Timestamp("ExpireAt", p, map[string]string{
+				"type":  "hidden",
+				"class": "expire_at __ponzu",
+			}),
+		},
 	}
 
 	for _, f := range defaults {
@@ -289,6 +289,9 @@ func Richtext(fieldName string, p interface{}, attrs map[string]string) []byte {
 						},
 						error: function(xhr, status, err) {
 							console.log(status, err);
+							if (xhr.responseText) {
+								alert(xhr.responseText);
+							}
 						}
 					})
 
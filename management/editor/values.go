@@ -25,7 +25,8 @@ func TagNameFromStructField(name string, post interface{}) string {
 		panic("Couldn't get json struct tag for: " + name + ". Struct fields for content types must have 'json' tags.")
 	}
 
-	return tag
+	// drop options such as omitempty from the tag
+	return strings.Split(tag, ",")[0]
 }
 
 // TagNameFromStructFieldMulti calls TagNameFromStructField and formats is for
@@ -20,6 +20,7 @@ const managerHTML = `
 		<input type="hidden" name="id" value="{{.ID}}"/>
 		<input type="hidden" name="type" value="{{.Kind}}"/>
 		<input type="hidden" name="slug" value="{{.Slug}}"/>
+		{{ if .ETag }}<input type="hidden" name="__etag" value="{{.ETag}}"/>{{ end }}
 		{{ .Editor }}
 	</form>
 	<script>
@@ -90,6 +91,23 @@ const managerHTML = `
 			}
 
 			setDefaultTimeAndDate(getFields(), time);
+
+			// show the optional publish and expire times from their hidden
+			// inputs in local time, and copy any changes back to them
+			$('input.__ponzu.schedule-date').each(function() {
+				var input = $(this),
+					ms = $('input.__ponzu.' + input.data('field'));
+
+				if (ms.val() !== "") {
+					var date = new Date(parseInt(ms.val()));
+					date.setMinutes(date.getMinutes() - date.getTimezoneOffset());
+					input.val(date.toISOString().slice(0, 16));
+				}
+
+				input.on('change', function() {
+					ms.val(input.val() === "" ? "" : (new Date(input.val())).getTime());
+				});
+			});
 			
 			var timeUpdated = false;
 			$('form').on('submit', function(e) {
@@ -119,11 +137,19 @@ type manager struct {
 	UUID   uuid.UUID
 	Kind   string
 	Slug   string
+	ETag   string
 	Editor template.HTML
 }
 
 // Manage ...
 func Manage(e editor.Editable, typeName string) ([]byte, error) {
+	return ManageWithETag(e, typeName, "")
+}
+
+// ManageWithETag is the same as Manage, but submits the ETag of the content
+// being edited with the form, so that a save is rejected if the content has
+// been changed by someone else in the meantime
+func ManageWithETag(e editor.Editable, typeName, etag string) ([]byte, error) {
 	v, err := e.MarshalEditor()
 	if err != nil {
 		return nil, fmt.Errorf("Couldn't marshal editor for content %s. %s", typeName, err.Error())
@@ -144,6 +170,7 @@ func Manage(e editor.Editable, typeName string) ([]byte, error) {
 		UUID:   i.UniqueID(),
 		Kind:   typeName,
 		Slug:   s.ItemSlug(),
+		ETag:   etag,
 		Editor: template.HTML(v),
 	}
 
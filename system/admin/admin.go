@@ -7,8 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"sort"
 
+	"github.com/ponzu-cms/ponzu/system/admin/upload"
 	"github.com/ponzu-cms/ponzu/system/admin/user"
 	"github.com/ponzu-cms/ponzu/system/api/analytics"
 	"github.com/ponzu-cms/ponzu/system/db"
@@ -64,10 +67,20 @@ var mainAdminHTML = `
 
                     <div class="card-title">System</div>                                
                     <div class="row collection-item">
+                        {{ if .IsAdmin }}
                         <li><a class="col s12" href="/admin/configure"><i class="tiny left material-icons">settings</i>Configuration</a></li>
+                        {{ end }}
                         <li><a class="col s12" href="/admin/configure/users"><i class="tiny left material-icons">supervisor_account</i>Admin Users</a></li>
+                        {{ if .IsAdmin }}
+                        <li><a class="col s12" href="/admin/configure/apikeys"><i class="tiny left material-icons">vpn_key</i>API Keys</a></li>
+                        <li><a class="col s12" href="/admin/configure/webhooks"><i class="tiny left material-icons">call_made</i>Webhooks</a></li>
+                        <li><a class="col s12" href="/admin/configure/audit"><i class="tiny left material-icons">history</i>Audit Log</a></li>
+                        <li><a class="col s12" href="/admin/configure/backups"><i class="tiny left material-icons">backup</i>Backups</a></li>
+                        {{ end }}
                         <li><a class="col s12" href="/admin/uploads"><i class="tiny left material-icons">swap_vert</i>Uploads</a></li>
+                        {{ if .IsAdmin }}
                         <li><a class="col s12" href="/admin/addons"><i class="tiny left material-icons">settings_input_svideo</i>Addons</a></li>
+                        {{ end }}
                     </div>
                 </ul>
                 </div>
@@ -91,11 +104,18 @@ var endAdminHTML = `
 type admin struct {
 	Logo    string
 	Types   map[string]func() interface{}
+	IsAdmin bool
 	Subview template.HTML
 }
 
 // Admin ...
 func Admin(view []byte) (_ []byte, err error) {
+	return AdminFor(nil, view)
+}
+
+// AdminFor is the same as Admin, but only lists the content types and system
+// pages the user making the request can access in the navigation
+func AdminFor(req *http.Request, view []byte) (_ []byte, err error) {
 	cfg, err := db.Config("name")
 	if err != nil {
 		return
@@ -108,9 +128,23 @@ func Admin(view []byte) (_ []byte, err error) {
 	a := admin{
 		Logo:    string(cfg),
 		Types:   item.Types,
+		IsAdmin: true,
 		Subview: template.HTML(view),
 	}
 
+	// a request from no user that can be found sees no types or admin links
+	if req != nil {
+		if usr, err := user.Current(req); err != nil || !usr.IsAdmin() {
+			a.IsAdmin = false
+			a.Types = make(map[string]func() interface{})
+			for t, fn := range item.Types {
+				if err == nil && usr.Can(user.ActionView, t) {
+					a.Types[t] = fn
+				}
+			}
+		}
+	}
+
 	buf := &bytes.Buffer{}
 	html := startAdminHTML + mainAdminHTML + endAdminHTML
 	tmpl := template.Must(template.New("admin").Parse(html))
@@ -404,6 +438,10 @@ func UsersList(req *http.Request) ([]byte, error) {
             </div>
         </form>
 
+        <p class="row"><span class="col s9">Two-factor authentication is {{ if .User.TOTPSecret }}enabled{{ else }}disabled{{ end }}. <a href="/admin/configure/users/edit">Manage two-factor authentication</a></span></p>
+        <p class="row"><span class="col s9"><a href="/admin/configure/users/sessions">Manage your sessions</a></span></p>
+
+        {{ if .IsAdmin }}
         <div class="card-title">Add a new user:</div>        
         <form class="row" enctype="multipart/form-data" action="/admin/configure/users" method="post">
             <div class="col s9">
@@ -416,24 +454,52 @@ func UsersList(req *http.Request) ([]byte, error) {
                 <input type="password" name="password"/>
             </div>
 
+            <div class="col s9">
+                <label class="active">Role</label>
+                <select class="browser-default" name="role">
+                    {{ range $.Roles }}<option value="{{ . }}">{{ . }}</option>{{ end }}
+                </select>
+            </div>
+
             <div class="col s9">            
                 <button class="btn waves-effect waves-light green right" type="submit">Add User</button>
             </div>   
         </form>        
 
-        <div class="card-title">Remove Admin Users</div>        
+        <div class="card-title">Manage Admin Users</div>        
+        <p class="row"><a class="col s9" href="/admin/configure/lockouts">View login lockouts</a></p>
         <ul class="users row">
-            {{ range .Users }}
+            {{ range $u := .Users }}
             <li class="col s9">
-                {{ .Email }}
+                {{ $u.Email }} <span class="grey-text">({{ role $u }})</span>
+                <a href="/admin/configure/users/sessions?email={{ $u.Email }}">Sessions</a>
                 <form enctype="multipart/form-data" class="delete-user __ponzu right" action="/admin/configure/users/delete" method="post">
                     <span>Delete</span>
-                    <input type="hidden" name="email" value="{{ .Email }}"/>
-                    <input type="hidden" name="id" value="{{ .ID }}"/>
+                    <input type="hidden" name="email" value="{{ $u.Email }}"/>
+                    <input type="hidden" name="id" value="{{ $u.ID }}"/>
+                </form>
+                <form enctype="multipart/form-data" class="user-role" action="/admin/configure/users/role" method="post">
+                    <input type="hidden" name="email" value="{{ $u.Email }}"/>
+                    <select class="browser-default" name="role">
+                        {{ range $.Roles }}<option value="{{ . }}"{{ if eq . (role $u) }} selected{{ end }}>{{ . }}</option>{{ end }}
+                    </select>
+                    <p>Limit access to the content types checked below, with only the actions checked for each. Leave all unchecked to use the role for every type.</p>
+                    <table class="permissions">
+                        {{ range $t := $.Types }}
+                        <tr>
+                            <td><input type="checkbox" id="type-{{ $u.ID }}-{{ $t }}" name="types" value="{{ $t }}"{{ if hasType $u $t }} checked{{ end }}/><label for="type-{{ $u.ID }}-{{ $t }}">{{ $t }}</label></td>
+                            {{ range $a := $.Actions }}
+                            <td><input type="checkbox" id="perm-{{ $u.ID }}-{{ $t }}-{{ $a }}" name="permissions.{{ $t }}" value="{{ $a }}"{{ if hasAction $u $t $a }} checked{{ end }}/><label for="perm-{{ $u.ID }}-{{ $t }}-{{ $a }}">{{ $a }}</label></td>
+                            {{ end }}
+                        </tr>
+                        {{ end }}
+                    </table>
+                    <button class="btn-flat waves-effect waves-light" type="submit">Save Role</button>
                 </form>
             </li>
             {{ end }}
         </ul>
+        {{ end }}
     </div>
     `
 	script := `
@@ -478,12 +544,45 @@ func UsersList(req *http.Request) ([]byte, error) {
 		}
 	}
 
+	var types []string
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	funcs := template.FuncMap{
+		"role": func(u user.User) string {
+			if u.Role == "" {
+				return user.RoleAdmin
+			}
+
+			return u.Role
+		},
+		"hasType": func(u user.User, t string) bool {
+			_, ok := u.Permissions[t]
+			return ok
+		},
+		"hasAction": func(u user.User, t, a string) bool {
+			for _, p := range u.Permissions[t] {
+				if p == a {
+					return true
+				}
+			}
+
+			return false
+		},
+	}
+
 	// make buffer to execute html into then pass buffer's bytes to Admin
 	buf := &bytes.Buffer{}
-	tmpl := template.Must(template.New("users").Parse(html + script))
+	tmpl := template.Must(template.New("users").Funcs(funcs).Parse(html + script))
 	data := map[string]interface{}{
-		"User":  usr,
-		"Users": usrs,
+		"User":    usr,
+		"Users":   usrs,
+		"IsAdmin": usr.IsAdmin(),
+		"Roles":   user.Roles,
+		"Actions": user.Actions,
+		"Types":   types,
 	}
 
 	err = tmpl.Execute(buf, data)
@@ -491,7 +590,7 @@ func UsersList(req *http.Request) ([]byte, error) {
 		return nil, err
 	}
 
-	return Admin(buf.Bytes())
+	return AdminFor(req, buf.Bytes())
 }
 
 var analyticsHTML = `
@@ -544,7 +643,7 @@ var analyticsHTML = `
 `
 
 // Dashboard returns the admin view with analytics dashboard
-func Dashboard() ([]byte, error) {
+func Dashboard(req *http.Request) ([]byte, error) {
 	buf := &bytes.Buffer{}
 	data, err := analytics.ChartData()
 	if err != nil {
@@ -556,7 +655,7 @@ func Dashboard() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Admin(buf.Bytes())
+	return AdminFor(req, buf.Bytes())
 }
 
 var err400HTML = []byte(`
@@ -575,6 +674,22 @@ func Error400() ([]byte, error) {
 	return Admin(err400HTML)
 }
 
+var err403HTML = []byte(`
+<div class="error-page e403 col s6">
+<div class="card">
+<div class="card-content">
+    <div class="card-title"><b>403</b> Error: Forbidden</div>
+    <blockquote>Sorry, your account is not permitted to do that.</blockquote>
+</div>
+</div>
+</div>
+`)
+
+// Error403 creates a subview for a 403 error page
+func Error403() ([]byte, error) {
+	return Admin(err403HTML)
+}
+
 var err404HTML = []byte(`
 <div class="error-page e404 col s6">
 <div class="card">
@@ -641,3 +756,16 @@ func ErrorMessage(title, message string) ([]byte, error) {
 	eHTML := fmt.Sprintf(errMessageHTML, title, message)
 	return Admin([]byte(eHTML))
 }
+
+// uploadRejected responds to a form with a file which failed validation,
+// describing which file and why
+func uploadRejected(res http.ResponseWriter, uerr *upload.Error) {
+	log.Println("Rejected file upload:", uerr)
+	res.WriteHeader(uerr.Status)
+	errView, err := ErrorMessage("File Upload Rejected", template.HTMLEscapeString(uerr.Error()))
+	if err != nil {
+		return
+	}
+
+	res.Write(errView)
+}
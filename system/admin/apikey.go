@@ -0,0 +1,267 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/api"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+var apiKeysHTML = `
+<div class="card api-keys">
+<div class="card-content">
+    <div class="card-title">API Keys</div>
+    <p>Clients send an API key to the content API in an <code>Authorization: Bearer &lt;key&gt;</code> header. Content types decide what each scope allows from their Hide, Create and Update methods.</p>
+    {{ if .Key }}
+    <div class="input-field">
+        <label class="active">New key for {{ .Name }}: copy it now, it won't be shown again</label>
+        <input type="text" readonly value="{{ .Key }}" onclick="this.select()"/>
+    </div>
+    {{ end }}
+    <ul class="keys row">
+    {{ range .Keys }}
+        <li class="col s12">
+            <strong>{{ .Name }}</strong> <code>{{ .Hint }}&hellip;</code>
+            <span class="post-detail">Created: {{ date .Created }}</span>
+            <span class="post-detail">Last used: {{ if .LastUsed }}{{ date .LastUsed }}{{ else }}never{{ end }}</span>
+            {{ if .Revoked }}
+            <span class="post-detail">Revoked: {{ date .Revoked }}</span>
+            {{ else }}
+            <form enctype="multipart/form-data" class="revoke-key __ponzu right" action="/admin/configure/apikeys/revoke" method="post">
+                <span>Revoke</span>
+                <input type="hidden" name="id" value="{{ .ID }}"/>
+            </form>
+            {{ end }}
+            <div class="grey-text">{{ join .Scopes ", " }}</div>
+        </li>
+    {{ else }}
+        <li class="col s12">No API keys have been created.</li>
+    {{ end }}
+    </ul>
+
+    <div class="card-title">Create an API key:</div>
+    <form class="row" enctype="multipart/form-data" action="/admin/configure/apikeys" method="post">
+        <div class="col s9">
+            <label class="active">Name</label>
+            <input type="text" name="name" required/>
+        </div>
+        <div class="col s9">
+            <table class="scopes">
+                {{ range $t := .Types }}
+                <tr>
+                    <td>{{ if eq $t "*" }}All types{{ else }}{{ $t }}{{ end }}</td>
+                    {{ range $a := $.Actions }}
+                    <td><input type="checkbox" id="scope-{{ $t }}-{{ $a }}" name="scopes" value="{{ $t }}:{{ $a }}"/><label for="scope-{{ $t }}-{{ $a }}">{{ $a }}</label></td>
+                    {{ end }}
+                </tr>
+                {{ end }}
+            </table>
+        </div>
+        <div class="col s9">
+            <button class="btn waves-effect waves-light green right" type="submit">Create Key</button>
+        </div>
+    </form>
+</div>
+</div>
+<script>
+    $(function() {
+        $('.revoke-key.__ponzu span').on('click', function(e) {
+            if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to revoke this key?\nClients using it will no longer be authenticated.")) {
+                $(e.target).parent().submit();
+            }
+        });
+    });
+</script>
+`
+
+// isScope reports whether s is the scope of an action on a registered type, or
+// on all types
+func isScope(s string) bool {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return false
+	}
+
+	t, action := s[:i], s[i+1:]
+	if _, ok := item.Types[t]; !ok && t != api.AllTypes {
+		return false
+	}
+
+	for _, a := range api.ScopeActions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+func apiKeysView(req *http.Request, name, key string) ([]byte, error) {
+	keys, err := db.APIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	types := []string{api.AllTypes}
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types[1:])
+
+	funcs := template.FuncMap{
+		"date": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04 PM")
+		},
+		"join": strings.Join,
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("apikeys").Funcs(funcs).Parse(apiKeysHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Keys":    keys,
+		"Name":    name,
+		"Key":     key,
+		"Types":   types,
+		"Actions": api.ScopeActions,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return AdminFor(req, buf.Bytes())
+}
+
+func apiKeysHandler(res http.ResponseWriter, req *http.Request) {
+	var name, key string
+
+	switch req.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		name = strings.TrimSpace(req.PostFormValue("name"))
+		scopes := req.PostForm["scopes"]
+		valid := name != "" && len(scopes) > 0
+		for _, s := range scopes {
+			valid = valid && isScope(s)
+		}
+
+		if !valid {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// the key is only shown in this response, so it must not be cached
+		res.Header().Set("Cache-Control", "no-store")
+		key, _, err = db.NewAPIKey(name, scopes)
+		if err != nil {
+			log.Println("Error creating API key:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		auditDetail(req, "apikey.create", name, strings.Join(scopes, ", "), nil, nil)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	view, err := apiKeysView(req, name, key)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(view)
+}
+
+func revokeAPIKeyHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	id, err := strconv.Atoi(req.PostFormValue("id"))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	err = db.RevokeAPIKey(id)
+	if err != nil {
+		log.Println("Error revoking API key:", id, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	audit(req, "apikey.revoke", fmt.Sprintf("apikey:%d", id), nil, nil)
+
+	http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/revoke"), http.StatusFound)
+}
@@ -0,0 +1,334 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// auditPageSize is the most entries shown at once in the audit log view
+const auditPageSize = 100
+
+var auditHTML = `
+<div class="card audit">
+<div class="card-content">
+    <div class="card-title">Audit Log</div>
+    <form class="row" method="get" action="/admin/configure/audit">
+        <div class="input-field col s4">
+            <input type="text" id="audit-actor" name="actor" value="{{ .Query.actor }}"/>
+            <label for="audit-actor" {{ if .Query.actor }}class="active"{{ end }}>Actor</label>
+        </div>
+        <div class="input-field col s4">
+            <input type="text" id="audit-action" name="action" value="{{ .Query.action }}" placeholder="ex. content or content.delete"/>
+            <label for="audit-action" class="active">Action</label>
+        </div>
+        <div class="input-field col s4">
+            <input type="text" id="audit-target" name="target" value="{{ .Query.target }}" placeholder="ex. Song or Song:12"/>
+            <label for="audit-target" class="active">Target</label>
+        </div>
+        <div class="input-field col s4">
+            <input type="date" id="audit-from" name="from" value="{{ .Query.from }}"/>
+            <label for="audit-from" class="active">From</label>
+        </div>
+        <div class="input-field col s4">
+            <input type="date" id="audit-to" name="to" value="{{ .Query.to }}"/>
+            <label for="audit-to" class="active">To</label>
+        </div>
+        <div class="col s4">
+            <button class="btn waves-effect waves-light" type="submit">Filter</button>
+            <a class="btn-flat" href="/admin/configure/audit/export?format=csv&{{ .Filter }}">CSV</a>
+            <a class="btn-flat" href="/admin/configure/audit/export?format=json&{{ .Filter }}">JSON</a>
+        </div>
+    </form>
+    {{ if .Entries }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>Time</th>
+                <th>Actor</th>
+                <th>Action</th>
+                <th>Target</th>
+                <th>IP</th>
+                <th>Changes</th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range .Entries }}
+            <tr>
+                <td>{{ date .Time }}</td>
+                <td>{{ .Actor }}</td>
+                <td>{{ .Action }}</td>
+                <td>{{ .Target }}</td>
+                <td>{{ .IP }}</td>
+                <td>
+                    {{ if .Detail }}<div>{{ .Detail }}</div>{{ end }}
+                    {{ if .Before }}<div class="grey-text">Before: {{ .Before }}</div>{{ end }}
+                    {{ if .After }}<div>After: {{ .After }}</div>{{ end }}
+                </td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ if .More }}<p>Showing the {{ len .Entries }} most recent entries. Narrow the filter, or export, to see more.</p>{{ end }}
+    {{ else }}
+    <p>No entries match the filter.</p>
+    {{ end }}
+</div>
+</div>
+`
+
+// audit adds an entry to the audit log for an action taken by the user making
+// the request, summarizing the changes between the json before and after it
+func audit(req *http.Request, action, target string, before, after []byte) {
+	auditDetail(req, action, target, "", before, after)
+}
+
+// auditDetail is like audit, with a description of the action
+func auditDetail(req *http.Request, action, target, detail string, before, after []byte) {
+	// the user is read from the request rather than its session, which may
+	// have been ended by the action
+	var actor string
+	if usr, err := user.Current(req); err == nil {
+		actor = usr.Email
+	}
+
+	auditAs(req, actor, action, target, detail, before, after)
+}
+
+// auditAs is like auditDetail, for an actor who isn't logged in, such as the
+// user of a backup authenticated by HTTP basic auth
+func auditAs(req *http.Request, actor, action, target, detail string, before, after []byte) {
+	b, a := db.AuditChanges(before, after)
+	err := db.Audit(db.AuditEntry{
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		IP:     user.RemoteIP(req),
+		Detail: detail,
+		Before: b,
+		After:  a,
+	})
+	if err != nil {
+		log.Println("Error adding audit entry:", action, target, err)
+	}
+}
+
+// contentTarget returns the audit target for content with the id in the bucket
+// t, and the status of buckets such as "Song__pending"
+func contentTarget(t string, id interface{}) (string, string) {
+	var status string
+	if i := strings.Index(t, "__"); i >= 0 {
+		t, status = t[:i], t[i+2:]
+	}
+
+	return fmt.Sprintf("%s:%v", t, id), status
+}
+
+// auditUser returns the fields of a user which are summarized in the audit log,
+// leaving out its password hash and two-factor secrets
+func auditUser(usr *user.User) []byte {
+	if usr == nil {
+		return nil
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"email":       usr.Email,
+		"role":        usr.Role,
+		"permissions": usr.Permissions,
+		"two_factor":  usr.HasTwoFactor(),
+	})
+	if err != nil {
+		return nil
+	}
+
+	return j
+}
+
+// auditFilter reads the audit log filter from the request's query string, where
+// the from and to dates are inclusive
+func auditFilter(req *http.Request) (db.AuditFilter, error) {
+	q := req.URL.Query()
+	f := db.AuditFilter{
+		Actor:  q.Get("actor"),
+		Action: q.Get("action"),
+		Target: q.Get("target"),
+	}
+
+	if from := q.Get("from"); from != "" {
+		t, err := time.ParseInLocation("2006-01-02", from, time.Local)
+		if err != nil {
+			return f, err
+		}
+
+		f.Since = t.UnixNano() / int64(time.Millisecond)
+	}
+
+	if to := q.Get("to"); to != "" {
+		t, err := time.ParseInLocation("2006-01-02", to, time.Local)
+		if err != nil {
+			return f, err
+		}
+
+		f.Until = t.AddDate(0, 0, 1).UnixNano() / int64(time.Millisecond)
+	}
+
+	return f, nil
+}
+
+func auditHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		errView, err := Error405()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	f, err := auditFilter(req)
+	if err != nil {
+		log.Println("Invalid audit log filter:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	var entries []db.AuditEntry
+	var more bool
+	err = db.AuditLog(f, func(e db.AuditEntry) bool {
+		if len(entries) == auditPageSize {
+			more = true
+			return false
+		}
+
+		entries = append(entries, e)
+		return true
+	})
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	q := req.URL.Query()
+	query := map[string]string{}
+	for _, k := range []string{"actor", "action", "target", "from", "to"} {
+		query[k] = q.Get(k)
+	}
+	q.Del("format")
+
+	funcs := template.FuncMap{
+		"date": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04:05 PM")
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("audit").Funcs(funcs).Parse(auditHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Entries": entries,
+		"More":    more,
+		"Query":   query,
+		"Filter":  template.URL(q.Encode()),
+	})
+	if err != nil {
+		log.Println("Error executing audit template:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	adminView, err := AdminFor(req, buf.Bytes())
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(adminView)
+}
+
+func auditExportHandler(res http.ResponseWriter, req *http.Request) {
+	// /admin/configure/audit/export?format=csv&action=content.delete
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	f, err := auditFilter(req)
+	if err != nil {
+		log.Println("Invalid audit log filter:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	disposition := `attachment; filename="audit-%d.%s"`
+	ts := time.Now().Unix()
+
+	switch format {
+	case "csv":
+		res.Header().Set("Content-Type", "text/csv")
+		res.Header().Set("Content-Disposition", fmt.Sprintf(disposition, ts, format))
+
+		w := csv.NewWriter(res)
+		w.Write([]string{"id", "time", "actor", "action", "target", "ip", "detail", "before", "after"})
+		err = db.AuditLog(f, func(e db.AuditEntry) bool {
+			w.Write([]string{
+				strconv.FormatUint(e.ID, 10),
+				time.Unix(0, e.Time*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+				e.Actor, e.Action, e.Target, e.IP, e.Detail, e.Before, e.After,
+			})
+			return true
+		})
+		w.Flush()
+
+	case "json":
+		res.Header().Set("Content-Type", "application/json")
+		res.Header().Set("Content-Disposition", fmt.Sprintf(disposition, ts, format))
+
+		// entries are written as they are read, so the log isn't held in memory
+		enc := json.NewEncoder(res)
+		sep := []byte("[")
+		err = db.AuditLog(f, func(e db.AuditEntry) bool {
+			res.Write(sep)
+			sep = []byte(",")
+			return enc.Encode(e) == nil
+		})
+		if string(sep) == "[" {
+			res.Write(sep)
+		}
+		res.Write([]byte("]"))
+
+	default:
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		log.Println("Error exporting audit log:", err)
+	}
+}
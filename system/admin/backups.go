@@ -0,0 +1,454 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/backup"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+var backupsHTML = `
+<div class="card backups">
+<div class="card-content">
+    <div class="card-title">Backups</div>
+    <p>Snapshots of the database, search indexes and uploads are written to <code>{{ .Dir }}</code>. Uploads are stored once, and only new uploads are copied by each snapshot. Change the schedule and retention in the <a href="/admin/configure">Configuration</a>.</p>
+    <ul class="row">
+        <li class="col s12">
+            <span class="grey-text">Schedule:</span>
+            {{ if .Schedule }}<code>{{ .Schedule }}</code>{{ else }}Not scheduled{{ end }}
+            {{ if .ScheduleError }}<span class="red-text">{{ .ScheduleError }}</span>{{ end }}
+        </li>
+        {{ if not .Next.IsZero }}
+        <li class="col s12"><span class="grey-text">Next backup:</span> {{ .Next.Format "01/02/06 03:04 PM" }}</li>
+        {{ end }}
+        <li class="col s12">
+            <span class="grey-text">Last backup:</span>
+            {{ if .Last.Running }}
+            Running now, refresh to see its result.
+            {{ else if .Last.Started }}
+            {{ date .Last.Started }} in {{ duration .Last.Duration }},
+            {{ if .Last.Error }}
+            <span class="red-text">failed: {{ .Last.Error }}</span>
+            {{ else }}
+            {{ .Last.Snapshot }} with {{ .Last.NewUploads }} new uploads{{ if .Last.Removed }}, {{ .Last.Removed }} old snapshots removed{{ end }}
+            {{ end }}
+            {{ else }}
+            Never
+            {{ end }}
+        </li>
+    </ul>
+    <form enctype="multipart/form-data" action="/admin/configure/backups" method="post">
+        <input type="hidden" name="action" value="run"/>
+        <button class="btn waves-effect waves-light green" type="submit" {{ if .Last.Running }}disabled{{ end }}>Back Up Now</button>
+    </form>
+
+    {{ with .Result }}
+    <p class="{{ if .Error }}red-text{{ else }}green-text{{ end }}">
+        {{ .Name }}: {{ if .Error }}{{ .Error }}{{ else }}{{ .Message }}{{ end }}
+    </p>
+    {{ end }}
+
+    {{ if .Snapshots }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>Snapshot</th>
+                <th>Created</th>
+                <th>Version</th>
+                <th>Database &amp; Search</th>
+                <th>Uploads</th>
+                <th></th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range .Snapshots }}
+            <tr>
+                <td>{{ .Name }}</td>
+                <td>{{ date .Created }}</td>
+                <td>{{ .Version }}</td>
+                <td>{{ size (files .) }}</td>
+                <td>{{ len .Uploads }} files, {{ size (uploads .) }} ({{ .NewUploads }} new)</td>
+                <td>
+                    <form enctype="multipart/form-data" action="/admin/configure/backups" method="post">
+                        <input type="hidden" name="action" value="verify"/>
+                        <input type="hidden" name="name" value="{{ .Name }}"/>
+                        <button class="btn-flat" type="submit">Verify</button>
+                    </form>
+                    <a class="btn-flat" href="/admin/configure/backups/download?name={{ .Name }}">Download</a>
+                    <form enctype="multipart/form-data" class="restore-snapshot" action="/admin/configure/backups/restore" method="post">
+                        <input type="hidden" name="name" value="{{ .Name }}"/>
+                        <button class="btn-flat red-text" type="submit">Restore</button>
+                    </form>
+                </td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ else }}
+    <p>No snapshots have been written.</p>
+    {{ end }}
+
+    <div class="card-title">Restore an archive:</div>
+    <p>Restoring replaces all content, users, settings, uploads and search indexes by those of the snapshot. The audit log is kept. Download a snapshot to get an archive which can be restored on another server.</p>
+    <form class="row restore-snapshot" enctype="multipart/form-data" action="/admin/configure/backups/restore" method="post">
+        <div class="file-field input-field col s9">
+            <div class="btn">
+                <span>Archive</span>
+                <input type="file" name="archive" accept=".tar.gz,.tgz,application/gzip" required/>
+            </div>
+            <div class="file-path-wrapper">
+                <input class="file-path validate" type="text" placeholder="snapshot .tar.gz"/>
+            </div>
+        </div>
+        <div class="col s3">
+            <button class="btn waves-effect waves-light red" type="submit">Restore</button>
+        </div>
+    </form>
+</div>
+</div>
+<script>
+    $(function() {
+        $('form.restore-snapshot').on('submit', function(e) {
+            if (!confirm("[Ponzu] Please confirm:\n\nAre you sure you want to restore this snapshot?\nAll current content, users, settings and uploads will be replaced.")) {
+                e.preventDefault();
+            }
+        });
+    });
+</script>
+`
+
+// backupResult is the result of an action on a snapshot, shown on the backups page
+type backupResult struct {
+	Name    string
+	Message string
+	Error   error
+}
+
+func backupsView(req *http.Request, result *backupResult) ([]byte, error) {
+	dir := db.BackupDir()
+	snapshots, err := backup.Snapshots(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := db.LastBackup()
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, _ := db.ConfigCache("backup_schedule").(string)
+	next, err := db.NextBackup(time.Now())
+	var scheduleErr string
+	if err != nil {
+		scheduleErr = err.Error()
+	}
+
+	funcs := template.FuncMap{
+		"date": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04 PM")
+		},
+		"duration": func(ms int64) string {
+			return (time.Duration(ms) * time.Millisecond).String()
+		},
+		"size": func(n int64) string {
+			return item.FmtBytes(float64(n))
+		},
+		"files": func(m *backup.Manifest) int64 {
+			files, _ := m.Size()
+			return files
+		},
+		"uploads": func(m *backup.Manifest) int64 {
+			_, uploads := m.Size()
+			return uploads
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("backups").Funcs(funcs).Parse(backupsHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Dir":           dir,
+		"Schedule":      schedule,
+		"ScheduleError": scheduleErr,
+		"Next":          next,
+		"Last":          last,
+		"Snapshots":     snapshots,
+		"Result":        result,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return AdminFor(req, buf.Bytes())
+}
+
+func backupsHandler(res http.ResponseWriter, req *http.Request) {
+	var result *backupResult
+
+	switch req.Method {
+	case http.MethodGet:
+		if name := req.URL.Query().Get("restored"); name != "" {
+			result = &backupResult{Name: name, Message: "restored."}
+		}
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		switch req.PostFormValue("action") {
+		case "run":
+			// snapshots can take a while, so the result is shown in the
+			// status once it is done
+			go func() {
+				m, err := db.Snapshot(context.Background())
+				if err != nil {
+					log.Println("Error running backup:", err)
+					return
+				}
+
+				log.Println("Backed up to", m.Name)
+			}()
+
+			auditDetail(req, "backup.run", "backups", db.BackupDir(), nil, nil)
+
+			http.Redirect(res, req, req.URL.String(), http.StatusFound)
+			return
+
+		case "verify":
+			m, err := findSnapshot(req.PostFormValue("name"))
+			if err != nil {
+				log.Println(err)
+				res.WriteHeader(http.StatusInternalServerError)
+				errView, err := Error500()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+
+			if m == nil {
+				res.WriteHeader(http.StatusNotFound)
+				errView, err := Error404()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+
+			result = &backupResult{
+				Name:    m.Name,
+				Message: "every file matches its checksum.",
+				Error:   backup.Verify(db.BackupDir(), m),
+			}
+
+			detail := "ok"
+			if result.Error != nil {
+				detail = result.Error.Error()
+			}
+			auditDetail(req, "backup.verify", "backup:"+m.Name, detail, nil, nil)
+
+		default:
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	view, err := backupsView(req, result)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(view)
+}
+
+// findSnapshot returns the snapshot in the backup directory with the name, or
+// nil if there is none
+func findSnapshot(name string) (*backup.Manifest, error) {
+	snapshots, err := backup.Snapshots(db.BackupDir())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range snapshots {
+		if m.Name == name {
+			return m, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func downloadBackupHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	m, err := findSnapshot(req.URL.Query().Get("name"))
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if m == nil {
+		res.WriteHeader(http.StatusNotFound)
+		errView, err := Error404()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	res.Header().Set("Content-Type", "application/gzip")
+	res.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ponzu-%s.tar.gz"`, m.Name))
+
+	err = backup.WriteArchive(ctx, db.BackupDir(), m, res)
+	if err != nil {
+		log.Println("Failed to write snapshot archive:", m.Name, err)
+		return
+	}
+
+	audit(req, "backup.download", "backup:"+m.Name, nil, nil)
+}
+
+func restoreBackupHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	// restore a snapshot in the backup directory, or an uploaded archive
+	name := req.PostFormValue("name")
+	src := filepath.Join(db.BackupDir(), name)
+	if name == "" {
+		file, hdr, err := req.FormFile("archive")
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		defer file.Close()
+
+		tmp, err := ioutil.TempFile("", "ponzu-restore-")
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = io.Copy(tmp, file)
+		tmp.Close()
+		if err != nil {
+			log.Println("Failed to save archive to restore:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		name = filepath.Base(hdr.Filename)
+		src = tmp.Name()
+	} else {
+		m, err := findSnapshot(name)
+		if err != nil || m == nil {
+			res.WriteHeader(http.StatusNotFound)
+			errView, err := Error404()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+	}
+
+	m, err := db.Restore(context.Background(), src)
+	if err != nil {
+		log.Println("Failed to restore", name, err)
+		auditDetail(req, "backup.restore", "backup:"+name, "failed: "+err.Error(), nil, nil)
+
+		res.WriteHeader(http.StatusBadRequest)
+		view, err := backupsView(req, &backupResult{Name: name, Error: err})
+		if err != nil {
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(view)
+		return
+	}
+
+	auditDetail(req, "backup.restore", "backup:"+m.Name, fmt.Sprintf("Ponzu v%s, %d uploads", m.Version, len(m.Uploads)), nil, nil)
+
+	// the restored users and sessions may not include the current one, which
+	// has to log in again
+	redir := "/admin/configure/backups?restored=" + url.QueryEscape(m.Name)
+	http.Redirect(res, req, redir, http.StatusFound)
+}
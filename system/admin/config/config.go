@@ -1,6 +1,6 @@
 // Package config provides a content type to manage the Ponzu system's configuration
-// settings for things such as its name, domain, HTTP(s) port, email, server defaults
-// and backups.
+// settings for things such as its name, domain, HTTP(s) port, email, server defaults,
+// backups, image sizes and upload limits.
 package config
 
 import (
@@ -25,8 +25,20 @@ type Config struct {
 	DisableHTTPCache        bool     `json:"cache_disabled"`
 	CacheMaxAge             int64    `json:"cache_max_age"`
 	CacheInvalidate         []string `json:"cache"`
+	ExpandMaxDepth          int64    `json:"expand_max_depth"`
+	TrashRetentionDays      int64    `json:"trash_retention_days"`
+	RequireTwoFactor        bool     `json:"require_2fa"`
 	BackupBasicAuthUser     string   `json:"backup_basic_auth_user"`
 	BackupBasicAuthPassword string   `json:"backup_basic_auth_password"`
+	BackupSchedule          string   `json:"backup_schedule"`
+	BackupDir               string   `json:"backup_dir"`
+	BackupKeepDaily         int64    `json:"backup_keep_daily"`
+	BackupKeepWeekly        int64    `json:"backup_keep_weekly"`
+	ImageSizes              string   `json:"image_sizes"`
+	ImageAnySize            bool     `json:"image_any_size"`
+	ImageCacheMaxSize       int64    `json:"image_cache_max_size"`
+	MaxUploadSize           int64    `json:"max_upload_size"`
+	UploadTypes             string   `json:"upload_types"`
 }
 
 const (
@@ -34,6 +46,21 @@ const (
 		<p class="flow-text">Database Backup Credentials:</p>
 		<p>Add a user name and password to download a backup of your data via HTTP.</p>
 	`
+
+	scheduledBackupInfo = `
+		<p class="flow-text">Scheduled Backups:</p>
+		<p>Snapshots of your data are written to the backup directory on the schedule, e.g. "0 3 * * *" or "@daily". Leave the schedule empty to disable scheduled backups.</p>
+	`
+
+	imageSizesInfo = `
+		<p class="flow-text">Image Sizes:</p>
+		<p>Name sizes of uploaded images, one per line, e.g. "thumbnail: w=200&amp;h=200&amp;fit=cover". They are requested as /api/uploads/{path}?size=thumbnail. Other sizes may only be requested by logged in users, unless any size is allowed.</p>
+	`
+
+	uploadLimitsInfo = `
+		<p class="flow-text">Upload Limits:</p>
+		<p>Files larger than the limit, or of types which aren't allowed, are rejected. Types are detected from the content of files, and listed like "image/*, application/pdf". Content types may set stricter limits for their own fields.</p>
+	`
 )
 
 // String partially implements item.Identifiable and overrides Item's String()
@@ -130,6 +157,25 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"invalidate": "Invalidate Cache",
 			}),
 		},
+		editor.Field{
+			View: editor.Input("ExpandMaxDepth", c, map[string]string{
+				"label": "Levels of references which may be expanded in API responses (0 = 2)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("TrashRetentionDays", c, map[string]string{
+				"label": "Days to keep deleted content in the Trash (0 = 30, -1 = keep forever)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Checkbox("RequireTwoFactor", c, map[string]string{
+				"label": "Require two-factor authentication (users without it must enable it when they next log in)",
+			}, map[string]string{
+				"true": "Require Two-Factor Authentication",
+			}),
+		},
 		editor.Field{
 			View: []byte(dbBackupInfo),
 		},
@@ -147,6 +193,73 @@ func (c *Config) MarshalEditor() ([]byte, error) {
 				"type":        "password",
 			}),
 		},
+		editor.Field{
+			View: []byte(scheduledBackupInfo),
+		},
+		editor.Field{
+			View: editor.Input("BackupSchedule", c, map[string]string{
+				"label":       "Backup Schedule (minute hour day-of-month month day-of-week)",
+				"placeholder": "e.g. 0 3 * * *",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("BackupDir", c, map[string]string{
+				"label":       "Backup Directory",
+				"placeholder": "Defaults to the backups directory in the data directory",
+				"type":        "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("BackupKeepDaily", c, map[string]string{
+				"label": "Days to keep a daily snapshot for (0 = 7)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("BackupKeepWeekly", c, map[string]string{
+				"label": "Weeks to keep a weekly snapshot for (0 = 4)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: []byte(imageSizesInfo),
+		},
+		editor.Field{
+			View: editor.Textarea("ImageSizes", c, map[string]string{
+				"label":       "Image Sizes",
+				"placeholder": "thumbnail: w=200&h=200&fit=cover",
+			}),
+		},
+		editor.Field{
+			View: editor.Checkbox("ImageAnySize", c, map[string]string{
+				"label": "Allow anyone to request images in any size, with the w, h, fit, fm and q parameters",
+			}, map[string]string{
+				"true": "Allow Any Image Size",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("ImageCacheMaxSize", c, map[string]string{
+				"label": "Disk space for resized images in MB, the least recently used are removed beyond it (0 = 1024, -1 = no limit)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: []byte(uploadLimitsInfo),
+		},
+		editor.Field{
+			View: editor.Input("MaxUploadSize", c, map[string]string{
+				"label": "Size limit of uploaded files in MB (0 = 32, -1 = no limit)",
+				"type":  "text",
+			}),
+		},
+		editor.Field{
+			View: editor.Input("UploadTypes", c, map[string]string{
+				"label":       "Allowed types of uploaded files",
+				"placeholder": "Leave empty to allow any type, e.g. image/*, application/pdf",
+				"type":        "text",
+			}),
+		},
 	)
 	if err != nil {
 		return nil, err
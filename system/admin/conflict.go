@@ -0,0 +1,141 @@
+package admin
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/tidwall/gjson"
+)
+
+var conflictHTML = `
+<div class="card conflict">
+<div class="card-content">
+    <div class="card-title"><b>412</b> Conflict: Content has changed</div>
+    {{ if .Deleted }}
+    <blockquote>This content was deleted by someone else after you began editing it.</blockquote>
+    {{ else }}
+    <blockquote>This content was saved by someone else after you began editing it. Saving now would overwrite their changes.</blockquote>
+    {{ if .Fields }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>Field</th>
+                <th>Saved version</th>
+                <th>Your changes</th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range .Fields }}
+            <tr>
+                <td>{{ .Name }}</td>
+                <td>{{ .Saved }}</td>
+                <td>{{ .Yours }}</td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ end }}
+    {{ end }}
+</div>
+<div class="card-action">
+    {{ if not .Deleted }}
+    <form method="post" action="{{ .Action }}" enctype="multipart/form-data" class="right">
+        {{ range $name, $values := .Form }}{{ range $values }}
+        <input type="hidden" name="{{ $name }}" value="{{ . }}"/>
+        {{ end }}{{ end }}
+        <input type="hidden" name="__etag" value="{{ .ETag }}"/>
+        <button class="btn waves-effect waves-light red" type="submit">Overwrite with my changes</button>
+    </form>
+    {{ end }}
+    <a class="btn waves-effect waves-light" href="{{ .Latest }}">Discard my changes and load the latest version</a>
+</div>
+</div>
+`
+
+type conflictField struct {
+	Name  string
+	Saved string
+	Yours string
+}
+
+// fields set by the editor or system which aren't shown as conflicting changes
+var conflictIgnoreFields = map[string]bool{
+	"id":        true,
+	"uuid":      true,
+	"type":      true,
+	"slug":      true,
+	"timestamp": true,
+	"updated":   true,
+}
+
+// contentConflict responds to a stale save from the content editor with a view
+// listing the fields which differ between the saved content and the submitted
+// form, so the user can choose to overwrite the saved content or reload it
+func contentConflict(res http.ResponseWriter, req *http.Request, t, id string, current []byte) {
+	pt := strings.Split(t, "__")[0]
+	latest := "/admin/edit?type=" + pt + "&id=" + id
+	if strings.HasSuffix(t, "__pending") {
+		latest += "&status=pending"
+	}
+
+	var fields []conflictField
+	for name, values := range req.PostForm {
+		if conflictIgnoreFields[name] {
+			continue
+		}
+
+		var saved []string
+		v := gjson.GetBytes(current, name)
+		if strings.HasPrefix(v.Raw, "[") {
+			for _, el := range v.Array() {
+				saved = append(saved, el.String())
+			}
+		} else if v.Exists() {
+			saved = append(saved, v.String())
+		}
+
+		s, y := strings.Join(saved, ", "), strings.Join(values, ", ")
+		if s != y {
+			fields = append(fields, conflictField{Name: name, Saved: s, Yours: y})
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	data := map[string]interface{}{
+		"Deleted": len(current) == 0,
+		"Fields":  fields,
+		"Form":    req.PostForm,
+		"ETag":    db.ContentETag(current),
+		"Action":  req.URL.RequestURI(),
+		"Latest":  latest,
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("conflict").Parse(conflictHTML))
+	err := tmpl.Execute(buf, data)
+	if err != nil {
+		log.Println("Error executing conflict template:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	view, err := AdminFor(req, buf.Bytes())
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.WriteHeader(http.StatusPreconditionFailed)
+	res.Write(view)
+}
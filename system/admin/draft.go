@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+var previewHTML = `
+<div class="card preview">
+<div class="card-content">
+    <div class="card-title">Preview {{ .Type }} {{ .ID }}</div>
+    <blockquote>Anyone with this link can read the unpublished version of this content from the content API until {{ .Expires }}.</blockquote>
+    <div class="input-field">
+        <label class="active">Preview Token</label>
+        <input type="text" readonly value="{{ .Token }}" onclick="this.select()"/>
+    </div>
+    <div class="input-field">
+        <label class="active">Content API URL</label>
+        <input type="text" readonly value="{{ .URL }}" onclick="this.select()"/>
+    </div>
+</div>
+<div class="card-action">
+    <a class="btn waves-effect waves-light" href="{{ .URL }}" target="_blank">Open</a>
+    <a class="btn-flat" href="/admin/edit?type={{ .Type }}&id={{ .ID }}&status={{ .Status }}">Back to editor</a>
+</div>
+</div>
+`
+
+// draftTarget reads the type and id of the content from a posted editor form,
+// and returns them with the type stripped of any specifier
+func draftTarget(res http.ResponseWriter, req *http.Request) (string, string, string, bool) {
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return "", "", "", false
+		}
+
+		res.Write(errView)
+		return "", "", "", false
+	}
+
+	ns := req.FormValue("type")
+	id := req.FormValue("id")
+	t := strings.Split(ns, "__")[0]
+	if _, ok := item.Types[t]; !ok || !db.IsValidID(id) {
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return "", "", "", false
+		}
+
+		res.Write(errView)
+		return "", "", "", false
+	}
+
+	return ns, t, id, true
+}
+
+func publishDraftHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		errView, err := Error405()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	_, t, id, ok := draftTarget(res, req)
+	if !ok {
+		return
+	}
+
+	if !allowed(res, req, user.ActionEdit, t) {
+		return
+	}
+
+	before, _ := db.Content(t + ":" + id)
+	err := db.PublishDraft(t+":"+id, currentEmail(req))
+	if err != nil {
+		log.Println("Error publishing draft:", t, id, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	after, _ := db.Content(t + ":" + id)
+	auditDetail(req, "content.update", t+":"+id, "published draft", before, after)
+	notify(db.WebhookUpdate, t, id, after)
+
+	redir := "/admin/edit?type=" + url.QueryEscape(t) + "&id=" + id
+	if scheduled, err := db.Content(t + "__scheduled:" + id); err == nil && len(scheduled) > 0 {
+		redir += "&status=scheduled"
+	}
+
+	http.Redirect(res, req, redir, http.StatusFound)
+}
+
+func previewHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		errView, err := Error405()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	ns, t, id, ok := draftTarget(res, req)
+	if !ok {
+		return
+	}
+
+	if !allowed(res, req, user.ActionView, t) {
+		return
+	}
+
+	target := ns + ":" + id
+	if !db.CanPreview(target) {
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	token, err := db.PreviewToken(target, db.DefaultPreviewTTL)
+	if err != nil {
+		log.Println("Error creating preview token:", target, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("preview").Parse(previewHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Type":    t,
+		"ID":      id,
+		"Status":  strings.TrimPrefix(strings.TrimPrefix(ns, t), "__"),
+		"Token":   token,
+		"URL":     "/api/content?preview=" + url.QueryEscape(token),
+		"Expires": time.Now().Add(db.DefaultPreviewTTL).Format("Jan 2, 2006 3:04 PM"),
+	})
+	if err != nil {
+		log.Println("Error executing preview template:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	adminView, err := AdminFor(req, buf.Bytes())
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(adminView)
+}
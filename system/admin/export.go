@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/ponzu-cms/ponzu/management/format"
+	"github.com/ponzu-cms/ponzu/system/admin/user"
 	"github.com/ponzu-cms/ponzu/system/db"
 	"github.com/ponzu-cms/ponzu/system/item"
 
@@ -45,6 +46,10 @@ func exportHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !allowed(res, req, user.ActionView, t) {
+		return
+	}
+
 	switch f {
 	case "csv":
 		csv, ok := pt().(format.CSVFormattable)
@@ -21,7 +21,9 @@ import (
 	"github.com/ponzu-cms/ponzu/system/admin/user"
 	"github.com/ponzu-cms/ponzu/system/api"
 	"github.com/ponzu-cms/ponzu/system/api/analytics"
+	"github.com/ponzu-cms/ponzu/system/backup"
 	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/imaging"
 	"github.com/ponzu-cms/ponzu/system/item"
 	"github.com/ponzu-cms/ponzu/system/search"
 
@@ -32,7 +34,7 @@ import (
 )
 
 func adminHandler(res http.ResponseWriter, req *http.Request) {
-	view, err := Dashboard()
+	view, err := Dashboard(req)
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -86,6 +88,7 @@ func initHandler(res http.ResponseWriter, req *http.Request) {
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		usr.Role = user.RoleAdmin
 
 		_, err = db.SetUser(usr)
 		if err != nil {
@@ -107,28 +110,25 @@ func initHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// add _token cookie for login persistence
-		week := time.Now().Add(time.Hour * 24 * 7)
-		claims := map[string]interface{}{
-			"exp":  week.Unix(),
-			"user": usr.Email,
+		err = db.Audit(db.AuditEntry{
+			Actor:  email,
+			Action: "system.init",
+			Target: "settings",
+			IP:     user.RemoteIP(req),
+		})
+		if err != nil {
+			log.Println("Error adding audit entry:", err)
 		}
 
+		// add _token cookie for login persistence
 		jwt.Secret([]byte(secret))
-		token, err := jwt.New(claims)
+		err = setLoginToken(res, req, usr.Email)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		http.SetCookie(res, &http.Cookie{
-			Name:    "_token",
-			Value:   token,
-			Expires: week,
-			Path:    "/",
-		})
-
 		redir := strings.TrimSuffix(req.URL.String(), "/init")
 		http.Redirect(res, req, redir, http.StatusFound)
 
@@ -163,7 +163,7 @@ func configHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		adminView, err := Admin(cfg)
+		adminView, err := AdminFor(req, cfg)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -181,6 +181,54 @@ func configHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if spec := req.FormValue("backup_schedule"); spec != "" {
+			_, err = backup.ParseSchedule(spec)
+			if err != nil {
+				log.Println("Invalid backup schedule:", err)
+				res.WriteHeader(http.StatusBadRequest)
+				errView, err := Error400()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+		}
+
+		_, err = imaging.ParseSizes(req.FormValue("image_sizes"))
+		if err != nil {
+			log.Println("Invalid image sizes:", err)
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		_, err = upload.ParseTypes(req.FormValue("upload_types"))
+		if err != nil {
+			log.Println("Invalid upload types:", err)
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		before, err := db.ConfigAll()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
 		err = db.SetConfig(req.Form)
 		if err != nil {
 			log.Println(err)
@@ -188,6 +236,13 @@ func configHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		after, err := db.ConfigAll()
+		if err != nil {
+			log.Println(err)
+		}
+
+		audit(req, "config.update", "settings", before, after)
+
 		http.Redirect(res, req, req.URL.String(), http.StatusFound)
 
 	default:
@@ -235,7 +290,12 @@ func backupHandler(res http.ResponseWriter, req *http.Request) {
 
 	default:
 		res.WriteHeader(http.StatusBadRequest)
+		return
 	}
+
+	// the basic auth user has been checked by system.BasicAuth
+	actor, _, _ := req.BasicAuth()
+	auditAs(req, actor, "backup.download", req.URL.Query().Get("source"), "", nil, nil)
 }
 
 func configUsersHandler(res http.ResponseWriter, req *http.Request) {
@@ -258,6 +318,11 @@ func configUsersHandler(res http.ResponseWriter, req *http.Request) {
 
 	case http.MethodPost:
 		// create new user
+		if !isAdmin(req) {
+			forbidden(res, req, nil)
+			return
+		}
+
 		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
 		if err != nil {
 			log.Println(err)
@@ -292,6 +357,19 @@ func configUsersHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		role := req.PostFormValue("role")
+		if role != "" && !user.IsValidRole(role) {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		usr.Role = role
+
 		_, err = db.SetUser(usr)
 		if err != nil {
 			log.Println(err)
@@ -305,6 +383,8 @@ func configUsersHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		audit(req, "user.create", usr.Email, nil, auditUser(usr))
+
 		http.Redirect(res, req, req.URL.String(), http.StatusFound)
 
 	default:
@@ -314,6 +394,36 @@ func configUsersHandler(res http.ResponseWriter, req *http.Request) {
 
 func configUsersEditHandler(res http.ResponseWriter, req *http.Request) {
 	switch req.Method {
+	case http.MethodGet:
+		usr, err := user.Current(req)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		view, err := twoFactorSettings(req, usr, nil)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(view)
+
 	case http.MethodPost:
 		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
 		if err != nil {
@@ -371,6 +481,11 @@ func configUsersEditHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if action := req.PostFormValue("action"); action != "" {
+			configTwoFactorHandler(res, req, usr, action)
+			return
+		}
+
 		email := strings.ToLower(req.PostFormValue("email"))
 		newPassword := req.PostFormValue("new_password")
 		var updatedUser *user.User
@@ -393,6 +508,13 @@ func configUsersEditHandler(res http.ResponseWriter, req *http.Request) {
 		// set the ID to the same ID as current user
 		updatedUser.ID = usr.ID
 
+		// users can't change their own role or permissions
+		updatedUser.Role = usr.Role
+		updatedUser.Permissions = usr.Permissions
+		updatedUser.TOTPSecret = usr.TOTPSecret
+		updatedUser.TOTPLastStep = usr.TOTPLastStep
+		updatedUser.RecoveryCodes = usr.RecoveryCodes
+
 		// set user in db
 		err = db.UpdateUser(usr, updatedUser)
 		if err != nil {
@@ -407,13 +529,9 @@ func configUsersEditHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// create new token
-		week := time.Now().Add(time.Hour * 24 * 7)
-		claims := map[string]interface{}{
-			"exp":  week,
-			"user": updatedUser.Email,
-		}
-		token, err := jwt.New(claims)
+		// end all sessions, including this one, which is replaced below with a
+		// session for the updated user
+		err = db.RevokeSessions(usr.Email)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -426,17 +544,25 @@ func configUsersEditHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// add token to cookie +1 week expiration
-		cookie := &http.Cookie{
-			Name:    "_token",
-			Value:   token,
-			Expires: week,
-			Path:    "/",
+		// add new token to cookie +1 week expiration
+		err = setLoginToken(res, req, updatedUser.Email)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
 		}
-		http.SetCookie(res, cookie)
 
-		// add new token cookie to the request
-		req.AddCookie(cookie)
+		var detail string
+		if newPassword != "" {
+			detail = "password changed"
+		}
+		auditDetail(req, "user.update", usr.Email, detail, auditUser(usr), auditUser(updatedUser))
 
 		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/edit"), http.StatusFound)
 
@@ -503,6 +629,11 @@ func configUsersDeleteHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		var deleted *user.User
+		if j, err := db.User(email); err == nil && j != nil {
+			json.Unmarshal(j, &deleted)
+		}
+
 		// delete existing user
 		err = db.DeleteUser(email)
 		if err != nil {
@@ -517,6 +648,8 @@ func configUsersDeleteHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		audit(req, "user.delete", email, auditUser(deleted), nil)
+
 		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/delete"), http.StatusFound)
 
 	default:
@@ -524,6 +657,113 @@ func configUsersDeleteHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func configUsersRoleHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		email := strings.ToLower(req.PostFormValue("email"))
+		role := req.PostFormValue("role")
+
+		// do not allow current user to change their own role, which could
+		// leave the system without an admin
+		if email == "" || email == currentEmail(req) || !user.IsValidRole(role) {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		j, err := db.User(email)
+		if err != nil || j == nil {
+			log.Println("Error finding user by email:", email, err)
+			res.WriteHeader(http.StatusNotFound)
+			errView, err := Error404()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		usr := &user.User{}
+		err = json.Unmarshal(j, usr)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// per-type permissions are set from a list of types the user can
+		// access, and the actions checked for each type
+		perms := make(map[string][]string)
+		for _, t := range req.PostForm["types"] {
+			if _, ok := item.Types[t]; ok {
+				perms[t] = []string{}
+			}
+		}
+
+		for t := range item.Types {
+			for _, a := range user.Actions {
+				for _, v := range req.PostForm["permissions."+t] {
+					if v == a {
+						perms[t] = append(perms[t], a)
+					}
+				}
+			}
+		}
+
+		update := *usr
+		update.Role = role
+		update.Permissions = nil
+		if role != user.RoleAdmin && len(perms) > 0 {
+			update.Permissions = perms
+		}
+
+		err = db.UpdateUser(usr, &update)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		audit(req, "user.role", usr.Email, auditUser(usr), auditUser(&update))
+
+		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/role"), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
 func loginHandler(res http.ResponseWriter, req *http.Request) {
 	if !db.SystemInitComplete() {
 		redir := req.URL.Scheme + req.URL.Host + "/admin/init"
@@ -561,8 +801,15 @@ func loginHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		email := strings.ToLower(req.FormValue("email"))
+		attempt := startLogin(res, req, email)
+		if attempt == nil {
+			return
+		}
+		defer attempt.release()
+
 		// check email & password
-		j, err := db.User(strings.ToLower(req.FormValue("email")))
+		j, err := db.User(email)
 		if err != nil {
 			log.Println(err)
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
@@ -570,6 +817,7 @@ func loginHandler(res http.ResponseWriter, req *http.Request) {
 		}
 
 		if j == nil {
+			attempt.failed("login.fail")
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
 			return
 		}
@@ -583,35 +831,46 @@ func loginHandler(res http.ResponseWriter, req *http.Request) {
 		}
 
 		if !user.IsUser(usr, req.FormValue("password")) {
+			attempt.failed("login.fail")
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
 			return
 		}
-		// create new token
-		week := time.Now().Add(time.Hour * 24 * 7)
-		claims := map[string]interface{}{
-			"exp":  week,
-			"user": usr.Email,
+
+		// continue to the second login step for users with two-factor
+		// authentication, or who must enable it
+		if usr.HasTwoFactor() || twoFactorRequired() {
+			err = setPendingLogin(res, usr.Email)
+			if err != nil {
+				log.Println(err)
+				http.Redirect(res, req, req.URL.String(), http.StatusFound)
+				return
+			}
+
+			http.Redirect(res, req, req.URL.String()+"/2fa", http.StatusFound)
+			return
 		}
-		token, err := jwt.New(claims)
+
+		// add new token to cookie +1 week expiration
+		err = setLoginToken(res, req, usr.Email)
 		if err != nil {
 			log.Println(err)
 			http.Redirect(res, req, req.URL.String(), http.StatusFound)
 			return
 		}
-
-		// add it to cookie +1 week expiration
-		http.SetCookie(res, &http.Cookie{
-			Name:    "_token",
-			Value:   token,
-			Expires: week,
-			Path:    "/",
-		})
+		attempt.succeeded()
 
 		http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/login"), http.StatusFound)
 	}
 }
 
 func logoutHandler(res http.ResponseWriter, req *http.Request) {
+	if sid := user.SessionID(req); sid != "" {
+		err := db.RevokeSession(currentEmail(req), sid)
+		if err != nil {
+			log.Println("Error ending session:", err)
+		}
+	}
+
 	http.SetCookie(res, &http.Cookie{
 		Name:    "_token",
 		Expires: time.Unix(0, 0),
@@ -760,10 +1019,16 @@ func recoveryKeyHandler(res http.ResponseWriter, req *http.Request) {
 		// check for email & key match
 		email := strings.ToLower(req.FormValue("email"))
 		key := req.FormValue("key")
+		attempt := startLogin(res, req, email)
+		if attempt == nil {
+			return
+		}
+		defer attempt.release()
 
 		var actual string
 		if actual, err = db.RecoveryKey(email); err != nil || actual == "" {
 			log.Println("Error getting recovery key from database:", err)
+			attempt.failed("recovery.fail")
 
 			res.WriteHeader(http.StatusInternalServerError)
 			res.Write([]byte("Error, please go back and try again."))
@@ -772,6 +1037,7 @@ func recoveryKeyHandler(res http.ResponseWriter, req *http.Request) {
 
 		if key != actual {
 			log.Println("Bad recovery key submitted:", key)
+			attempt.failed("recovery.fail")
 
 			res.WriteHeader(http.StatusBadRequest)
 			res.Write([]byte("Error, please go back and try again."))
@@ -817,6 +1083,11 @@ func recoveryKeyHandler(res http.ResponseWriter, req *http.Request) {
 		}
 
 		update.ID = usr.ID
+		update.Role = usr.Role
+		update.Permissions = usr.Permissions
+		update.TOTPSecret = usr.TOTPSecret
+		update.TOTPLastStep = usr.TOTPLastStep
+		update.RecoveryCodes = usr.RecoveryCodes
 
 		err = db.UpdateUser(usr, update)
 		if err != nil {
@@ -826,6 +1097,24 @@ func recoveryKeyHandler(res http.ResponseWriter, req *http.Request) {
 			res.Write([]byte("Error, please go back and try again."))
 			return
 		}
+		attempt.succeeded()
+
+		// end any sessions started with the old password
+		err = db.RevokeSessions(email)
+		if err != nil {
+			log.Println("Error revoking sessions:", err)
+		}
+
+		err = db.Audit(db.AuditEntry{
+			Actor:  email,
+			Action: "user.recover",
+			Target: email,
+			IP:     user.RemoteIP(req),
+			Detail: "password changed with a recovery key",
+		})
+		if err != nil {
+			log.Println("Error adding audit entry:", err)
+		}
 
 		// redirect to /admin/login
 		redir := req.URL.Scheme + req.URL.Host + "/admin/login"
@@ -1086,7 +1375,7 @@ func uploadContentsHandler(res http.ResponseWriter, req *http.Request) {
 	btn := `<div class="col s3"><a href="/admin/edit/upload" class="btn new-post waves-effect waves-light">New Upload</a></div></div>`
 	html = html + b.String() + script + btn
 
-	adminView, err := Admin([]byte(html))
+	adminView, err := AdminFor(req, []byte(html))
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -1129,6 +1418,10 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !allowed(res, req, user.ActionView, t) {
+		return
+	}
+
 	pt := item.Types[t]()
 
 	p, ok := pt.(editor.Editable)
@@ -1188,10 +1481,15 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 	}
 
 	var specifier string
-	if status == "public" || status == "" {
+	switch status {
+	case "public", "":
 		specifier = "__sorted"
-	} else if status == "pending" {
+	case "pending":
 		specifier = "__pending"
+	case "scheduled":
+		specifier = "__scheduled"
+	case "draft":
+		specifier = "__draft"
 	}
 
 	b := &bytes.Buffer{}
@@ -1248,31 +1546,12 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
                     </form>	
 					</div>`
 	if hasExt {
-		if status == "" {
-			q.Set("status", "public")
-		}
-
-		// always start from top of results when changing public/pending
-		q.Del("count")
-		q.Del("offset")
-
-		q.Set("status", "public")
-		publicURL := req.URL.Path + "?" + q.Encode()
-
-		q.Set("status", "pending")
-		pendingURL := req.URL.Path + "?" + q.Encode()
-
 		switch status {
-		case "public", "":
-			// get __sorted posts of type t from the db
+		case "public", "", "scheduled", "draft":
+			// get __sorted, __scheduled or __draft posts of type t from the db
 			total, posts = db.Query(t+specifier, opts)
 
-			html += `<div class="row externalable">
-					<span class="description">Status:</span> 
-					<span class="active">Public</span>
-					&nbsp;&vert;&nbsp;
-					<a href="` + pendingURL + `">Pending</a>
-				</div>`
+			html += contentStatusLinks(req, status, "public", "pending", "scheduled", "draft")
 
 			for i := range posts {
 				err := json.Unmarshal(posts[i], &p)
@@ -1317,12 +1596,7 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 			// get __pending posts of type t from the db
 			total, posts = db.Query(t+"__pending", opts)
 
-			html += `<div class="row externalable">
-					<span class="description">Status:</span> 
-					<a href="` + publicURL + `">Public</a>
-					&nbsp;&vert;&nbsp;
-					<span class="active">Pending</span>					
-				</div>`
+			html += contentStatusLinks(req, status, "public", "pending", "scheduled", "draft")
 
 			for i := len(posts) - 1; i >= 0; i-- {
 				err := json.Unmarshal(posts[i], &p)
@@ -1366,6 +1640,8 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 	} else {
 		total, posts = db.Query(t+specifier, opts)
 
+		html += contentStatusLinks(req, status, "public", "scheduled", "draft")
+
 		for i := range posts {
 			err := json.Unmarshal(posts[i], &p)
 			if err != nil {
@@ -1487,7 +1763,7 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		$(function() {
 			var del = $('.quick-delete-post.__ponzu span');
 			del.on('click', function(e) {
-				if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nThis cannot be undone.")) {
+				if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nPublic posts are moved to the Trash.")) {
 					$(e.target).parent().submit();
 				}
 			});
@@ -1515,9 +1791,15 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 				</a>`
 	}
 
+	btn += `<br/>
+			<a href="/admin/trash?type=` + t + `" class="grey darken-1 btn trash-post waves-effect waves-light">
+				<i class="material-icons left">delete</i>
+				Trash
+			</a>`
+
 	html += b.String() + script + btn + `</div></div>`
 
-	adminView, err := Admin([]byte(html))
+	adminView, err := AdminFor(req, []byte(html))
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -1528,6 +1810,36 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 	res.Write(adminView)
 }
 
+// contentStatusLinks creates the row of links between the lists of content in
+// each of the statuses, with the current status shown as active
+func contentStatusLinks(req *http.Request, current string, statuses ...string) string {
+	if current == "" {
+		current = "public"
+	}
+
+	// always start from top of results when changing status
+	q := req.URL.Query()
+	q.Del("count")
+	q.Del("offset")
+
+	links := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		name := strings.Title(status)
+		if status == current {
+			links = append(links, `<span class="active">`+name+`</span>`)
+			continue
+		}
+
+		q.Set("status", status)
+		links = append(links, `<a href="`+req.URL.Path+"?"+q.Encode()+`">`+name+`</a>`)
+	}
+
+	return `<div class="row externalable">
+					<span class="description">Status:</span> 
+					` + strings.Join(links, " &nbsp;&vert;&nbsp; ") + `
+				</div>`
+}
+
 // adminPostListItem is a helper to create the li containing a post.
 // p is the asserted post as an Editable, t is the Type of the post.
 // specifier is passed to append a name to a namespace like __pending
@@ -1554,6 +1866,27 @@ func adminPostListItem(e editor.Editable, typeName, status string) []byte {
 
 	cid := fmt.Sprintf("%d", i.ItemID())
 
+	// show when scheduled content will be published, or when it expires
+	var scheduled string
+	if sc, ok := e.(item.Schedulable); ok {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		format := func(ms int64) string {
+			return time.Unix(ms/1000, 0).Format("01/02/06 03:04 PM")
+		}
+
+		switch {
+		case sc.PublishTime() > now:
+			scheduled = "Publishes: " + format(sc.PublishTime())
+		case sc.ExpireTime() > 0 && sc.ExpireTime() <= now:
+			scheduled = "Expired: " + format(sc.ExpireTime())
+		case sc.ExpireTime() > 0:
+			scheduled = "Expires: " + format(sc.ExpireTime())
+		}
+	}
+	if scheduled != "" {
+		scheduled = `<span class="post-detail">` + scheduled + `</span>`
+	}
+
 	switch status {
 	case "public", "":
 		status = ""
@@ -1571,6 +1904,7 @@ func adminPostListItem(e editor.Editable, typeName, status string) []byte {
 			<li class="col s12">
 				` + link + `
 				<span class="post-detail">Updated: ` + updatedTime + `</span>
+				` + scheduled + `
 				<span class="publish-date right">` + publishTime + `</span>
 
 				<form enctype="multipart/form-data" class="quick-delete-post __ponzu right" action="` + action + `" method="post">
@@ -1614,6 +1948,10 @@ func approveContentHandler(res http.ResponseWriter, req *http.Request) {
 		t = strings.Split(t, "__")[0]
 	}
 
+	if !allowed(res, req, user.ActionApprove, t) {
+		return
+	}
+
 	post := item.Types[t]()
 
 	// run hooks
@@ -1685,6 +2023,11 @@ func approveContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	var pending []byte
+	if pendingID != "" {
+		pending, _ = db.Content(req.FormValue("type") + ":" + pendingID)
+	}
+
 	// Store the content in the bucket t
 	id, err := db.SetContent(t+":-1", req.Form)
 	if err != nil {
@@ -1699,6 +2042,11 @@ func approveContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	approved, _ := db.Content(fmt.Sprintf("%s:%d", t, id))
+	from, _ := contentTarget(req.FormValue("type"), pendingID)
+	auditDetail(req, "content.approve", fmt.Sprintf("%s:%d", t, id), "approved from "+from, pending, approved)
+	notify(db.WebhookApprove, t, id, approved)
+
 	// set the target in the context so user can get saved value from db in hook
 	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 	req = req.WithContext(ctx)
@@ -1735,11 +2083,26 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			fmt.Fprintf(res, item.ErrTypeNotRegistered.Error(), t)
 			return
 		}
+		action := user.ActionView
+		if i == "" {
+			action = user.ActionCreate
+		}
+
+		if !allowed(res, req, action, t) {
+			return
+		}
+
 		post := contentType()
 
+		var etag string
 		if i != "" {
-			if status == "pending" {
+			switch status {
+			case "pending":
 				t = t + "__pending"
+			case "scheduled":
+				t = t + "__scheduled"
+			case "draft":
+				t = t + "__draft"
 			}
 
 			data, err := db.Content(t + ":" + i)
@@ -1778,6 +2141,8 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 				res.Write(errView)
 				return
 			}
+
+			etag = db.ContentETag(data)
 		} else {
 			item, ok := post.(item.Identifiable)
 			if !ok {
@@ -1788,7 +2153,7 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			item.SetItemID(-1)
 		}
 
-		m, err := manager.Manage(post.(editor.Editable), t)
+		m, err := manager.ManageWithETag(post.(editor.Editable), t, etag)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -1801,7 +2166,25 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		adminView, err := Admin(m)
+		// show the history of saved public or scheduled content below the editor
+		if i != "" && status != "pending" && status != "draft" {
+			revs, err := RevisionsList(strings.TrimSuffix(t, "__scheduled"), i)
+			if err != nil {
+				log.Println(err)
+				res.WriteHeader(http.StatusInternalServerError)
+				errView, err := Error500()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+
+			m = append(m, revs...)
+		}
+
+		adminView, err := AdminFor(req, m)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -1812,7 +2195,11 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 		res.Write(adminView)
 
 	case http.MethodPost:
-		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		err := upload.ParseForm(res, req)
+		if uerr, ok := err.(*upload.Error); ok {
+			uploadRejected(res, uerr)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -1840,7 +2227,37 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			req.PostForm.Set("updated", ts)
 		}
 
-		urlPaths, err := upload.StoreFiles(req)
+		// the ETag of the content when the editor was opened, used to detect
+		// changes saved by someone else since then
+		ifMatch := req.Header.Get("If-Match")
+		if ifMatch == "" {
+			ifMatch = req.PostForm.Get("__etag")
+		}
+		req.PostForm.Del("__etag")
+
+		if cid == "-1" {
+			ifMatch = ""
+		}
+
+		action := user.ActionEdit
+		if cid == "-1" {
+			action = user.ActionCreate
+		}
+
+		if !allowed(res, req, action, strings.Split(t, "__")[0]) {
+			return
+		}
+
+		var policy upload.Policy
+		if p, ok := item.Types[strings.Split(t, "__")[0]]; ok {
+			policy = upload.PolicyFor(p())
+		}
+
+		urlPaths, err := upload.StoreFiles(req, policy)
+		if uerr, ok := err.(*upload.Error); ok {
+			uploadRejected(res, uerr)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -1910,6 +2327,13 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			pt = strings.Split(t, "__")[0]
 		}
 
+		// save to the draft of the content, leaving any published version
+		// unchanged until the draft is published
+		if req.URL.Query().Get("status") == "draft" && t != pt+"__draft" {
+			t = pt + "__draft"
+			ifMatch = ""
+		}
+
 		p, ok := item.Types[pt]
 		if !ok {
 			log.Println("Type", t, "is not a content type. Cannot edit or save.")
@@ -1954,6 +2378,27 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if ifMatch != "" {
+			current, err := db.Content(t + ":" + cid)
+			if err != nil {
+				log.Println(err)
+				res.WriteHeader(http.StatusInternalServerError)
+				errView, err := Error500()
+				if err != nil {
+					return
+				}
+
+				res.Write(errView)
+				return
+			}
+
+			if len(current) == 0 || !db.MatchETag(ifMatch, db.ContentETag(current), false) {
+				log.Println("Rejected stale edit in editHandler for:", t, cid)
+				contentConflict(res, req, t, cid, current)
+				return
+			}
+		}
+
 		if cid == "-1" {
 			err = hook.BeforeAdminCreate(res, req)
 			if err != nil {
@@ -1974,7 +2419,23 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		id, err := db.SetContent(t+":"+cid, req.PostForm)
+		var before []byte
+		if cid != "-1" {
+			before, _ = db.Content(t + ":" + cid)
+		}
+
+		var id int
+		err = db.UpdateIfMatch(t+":"+cid, ifMatch, func() error {
+			var err error
+			id, err = db.SetContentBy(t+":"+cid, currentEmail(req), req.PostForm)
+			return err
+		})
+		if err == db.ErrPreconditionFailed {
+			log.Println("Rejected stale edit in editHandler for:", t, cid)
+			current, _ := db.Content(t + ":" + cid)
+			contentConflict(res, req, t, cid, current)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -1987,6 +2448,25 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		after, _ := db.Content(fmt.Sprintf("%s:%d", t, id))
+		if len(after) == 0 {
+			after, _ = db.Content(fmt.Sprintf("%s__scheduled:%d", pt, id))
+		}
+
+		change := "content.update"
+		if cid == "-1" {
+			change = "content.create"
+		}
+
+		target, status := contentTarget(t, id)
+		auditDetail(req, change, target, status, before, after)
+
+		if cid == "-1" {
+			notify(db.WebhookCreate, t, id, after)
+		} else {
+			notify(db.WebhookUpdate, t, id, after)
+		}
+
 		// set the target in the context so user can get saved value from db in hook
 		ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 		req = req.WithContext(ctx)
@@ -2017,8 +2497,15 @@ func editHandler(res http.ResponseWriter, req *http.Request) {
 		sid := fmt.Sprintf("%d", id)
 		redir := scheme + host + path + "?type=" + pt + "&id=" + sid
 
-		if req.URL.Query().Get("status") == "pending" {
-			redir += "&status=pending"
+		switch req.URL.Query().Get("status") {
+		case "pending", "draft":
+			redir += "&status=" + req.URL.Query().Get("status")
+		default:
+			if scheduled, err := db.Content(pt + "__scheduled:" + sid); err == nil && len(scheduled) > 0 {
+				// content saved with a publish time in the future, or which has
+				// expired, is kept in the __scheduled bucket
+				redir += "&status=scheduled"
+			}
 		}
 
 		http.Redirect(res, req, redir, http.StatusFound)
@@ -2075,6 +2562,15 @@ func deleteHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	action := user.ActionDelete
+	if req.URL.Query().Get("reject") == "true" {
+		action = user.ActionApprove
+	}
+
+	if !allowed(res, req, action, ct) {
+		return
+	}
+
 	post := p()
 	hook, ok := post.(item.Hookable)
 	if !ok {
@@ -2128,6 +2624,15 @@ func deleteHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	target, status := contentTarget(t, id)
+	if reject == "true" {
+		auditDetail(req, "content.reject", target, status, data, nil)
+		notify(db.WebhookReject, ct, id, data)
+	} else {
+		auditDetail(req, "content.delete", target, status, data, nil)
+		notify(db.WebhookDelete, t, id, data)
+	}
+
 	err = hook.AfterDelete(res, req)
 	if err != nil {
 		log.Println("Error running AfterDelete method in deleteHandler for:", t, err)
@@ -2180,6 +2685,10 @@ func deleteUploadHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !allowedUpload(res, req, user.ActionDelete) {
+		return
+	}
+
 	post := interface{}(&item.FileUpload{})
 	hook, ok := post.(item.Hookable)
 	if !ok {
@@ -2201,16 +2710,9 @@ func deleteUploadHandler(res http.ResponseWriter, req *http.Request) {
 	}
 
 	dbTarget := t + ":" + id
+	stored, _ := db.Upload(dbTarget)
 
-	// delete from file system, if good, we continue to delete
-	// from database, if bad error 500
-	err = deleteUploadFromDisk(dbTarget)
-	if err != nil {
-		log.Println(err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
+	// delete from storage and database, if bad error 500
 	err = db.DeleteUpload(dbTarget)
 	if err != nil {
 		log.Println(err)
@@ -2218,6 +2720,8 @@ func deleteUploadHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	audit(req, "upload.delete", "upload:"+id, stored, nil)
+
 	err = hook.AfterDelete(res, req)
 	if err != nil {
 		log.Println("Error running AfterDelete method in deleteHandler for:", t, err)
@@ -2235,6 +2739,15 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 		i := q.Get("id")
 		t := "__uploads"
 
+		action := user.ActionView
+		if i == "" {
+			action = user.ActionCreate
+		}
+
+		if !allowedUpload(res, req, action) {
+			return
+		}
+
 		post := &item.FileUpload{}
 
 		if i != "" {
@@ -2297,7 +2810,13 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		adminView, err := Admin(m)
+		sizes, err := uploadSizesView(post)
+		if err != nil {
+			log.Println("Error rendering image sizes:", err)
+		}
+		m = append(m, sizes...)
+
+		adminView, err := AdminFor(req, m)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -2308,7 +2827,11 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 		res.Write(adminView)
 
 	case http.MethodPost:
-		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		err := upload.ParseForm(res, req)
+		if uerr, ok := err.(*upload.Error); ok {
+			uploadRejected(res, uerr)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -2336,6 +2859,15 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 			req.PostForm.Set("updated", ts)
 		}
 
+		action := user.ActionEdit
+		if req.FormValue("id") == "-1" {
+			action = user.ActionCreate
+		}
+
+		if !allowedUpload(res, req, action) {
+			return
+		}
+
 		post := interface{}(&item.FileUpload{})
 		hook, ok := post.(item.Hookable)
 		if !ok {
@@ -2357,7 +2889,11 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 		}
 
 		// StoreFiles has the SetUpload call (which is equivalent of SetContent in other handlers)
-		urlPaths, err := upload.StoreFiles(req)
+		urlPaths, err := upload.StoreFiles(req, upload.Policy{})
+		if uerr, ok := err.(*upload.Error); ok {
+			uploadRejected(res, uerr)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -2433,7 +2969,24 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 		http.Redirect(res, req, redir, http.StatusFound)
 
 	case http.MethodPut:
-		urlPaths, err := upload.StoreFiles(req)
+		err := upload.ParseForm(res, req)
+		if uerr, ok := err.(*upload.Error); ok {
+			log.Println("Rejected file upload:", uerr)
+			http.Error(res, uerr.Error(), uerr.Status)
+			return
+		}
+		if err != nil {
+			log.Println("Couldn't parse file uploads.", err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		urlPaths, err := upload.StoreFiles(req, upload.Policy{})
+		if uerr, ok := err.(*upload.Error); ok {
+			log.Println("Rejected file upload:", uerr)
+			http.Error(res, uerr.Error(), uerr.Status)
+			return
+		}
 		if err != nil {
 			log.Println("Couldn't store file uploads.", err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -2455,7 +3008,7 @@ func editUploadHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	urlPaths, err := upload.StoreFiles(req)
+	urlPaths, err := upload.StoreFiles(req, upload.Policy{})
 	if err != nil {
 		log.Println("Couldn't store file uploads.", err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -2491,6 +3044,10 @@ func searchHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !allowed(res, req, user.ActionView, t) {
+		return
+	}
+
 	post := pt()
 
 	p := post.(editor.Editable)
@@ -2575,7 +3132,7 @@ func searchHandler(res http.ResponseWriter, req *http.Request) {
 		$(function() {
 			var del = $('.quick-delete-post.__ponzu span');
 			del.on('click', function(e) {
-				if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nThis cannot be undone.")) {
+				if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this post?\nPublic posts are moved to the Trash.")) {
 					$(e.target).parent().submit();
 				}
 			});
@@ -2597,7 +3154,7 @@ func searchHandler(res http.ResponseWriter, req *http.Request) {
 
 	html += b.String() + script + btn + `</div></div>`
 
-	adminView, err := Admin([]byte(html))
+	adminView, err := AdminFor(req, []byte(html))
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -2700,7 +3257,7 @@ func uploadSearchHandler(res http.ResponseWriter, req *http.Request) {
 	btn := `<div class="col s3"><a href="/admin/edit/upload" class="btn new-post waves-effect waves-light">New Upload</a></div></div>`
 	html = html + b.String() + btn
 
-	adminView, err := Admin([]byte(html))
+	adminView, err := AdminFor(req, []byte(html))
 	if err != nil {
 		log.Println(err)
 		res.WriteHeader(http.StatusInternalServerError)
@@ -2800,7 +3357,7 @@ func addonsHandler(res http.ResponseWriter, req *http.Request) {
 			}
 		}
 
-		view, err := Admin(html.Bytes())
+		view, err := AdminFor(req, html.Bytes())
 		if err != nil {
 			log.Println("Error writing addon html to admin view:", err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -2919,6 +3476,8 @@ func addonsHandler(res http.ResponseWriter, req *http.Request) {
 				return
 			}
 
+			audit(req, "addon.enable", id, nil, nil)
+
 			err = h.AfterEnable(res, req)
 			if err != nil {
 				log.Println(err)
@@ -2959,6 +3518,8 @@ func addonsHandler(res http.ResponseWriter, req *http.Request) {
 				return
 			}
 
+			audit(req, "addon.disable", id, nil, nil)
+
 			err = h.AfterDisable(res, req)
 			if err != nil {
 				log.Println(err)
@@ -3041,7 +3602,7 @@ func addonHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		addonView, err := Admin(m)
+		addonView, err := AdminFor(req, m)
 		if err != nil {
 			log.Println(err)
 			res.WriteHeader(http.StatusInternalServerError)
@@ -3092,6 +3653,7 @@ func addonHandler(res http.ResponseWriter, req *http.Request) {
 			}
 		}
 
+		before, _ := db.Addon(id)
 		err = db.SetAddon(req.Form, at())
 		if err != nil {
 			log.Println("Error saving addon:", name, err)
@@ -3105,6 +3667,9 @@ func addonHandler(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		after, _ := db.Addon(id)
+		audit(req, "addon.update", id, before, after)
+
 		http.Redirect(res, req, "/admin/addon?id="+id, http.StatusFound)
 
 	default:
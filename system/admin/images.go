@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/imaging"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+var uploadSizesHTML = `
+<div class="card upload-sizes">
+<div class="card-content">
+    <div class="card-title">Image Sizes</div>
+    <p>Resize this image with <code>w</code>, <code>h</code>, <code>fit</code>, <code>fm</code> and <code>q</code> parameters, or one of the named sizes below, which are set in the <a href="/admin/configure">Configuration</a>. Unless any size is allowed there, only the named sizes may be requested by visitors who aren't logged in.
+    {{ if .Upload.Width }}The original is {{ .Upload.Width }} &times; {{ .Upload.Height }} pixels.{{ end }}</p>
+    {{ if .Sizes }}
+    <div class="row">
+        {{ range .Sizes }}
+        <div class="col s12 m6 l4">
+            <a href="{{ $.Upload.Path }}?size={{ .Name }}" target="_blank">
+                <img class="responsive-img" src="{{ $.Upload.Path }}?size={{ .Name }}" alt="{{ .Name }}"/>
+            </a>
+            <p><strong>{{ .Name }}</strong> <span class="grey-text">{{ .Options }}</span></p>
+            <input type="text" readonly value="{{ $.Upload.Path }}?size={{ .Name }}" onclick="this.select()"/>
+        </div>
+        {{ end }}
+    </div>
+    {{ else }}
+    <p>No sizes are named yet.</p>
+    {{ end }}
+</div>
+</div>
+`
+
+// imageSizes returns the named sizes of images from the configuration
+func imageSizes() []imaging.Size {
+	text, _ := db.ConfigCache("image_sizes").(string)
+	sizes, err := imaging.ParseSizes(text)
+	if err != nil {
+		log.Println("Error parsing image sizes:", err)
+		return nil
+	}
+
+	return sizes
+}
+
+// imageAnySize reports whether the request may ask for images in any size,
+// rather than only the named sizes, which every request may ask for
+func imageAnySize(req *http.Request) bool {
+	allowed, _ := db.ConfigCache("image_any_size").(bool)
+	return allowed || user.IsValid(req)
+}
+
+// imageCacheSize returns the most the cached variants of images may take up on
+// disk from the configuration, or 0 if there is no limit
+func imageCacheSize() int64 {
+	mb, _ := db.ConfigCache("image_cache_max_size").(float64)
+	switch {
+	case mb < 0:
+		return 0
+	case mb == 0:
+		return imaging.DefaultCacheSize
+	}
+
+	return int64(mb * 1024 * 1024)
+}
+
+// uploadSizesView lists the named sizes of an uploaded image, or returns nil if
+// the upload isn't an image which can be resized
+func uploadSizesView(upload *item.FileUpload) ([]byte, error) {
+	switch strings.ToLower(upload.ContentType) {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, nil
+	}
+
+	tmpl, err := template.New("upload-sizes").Parse(uploadSizesHTML)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Upload *item.FileUpload
+		Sizes  []imaging.Size
+	}{
+		Upload: upload,
+		Sizes:  imageSizes(),
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
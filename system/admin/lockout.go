@@ -0,0 +1,232 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+var lockoutsHTML = `
+<div class="card lockouts">
+<div class="card-content">
+    <div class="card-title">Login Lockouts</div>
+    <p>IP addresses and accounts with failed attempts to log in or recover an account in the last {{ .Window }}. Each failure doubles the wait before the next attempt, and after {{ .Threshold }} failures for an account ({{ .ThresholdIP }} for an IP address) it is locked out for at least {{ .Duration }}.</p>
+    <ul class="row">
+    {{ range .Lockouts }}
+        <li class="col s12">
+            {{ .Key }}
+            <span class="post-detail">Failures: {{ .Failures }}</span>
+            <span class="post-detail">Last failure: {{ date .Last }}</span>
+            {{ if .IsLocked }}<span class="post-detail red-text">Locked until: {{ date .Until }}</span>{{ end }}
+            <form enctype="multipart/form-data" class="clear-lockout __ponzu right" action="/admin/configure/lockouts" method="post">
+                <span>Clear</span>
+                <input type="hidden" name="key" value="{{ .Key }}"/>
+            </form>
+        </li>
+    {{ else }}
+        <li class="col s12">There are no recent failed attempts.</li>
+    {{ end }}
+    </ul>
+</div>
+</div>
+<script>
+    $(function() {
+        $('.clear-lockout.__ponzu span').on('click', function(e) {
+            $(e.target).parent().submit();
+        });
+    });
+</script>
+`
+
+// loginKeys returns the lockout keys which throttle attempts to log in to, or
+// recover, the account from the request's IP address
+func loginKeys(req *http.Request, email string) []string {
+	keys := []string{db.LockoutIP(user.RemoteIP(req))}
+	if email != "" {
+		keys = append(keys, db.LockoutAccount(email))
+	}
+
+	return keys
+}
+
+// loginAttempt is an attempt to log in to, or recover, an account, which counts
+// against the lockouts of the account and the request's IP address from when it
+// starts until it ends
+type loginAttempt struct {
+	req   *http.Request
+	email string
+	ended bool
+}
+
+// startLogin starts an attempt to log in to, or recover, the account from the
+// request's IP address, or responds with 429 Too Many Requests and returns nil
+// if another attempt can't be made yet. The attempt must be released once the
+// request is handled, if it hasn't failed or succeeded.
+func startLogin(res http.ResponseWriter, req *http.Request, email string) *loginAttempt {
+	wait, err := db.LoginAttempt(loginKeys(req, email)...)
+	if err != nil {
+		log.Println("Error checking login lockout:", err)
+	}
+
+	if wait <= 0 {
+		return &loginAttempt{req: req, email: email}
+	}
+
+	secs := int64(wait/time.Second) + 1
+	res.Header().Set("Retry-After", fmt.Sprintf("%d", secs))
+	res.WriteHeader(http.StatusTooManyRequests)
+	res.Write([]byte(fmt.Sprintf("Too many failed attempts, please try again in %s.", time.Duration(secs)*time.Second)))
+	return nil
+}
+
+// failed records the attempt as a failure, and adds it to the audit log
+func (a *loginAttempt) failed(action string) {
+	if a.ended {
+		return
+	}
+	a.ended = true
+
+	lockouts, err := db.LoginFailed(loginKeys(a.req, a.email)...)
+	if err != nil {
+		log.Println("Error recording failed login:", err)
+	}
+
+	var detail []string
+	for _, l := range lockouts {
+		d := fmt.Sprintf("%s failures: %d", l.Key, l.Failures)
+		if l.IsLocked() {
+			d += ", locked out"
+		}
+
+		detail = append(detail, d)
+	}
+
+	err = db.Audit(db.AuditEntry{
+		Actor:  a.email,
+		Action: action,
+		IP:     user.RemoteIP(a.req),
+		Detail: strings.Join(detail, "; "),
+	})
+	if err != nil {
+		log.Println("Error adding audit entry:", err)
+	}
+}
+
+// succeeded ends the attempt and forgets the failed attempts for the account,
+// but not those from the request's IP address
+func (a *loginAttempt) succeeded() {
+	if a.ended {
+		return
+	}
+	a.ended = true
+
+	err := db.LoginSucceeded(loginKeys(a.req, a.email)...)
+	if err != nil {
+		log.Println("Error clearing login lockout:", err)
+	}
+}
+
+// release ends the attempt if it hasn't failed or succeeded, such as when it
+// couldn't be completed, or continues to the second login step
+func (a *loginAttempt) release() {
+	if a.ended {
+		return
+	}
+	a.ended = true
+
+	err := db.LoginReleased(loginKeys(a.req, a.email)...)
+	if err != nil {
+		log.Println("Error ending login attempt:", err)
+	}
+}
+
+func lockoutsHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		lockouts, err := db.Lockouts()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		funcs := template.FuncMap{
+			"date": func(ms int64) string {
+				return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04 PM")
+			},
+		}
+
+		buf := &bytes.Buffer{}
+		tmpl := template.Must(template.New("lockouts").Funcs(funcs).Parse(lockoutsHTML))
+		err = tmpl.Execute(buf, map[string]interface{}{
+			"Lockouts":    lockouts,
+			"Window":      db.LockoutWindow,
+			"Threshold":   db.LockoutThreshold,
+			"ThresholdIP": db.LockoutThresholdIP,
+			"Duration":    db.LockoutDuration,
+		})
+		if err != nil {
+			log.Println("Error executing lockouts template:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		adminView, err := AdminFor(req, buf.Bytes())
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(adminView)
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		key := req.PostFormValue("key")
+		err = db.ClearLockout(key)
+		if err != nil {
+			log.Println("Error clearing lockout:", key, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		audit(req, "lockout.clear", key, nil, nil)
+
+		http.Redirect(res, req, req.URL.String(), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+)
+
+// allowed reports whether the user making the request may take the action on
+// content of type t, and responds with 403 Forbidden if not
+func allowed(res http.ResponseWriter, req *http.Request, action, t string) bool {
+	usr, err := user.Current(req)
+	if err == nil && usr.Can(action, t) {
+		return true
+	}
+
+	forbidden(res, req, err)
+	return false
+}
+
+// allowedUpload reports whether the user making the request may take the action
+// on file uploads, and responds with 403 Forbidden if not
+func allowedUpload(res http.ResponseWriter, req *http.Request, action string) bool {
+	usr, err := user.Current(req)
+	if err == nil && usr.CanUpload(action) {
+		return true
+	}
+
+	forbidden(res, req, err)
+	return false
+}
+
+// isAdmin reports whether the user making the request has the admin role
+func isAdmin(req *http.Request) bool {
+	usr, err := user.Current(req)
+	return err == nil && usr.IsAdmin()
+}
+
+func forbidden(res http.ResponseWriter, req *http.Request, err error) {
+	if err != nil {
+		log.Println("Error finding user:", err)
+	}
+
+	log.Println("Forbidden:", req.Method, req.URL.String())
+	res.WriteHeader(http.StatusForbidden)
+	errView, err := Error403()
+	if err != nil {
+		return
+	}
+
+	res.Write(errView)
+}
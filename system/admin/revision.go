@@ -0,0 +1,346 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+
+	"github.com/tidwall/gjson"
+)
+
+var revisionsHTML = `
+<div class="card revisions">
+<div class="card-content">
+    <div class="card-title">Revision History</div>
+    {{ if .Revisions }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>Revision</th>
+                <th>Saved</th>
+                <th>Author</th>
+                <th></th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range $i, $rev := .Revisions }}
+            <tr>
+                <td>#{{ $rev.ID }}</td>
+                <td>{{ date $rev.Timestamp }}</td>
+                <td>{{ if $rev.Author }}{{ $rev.Author }}{{ else }}&mdash;{{ end }}</td>
+                <td>{{ if eq $i 0 }}Current{{ else }}<a href="/admin/edit/revision?type={{ $.Type }}&id={{ $.ID }}&rev={{ $rev.ID }}">Compare &amp; Restore</a>{{ end }}</td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ else }}
+    <p>No revisions have been saved for this content yet.</p>
+    {{ end }}
+</div>
+</div>
+`
+
+var revisionHTML = `
+<div class="card revision">
+<div class="card-content">
+    <div class="card-title">Revision #{{ .Revision.ID }} of {{ .Type }} {{ .ID }}</div>
+    <blockquote>Saved {{ date .Revision.Timestamp }}{{ if .Revision.Author }} by {{ .Revision.Author }}{{ end }}. Changed fields are compared with the current version below.</blockquote>
+    {{ if .Fields }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>Field</th>
+                <th>Revision #{{ .Revision.ID }}</th>
+                <th>Current version</th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range .Fields }}
+            <tr>
+                <td>{{ .Name }}</td>
+                <td>{{ .Revision }}</td>
+                <td>{{ .Current }}</td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ else }}
+    <p>This revision has the same content as the current version.</p>
+    {{ end }}
+</div>
+<div class="card-action">
+    <form method="post" action="/admin/edit/revision" enctype="multipart/form-data" class="right">
+        <input type="hidden" name="type" value="{{ .Type }}"/>
+        <input type="hidden" name="id" value="{{ .ID }}"/>
+        <input type="hidden" name="rev" value="{{ .Revision.ID }}"/>
+        <button class="btn waves-effect waves-light" type="submit">Restore this revision</button>
+    </form>
+    <a class="btn-flat" href="/admin/edit?type={{ .Type }}&id={{ .ID }}">Back to editor</a>
+</div>
+</div>
+`
+
+var revisionFuncs = template.FuncMap{
+	"date": func(ms int64) string {
+		return time.Unix(0, ms*int64(time.Millisecond)).Format("Jan 2, 2006 3:04 PM")
+	},
+}
+
+type revisionField struct {
+	Name     string
+	Revision string
+	Current  string
+}
+
+// fields which are set by the system on every save, and aren't compared
+var revisionIgnoreFields = map[string]bool{
+	"id":      true,
+	"uuid":    true,
+	"updated": true,
+}
+
+// diffFields compares the top-level fields of two json objects, and returns
+// those which differ, sorted by name
+func diffFields(rev, current []byte) ([]revisionField, error) {
+	var r, c map[string]json.RawMessage
+	err := json.Unmarshal(rev, &r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(current, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for k := range r {
+		names[k] = true
+	}
+	for k := range c {
+		names[k] = true
+	}
+
+	var fields []revisionField
+	for name := range names {
+		if revisionIgnoreFields[name] {
+			continue
+		}
+
+		rv, cv := gjson.ParseBytes(r[name]).String(), gjson.ParseBytes(c[name]).String()
+		if rv != cv {
+			fields = append(fields, revisionField{Name: name, Revision: rv, Current: cv})
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Name < fields[j].Name
+	})
+
+	return fields, nil
+}
+
+// currentEmail returns the email of the admin user making the request, or an
+// empty string if the user can't be found
+func currentEmail(req *http.Request) string {
+	j, err := db.CurrentUser(req)
+	if err != nil {
+		return ""
+	}
+
+	var usr user.User
+	err = json.Unmarshal(j, &usr)
+	if err != nil {
+		return ""
+	}
+
+	return usr.Email
+}
+
+// RevisionsList creates a subview listing the revision history of the content
+// of type t with the id provided, to be shown below its editor
+func RevisionsList(t, id string) ([]byte, error) {
+	revs, err := db.Revisions(t + ":" + id)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("revisions").Funcs(revisionFuncs).Parse(revisionsHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Type":      t,
+		"ID":        id,
+		"Revisions": revs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func revisionHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		t := q.Get("type")
+		id := q.Get("id")
+
+		if _, ok := item.Types[t]; !ok || !db.IsValidID(id) {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		if !allowed(res, req, user.ActionView, t) {
+			return
+		}
+
+		rev, err := strconv.Atoi(q.Get("rev"))
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		target := t + ":" + id
+		r, err := db.ContentRevision(target, rev)
+		if err != nil {
+			log.Println("Error finding revision:", target, rev, err)
+			res.WriteHeader(http.StatusNotFound)
+			errView, err := Error404()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		current, err := db.Content(target)
+		if err != nil || len(current) == 0 {
+			log.Println("Error finding content for revision:", target, err)
+			res.WriteHeader(http.StatusNotFound)
+			errView, err := Error404()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		fields, err := diffFields(r.Data, current)
+		if err != nil {
+			log.Println("Error comparing revision:", target, rev, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		tmpl := template.Must(template.New("revision").Funcs(revisionFuncs).Parse(revisionHTML))
+		err = tmpl.Execute(buf, map[string]interface{}{
+			"Type":     t,
+			"ID":       id,
+			"Revision": r,
+			"Fields":   fields,
+		})
+		if err != nil {
+			log.Println("Error executing revision template:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		adminView, err := AdminFor(req, buf.Bytes())
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(adminView)
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		t := req.FormValue("type")
+		id := req.FormValue("id")
+		rev, err := strconv.Atoi(req.FormValue("rev"))
+		if _, ok := item.Types[t]; !ok || !db.IsValidID(id) || err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		if !allowed(res, req, user.ActionEdit, t) {
+			return
+		}
+
+		before, _ := db.Content(t + ":" + id)
+		_, err = db.RestoreRevision(t+":"+id, rev, currentEmail(req))
+		if err != nil {
+			log.Println("Error restoring revision:", t, id, rev, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		after, _ := db.Content(t + ":" + id)
+		auditDetail(req, "content.update", t+":"+id, fmt.Sprintf("restored revision #%d", rev), before, after)
+		notify(db.WebhookUpdate, t, id, after)
+
+		redir := fmt.Sprintf("/admin/edit?type=%s&id=%s", t, id)
+		http.Redirect(res, req, redir, http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
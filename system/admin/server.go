@@ -13,6 +13,8 @@ import (
 	"github.com/ponzu-cms/ponzu/system/admin/user"
 	"github.com/ponzu-cms/ponzu/system/api"
 	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/imaging"
+	"github.com/ponzu-cms/ponzu/system/storage"
 )
 
 // Run adds Handlers to default http listener for Admin
@@ -22,6 +24,7 @@ func Run() {
 	http.HandleFunc("/admin/init", initHandler)
 
 	http.HandleFunc("/admin/login", loginHandler)
+	http.HandleFunc("/admin/login/2fa", loginTwoFactorHandler)
 	http.HandleFunc("/admin/logout", logoutHandler)
 
 	http.HandleFunc("/admin/recover", forgotPasswordHandler)
@@ -34,6 +37,20 @@ func Run() {
 	http.HandleFunc("/admin/configure/users", user.Auth(configUsersHandler))
 	http.HandleFunc("/admin/configure/users/edit", user.Auth(configUsersEditHandler))
 	http.HandleFunc("/admin/configure/users/delete", user.Auth(configUsersDeleteHandler))
+	http.HandleFunc("/admin/configure/users/role", user.Auth(configUsersRoleHandler))
+	http.HandleFunc("/admin/configure/users/sessions", user.Auth(configUsersSessionsHandler))
+	http.HandleFunc("/admin/configure/apikeys", user.Auth(apiKeysHandler))
+	http.HandleFunc("/admin/configure/apikeys/revoke", user.Auth(revokeAPIKeyHandler))
+	http.HandleFunc("/admin/configure/lockouts", user.Auth(lockoutsHandler))
+	http.HandleFunc("/admin/configure/webhooks", user.Auth(webhooksHandler))
+	http.HandleFunc("/admin/configure/webhooks/delete", user.Auth(deleteWebhookHandler))
+	http.HandleFunc("/admin/configure/webhooks/deliveries", user.Auth(webhookDeliveriesHandler))
+	http.HandleFunc("/admin/configure/webhooks/redeliver", user.Auth(redeliverWebhookHandler))
+	http.HandleFunc("/admin/configure/audit", user.Auth(auditHandler))
+	http.HandleFunc("/admin/configure/audit/export", user.Auth(auditExportHandler))
+	http.HandleFunc("/admin/configure/backups", user.Auth(backupsHandler))
+	http.HandleFunc("/admin/configure/backups/download", user.Auth(downloadBackupHandler))
+	http.HandleFunc("/admin/configure/backups/restore", user.Auth(restoreBackupHandler))
 
 	http.HandleFunc("/admin/uploads", user.Auth(uploadContentsHandler))
 	http.HandleFunc("/admin/uploads/search", user.Auth(uploadSearchHandler))
@@ -41,10 +58,14 @@ func Run() {
 	http.HandleFunc("/admin/contents", user.Auth(contentsHandler))
 	http.HandleFunc("/admin/contents/search", user.Auth(searchHandler))
 	http.HandleFunc("/admin/contents/export", user.Auth(exportHandler))
+	http.HandleFunc("/admin/trash", user.Auth(trashHandler))
 
 	http.HandleFunc("/admin/edit", user.Auth(editHandler))
 	http.HandleFunc("/admin/edit/delete", user.Auth(deleteHandler))
 	http.HandleFunc("/admin/edit/approve", user.Auth(approveContentHandler))
+	http.HandleFunc("/admin/edit/revision", user.Auth(revisionHandler))
+	http.HandleFunc("/admin/edit/publish", user.Auth(publishDraftHandler))
+	http.HandleFunc("/admin/edit/preview", user.Auth(previewHandler))
 	http.HandleFunc("/admin/edit/upload", user.Auth(editUploadHandler))
 	http.HandleFunc("/admin/edit/upload/delete", user.Auth(deleteUploadHandler))
 
@@ -55,8 +76,9 @@ func Run() {
 	// API path needs to be registered within server package so that it is handled
 	// even if the API server is not running. Otherwise, images/files uploaded
 	// through the editor will not load within the admin system.
-	uploadsDir := cfg.UploadDir()
-	http.Handle("/api/uploads/", api.Record(api.CORS(db.CacheControl(http.StripPrefix("/api/uploads/", http.FileServer(restrict(http.Dir(uploadsDir))))))))
+	cache := &imaging.Cache{Dir: cfg.ImageCacheDir(), MaxBytes: imageCacheSize}
+	uploads := imaging.Handler(storage.Uploads(), cache, imageSizes, imageAnySize)
+	http.Handle("/api/uploads/", api.Record(api.CORS(db.CacheControl(http.StripPrefix("/api/uploads/", uploads)))))
 
 	// Database & uploads backup via HTTP route registered with Basic Auth middleware.
 	http.HandleFunc("/admin/backup", system.BasicAuth(backupHandler))
@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/nilslice/jwt"
+)
+
+// sessionTTL is how long a user stays logged in
+const sessionTTL = time.Hour * 24 * 7
+
+var sessionsHTML = `
+<div class="card user-management">
+    <div class="card-title">Sessions for {{ .Email }}</div>
+    <p>Each device or browser logged in as {{ .Email }}. Ending a session logs it out the next time it is used.</p>
+    <ul class="sessions row">
+    {{ range .Sessions }}
+        <li class="col s12">
+            {{ device .UserAgent }}{{ if eq .ID $.Current }} <strong>(this session)</strong>{{ end }}
+            <span class="post-detail">IP: {{ .IP }}</span>
+            <span class="post-detail">Last seen: {{ date .LastSeen }}</span>
+            <span class="post-detail">Logged in: {{ date .Created }}</span>
+            {{ if ne .ID $.Current }}
+            <form enctype="multipart/form-data" class="end-session __ponzu right" action="/admin/configure/users/sessions" method="post">
+                <span>End Session</span>
+                <input type="hidden" name="email" value="{{ $.Email }}"/>
+                <input type="hidden" name="id" value="{{ .ID }}"/>
+            </form>
+            {{ end }}
+        </li>
+    {{ else }}
+        <li class="col s12">There are no active sessions.</li>
+    {{ end }}
+    </ul>
+    <form class="row" enctype="multipart/form-data" action="/admin/configure/users/sessions" method="post">
+        <input type="hidden" name="email" value="{{ .Email }}"/>
+        <input type="hidden" name="all" value="true"/>
+        <div class="col s9">
+            <button class="btn waves-effect waves-light red right" type="submit">Log Out Everywhere</button>
+        </div>
+    </form>
+    <a href="/admin/configure/users">Back to users</a>
+</div>
+<script>
+    $(function() {
+        $('.end-session.__ponzu span').on('click', function(e) {
+            $(e.target).parent().submit();
+        });
+    });
+</script>
+`
+
+// setLoginToken starts a session for the user, and adds the token cookie which
+// logs them in until the session expires
+func setLoginToken(res http.ResponseWriter, req *http.Request, email string) error {
+	exp := time.Now().Add(sessionTTL)
+	s, err := db.NewSession(email, user.RemoteIP(req), req.UserAgent(), exp)
+	if err != nil {
+		return err
+	}
+
+	claims := map[string]interface{}{
+		"exp":             exp.Unix(),
+		"user":            email,
+		user.SessionClaim: s.ID,
+	}
+	token, err := jwt.New(claims)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:    "_token",
+		Value:   token,
+		Expires: exp,
+		Path:    "/",
+	})
+
+	return nil
+}
+
+// device returns a short description of the browser and OS from a User-Agent
+func device(ua string) string {
+	var browser, os string
+	for _, b := range []string{"Edg", "OPR", "Firefox", "Chrome", "Safari"} {
+		if strings.Contains(ua, b+"/") {
+			browser = strings.NewReplacer("Edg", "Edge", "OPR", "Opera").Replace(b)
+			break
+		}
+	}
+
+	for _, o := range []string{"Windows", "iPhone", "iPad", "Android", "Mac OS X", "Linux"} {
+		if strings.Contains(ua, o) {
+			os = strings.Replace(o, "Mac OS X", "macOS", 1)
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "" || os != "":
+		return browser + os
+	case ua != "":
+		return ua
+	}
+
+	return "Unknown device"
+}
+
+// sessionsEmail returns the email address of the user whose sessions may be
+// managed by the request: any user for admins, otherwise the current user
+func sessionsEmail(res http.ResponseWriter, req *http.Request, email string) (string, bool) {
+	current := currentEmail(req)
+	if email == "" {
+		email = current
+	}
+
+	if email != current && !isAdmin(req) {
+		forbidden(res, req, nil)
+		return "", false
+	}
+
+	return strings.ToLower(email), true
+}
+
+func configUsersSessionsHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		email, ok := sessionsEmail(res, req, req.URL.Query().Get("email"))
+		if !ok {
+			return
+		}
+
+		sessions, err := db.Sessions(email)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		funcs := template.FuncMap{
+			"date": func(ms int64) string {
+				return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04 PM")
+			},
+			"device": device,
+		}
+
+		buf := &bytes.Buffer{}
+		tmpl := template.Must(template.New("sessions").Funcs(funcs).Parse(sessionsHTML))
+		err = tmpl.Execute(buf, map[string]interface{}{
+			"Email":    email,
+			"Sessions": sessions,
+			"Current":  user.SessionID(req),
+		})
+		if err != nil {
+			log.Println("Error executing sessions template:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		adminView, err := AdminFor(req, buf.Bytes())
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(adminView)
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		email, ok := sessionsEmail(res, req, req.PostFormValue("email"))
+		if !ok {
+			return
+		}
+
+		if req.PostFormValue("all") == "true" {
+			err = db.RevokeSessions(email)
+		} else {
+			err = db.RevokeSession(email, req.PostFormValue("id"))
+		}
+		if err != nil {
+			log.Println("Error ending sessions for:", email, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		if req.PostFormValue("all") == "true" {
+			auditDetail(req, "user.session_revoke", email, "all sessions", nil, nil)
+		} else {
+			audit(req, "user.session_revoke", email, nil, nil)
+		}
+
+		redir := req.URL.Path + "?email=" + url.QueryEscape(email)
+		http.Redirect(res, req, redir, http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+var trashHTML = `
+<div class="col s9 card">
+<div class="card-content">
+    <div class="row">
+        <div class="card-title col s8">{{ .Type }} Trash</div>
+        <div class="col s4"><a class="right" href="/admin/contents?type={{ .Type }}">Back to {{ .Type }} Items</a></div>
+    </div>
+    <p>Deleted content is kept here for {{ if .Retention }}{{ .Retention }} days{{ else }}ever{{ end }}, and can be restored until it is deleted permanently.</p>
+    <ul class="posts row">
+    {{ range .Items }}
+        <li class="col s12">
+            {{ .Title }}
+            <span class="post-detail">Deleted: {{ .Deleted }}</span>
+            <form enctype="multipart/form-data" class="delete-trash __ponzu right" action="/admin/trash" method="post">
+                <span>Delete Permanently</span>
+                <input type="hidden" name="action" value="delete"/>
+                <input type="hidden" name="type" value="{{ $.Type }}"/>
+                <input type="hidden" name="id" value="{{ .ID }}"/>
+            </form>
+            <form enctype="multipart/form-data" class="restore-trash __ponzu right" action="/admin/trash" method="post">
+                <span>Restore</span>
+                <input type="hidden" name="action" value="restore"/>
+                <input type="hidden" name="type" value="{{ $.Type }}"/>
+                <input type="hidden" name="id" value="{{ .ID }}"/>
+            </form>
+        </li>
+    {{ else }}
+        <li class="col s12">The trash is empty.</li>
+    {{ end }}
+    </ul>
+</div>
+</div>
+<script>
+    $(function() {
+        $('.restore-trash.__ponzu span').on('click', function(e) {
+            $(e.target).parent().submit();
+        });
+
+        $('.delete-trash.__ponzu span').on('click', function(e) {
+            if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to permanently delete this post?\nThis cannot be undone.")) {
+                $(e.target).parent().submit();
+            }
+        });
+    });
+</script>
+`
+
+type trashListItem struct {
+	ID      string
+	Title   string
+	Deleted string
+}
+
+func trashHandler(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		t := req.URL.Query().Get("type")
+		it, ok := item.Types[t]
+		if !ok {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		if !allowed(res, req, user.ActionView, t) {
+			return
+		}
+
+		trash, err := db.Trash(t)
+		if err != nil {
+			log.Println("Error reading trash for", t, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		var items []trashListItem
+		for _, tr := range trash {
+			li := trashListItem{
+				ID:    tr.ID,
+				Title: t + " " + tr.ID,
+			}
+
+			p := it()
+			err := json.Unmarshal(tr.Data, p)
+			if err != nil {
+				log.Println("Error unmarshal json into", t, err, string(tr.Data))
+			} else if i, ok := p.(item.Identifiable); ok {
+				li.Title = i.String()
+			}
+
+			if !tr.Deleted.IsZero() {
+				li.Deleted = tr.Deleted.Format("01/02/06 03:04 PM")
+			}
+
+			items = append(items, li)
+		}
+
+		retention, _ := db.ConfigCache("trash_retention_days").(float64)
+		if retention == 0 {
+			retention = db.DefaultTrashRetention
+		}
+		if retention < 0 {
+			retention = 0
+		}
+
+		buf := &bytes.Buffer{}
+		tmpl := template.Must(template.New("trash").Parse(trashHTML))
+		err = tmpl.Execute(buf, map[string]interface{}{
+			"Type":      t,
+			"Items":     items,
+			"Retention": int64(retention),
+		})
+		if err != nil {
+			log.Println("Error executing trash template:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		adminView, err := AdminFor(req, buf.Bytes())
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html")
+		res.Write(adminView)
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		t := req.FormValue("type")
+		id := req.FormValue("id")
+		if _, ok := item.Types[t]; !ok || !db.IsValidID(id) {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// restoring content undoes its deletion, so needs the same permission
+		if !allowed(res, req, user.ActionDelete, t) {
+			return
+		}
+
+		action := req.FormValue("action")
+		switch action {
+		case "restore":
+			err = db.RestoreContent(t + ":" + id)
+		case "delete":
+			err = db.DeleteContent(t + "__trash:" + id)
+		default:
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+		if err != nil {
+			log.Println("Error updating trash for", t, id, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		auditDetail(req, "content."+action, t+":"+id, "trash", nil, nil)
+
+		if action == "restore" {
+			restored, _ := db.Content(t + ":" + id)
+			notify(db.WebhookCreate, t, id, restored)
+		}
+
+		http.Redirect(res, req, "/admin/trash?type="+url.QueryEscape(t), http.StatusFound)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
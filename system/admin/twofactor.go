@@ -0,0 +1,447 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/nilslice/jwt"
+)
+
+// pendingCookie holds the token of a user between the password and two-factor
+// login steps, which expires after pendingTTL
+const (
+	pendingCookie = "_2fa"
+	pendingTTL    = time.Minute * 5
+)
+
+var errPendingLogin = errors.New("No valid pending two-factor login")
+
+var twoFactorLoginHTML = `
+<div class="init col s5">
+<div class="card">
+<div class="card-content">
+    <div class="card-title">Two-Factor Authentication</div>
+    {{ if .Codes }}
+    <blockquote>Two-factor authentication is enabled. Keep these recovery codes somewhere safe. Each can be used once to log in if you lose access to your authenticator app, and they won't be shown again.</blockquote>
+    <pre class="recovery-codes">{{ range .Codes }}{{ . }}
+{{ end }}</pre>
+    <a class="btn waves-effect waves-light right" href="/admin">Continue</a>
+    {{ else }}
+    {{ if .Secret }}
+    <blockquote>Two-factor authentication is required. Add this key to an authenticator app, or open the link on your phone, then enter the 6-digit code it shows.</blockquote>
+    <p><code>{{ .Secret }}</code></p>
+    <p><a href="{{ .URI }}">Add to authenticator app</a></p>
+    {{ else }}
+    <blockquote>Enter the 6-digit code from your authenticator app, or one of your recovery codes.</blockquote>
+    {{ end }}
+    {{ if .Error }}<p class="red-text">{{ .Error }}</p>{{ end }}
+    <form method="post" action="/admin/login/2fa" class="row">
+        <input type="hidden" name="secret" value="{{ .Secret }}"/>
+        <div class="input-field col s12">
+            <input placeholder="Enter your code" class="validate required" type="text" id="code" name="code" autocomplete="one-time-code" autofocus/>
+            <label for="code" class="active">Code</label>
+        </div>
+        <a href="/admin/login">Start over</a>
+        <button class="btn waves-effect waves-light right">Verify</button>
+    </form>
+    {{ end }}
+</div>
+</div>
+</div>
+<script>
+    $(function() {
+        $('.nav-wrapper ul.right').hide();
+    });
+</script>
+`
+
+var twoFactorSettingsHTML = `
+<div class="card user-management">
+    <div class="card-title">Two-Factor Authentication</div>
+    {{ if .Codes }}
+    <blockquote>Keep these recovery codes somewhere safe. Each can be used once to log in if you lose access to your authenticator app, and they won't be shown again.</blockquote>
+    <pre class="recovery-codes">{{ range .Codes }}{{ . }}
+{{ end }}</pre>
+    {{ end }}
+
+    {{ if .Enabled }}
+    <p>Two-factor authentication is enabled for {{ .Email }}, with {{ .Remaining }} unused recovery codes.</p>
+    <form class="row" enctype="multipart/form-data" action="/admin/configure/users/edit" method="post">
+        <div class="col s9">
+            <label class="active">To make changes, enter your password:</label>
+            <input type="password" name="password"/>
+        </div>
+        <div class="col s9">
+            <button class="btn waves-effect waves-light right" type="submit" name="action" value="recovery_codes">New Recovery Codes</button>
+            {{ if not .Required }}
+            <button class="btn-flat waves-effect waves-light right" type="submit" name="action" value="disable_2fa">Disable</button>
+            {{ end }}
+        </div>
+    </form>
+    {{ else }}
+    <p>Add this key to an authenticator app, or open the link on your phone, then enter your password and the 6-digit code the app shows.</p>
+    <p><code>{{ .Secret }}</code></p>
+    <p><a href="{{ .URI }}">Add to authenticator app</a></p>
+    <form class="row" enctype="multipart/form-data" action="/admin/configure/users/edit" method="post">
+        <input type="hidden" name="secret" value="{{ .Secret }}"/>
+        <div class="col s9">
+            <label class="active">Current Password</label>
+            <input type="password" name="password"/>
+        </div>
+        <div class="col s9">
+            <label class="active">Code</label>
+            <input type="text" name="code" autocomplete="one-time-code"/>
+        </div>
+        <div class="col s9">
+            <button class="btn waves-effect waves-light green right" type="submit" name="action" value="enable_2fa">Enable</button>
+        </div>
+    </form>
+    {{ end }}
+    <a href="/admin/configure/users">Back to your account</a>
+</div>
+`
+
+// twoFactorRequired reports whether all users must log in with two-factor
+// authentication
+func twoFactorRequired() bool {
+	required, _ := db.ConfigCache("require_2fa").(bool)
+	return required
+}
+
+// totpIssuer is the name an authenticator app shows for this system
+func totpIssuer() string {
+	name, _ := db.ConfigCache("name").(string)
+	if name == "" {
+		name = "Ponzu"
+	}
+
+	return name
+}
+
+// setPendingLogin adds the cookie for a user who has logged in with a password,
+// but must still complete the two-factor login step
+func setPendingLogin(res http.ResponseWriter, email string) error {
+	exp := time.Now().Add(pendingTTL)
+	claims := map[string]interface{}{
+		"exp":             exp.Unix(),
+		"user":            email,
+		user.PendingClaim: true,
+	}
+	token, err := jwt.New(claims)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     pendingCookie,
+		Value:    token,
+		Expires:  exp,
+		Path:     "/admin/login",
+		HttpOnly: true,
+	})
+
+	return nil
+}
+
+func clearPendingLogin(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:    pendingCookie,
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+		Path:    "/admin/login",
+	})
+}
+
+// pendingUser returns the user with a valid, unexpired pending login
+func pendingUser(req *http.Request) (*user.User, error) {
+	cookie, err := req.Cookie(pendingCookie)
+	if err != nil || !jwt.Passes(cookie.Value) {
+		return nil, errPendingLogin
+	}
+
+	claims := jwt.GetClaims(cookie.Value)
+	exp, _ := claims["exp"].(float64)
+	email, _ := claims["user"].(string)
+	if _, ok := claims[user.PendingClaim]; !ok || email == "" || time.Now().Unix() > int64(exp) {
+		return nil, errPendingLogin
+	}
+
+	j, err := db.User(email)
+	if err != nil || j == nil {
+		return nil, errPendingLogin
+	}
+
+	usr := &user.User{}
+	err = json.Unmarshal(j, usr)
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
+// TwoFactorLogin returns the second login step, which shows the TOTP secret of
+// a user who must enroll, and the recovery codes once enrolled
+func TwoFactorLogin(email, secret, errMsg string, codes []string) ([]byte, error) {
+	html := startAdminHTML + twoFactorLoginHTML + endAdminHTML
+
+	cfg, err := db.Config("name")
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		cfg = []byte("")
+	}
+
+	var uri string
+	if secret != "" {
+		uri = user.TOTPURI(totpIssuer(), email, secret)
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("twoFactorLogin").Parse(html))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Logo":   string(cfg),
+		"Secret": secret,
+		"URI":    template.URL(uri),
+		"Error":  errMsg,
+		"Codes":  codes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func loginTwoFactorHandler(res http.ResponseWriter, req *http.Request) {
+	usr, err := pendingUser(req)
+	if err != nil {
+		http.Redirect(res, req, req.URL.Scheme+req.URL.Host+"/admin/login", http.StatusFound)
+		return
+	}
+
+	var secret, errMsg string
+	var codes []string
+
+	switch req.Method {
+	case http.MethodGet:
+		if !usr.HasTwoFactor() {
+			secret, err = user.NewTOTPSecret()
+			if err != nil {
+				log.Println(err)
+				res.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
+	case http.MethodPost:
+		err := req.ParseForm()
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		attempt := startLogin(res, req, usr.Email)
+		if attempt == nil {
+			return
+		}
+		defer attempt.release()
+
+		// the code is checked and the user saved together, so a code can't be
+		// used by two requests at once
+		code := req.FormValue("code")
+		var enrolling bool
+		_, err = db.UpdateUserWith(usr.Email, func(u *user.User) error {
+			if u.HasTwoFactor() {
+				if !u.VerifyTwoFactor(code) {
+					return user.ErrInvalidCode
+				}
+
+				return nil
+			}
+
+			// a user enrolls during login when two-factor authentication is
+			// required, and sees their recovery codes before continuing
+			enrolling = true
+			secret = req.FormValue("secret")
+
+			var err error
+			codes, err = u.EnableTwoFactor(secret, code)
+			return err
+		})
+		if err == user.ErrInvalidCode {
+			if !enrolling {
+				log.Println("Failed two-factor login for", usr.Email)
+				attempt.failed("login.2fa_fail")
+			}
+
+			errMsg = "The code is not valid, please try again."
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		err = setLoginToken(res, req, usr.Email)
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		clearPendingLogin(res)
+		attempt.succeeded()
+
+		if len(codes) == 0 {
+			http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/login/2fa"), http.StatusFound)
+			return
+		}
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	view, err := TwoFactorLogin(usr.Email, secret, errMsg, codes)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(view)
+}
+
+// twoFactorSettings returns the admin view to enable or disable two-factor
+// authentication for usr, with any recovery codes which were just created
+func twoFactorSettings(req *http.Request, usr *user.User, codes []string) ([]byte, error) {
+	var secret string
+	if !usr.HasTwoFactor() {
+		var err error
+		secret, err = user.NewTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("twoFactor").Parse(twoFactorSettingsHTML))
+	err := tmpl.Execute(buf, map[string]interface{}{
+		"Email":     usr.Email,
+		"Enabled":   usr.HasTwoFactor(),
+		"Required":  twoFactorRequired(),
+		"Remaining": len(usr.RecoveryCodes),
+		"Secret":    secret,
+		"URI":       template.URL(user.TOTPURI(totpIssuer(), usr.Email, secret)),
+		"Codes":     codes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return AdminFor(req, buf.Bytes())
+}
+
+// configTwoFactorHandler changes the two-factor authentication settings of the
+// current user, whose password has been checked, and responds with the settings
+// view
+func configTwoFactorHandler(res http.ResponseWriter, req *http.Request, usr *user.User, action string) {
+	update := *usr
+
+	var codes []string
+	var err error
+	switch action {
+	case "enable_2fa":
+		if !usr.HasTwoFactor() {
+			codes, err = update.EnableTwoFactor(req.PostFormValue("secret"), req.PostFormValue("code"))
+		}
+
+		if usr.HasTwoFactor() || err == user.ErrInvalidCode {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+	case "disable_2fa":
+		if twoFactorRequired() {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		update.DisableTwoFactor()
+
+	case "recovery_codes":
+		if usr.HasTwoFactor() {
+			codes, err = update.NewRecoveryCodes()
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	err = db.UpdateUser(usr, &update)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	auditDetail(req, "user.2fa", usr.Email, action, auditUser(usr), auditUser(&update))
+
+	view, err := twoFactorSettings(req, &update, codes)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	// recovery codes must not be stored by the browser's cache
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(view)
+}
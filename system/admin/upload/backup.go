@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ponzu-cms/ponzu/system/backup"
+	"github.com/ponzu-cms/ponzu/system/storage"
 )
 
 // Backup creates an archive of a project's uploads and writes it
@@ -26,7 +27,7 @@ func Backup(ctx context.Context, res http.ResponseWriter) error {
 		return err
 	}
 
-	err = backup.ArchiveFS(ctx, "uploads", f)
+	err = backup.ArchiveStorage(ctx, storage.Uploads(), "uploads", f)
 	if err != nil {
 		return err
 	}
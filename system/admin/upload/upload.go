@@ -4,24 +4,26 @@ package upload
 
 import (
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
-	"github.com/ponzu-cms/ponzu/system/cfg"
-
 	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/imaging"
 	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/storage"
 )
 
-// StoreFiles stores file uploads at paths like /YYYY/MM/filename.ext
-func StoreFiles(req *http.Request) (map[string]string, error) {
+// StoreFiles stores file uploads at paths like /YYYY/MM/filename.ext, once all
+// of them are validated against the policy. A rejected file is reported by an
+// *Error, and none of the files are stored. The form should be parsed with
+// ParseForm first, so that the size of the request is limited.
+func StoreFiles(req *http.Request, p Policy) (map[string]string, error) {
 	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
 	if err != nil {
 		return nil, err
@@ -42,7 +44,7 @@ func StoreFiles(req *http.Request) (map[string]string, error) {
 
 	req.Form.Set("timestamp", ts)
 
-	// get or create upload directory to save files from request
+	// get the month the uploaded files are stored under
 	i, err := strconv.ParseInt(ts, 10, 64)
 	if err != nil {
 		return nil, err
@@ -50,15 +52,24 @@ func StoreFiles(req *http.Request) (map[string]string, error) {
 
 	tm := time.Unix(int64(i/1000), int64(i%1000))
 
+	// check every file before storing any, by the types detected from their
+	// content rather than the ones sent by the client
+	contentTypes := make(map[string]string)
+	maxSize, allowed := MaxSize(), AllowedTypes()
+	for name, fds := range req.MultipartForm.File {
+		contentType, err := p.check(name, fds[0], maxSize, allowed)
+		if err != nil {
+			return nil, err
+		}
+
+		contentTypes[name] = contentType
+	}
+
 	urlPathPrefix := "api"
 	uploadDirName := "uploads"
-	uploadDir := filepath.Join(cfg.UploadDir(), fmt.Sprintf("%d", tm.Year()), fmt.Sprintf("%02d", tm.Month()))
-	err = os.MkdirAll(uploadDir, os.ModeDir|os.ModePerm)
-	if err != nil {
-		return nil, err
-	}
+	uploads := storage.Uploads()
 
-	// loop over all files and save them to disk
+	// loop over all files and store them
 	for name, fds := range req.MultipartForm.File {
 		filename, err := item.NormalizeString(fds[0].Filename)
 		if err != nil {
@@ -74,46 +85,76 @@ func StoreFiles(req *http.Request) (map[string]string, error) {
 		defer src.Close()
 
 		// check if file at path exists, if so, add timestamp to file
-		absPath := filepath.Join(uploadDir, filename)
+		filePath := fmt.Sprintf("%d/%02d/%s", tm.Year(), tm.Month(), filename)
 
-		if _, err := os.Stat(absPath); !os.IsNotExist(err) {
-			filename = fmt.Sprintf("%d-%s", time.Now().Unix(), filename)
-			absPath = filepath.Join(uploadDir, filename)
+		_, err = uploads.Stat(req.Context(), filePath)
+		if err != nil && err != storage.ErrNotExist {
+			err := fmt.Errorf("Failed to check for existing upload: %s", err)
+			return nil, err
 		}
 
-		// save to disk (TODO: or check if S3 credentials exist, & save to cloud)
-		dst, err := os.Create(absPath)
-		if err != nil {
-			err := fmt.Errorf("Failed to create destination file for upload: %s", err)
-			return nil, err
+		if err == nil {
+			filename = fmt.Sprintf("%d-%s", time.Now().Unix(), filename)
+			filePath = fmt.Sprintf("%d/%02d/%s", tm.Year(), tm.Month(), filename)
 		}
 
-		// copy file from src to dst on disk
-		var size int64
-		if size, err = io.Copy(dst, src); err != nil {
-			err := fmt.Errorf("Failed to copy uploaded file to destination: %s", err)
+		// save to the upload storage, on disk or in the cloud
+		contentType := contentTypes[name]
+		err = uploads.Put(req.Context(), filePath, src, fds[0].Size, contentType)
+		if err != nil {
+			err := fmt.Errorf("Failed to store uploaded file: %s", err)
 			return nil, err
 		}
 
 		// add name:urlPath to req.PostForm to be inserted into db
-		urlPath := fmt.Sprintf("/%s/%s/%d/%02d/%s", urlPathPrefix, uploadDirName, tm.Year(), tm.Month(), filename)
+		urlPath := fmt.Sprintf("/%s/%s/%s", urlPathPrefix, uploadDirName, filePath)
 		urlPaths[name] = urlPath
 
+		// describe images by their dimensions and a placeholder
+		info := describe(src, filePath)
+
 		// add upload information to db
-		go storeFileInfo(size, filename, urlPath, fds)
+		go storeFileInfo(fds[0].Size, filename, urlPath, contentType, info)
 	}
 
 	return urlPaths, nil
 }
 
-func storeFileInfo(size int64, filename, urlPath string, fds []*multipart.FileHeader) {
+// describe returns the description of the uploaded file if it is an image, or
+// nil if it isn't
+func describe(src multipart.File, filePath string) *imaging.Info {
+	_, err := src.Seek(0, io.SeekStart)
+	if err != nil {
+		log.Println("Error reading uploaded file:", filePath, err)
+		return nil
+	}
+
+	info, err := imaging.Describe(src)
+	if err == image.ErrFormat {
+		return nil
+	}
+	if err != nil {
+		log.Println("Error describing uploaded image:", filePath, err)
+		return nil
+	}
+
+	return info
+}
+
+func storeFileInfo(size int64, filename, urlPath, contentType string, info *imaging.Info) {
 	data := url.Values{
 		"name":           []string{filename},
 		"path":           []string{urlPath},
-		"content_type":   []string{fds[0].Header.Get("Content-Type")},
+		"content_type":   []string{contentType},
 		"content_length": []string{fmt.Sprintf("%d", size)},
 	}
 
+	if info != nil {
+		data.Set("width", fmt.Sprintf("%d", info.Width))
+		data.Set("height", fmt.Sprintf("%d", info.Height))
+		data.Set("blurhash", info.Blurhash)
+	}
+
 	_, err := db.SetUpload("__uploads:-1", data)
 	if err != nil {
 		log.Println("Error saving file upload record to database:", err)
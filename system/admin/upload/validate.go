@@ -0,0 +1,295 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+// DefaultMaxSize is the size limit of uploaded files, in bytes, unless another
+// is set in the system configuration
+const DefaultMaxSize = 1024 * 1024 * 32
+
+// formOverhead is the room left in the body of a request with uploaded files
+// for its other form values and the headers of its parts
+const formOverhead = 1024 * 1024
+
+// Policy holds the rules which uploaded files are validated against before any
+// of them are stored
+type Policy struct {
+	// Rules of the files uploaded to each field, by the field's name
+	Rules map[string]item.UploadRule
+
+	// Public rejects files which are unsafe to serve from the site, such as
+	// HTML and executables, for uploads made by external clients
+	Public bool
+}
+
+// PolicyFor returns the policy of files uploaded with content of the type of
+// post, which declares its own rules if it is an item.Uploadable
+func PolicyFor(post interface{}) Policy {
+	var p Policy
+	if up, ok := post.(item.Uploadable); ok {
+		p.Rules = up.UploadRules()
+	}
+
+	return p
+}
+
+// Error reports an uploaded file which was rejected, and the HTTP status code
+// to respond with
+type Error struct {
+	Field    string
+	Filename string
+	Status   int
+	Reason   string
+}
+
+func (e *Error) Error() string {
+	name := e.Filename
+	if name == "" {
+		name = "The files"
+	}
+
+	return fmt.Sprintf("%s can't be uploaded: %s", name, e.Reason)
+}
+
+// ParseForm parses the multipart form of a request with uploaded files, reading
+// no more of its body than the size limit of the system configuration allows,
+// so a request larger than it is rejected before it is stored in memory or
+// temporary files. A request which is too large is reported by an *Error.
+func ParseForm(res http.ResponseWriter, req *http.Request) error {
+	return parseForm(res, req, MaxSize())
+}
+
+func parseForm(res http.ResponseWriter, req *http.Request, max int64) error {
+	tooLarge := &Error{
+		Status: http.StatusRequestEntityTooLarge,
+		Reason: fmt.Sprintf("together they are larger than the limit of %s", item.FmtBytes(float64(max))),
+	}
+
+	if max > 0 {
+		if req.ContentLength > max+formOverhead {
+			return tooLarge
+		}
+
+		req.Body = http.MaxBytesReader(res, req.Body, max+formOverhead)
+	}
+
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return tooLarge
+	}
+
+	return err
+}
+
+// unsafeTypes are the media types rejected by public policies, as browsers run
+// scripts in them when they are served from the site
+var unsafeTypes = map[string]bool{
+	"text/html":                 true,
+	"application/xhtml+xml":     true,
+	"image/svg+xml":             true,
+	"text/xml":                  true,
+	"application/xml":           true,
+	"text/javascript":           true,
+	"application/javascript":    true,
+	"application/x-msdownload":  true,
+	"application/x-executable":  true,
+	"application/x-mach-binary": true,
+	"text/x-shellscript":        true,
+}
+
+// unsafeExtensions are rejected by public policies whatever the content of the
+// file is, as files are served by the type of their extension
+var unsafeExtensions = map[string]bool{
+	".htm": true, ".html": true, ".shtml": true, ".xhtml": true, ".xht": true,
+	".svg": true, ".svgz": true, ".xml": true, ".xsl": true, ".js": true, ".mjs": true,
+	".exe": true, ".dll": true, ".com": true, ".scr": true, ".msi": true, ".bat": true,
+	".cmd": true, ".ps1": true, ".vbs": true, ".jar": true, ".sh": true, ".app": true,
+}
+
+// executables are the leading bytes of programs, which aren't recognized by
+// http.DetectContentType
+var executables = []struct {
+	magic       string
+	contentType string
+}{
+	{"MZ", "application/x-msdownload"},
+	{"\x7fELF", "application/x-executable"},
+	{"\xfe\xed\xfa\xce", "application/x-mach-binary"},
+	{"\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"#!", "text/x-shellscript"},
+}
+
+// sniffed are the media types which http.DetectContentType recognizes by the
+// content of files, so an extension claiming one of them is never trusted
+var sniffed = map[string]bool{
+	"image/jpeg": true, "image/png": true, "image/gif": true, "image/webp": true,
+	"image/bmp": true, "image/x-icon": true, "application/pdf": true,
+	"text/html": true, "video/mp4": true, "video/webm": true, "audio/mpeg": true,
+	"audio/wave": true, "audio/ogg": true, "application/ogg": true,
+	"font/woff": true, "font/woff2": true, "font/ttf": true, "font/otf": true,
+}
+
+// Detect returns the content type of an uploaded file from its first bytes.
+// Types which can't be told apart by content alone, such as plain text and zip
+// based documents, are refined by the extension of the file's name, unless the
+// extension claims a type which would have been recognized by its content.
+func Detect(head []byte, filename string) string {
+	for _, exe := range executables {
+		if bytes.HasPrefix(head, []byte(exe.magic)) {
+			return exe.contentType
+		}
+	}
+
+	contentType := http.DetectContentType(head)
+	switch mediaType(contentType) {
+	case "application/octet-stream", "text/plain", "text/xml", "application/zip":
+		ext := mime.TypeByExtension(strings.ToLower(path.Ext(filename)))
+		if ext != "" && !sniffed[mediaType(ext)] {
+			return ext
+		}
+	}
+
+	return contentType
+}
+
+// mediaType returns the content type without its parameters, in lower case
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+
+	return mt
+}
+
+// Match reports whether the content type matches one of the patterns, which
+// are media types like "application/pdf", or "image/*" for any image
+func Match(contentType string, patterns []string) bool {
+	mt := mediaType(contentType)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mt || pattern == "*/*" {
+			return true
+		}
+
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseTypes parses the allowed types of the system configuration, which are
+// separated by commas or white space
+func ParseTypes(text string) ([]string, error) {
+	var types []string
+	for _, t := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		parts := strings.Split(t, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || (parts[0] == "*" && parts[1] != "*") {
+			return nil, fmt.Errorf("%s is not a media type like image/png or image/*", t)
+		}
+
+		types = append(types, strings.ToLower(t))
+	}
+
+	return types, nil
+}
+
+// MaxSize returns the size limit of uploaded files from the system
+// configuration, or 0 if there is no limit
+func MaxSize() int64 {
+	mb, _ := db.ConfigCache("max_upload_size").(float64)
+	switch {
+	case mb < 0:
+		return 0
+	case mb == 0:
+		return DefaultMaxSize
+	}
+
+	return int64(mb * 1024 * 1024)
+}
+
+// AllowedTypes returns the types of files which may be uploaded from the system
+// configuration, or nil if any type may be
+func AllowedTypes() []string {
+	text, _ := db.ConfigCache("upload_types").(string)
+	types, err := ParseTypes(text)
+	if err != nil {
+		return nil
+	}
+
+	return types
+}
+
+// check validates an uploaded file against the policy and the limits of the
+// system configuration, and returns its detected content type
+func (p Policy) check(field string, fh *multipart.FileHeader, maxSize int64, allowed []string) (string, error) {
+	reject := func(status int, reason string, args ...interface{}) (string, error) {
+		return "", &Error{
+			Field:    field,
+			Filename: fh.Filename,
+			Status:   status,
+			Reason:   fmt.Sprintf(reason, args...),
+		}
+	}
+
+	// fields with many files are named like field.0, field.1
+	name := strings.Split(field, ".")[0]
+	rule := p.Rules[name]
+	if rule.MaxSize > 0 && (maxSize == 0 || rule.MaxSize < maxSize) {
+		maxSize = rule.MaxSize
+	}
+
+	if maxSize > 0 && fh.Size > maxSize {
+		return reject(http.StatusRequestEntityTooLarge, "it is %s, larger than the limit of %s",
+			item.FmtBytes(float64(fh.Size)), item.FmtBytes(float64(maxSize)))
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return "", fmt.Errorf("Couldn't open uploaded file: %s", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("Couldn't read uploaded file: %s", err)
+	}
+
+	contentType := Detect(head[:n], fh.Filename)
+	mt := mediaType(contentType)
+
+	if p.Public && (unsafeTypes[mt] || unsafeExtensions[strings.ToLower(path.Ext(fh.Filename))]) {
+		return reject(http.StatusUnsupportedMediaType, "executable, HTML and script files are not accepted")
+	}
+
+	if len(allowed) > 0 && !Match(contentType, allowed) {
+		return reject(http.StatusUnsupportedMediaType, "files of type %s are not allowed", mt)
+	}
+
+	if len(rule.Types) > 0 && !Match(contentType, rule.Types) {
+		return reject(http.StatusUnsupportedMediaType, "files of type %s are not allowed in %s, only %s",
+			mt, name, strings.Join(rule.Types, ", "))
+	}
+
+	return contentType, nil
+}
@@ -0,0 +1,213 @@
+package upload
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+func TestDetect(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	cases := []struct {
+		content, filename, expected string
+	}{
+		{png, "photo.png", "image/png"},
+		{png, "photo.txt", "image/png"},
+		{"plain text", "photo.png", "text/plain"},
+		{"<!DOCTYPE html><p>hi</p>", "notes.txt", "text/html"},
+		{"MZ\x90\x00\x03", "setup.pdf", "application/x-msdownload"},
+		{"\x7fELF\x02\x01", "run", "application/x-executable"},
+		{"#!/bin/sh\nrm -rf /", "script.txt", "text/x-shellscript"},
+		{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, "logo.svg", "image/svg+xml"},
+		{"PK\x03\x04\x14\x00", "report.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"%PDF-1.4", "paper.pdf", "application/pdf"},
+	}
+
+	for _, c := range cases {
+		got := mediaType(Detect([]byte(c.content), c.filename))
+		if got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.filename, c.expected, got)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	patterns := []string{"image/*", "application/pdf"}
+	for contentType, expected := range map[string]bool{
+		"image/png":                 true,
+		"image/svg+xml":             true,
+		"application/pdf":           true,
+		"text/plain; charset=utf-8": false,
+		"imagex/png":                false,
+	} {
+		if Match(contentType, patterns) != expected {
+			t.Errorf("%s: expected match %v", contentType, expected)
+		}
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	types, err := ParseTypes("image/*, application/PDF\ntext/csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(types, " ") != "image/* application/pdf text/csv" {
+		t.Errorf("parsed %v", types)
+	}
+
+	for _, text := range []string{"image", "/png", "*/png", "image/png/x"} {
+		_, err = ParseTypes(text)
+		if err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+// form returns the headers of files uploaded to fields of a multipart form
+func form(t *testing.T, files map[string][2]string) map[string][]*multipart.FileHeader {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for field, file := range files {
+		fw, err := w.CreateFormFile(field, file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fw.Write([]byte(file[1]))
+	}
+	w.Close()
+
+	req, err := http.NewRequest(http.MethodPost, "/", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	err = req.ParseMultipartForm(1024 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return req.MultipartForm.File
+}
+
+func TestCheck(t *testing.T) {
+	files := form(t, map[string][2]string{
+		"photo":        {"photo.png", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"},
+		"attachment":   {"page.html", "<html><script>alert(1)</script></html>"},
+		"disguised":    {"page.txt", "<html><script>alert(1)</script></html>"},
+		"program":      {"tool.bin", "MZ\x90\x00\x03"},
+		"document":     {"notes.txt", strings.Repeat("a", 2048)},
+		"gallery.0":    {"cat.txt", "meow"},
+		"unrestricted": {"data.csv", "a,b\n1,2\n"},
+	})
+
+	p := Policy{
+		Rules: map[string]item.UploadRule{
+			"photo":    {Types: []string{"image/*"}},
+			"document": {MaxSize: 1024},
+			"gallery":  {Types: []string{"image/*"}},
+		},
+	}
+
+	cases := []struct {
+		field   string
+		public  bool
+		allowed []string
+		status  int
+	}{
+		{"photo", false, nil, 0},
+		{"photo", true, []string{"image/png"}, 0},
+		{"photo", false, []string{"application/pdf"}, http.StatusUnsupportedMediaType},
+		{"attachment", false, nil, 0},
+		{"attachment", true, nil, http.StatusUnsupportedMediaType},
+		{"disguised", true, nil, http.StatusUnsupportedMediaType},
+		{"program", false, nil, 0},
+		{"program", true, nil, http.StatusUnsupportedMediaType},
+		{"document", false, nil, http.StatusRequestEntityTooLarge},
+		{"gallery.0", false, nil, http.StatusUnsupportedMediaType},
+		{"unrestricted", true, []string{"text/*"}, 0},
+	}
+
+	for _, c := range cases {
+		p.Public = c.public
+		_, err := p.check(c.field, files[c.field][0], 0, c.allowed)
+
+		status := 0
+		if uerr, ok := err.(*Error); ok {
+			status = uerr.Status
+		} else if err != nil {
+			t.Fatal(c.field, err)
+		}
+
+		if status != c.status {
+			t.Errorf("%s (public %v, allowed %v): expected status %d, got %d (%v)", c.field, c.public, c.allowed, c.status, status, err)
+		}
+	}
+
+	// the global limit applies to every file
+	_, err := p.check("photo", files["photo"][0], 8, nil)
+	if uerr, ok := err.(*Error); !ok || uerr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected the photo to be too large, got %v", err)
+	}
+}
+
+func TestParseForm(t *testing.T) {
+	body := func(size int) (*bytes.Buffer, string) {
+		buf := &bytes.Buffer{}
+		w := multipart.NewWriter(buf)
+		fw, err := w.CreateFormFile("photo", "photo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fw.Write(bytes.Repeat([]byte("a"), size))
+		w.Close()
+
+		return buf, w.FormDataContentType()
+	}
+
+	cases := []struct {
+		size    int
+		chunked bool
+		status  int
+	}{
+		{1024, false, 0},
+		{1024, true, 0},
+		{formOverhead + 4096, false, http.StatusRequestEntityTooLarge},
+		{formOverhead + 4096, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, c := range cases {
+		buf, contentType := body(c.size)
+		req, err := http.NewRequest(http.MethodPost, "/", buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+
+		// a chunked body has no length, so it is only stopped once it is read
+		if c.chunked {
+			req.ContentLength = -1
+		}
+
+		err = parseForm(httptest.NewRecorder(), req, 2048)
+
+		status := 0
+		if uerr, ok := err.(*Error); ok {
+			status = uerr.Status
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		if status != c.status {
+			t.Errorf("%d bytes, chunked %v: expected status %d, got %d (%v)", c.size, c.chunked, c.status, status, err)
+		}
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	"encoding/base64"
+	"errors"
 	"log"
 	mrand "math/rand"
+	"net"
 	"net/http"
 	"time"
 
@@ -21,8 +23,26 @@ type User struct {
 	Email string `json:"email"`
 	Hash  string `json:"hash"`
 	Salt  string `json:"salt"`
+
+	// Role is one of Roles, and Permissions optionally limits the user to the
+	// content types it contains, with the Actions permitted on each
+	Role        string              `json:"role,omitempty"`
+	Permissions map[string][]string `json:"permissions,omitempty"`
+
+	// TOTPSecret is set when two-factor authentication is enabled, with the
+	// hashes of unused RecoveryCodes, and the last TOTP time step used to log in
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
 }
 
+// SessionClaim is the claim of a token which holds the ID of its session
+const SessionClaim = "sid"
+
+// PendingClaim is set in the claims of a token issued to a user who has logged
+// in with a password, but not yet with a second factor
+const PendingClaim = "2fa_pending"
+
 var (
 	r = mrand.New(mrand.NewSource(time.Now().Unix()))
 )
@@ -48,16 +68,32 @@ func New(email, password string) (*User, error) {
 	return user, nil
 }
 
-// Auth is HTTP middleware to ensure the request has proper token credentials
+// Auth is HTTP middleware to ensure the request has proper token credentials,
+// from a user whose role permits access to the requested route. The user is
+// available to the next handler from Current.
 func Auth(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		redir := req.URL.Scheme + req.URL.Host + "/admin/login"
 
-		if IsValid(req) {
-			next.ServeHTTP(res, req)
-		} else {
+		if !IsValid(req) {
+			http.Redirect(res, req, redir, http.StatusFound)
+			return
+		}
+
+		usr, err := tokenUser(req)
+		if err != nil {
+			log.Println("Error finding user for request:", err)
 			http.Redirect(res, req, redir, http.StatusFound)
+			return
 		}
+
+		if !usr.CanAccessPath(req.URL.Path) {
+			log.Println("User", usr.Email, "with role", usr.Role, "denied access to", req.URL.Path)
+			http.Error(res, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(res, WithUser(req, usr))
 	})
 }
 
@@ -70,7 +106,60 @@ func IsValid(req *http.Request) bool {
 	}
 	// validate it and allow or redirect request
 	token := cookie.Value
-	return jwt.Passes(token)
+	if !jwt.Passes(token) {
+		return false
+	}
+
+	// a token issued between the password and two-factor login steps is not
+	// valid for anything else
+	claims := jwt.GetClaims(token)
+	if _, pending := claims[PendingClaim]; pending {
+		return false
+	}
+
+	if sessionCheck == nil {
+		return true
+	}
+
+	// the token must belong to a session which hasn't expired or been revoked
+	sid, _ := claims[SessionClaim].(string)
+	return sid != "" && sessionCheck(sid, RemoteIP(req))
+}
+
+// SessionID returns the ID of the session the request's token belongs to
+func SessionID(req *http.Request) string {
+	cookie, err := req.Cookie("_token")
+	if err != nil {
+		return ""
+	}
+
+	sid, _ := jwt.GetClaims(cookie.Value)[SessionClaim].(string)
+	return sid
+}
+
+// RemoteIP returns the IP address of the client making the request
+func RemoteIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return ip
+}
+
+// tokenEmail returns the email address of the user from the request's token
+func tokenEmail(req *http.Request) (string, error) {
+	cookie, err := req.Cookie("_token")
+	if err != nil {
+		return "", err
+	}
+
+	email, ok := jwt.GetClaims(cookie.Value)["user"].(string)
+	if !ok {
+		return "", errors.New("No user data found in request token")
+	}
+
+	return email, nil
 }
 
 // IsUser checks for consistency in email/pass combination
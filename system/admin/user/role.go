@@ -0,0 +1,199 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Roles a user may have. Users with no role, created before roles were added,
+// are admins.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleViewer = "viewer"
+)
+
+// Actions a user may be permitted to take on content of a type. All users who
+// can access a type may view its content.
+const (
+	ActionView    = "view"
+	ActionCreate  = "create"
+	ActionEdit    = "edit"
+	ActionDelete  = "delete"
+	ActionApprove = "approve"
+)
+
+// Roles lists every role, from most to least powerful
+var Roles = []string{RoleAdmin, RoleEditor, RoleAuthor, RoleViewer}
+
+// Actions lists the actions which can be permitted per content type
+var Actions = []string{ActionCreate, ActionEdit, ActionDelete, ActionApprove}
+
+// roleActions are the actions a role permits on every content type, unless the
+// user has per-type permissions
+var roleActions = map[string][]string{
+	RoleEditor: {ActionCreate, ActionEdit, ActionDelete, ActionApprove},
+	RoleAuthor: {ActionCreate, ActionEdit},
+	RoleViewer: {},
+}
+
+// adminPaths are the admin routes, matched by prefix, which only admins can
+// access. All users may manage their own account from selfPaths.
+var (
+	adminPaths = []string{"/admin/configure", "/admin/addon"}
+	selfPaths  = []string{"/admin/configure/users", "/admin/configure/users/edit", "/admin/configure/users/sessions"}
+)
+
+// ErrNoLookup is returned by Current when no lookup has been set to find users
+var ErrNoLookup = errors.New("No user lookup has been set")
+
+// ErrNoUser is returned by Current for a request which isn't from a logged in
+// user, as its token isn't valid
+var ErrNoUser = errors.New("The request is not from a logged in user")
+
+type contextKey string
+
+const userKey contextKey = "user"
+
+// lookup finds a user by email address, see SetLookup
+var lookup func(email string) (*User, error)
+
+// sessionCheck reports whether a session is valid, see SetSessionCheck
+var sessionCheck func(sid, ip string) bool
+
+// SetSessionCheck sets the func used by IsValid to check that the session of a
+// request's token is valid, and record that it was used from the IP address. It
+// is set by the package which stores sessions.
+func SetSessionCheck(fn func(sid, ip string) bool) {
+	sessionCheck = fn
+}
+
+// SetLookup sets the func used to find the user making a request by the email
+// address in its token. It is set by the package which stores users.
+func SetLookup(fn func(email string) (*User, error)) {
+	lookup = fn
+}
+
+// IsValidRole reports whether role is one of Roles
+func IsValidRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsAdmin reports whether the user has the admin role, and can access all of
+// the system
+func (u *User) IsAdmin() bool {
+	return u.Role == "" || u.Role == RoleAdmin
+}
+
+// Can reports whether the user is permitted to take the action on content of
+// the type. If the user has per-type permissions, only the types listed can
+// be accessed, with the actions listed for each. Otherwise, the user's role
+// determines the actions permitted on all types.
+func (u *User) Can(action, typeName string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+
+	actions := roleActions[u.Role]
+	if len(u.Permissions) > 0 {
+		var ok bool
+		actions, ok = u.Permissions[typeName]
+		if !ok {
+			return false
+		}
+	}
+
+	if action == ActionView {
+		return true
+	}
+
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanUpload reports whether the user is permitted to take the action on file
+// uploads, which follow the user's role regardless of per-type permissions
+func (u *User) CanUpload(action string) bool {
+	if u.IsAdmin() || action == ActionView {
+		return true
+	}
+
+	for _, a := range roleActions[u.Role] {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccessPath reports whether the user may access the admin route at path
+func (u *User) CanAccessPath(path string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+
+	path = strings.TrimSuffix(path, "/")
+	for _, p := range selfPaths {
+		if path == p {
+			return true
+		}
+	}
+
+	for _, p := range adminPaths {
+		if strings.HasPrefix(path, p) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Current returns the user making the request, as set by Auth, or found from
+// the request's token if Auth has not run, only once the token is checked by
+// IsValid
+func Current(req *http.Request) (*User, error) {
+	if usr, ok := req.Context().Value(userKey).(*User); ok {
+		return usr, nil
+	}
+
+	if !IsValid(req) {
+		return nil, ErrNoUser
+	}
+
+	return tokenUser(req)
+}
+
+// tokenUser finds the user by the email address in the request's token, which
+// must already have been checked by IsValid
+func tokenUser(req *http.Request) (*User, error) {
+	if lookup == nil {
+		return nil, ErrNoLookup
+	}
+
+	email, err := tokenEmail(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return lookup(email)
+}
+
+// WithUser returns a copy of the request with usr set as the user making it
+func WithUser(req *http.Request, usr *User) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userKey, usr))
+}
@@ -0,0 +1,173 @@
+package user
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nilslice/jwt"
+)
+
+func TestCan(t *testing.T) {
+	users := map[string]*User{
+		"legacy": {},
+		"admin":  {Role: RoleAdmin},
+		"editor": {Role: RoleEditor},
+		"author": {Role: RoleAuthor},
+		"viewer": {Role: RoleViewer},
+		"songs": {Role: RoleViewer, Permissions: map[string][]string{
+			"Song":  {ActionCreate, ActionEdit},
+			"Album": {},
+		}},
+	}
+
+	all := append([]string{ActionView}, Actions...)
+	edit := []string{ActionView, ActionCreate, ActionEdit}
+	view := []string{ActionView}
+
+	// the actions each user can take on Song and Review content, and uploads
+	cases := []struct {
+		user                  string
+		song, review, uploads []string
+	}{
+		{"legacy", all, all, all},
+		{"admin", all, all, all},
+		{"editor", all, all, all},
+		{"author", edit, edit, edit},
+		{"viewer", view, view, view},
+		{"songs", edit, nil, view},
+	}
+
+	has := func(actions []string, action string) bool {
+		for _, a := range actions {
+			if a == action {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _, c := range cases {
+		usr := users[c.user]
+		for _, action := range all {
+			if usr.Can(action, "Song") != has(c.song, action) {
+				t.Errorf("%s: expected Can(%s, Song) %v", c.user, action, has(c.song, action))
+			}
+
+			if usr.Can(action, "Review") != has(c.review, action) {
+				t.Errorf("%s: expected Can(%s, Review) %v", c.user, action, has(c.review, action))
+			}
+
+			// uploads follow the role, whatever the per-type permissions
+			if usr.CanUpload(action) != has(c.uploads, action) {
+				t.Errorf("%s: expected CanUpload(%s) %v", c.user, action, has(c.uploads, action))
+			}
+		}
+	}
+
+	// a type listed without actions can only be viewed
+	if !users["songs"].Can(ActionView, "Album") || users["songs"].Can(ActionEdit, "Album") {
+		t.Error("songs: expected to only view Album")
+	}
+}
+
+func TestCanAccessPath(t *testing.T) {
+	paths := map[string]bool{
+		"/admin":                          true,
+		"/admin/contents":                 true,
+		"/admin/edit":                     true,
+		"/admin/uploads":                  true,
+		"/admin/configure":                false,
+		"/admin/configure/":               false,
+		"/admin/configure/apikeys":        false,
+		"/admin/configure/backups":        false,
+		"/admin/configure/users/delete":   false,
+		"/admin/configure/users/role":     false,
+		"/admin/configure/users":          true,
+		"/admin/configure/users/":         true,
+		"/admin/configure/users/edit":     true,
+		"/admin/configure/users/session":  false,
+		"/admin/configure/users/sessions": true,
+		"/admin/addon":                    false,
+		"/admin/addons":                   false,
+	}
+
+	admin := &User{Role: RoleAdmin}
+	for _, role := range []string{RoleEditor, RoleAuthor, RoleViewer} {
+		usr := &User{Role: role}
+		for path, expected := range paths {
+			if usr.CanAccessPath(path) != expected {
+				t.Errorf("%s: expected access to %s %v", role, path, expected)
+			}
+
+			if !admin.CanAccessPath(path) {
+				t.Errorf("admin: expected access to %s", path)
+			}
+		}
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	defer SetLookup(lookup)
+	SetLookup(func(email string) (*User, error) {
+		return &User{Email: email, Role: RoleAdmin}, nil
+	})
+
+	token := func(secret string, claims map[string]interface{}) string {
+		jwt.Secret([]byte(secret))
+		tok, err := jwt.New(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tok
+	}
+
+	claims := map[string]interface{}{"user": "admin@example.com"}
+	forged := token("another secret", claims)
+	pending := token("secret", map[string]interface{}{"user": "admin@example.com", PendingClaim: true})
+	valid := token("secret", claims)
+
+	cases := []struct {
+		name  string
+		token string
+		email string
+	}{
+		{"no token", "", ""},
+		{"forged token", forged, ""},
+		{"unsigned token", "eyJhbGciOiJub25lIn0.eyJ1c2VyIjoiYWRtaW5AZXhhbXBsZS5jb20ifQ.", ""},
+		{"pending two-factor login", pending, ""},
+		{"valid token", valid, "admin@example.com"},
+	}
+
+	for _, c := range cases {
+		req, err := http.NewRequest(http.MethodGet, "/admin", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.token != "" {
+			req.AddCookie(&http.Cookie{Name: "_token", Value: c.token})
+		}
+
+		usr, err := Current(req)
+		if c.email == "" {
+			if err == nil {
+				t.Errorf("%s: expected no user, got %s", c.name, usr.Email)
+			}
+
+			continue
+		}
+
+		if err != nil || usr.Email != c.email {
+			t.Errorf("%s: expected %s, got %v (%v)", c.name, c.email, usr, err)
+		}
+	}
+
+	// the user set by Auth is used without a token
+	req, _ := http.NewRequest(http.MethodGet, "/admin", nil)
+	usr, err := Current(WithUser(req, &User{Email: "editor@example.com"}))
+	if err != nil || usr.Email != "editor@example.com" {
+		t.Errorf("expected the request's user, got %v (%v)", usr, err)
+	}
+}
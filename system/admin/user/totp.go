@@ -0,0 +1,190 @@
+package user
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters, as used by common authenticator apps (RFC 6238)
+const (
+	totpStep   = 30 // seconds
+	totpDigits = 6
+	totpSkew   = 1 // steps of clock drift allowed either side of now
+)
+
+// RecoveryCodeCount is the number of recovery codes created for a user when
+// two-factor authentication is enabled
+const RecoveryCodeCount = 10
+
+// ErrInvalidCode is returned when a two-factor authentication code isn't valid,
+// or has already been used
+var ErrInvalidCode = errors.New("The code is not valid")
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret creates a random base32 encoded secret for a user's
+// authenticator app
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := crand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return b32.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth:// URI used to add the secret to an authenticator
+// app, labeled with the issuer and email address
+func TOTPURI(issuer, email, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+
+	label := url.PathEscape(issuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode returns the code for the secret at a time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000), nil
+}
+
+// checkTOTP returns the time step at which code is valid for the secret near
+// time t, or -1 if it isn't valid
+func checkTOTP(secret, code string, t time.Time) int64 {
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != totpDigits {
+		return -1
+	}
+
+	now := t.Unix() / totpStep
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		c, err := totpCode(secret, step)
+		if err != nil {
+			return -1
+		}
+
+		if subtle.ConstantTimeCompare([]byte(c), []byte(code)) == 1 {
+			return step
+		}
+	}
+
+	return -1
+}
+
+// HasTwoFactor reports whether the user has enabled two-factor authentication
+func (u *User) HasTwoFactor() bool {
+	return u.TOTPSecret != ""
+}
+
+// EnableTwoFactor sets the user's TOTP secret once code from the user's
+// authenticator app is checked against it, and returns a new set of recovery
+// codes, which are stored hashed and can only be shown now. ErrInvalidCode is
+// returned if the code isn't valid.
+func (u *User) EnableTwoFactor(secret, code string) ([]string, error) {
+	step := checkTOTP(secret, code, time.Now())
+	if step < 0 {
+		return nil, ErrInvalidCode
+	}
+
+	u.TOTPSecret = secret
+	// the code used to enable two-factor authentication can't be used again,
+	// even if it was accepted for a step after now
+	u.TOTPLastStep = step
+
+	return u.NewRecoveryCodes()
+}
+
+// DisableTwoFactor removes the user's TOTP secret and recovery codes
+func (u *User) DisableTwoFactor() {
+	u.TOTPSecret = ""
+	u.TOTPLastStep = 0
+	u.RecoveryCodes = nil
+}
+
+// NewRecoveryCodes replaces the user's recovery codes, and returns the new codes
+func (u *User) NewRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := crand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		c := strings.ToLower(b32.EncodeToString(b))
+		codes[i] = c[:4] + "-" + c[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	u.RecoveryCodes = hashes
+	return codes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.Replace(strings.ToLower(strings.TrimSpace(code)), "-", "", -1)
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyTwoFactor checks a code from the user's authenticator app, which can't
+// be used again, or one of the user's recovery codes, which is removed once it
+// has been used. The user must be saved after a code is verified, together with
+// reading it, so the code can't also be used by another request at once.
+func (u *User) VerifyTwoFactor(code string) bool {
+	if !u.HasTwoFactor() {
+		return false
+	}
+
+	step := checkTOTP(u.TOTPSecret, code, time.Now())
+	if step >= 0 {
+		if step <= u.TOTPLastStep {
+			return false
+		}
+
+		u.TOTPLastStep = step
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range u.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			// the codes are copied, as they may be shared with a copy of the
+			// user from before the code was used
+			codes := make([]string, 0, len(u.RecoveryCodes)-1)
+			codes = append(codes, u.RecoveryCodes[:i]...)
+			u.RecoveryCodes = append(codes, u.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
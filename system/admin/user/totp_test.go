@@ -0,0 +1,113 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// test vectors from RFC 6238, truncated to 6 digits
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := totpCode(secret, tt.unix/totpStep)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != tt.code {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+
+		if checkTOTP(secret, tt.code, time.Unix(tt.unix+totpStep, 0)) < 0 {
+			t.Errorf("checkTOTP rejected %s within allowed skew", tt.code)
+		}
+	}
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now, err := totpCode(secret, time.Now().Unix()/totpStep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &User{}
+	codes, err := u.EnableTwoFactor(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), RecoveryCodeCount)
+	}
+
+	// a copy of the user from before a recovery code is used keeps it
+	before := *u
+	if !u.VerifyTwoFactor(codes[0]) {
+		t.Error("recovery code was rejected")
+	}
+
+	if len(before.RecoveryCodes) != RecoveryCodeCount || before.RecoveryCodes[0] != hashRecoveryCode(codes[0]) {
+		t.Error("using a recovery code changed a copy of the user")
+	}
+
+	if u.VerifyTwoFactor(codes[0]) {
+		t.Error("recovery code was accepted twice")
+	}
+
+	code, err := totpCode(secret, time.Now().Unix()/totpStep+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u.VerifyTwoFactor(code) {
+		t.Error("TOTP code was rejected")
+	}
+
+	if u.VerifyTwoFactor(code) {
+		t.Error("TOTP code was accepted twice")
+	}
+}
+
+func TestEnableTwoFactor(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &User{}
+	_, err = u.EnableTwoFactor(secret, "000000x")
+	if err != ErrInvalidCode || u.HasTwoFactor() {
+		t.Errorf("expected an invalid code to be rejected, got %v", err)
+	}
+
+	// a code accepted for the next step, within the allowed skew, can't be
+	// used again once two-factor authentication is enabled with it
+	next, err := totpCode(secret, time.Now().Unix()/totpStep+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = u.EnableTwoFactor(secret, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.VerifyTwoFactor(next) {
+		t.Error("the code used to enable two-factor authentication was accepted again")
+	}
+}
@@ -0,0 +1,460 @@
+package admin
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/api"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+// webhookDeliveriesShown is the most deliveries shown in the delivery log
+const webhookDeliveriesShown = 50
+
+var webhooksHTML = `
+<div class="card webhooks">
+<div class="card-content">
+    <div class="card-title">Webhooks</div>
+    <p>Webhooks are sent a signed <code>POST</code> request when content changes. The request's <code>X-Ponzu-Signature</code> header is <code>sha256=</code> followed by the hex HMAC-SHA256 of the body, keyed with the webhook's secret.</p>
+    {{ if .Hook }}
+    <div class="input-field">
+        <label class="active">Secret for {{ .Hook.URL }}: copy it now, it won't be shown again</label>
+        <input type="text" readonly value="{{ .Hook.Secret }}" onclick="this.select()"/>
+    </div>
+    {{ end }}
+    <ul class="hooks row">
+    {{ range .Hooks }}
+        <li class="col s12">
+            <strong>{{ .URL }}</strong>
+            <span class="post-detail">Created: {{ date .Created }}</span>
+            <a href="/admin/configure/webhooks/deliveries?hook={{ .ID }}">Deliveries</a>
+            <form enctype="multipart/form-data" class="delete-hook __ponzu right" action="/admin/configure/webhooks/delete" method="post">
+                <span>Delete</span>
+                <input type="hidden" name="id" value="{{ .ID }}"/>
+            </form>
+            <div class="grey-text">{{ join .Events ", " }}</div>
+        </li>
+    {{ else }}
+        <li class="col s12">No webhooks have been added.</li>
+    {{ end }}
+    </ul>
+
+    <div class="card-title">Add a webhook:</div>
+    <form class="row" enctype="multipart/form-data" action="/admin/configure/webhooks" method="post">
+        <div class="col s9">
+            <label class="active">URL</label>
+            <input type="url" name="url" placeholder="https://example.com/hooks/ponzu" required/>
+        </div>
+        <div class="col s9">
+            <label class="active">Secret (leave blank to generate one)</label>
+            <input type="text" name="secret" autocomplete="off"/>
+        </div>
+        <div class="col s9">
+            <table class="events">
+                {{ range $t := .Types }}
+                <tr>
+                    <td>{{ if eq $t "*" }}All types{{ else }}{{ $t }}{{ end }}</td>
+                    {{ range $e := $.Events }}
+                    <td><input type="checkbox" id="event-{{ $t }}-{{ $e }}" name="events" value="{{ $t }}:{{ $e }}"/><label for="event-{{ $t }}-{{ $e }}">{{ $e }}</label></td>
+                    {{ end }}
+                </tr>
+                {{ end }}
+            </table>
+        </div>
+        <div class="col s9">
+            <button class="btn waves-effect waves-light green right" type="submit">Add Webhook</button>
+        </div>
+    </form>
+</div>
+</div>
+<script>
+    $(function() {
+        $('.delete-hook.__ponzu span').on('click', function(e) {
+            if (confirm("[Ponzu] Please confirm:\n\nAre you sure you want to delete this webhook?\nIt will no longer be sent any events.")) {
+                $(e.target).parent().submit();
+            }
+        });
+    });
+</script>
+`
+
+var webhookDeliveriesHTML = `
+<div class="card webhook-deliveries">
+<div class="card-content">
+    <div class="card-title">Webhook Deliveries{{ if .Hook }} to {{ .Hook.URL }}{{ end }}</div>
+    <p>The most recent deliveries from the last {{ .Retention }}. Failed deliveries are retried {{ .Attempts }} times, waiting longer after each attempt.</p>
+    {{ if .Deliveries }}
+    <table class="striped">
+        <thead>
+            <tr>
+                <th>#</th>
+                <th>Event</th>
+                <th>Created</th>
+                <th>Status</th>
+                <th>Attempts</th>
+                <th>Response</th>
+                <th></th>
+            </tr>
+        </thead>
+        <tbody>
+        {{ range .Deliveries }}
+            <tr>
+                <td>{{ .ID }}{{ if .Redeliver }} <span class="grey-text">(resends #{{ .Redeliver }})</span>{{ end }}</td>
+                <td>{{ .Event }}</td>
+                <td>{{ date .Created }}</td>
+                <td>{{ .Status }}{{ if eq .Status "pending" }}{{ if .Attempts }}, next attempt {{ date .Next }}{{ end }}{{ end }}</td>
+                <td>{{ .Attempts }}</td>
+                <td>{{ if .Response }}{{ .Response }}{{ end }} <span class="grey-text">{{ .Error }}</span></td>
+                <td>
+                    <form enctype="multipart/form-data" action="/admin/configure/webhooks/redeliver" method="post">
+                        <input type="hidden" name="id" value="{{ .ID }}"/>
+                        <button class="btn-flat" type="submit">Redeliver</button>
+                    </form>
+                </td>
+            </tr>
+        {{ end }}
+        </tbody>
+    </table>
+    {{ else }}
+    <p>There are no recent deliveries.</p>
+    {{ end }}
+    <a href="/admin/configure/webhooks">Back to webhooks</a>
+</div>
+</div>
+`
+
+// notify records the event for the content in the change log, and queues the
+// webhooks subscribed to it
+func notify(event, t string, id interface{}, data []byte) {
+	err := db.ContentChanged(event, t, fmt.Sprint(id), data)
+	if err != nil {
+		log.Println("Error queueing webhooks:", event, t, id, err)
+	}
+}
+
+// isWebhookEvent reports whether s is an event on a registered type, or on all
+// types
+func isWebhookEvent(s string) bool {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return false
+	}
+
+	t, event := s[:i], s[i+1:]
+	if _, ok := item.Types[t]; !ok && t != api.AllTypes {
+		return false
+	}
+
+	for _, e := range db.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func webhooksView(req *http.Request, hook *db.Webhook) ([]byte, error) {
+	hooks, err := db.Webhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	types := []string{api.AllTypes}
+	for t := range item.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types[1:])
+
+	funcs := template.FuncMap{
+		"date": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04 PM")
+		},
+		"join": strings.Join,
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("webhooks").Funcs(funcs).Parse(webhooksHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Hooks":  hooks,
+		"Hook":   hook,
+		"Types":  types,
+		"Events": db.WebhookEvents,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return AdminFor(req, buf.Bytes())
+}
+
+func webhooksHandler(res http.ResponseWriter, req *http.Request) {
+	var hook *db.Webhook
+
+	switch req.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+		if err != nil {
+			log.Println(err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		u, err := url.Parse(strings.TrimSpace(req.PostFormValue("url")))
+		events := req.PostForm["events"]
+		valid := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && len(events) > 0
+		for _, e := range events {
+			valid = valid && isWebhookEvent(e)
+		}
+
+		if !valid {
+			res.WriteHeader(http.StatusBadRequest)
+			errView, err := Error400()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		// the secret is only shown in this response, so it must not be cached
+		res.Header().Set("Cache-Control", "no-store")
+		hook, err = db.NewWebhook(u.String(), req.PostFormValue("secret"), events)
+		if err != nil {
+			log.Println("Error creating webhook:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			errView, err := Error500()
+			if err != nil {
+				return
+			}
+
+			res.Write(errView)
+			return
+		}
+
+		auditDetail(req, "webhook.create", fmt.Sprintf("webhook:%d", hook.ID), hook.URL+" "+strings.Join(events, ", "), nil, nil)
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	view, err := webhooksView(req, hook)
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(view)
+}
+
+func deleteWebhookHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	id, err := strconv.Atoi(req.PostFormValue("id"))
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	err = db.DeleteWebhook(id)
+	if err == db.ErrNoWebhook {
+		res.WriteHeader(http.StatusNotFound)
+		errView, err := Error404()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+	if err != nil {
+		log.Println("Error deleting webhook:", id, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	audit(req, "webhook.delete", fmt.Sprintf("webhook:%d", id), nil, nil)
+
+	http.Redirect(res, req, strings.TrimSuffix(req.URL.String(), "/delete"), http.StatusFound)
+}
+
+func webhookDeliveriesHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// all deliveries are shown if no webhook is given
+	id, _ := strconv.Atoi(req.URL.Query().Get("hook"))
+
+	var hook *db.Webhook
+	hooks, err := db.Webhooks()
+	if err == nil {
+		for i := range hooks {
+			if hooks[i].ID == id {
+				hook = &hooks[i]
+			}
+		}
+	}
+
+	var deliveries []db.WebhookDelivery
+	if err == nil {
+		deliveries, err = db.WebhookDeliveries(id, webhookDeliveriesShown)
+	}
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	funcs := template.FuncMap{
+		"date": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format("01/02/06 03:04:05 PM")
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	tmpl := template.Must(template.New("deliveries").Funcs(funcs).Parse(webhookDeliveriesHTML))
+	err = tmpl.Execute(buf, map[string]interface{}{
+		"Hook":       hook,
+		"Deliveries": deliveries,
+		"Retention":  db.WebhookDeliveryRetention,
+		"Attempts":   db.MaxWebhookAttempts,
+	})
+	if err != nil {
+		log.Println("Error executing webhook deliveries template:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	adminView, err := AdminFor(req, buf.Bytes())
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	res.Write(adminView)
+}
+
+func redeliverWebhookHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
+	if err != nil {
+		log.Println(err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	id, err := strconv.ParseUint(req.PostFormValue("id"), 10, 64)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		errView, err := Error400()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	d, err := db.Redeliver(id)
+	if err == db.ErrNoWebhook {
+		res.WriteHeader(http.StatusNotFound)
+		errView, err := Error404()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+	if err != nil {
+		log.Println("Error redelivering webhook:", id, err)
+		res.WriteHeader(http.StatusInternalServerError)
+		errView, err := Error500()
+		if err != nil {
+			return
+		}
+
+		res.Write(errView)
+		return
+	}
+
+	auditDetail(req, "webhook.redeliver", fmt.Sprintf("webhook:%d", d.Hook), fmt.Sprintf("%s, delivery #%d", d.Event, id), nil, nil)
+
+	redir := fmt.Sprintf("/admin/configure/webhooks/deliveries?hook=%d", d.Hook)
+	http.Redirect(res, req, redir, http.StatusFound)
+}
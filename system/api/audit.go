@@ -0,0 +1,47 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// audit adds an entry to the audit log for content changed through the API, by
+// the logged in user or API key making the request
+func audit(req *http.Request, action, t, id string, before, after []byte) {
+	actor := requestAuthor(req)
+	if k := APIKey(req); actor == "" && k != nil {
+		actor = "apikey:" + k.Name
+	}
+
+	var status string
+	if i := strings.Index(t, "__"); i >= 0 {
+		t, status = t[:i], t[i+2:]
+	}
+
+	b, a := db.AuditChanges(before, after)
+	err := db.Audit(db.AuditEntry{
+		Actor:  actor,
+		Action: action,
+		Target: t + ":" + id,
+		IP:     user.RemoteIP(req),
+		Detail: strings.TrimSpace("api " + status),
+		Before: b,
+		After:  a,
+	})
+	if err != nil {
+		log.Println("Error adding audit entry:", action, t, id, err)
+	}
+}
+
+// notify records the event for the content in the change log, and queues the
+// webhooks subscribed to it
+func notify(event, t, id string, data []byte) {
+	err := db.ContentChanged(event, t, id, data)
+	if err != nil {
+		log.Println("Error queueing webhooks:", event, t, id, err)
+	}
+}
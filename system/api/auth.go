@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// Scope actions which can be granted to an API key for a content type
+const (
+	ScopeRead   = "read"
+	ScopeCreate = "create"
+	ScopeUpdate = "update"
+	ScopeDelete = "delete"
+)
+
+// ScopeActions lists every action which can be granted to an API key
+var ScopeActions = []string{ScopeRead, ScopeCreate, ScopeUpdate, ScopeDelete}
+
+// AllTypes is used in place of a content type name to grant a scope for every type
+const AllTypes = "*"
+
+type apiKeyContextKey struct{}
+
+// Scope returns the name of the scope granting the action on content of type t,
+// e.g. Scope("Review", ScopeCreate) returns "Review:create"
+func Scope(t, action string) string {
+	return t + ":" + action
+}
+
+// APIKey returns the API key the request was authenticated with, or nil if the
+// request was not authenticated
+func APIKey(req *http.Request) *db.APIKey {
+	k, _ := req.Context().Value(apiKeyContextKey{}).(*db.APIKey)
+	return k
+}
+
+// Authenticated reports whether the request was authenticated with an API key
+func Authenticated(req *http.Request) bool {
+	return APIKey(req) != nil
+}
+
+// HasScope reports whether the request was authenticated with an API key which
+// was granted the scope, given as "Type:action" e.g. "Review:create". A key
+// granted the action for all types, e.g. "*:create", has the scope for every type.
+// It is intended to be called from item.Hideable's Hide, and Createable's Create
+// or Updateable's Update methods, e.g.
+//
+//	func (r *Review) Create(res http.ResponseWriter, req *http.Request) error {
+//		if !api.HasScope(req, api.Scope("Review", api.ScopeCreate)) {
+//			return errors.New("not allowed")
+//		}
+//		return nil
+//	}
+func HasScope(req *http.Request, scope string) bool {
+	k := APIKey(req)
+	if k == nil {
+		return false
+	}
+
+	action := scope[strings.LastIndex(scope, ":")+1:]
+	for _, s := range k.Scopes {
+		if s == scope || s == Scope(AllTypes, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Auth wraps a HandlerFunc to authenticate requests which send an API key in an
+// "Authorization: Bearer <key>" header. Requests without an API key are passed
+// on unauthenticated, and those with an invalid or revoked key are rejected.
+func Auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		k, err := db.VerifyAPIKey(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+		if err != nil {
+			log.Println("[Auth] rejected API key from:", req.RemoteAddr, err)
+			res.Header().Set("WWW-Authenticate", `Bearer realm="ponzu"`)
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// responses may differ by key, so must not be stored by shared caches
+		res.Header().Add("Vary", "Authorization")
+		res.Header().Set("Cache-Control", strings.Replace(res.Header().Get("Cache-Control"), "public", "private", 1))
+
+		ctx := context.WithValue(req.Context(), apiKeyContextKey{}, k)
+		next.ServeHTTP(res, req.WithContext(ctx))
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/tidwall/gjson"
+)
+
+// itemModified returns the time stored content was last updated, from its
+// "updated" value in milliseconds since the Unix epoch
+func itemModified(data []byte) time.Time {
+	ms := gjson.GetBytes(data, "updated").Int()
+	if ms == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// listETag creates an ETag for a list response of content of type t, which
+// changes whenever content of the type changes or the request query differs
+func listETag(req *http.Request, t string, modified time.Time) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d?%s", t, modified.UnixNano(), req.URL.RawQuery)))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// expandedETag creates a weak ETag for a response with expanded references,
+// which changes with any of the content in it. Being weak, it can't be used for
+// conditional updates.
+func expandedETag(data []byte) string {
+	return "W/" + db.ContentETag(data)
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+// changesBatch is the most changes read from the change log at once
+const changesBatch = 100
+
+// changesHeartbeat is how often a comment is sent to keep idle change feed
+// connections open through proxies
+var changesHeartbeat = time.Second * 15
+
+func changesHandler(res http.ResponseWriter, req *http.Request) {
+	// /api/changes?type=Song&type=Review or /api/changes?type=Song,Review
+	if req.Method != http.MethodGet {
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		log.Println("[Changes] response does not support streaming")
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// requested types which are hidden are not found, as they are for the other
+	// endpoints, and types which are hidden are left out of the whole feed
+	types := make(map[string]func() interface{})
+	for _, param := range req.URL.Query()["type"] {
+		for _, t := range strings.Split(param, ",") {
+			it, ok := item.Types[t]
+			if !ok {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			if hide(res, req, it()) {
+				return
+			}
+
+			types[t] = it
+		}
+	}
+
+	if len(types) == 0 {
+		for t, it := range item.Types {
+			if !hidden(req, it()) {
+				types[t] = it
+			}
+		}
+	}
+
+	// clients resume from the last event they received, or start from now
+	last := req.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = req.URL.Query().Get("last_event_id")
+	}
+
+	var after uint64
+	var err error
+	if last != "" {
+		after, err = strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		after, err = db.LastChange()
+		if err != nil {
+			log.Println("[Changes] error reading change log:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// subscribe before reading the log, so no changes are missed in between
+	changed, unsubscribe := db.SubscribeChanges()
+	defer unsubscribe()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, "retry: %d\n\n", 3000)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(changesHeartbeat)
+	defer heartbeat.Stop()
+
+	first := true
+	for {
+		changes, complete, err := db.Changes(after, changesBatch)
+		if err != nil {
+			log.Println("[Changes] error reading change log:", err)
+			return
+		}
+
+		// changes since the client's last event are no longer in the log, so
+		// it must fetch the content again
+		if first && !complete {
+			fmt.Fprintf(res, "event: reset\ndata: {}\n\n")
+
+			// a last event after the most recent change is resumed from the
+			// most recent change, so the next ones aren't skipped
+			last, err := db.LastChange()
+			if err != nil {
+				log.Println("[Changes] error reading change log:", err)
+				return
+			}
+
+			if after > last {
+				after = last
+			}
+		}
+		first = false
+
+		for _, c := range changes {
+			after = c.ID
+
+			// a reset is sent to every client, whatever types it follows
+			if c.Event == db.ChangeReset {
+				_, err = fmt.Fprintf(res, "id: %d\nevent: reset\ndata: {}\n\n", c.ID)
+				if err != nil {
+					return
+				}
+
+				continue
+			}
+
+			it, ok := types[c.Type]
+			if !ok || changeHidden(req, it, c) {
+				continue
+			}
+
+			j, err := json.Marshal(c)
+			if err != nil {
+				log.Println("[Changes] error encoding change:", err)
+				return
+			}
+
+			_, err = fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", c.ID, c.Event, j)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		if len(changes) == changesBatch {
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(res, ": ping\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// changeHidden reports whether the content of a change is hidden from the
+// request. Content which has been deleted is checked as its type, otherwise
+// as its current version, which is hidden if it is no longer public.
+func changeHidden(req *http.Request, it func() interface{}, c db.Change) bool {
+	if c.Event == db.ChangeDelete {
+		return hidden(req, it())
+	}
+
+	post, err := db.Content(c.Target)
+	if err != nil || len(post) == 0 {
+		return true
+	}
+
+	p := it()
+	err = json.Unmarshal(post, p)
+	if err != nil {
+		return true
+	}
+
+	return hidden(req, p)
+}
@@ -10,7 +10,8 @@ import (
 
 // sendPreflight is used to respond to a cross-origin "OPTIONS" request
 func sendPreflight(res http.ResponseWriter) {
-	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, If-Match")
+	res.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
 	res.Header().Set("Access-Control-Allow-Origin", "*")
 	res.WriteHeader(200)
 	return
@@ -39,8 +40,9 @@ func responseWithCORS(res http.ResponseWriter, req *http.Request) (http.Response
 		// in config
 		if origin == domain {
 			// apply limited CORS headers and return
-			res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+			res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, If-Match")
 			res.Header().Set("Access-Control-Allow-Origin", domain)
+			res.Header().Set("Access-Control-Expose-Headers", "ETag")
 			return res, true
 		}
 
@@ -50,15 +52,16 @@ func responseWithCORS(res http.ResponseWriter, req *http.Request) (http.Response
 	}
 
 	// apply full CORS headers and return
-	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type")
+	res.Header().Set("Access-Control-Allow-Headers", "Accept, Authorization, Content-Type, If-Match")
 	res.Header().Set("Access-Control-Allow-Origin", "*")
+	res.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 	return res, true
 }
 
 // CORS wraps a HandlerFunc to respond to OPTIONS requests properly
 func CORS(next http.HandlerFunc) http.HandlerFunc {
-	return db.CacheControl(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+	return db.CachePolicy(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		res, cors := responseWithCORS(res, req)
 		if !cors {
 			return
@@ -7,13 +7,10 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/ponzu-cms/ponzu/system/admin/upload"
 	"github.com/ponzu-cms/ponzu/system/db"
 	"github.com/ponzu-cms/ponzu/system/item"
-
-	"github.com/gorilla/schema"
 )
 
 // Createable accepts or rejects external POST requests to endpoints such as:
@@ -35,13 +32,6 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
-	if err != nil {
-		log.Println("[Create] error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
 	t := req.URL.Query().Get("type")
 	if t == "" {
 		res.WriteHeader(http.StatusBadRequest)
@@ -64,69 +54,6 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	ts := fmt.Sprintf("%d", int64(time.Nanosecond)*time.Now().UnixNano()/int64(time.Millisecond))
-	req.PostForm.Set("timestamp", ts)
-	req.PostForm.Set("updated", ts)
-
-	urlPaths, err := upload.StoreFiles(req)
-	if err != nil {
-		log.Println(err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	for name, urlPath := range urlPaths {
-		req.PostForm.Set(name, urlPath)
-	}
-
-	// check for any multi-value fields (ex. checkbox fields)
-	// and correctly format for db storage. Essentially, we need
-	// fieldX.0: value1, fieldX.1: value2 => fieldX: []string{value1, value2}
-	fieldOrderValue := make(map[string]map[string][]string)
-	for k, v := range req.PostForm {
-		if strings.Contains(k, ".") {
-			fo := strings.Split(k, ".")
-
-			// put the order and the field value into map
-			field := string(fo[0])
-			order := string(fo[1])
-			if len(fieldOrderValue[field]) == 0 {
-				fieldOrderValue[field] = make(map[string][]string)
-			}
-
-			// orderValue is 0:[?type=Thing&id=1]
-			orderValue := fieldOrderValue[field]
-			orderValue[order] = v
-			fieldOrderValue[field] = orderValue
-
-			// discard the post form value with name.N
-			req.PostForm.Del(k)
-		}
-
-	}
-
-	// add/set the key & value to the post form in order
-	for f, ov := range fieldOrderValue {
-		for i := 0; i < len(ov); i++ {
-			position := fmt.Sprintf("%d", i)
-			fieldValue := ov[position]
-
-			if req.PostForm.Get(f) == "" {
-				for i, fv := range fieldValue {
-					if i == 0 {
-						req.PostForm.Set(f, fv)
-					} else {
-						req.PostForm.Add(f, fv)
-					}
-				}
-			} else {
-				for _, fv := range fieldValue {
-					req.PostForm.Add(f, fv)
-				}
-			}
-		}
-	}
-
 	hook, ok := post.(item.Hookable)
 	if !ok {
 		log.Println("[Create] error: Type", t, "does not implement item.Hookable or embed item.Item.")
@@ -134,15 +61,28 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Let's be nice and make a proper item for the Hookable methods
-	dec := schema.NewDecoder()
-	dec.IgnoreUnknownKeys(true)
-	dec.SetAliasTag("json")
-	err = dec.Decode(post, req.PostForm)
-	if err != nil {
-		log.Println("Error decoding post form for edit handler:", t, err)
-		res.WriteHeader(http.StatusBadRequest)
-		return
+	// content is decoded from either a JSON body or multipart form values
+	var body []byte
+	var err error
+	if isJSONRequest(req) {
+		body, err = decodeContentJSON(req, post)
+		if err != nil {
+			log.Println("[Create] error decoding JSON for type:", t, err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		status, err := decodeContentForm(res, req, post)
+		if uerr, ok := err.(*upload.Error); ok {
+			log.Println("[Create] rejected file upload for type:", t, uerr)
+			sendUploadError(res, uerr)
+			return
+		}
+		if err != nil {
+			log.Println("[Create] error decoding form for type:", t, err)
+			res.WriteHeader(status)
+			return
+		}
 	}
 
 	err = hook.BeforeAPICreate(res, req)
@@ -181,13 +121,22 @@ func createContentHandler(res http.ResponseWriter, req *http.Request) {
 		spec = "__pending"
 	}
 
-	id, err := db.SetContent(t+spec+":-1", req.PostForm)
+	var id int
+	if body != nil {
+		id, err = db.SetContentJSONBy(t+spec+":-1", requestAuthor(req), body)
+	} else {
+		id, err = db.SetContentBy(t+spec+":-1", requestAuthor(req), req.PostForm)
+	}
 	if err != nil {
 		log.Println("[Create] error calling SetContent:", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	created, _ := db.Content(fmt.Sprintf("%s%s:%d", t, spec, id))
+	audit(req, "content.create", t+spec, fmt.Sprintf("%d", id), nil, created)
+	notify(db.WebhookCreate, t+spec, fmt.Sprintf("%d", id), created)
+
 	// set the target in the context so user can get saved value from db in hook
 	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%d", t, id))
 	req = req.WithContext(ctx)
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+)
+
+// ErrInvalidCursor is used to report a malformed after/before cursor param
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// cursors are opaque to clients, but contain either a key from the __sorted
+// bucket ("k:<timestamp>:<i>"), or for filtered/sorted queries which are not
+// ordered by sorted key, the position of an item in the result set ("o:<n>")
+const (
+	cursorKey    = "k:"
+	cursorOffset = "o:"
+)
+
+func encodeCursor(c string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c))
+}
+
+func decodeCursor(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+
+	c := string(b)
+	if !strings.HasPrefix(c, cursorKey) && !strings.HasPrefix(c, cursorOffset) {
+		return "", ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+// applyCursor sets the query options to continue from the after or before
+// cursor param in the request, if either is present
+func applyCursor(q url.Values, opts *db.QueryOptions) error {
+	after, before := q.Get("after"), q.Get("before")
+	if after == "" && before == "" {
+		return nil
+	}
+
+	if after != "" && before != "" {
+		return ErrInvalidCursor
+	}
+
+	c, err := decodeCursor(after + before)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(c, cursorKey) {
+		if after != "" {
+			opts.After = strings.TrimPrefix(c, cursorKey)
+		} else {
+			opts.Before = strings.TrimPrefix(c, cursorKey)
+		}
+
+		return nil
+	}
+
+	// offset cursors hold the position of the first item of the next page for
+	// after, or of the first item of the current page for before
+	pos, err := strconv.Atoi(strings.TrimPrefix(c, cursorOffset))
+	if err != nil || pos < 0 {
+		return ErrInvalidCursor
+	}
+
+	if opts.Count < 1 {
+		opts.Offset = 0
+		return nil
+	}
+
+	if before != "" {
+		pos -= opts.Count
+		if pos < 0 {
+			pos = 0
+		}
+	}
+
+	opts.Offset = pos / opts.Count
+
+	return nil
+}
+
+// pageMeta creates the "meta" object of a /api/contents response, with the
+// total and count of content as well as cursors and links to adjacent pages
+func pageMeta(req *http.Request, opts db.QueryOptions, page db.QueryPage) map[string]interface{} {
+	cursors := make(map[string]string)
+	links := make(map[string]string)
+
+	next, prev := pageCursors(opts, page)
+	if next != "" {
+		cursors["next"] = next
+		links["next"] = pageLink(req, "after", next)
+	}
+
+	if prev != "" {
+		cursors["prev"] = prev
+		links["prev"] = pageLink(req, "before", prev)
+	}
+
+	return map[string]interface{}{
+		"total":   page.Total,
+		"count":   len(page.Content),
+		"cursors": cursors,
+		"links":   links,
+	}
+}
+
+// pageCursors returns the encoded cursors to the pages after and before the page,
+// which are empty if there is no such page
+func pageCursors(opts db.QueryOptions, page db.QueryPage) (string, string) {
+	if len(page.Content) == 0 {
+		return "", ""
+	}
+
+	var next, prev string
+	if len(opts.Filters) > 0 || len(opts.Sort) > 0 {
+		start := opts.Count * opts.Offset
+		next = fmt.Sprintf("%s%d", cursorOffset, start+len(page.Content))
+		prev = fmt.Sprintf("%s%d", cursorOffset, start)
+	} else {
+		next = cursorKey + page.Last
+		prev = cursorKey + page.First
+	}
+
+	if !page.HasNext {
+		next = ""
+	} else {
+		next = encodeCursor(next)
+	}
+
+	if !page.HasPrev {
+		prev = ""
+	} else {
+		prev = encodeCursor(prev)
+	}
+
+	return next, prev
+}
+
+func pageLink(req *http.Request, param, cursor string) string {
+	q := req.URL.Query()
+	q.Del("after")
+	q.Del("before")
+	q.Del("offset")
+	q.Set(param, cursor)
+
+	return req.URL.Path + "?" + q.Encode()
+}
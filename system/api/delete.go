@@ -110,6 +110,9 @@ func deleteContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	audit(req, "content.delete", t, id, b, nil)
+	notify(db.WebhookDelete, t, id, b)
+
 	err = hook.AfterDelete(res, req)
 	if err != nil {
 		log.Println("[Delete] error calling AfterDelete:", err)
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// expandTree holds the reference fields to expand, and the fields to expand in
+// the content they reference
+type expandTree map[string]expandTree
+
+// parseExpand reads the expand param of a request to /api/content(s), a
+// comma-separated list of reference fields to replace by the content they
+// reference, i.e. expand=author,tags. Fields of referenced content are expanded
+// by their dot-separated path, i.e. expand=author.company
+func parseExpand(s string) (expandTree, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	depth, _ := db.ConfigCache("expand_max_depth").(float64)
+	if depth < 1 {
+		depth = 2
+	}
+
+	tree := make(expandTree)
+	for _, path := range strings.Split(s, ",") {
+		path = strings.TrimSpace(path)
+		if !rxFieldName.MatchString(path) {
+			return nil, fmt.Errorf("invalid expand field: %s", path)
+		}
+
+		fields := strings.Split(path, ".")
+		if len(fields) > int(depth) {
+			return nil, fmt.Errorf("cannot expand more than %d levels of references: %s", int(depth), path)
+		}
+
+		t := tree
+		for _, f := range fields {
+			if f == "" {
+				return nil, fmt.Errorf("invalid expand field: %s", path)
+			}
+
+			if t[f] == nil {
+				t[f] = make(expandTree)
+			}
+			t = t[f]
+		}
+	}
+
+	return tree, nil
+}
+
+// expandDoc is content in a response in which references are expanded, with the
+// targets of the content it is nested in, to detect cycles
+type expandDoc struct {
+	data      []byte
+	ancestors []string
+}
+
+// expandSlot is a reference in an expandDoc, at the json path, and the content
+// it is replaced by
+type expandSlot struct {
+	doc    *expandDoc
+	field  string
+	path   string
+	target string
+	expand *expandDoc
+}
+
+// expand replaces the references in the content of a response by the content
+// they reference, for the fields in the tree. Referenced content which is
+// hidden from the request is replaced by null, and fields omitted from its type
+// are removed.
+func expand(res http.ResponseWriter, req *http.Request, t string, data []byte, tree expandTree) ([]byte, error) {
+	if len(tree) == 0 {
+		return data, nil
+	}
+
+	var docs []*expandDoc
+	gjson.GetBytes(data, "data").ForEach(func(k, v gjson.Result) bool {
+		docs = append(docs, &expandDoc{
+			data:      []byte(v.Raw),
+			ancestors: []string{fmt.Sprintf("%s:%d", t, v.Get("id").Int())},
+		})
+		return true
+	})
+
+	err := expandRefs(res, req, docs, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, doc := range docs {
+		data, err = sjson.SetRawBytes(data, fmt.Sprintf("data.%d", i), doc.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// expandRefs expands the references in each of the docs, one level of the tree
+// at a time, so the content referenced by all of them is fetched together
+func expandRefs(res http.ResponseWriter, req *http.Request, docs []*expandDoc, tree expandTree) error {
+	var slots []*expandSlot
+	targets := make(map[string][]string)
+	seen := make(map[string]bool)
+
+	add := func(doc *expandDoc, field, path, ref string) {
+		t, target, ok := parseReference(ref)
+		if !ok {
+			return
+		}
+
+		// content referencing content it is nested in is left as a reference
+		for _, a := range doc.ancestors {
+			if a == target {
+				return
+			}
+		}
+
+		slots = append(slots, &expandSlot{doc: doc, field: field, path: path, target: target})
+		if !seen[target] {
+			seen[target] = true
+			targets[t] = append(targets[t], target)
+		}
+	}
+
+	for _, doc := range docs {
+		for field := range tree {
+			v := gjson.GetBytes(doc.data, field)
+			switch {
+			case v.Type == gjson.String:
+				add(doc, field, field, v.String())
+
+			case v.Type == gjson.JSON && strings.HasPrefix(v.Raw, "["):
+				for i, r := range v.Array() {
+					if r.Type == gjson.String {
+						add(doc, field, fmt.Sprintf("%s.%d", field, i), r.String())
+					}
+				}
+			}
+		}
+	}
+
+	if len(slots) == 0 {
+		return nil
+	}
+
+	content := make(map[string][]byte)
+	for t, tt := range targets {
+		err := expandContent(res, req, t, tt, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	// expand the next level of each field in the content it references
+	next := make(map[string][]*expandDoc)
+	for _, s := range slots {
+		post, ok := content[s.target]
+		if !ok {
+			continue
+		}
+
+		ancestors := append(append([]string{}, s.doc.ancestors...), s.target)
+		s.expand = &expandDoc{data: post, ancestors: ancestors}
+		next[s.field] = append(next[s.field], s.expand)
+	}
+
+	for field, docs := range next {
+		if len(tree[field]) == 0 {
+			continue
+		}
+
+		err := expandRefs(res, req, docs, tree[field])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range slots {
+		var err error
+		if s.expand == nil {
+			s.doc.data, err = sjson.SetBytes(s.doc.data, s.path, nil)
+		} else {
+			s.doc.data, err = sjson.SetRawBytes(s.doc.data, s.path, s.expand.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expandContent fetches the targets of type t, and adds the content which isn't
+// hidden from the request to content by target, without its omitted fields
+func expandContent(res http.ResponseWriter, req *http.Request, t string, targets []string, content map[string][]byte) error {
+	it, ok := item.Types[t]
+	if !ok || hidden(req, it()) {
+		return nil
+	}
+
+	posts, err := db.ContentMulti(targets)
+	if err != nil {
+		return err
+	}
+
+	var found []string
+	var result []json.RawMessage
+	for i, post := range posts {
+		// content which doesn't exist, or is scheduled and not public
+		if len(post) == 0 {
+			continue
+		}
+
+		p := it()
+		err := json.Unmarshal(post, p)
+		if err != nil {
+			return err
+		}
+
+		if hidden(req, p) {
+			continue
+		}
+
+		found = append(found, targets[i])
+		result = append(result, post)
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	j, err := fmtJSON(result...)
+	if err != nil {
+		return err
+	}
+
+	j, err = omit(res, req, it(), j)
+	if err != nil {
+		return err
+	}
+
+	for i, target := range found {
+		content[target] = []byte(gjson.GetBytes(j, fmt.Sprintf("data.%d", i)).Raw)
+	}
+
+	return nil
+}
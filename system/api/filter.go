@@ -0,0 +1,122 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+// rxFilterParam matches query params in the form filter[field] or
+// filter[field][op], where field is a json tag name or a dot-separated path
+var rxFilterParam = regexp.MustCompile(`^filter\[([A-Za-z0-9_.]+)\](?:\[([a-z]+)\])?$`)
+
+var rxFieldName = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// parseFilters reads field-level filters from the query params of a request
+// to /api/contents, i.e. filter[category]=news or filter[price][lt]=100.
+// Values for the "in" operator are comma-separated.
+func parseFilters(q url.Values) ([]db.Filter, error) {
+	var filters []db.Filter
+	for k, vv := range q {
+		if !strings.HasPrefix(k, "filter[") {
+			continue
+		}
+
+		m := rxFilterParam.FindStringSubmatch(k)
+		if m == nil {
+			return nil, fmt.Errorf("invalid filter param: %s", k)
+		}
+
+		field, op := m[1], m[2]
+		if op == "" {
+			op = db.FilterEq
+		}
+
+		if !db.IsFilterOp(op) {
+			return nil, fmt.Errorf("invalid filter operator: %s", op)
+		}
+
+		var values []string
+		for _, v := range vv {
+			if op == db.FilterIn {
+				values = append(values, strings.Split(v, ",")...)
+				continue
+			}
+
+			values = append(values, v)
+		}
+
+		filters = append(filters, db.Filter{
+			Field:  field,
+			Op:     op,
+			Values: values,
+		})
+	}
+
+	return filters, nil
+}
+
+// parseSort reads the sort param of a request to /api/contents, a comma-separated
+// list of json tag names, each prefixed by "-" to sort in descending order
+// i.e. sort=-price,title
+func parseSort(s string) ([]db.SortBy, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var sorts []db.SortBy
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		desc := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+
+		if !rxFieldName.MatchString(field) {
+			return nil, fmt.Errorf("invalid sort field: %s", field)
+		}
+
+		sorts = append(sorts, db.SortBy{
+			Field: field,
+			Desc:  desc,
+		})
+	}
+
+	return sorts, nil
+}
+
+// checkOmittedFields ensures that fields hidden from responses by an
+// item.Omittable can't be probed through filters or sorting
+func checkOmittedFields(res http.ResponseWriter, req *http.Request, it interface{}, filters []db.Filter, sorts []db.SortBy) error {
+	om, ok := it.(item.Omittable)
+	if !ok {
+		return nil
+	}
+
+	fields, err := om.Omit(res, req)
+	if err != nil {
+		return err
+	}
+
+	omitted := make(map[string]bool)
+	for _, f := range fields {
+		omitted[f] = true
+	}
+
+	for _, f := range filters {
+		if omitted[strings.Split(f.Field, ".")[0]] {
+			return fmt.Errorf("cannot filter on omitted field: %s", f.Field)
+		}
+	}
+
+	for _, s := range sorts {
+		if omitted[strings.Split(s.Field, ".")[0]] {
+			return fmt.Errorf("cannot sort on omitted field: %s", s.Field)
+		}
+	}
+
+	return nil
+}
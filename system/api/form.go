@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/admin/upload"
+	"github.com/ponzu-cms/ponzu/system/db"
+
+	"github.com/gorilla/schema"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// maxJSONBody limits the size of application/json request bodies to 4MB,
+// the same as the maxMemory used to parse multipart forms
+const maxJSONBody = 1024 * 1024 * 4
+
+// ErrInvalidJSON is used to report a request body which is not a JSON object
+var ErrInvalidJSON = errors.New("Request body must be a JSON object")
+
+// requestAuthor returns the email of the logged in user making a request, if any,
+// to record as the author of content revisions
+func requestAuthor(req *http.Request) string {
+	usr, err := db.CurrentUser(req)
+	if err != nil {
+		return ""
+	}
+
+	return gjson.GetBytes(usr, "email").String()
+}
+
+// isJSONRequest checks if the request body is encoded as application/json
+func isJSONRequest(req *http.Request) bool {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return mt == "application/json"
+}
+
+// decodeContentJSON reads the application/json body of a create or update
+// request and decodes it into post. The id and uuid of the content are managed
+// by the system and are removed, and timestamp and updated are set to now. The
+// returned json is to be stored with db.SetContentJSON or db.UpdateContentJSON.
+func decodeContentJSON(req *http.Request, post interface{}) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxJSONBody+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxJSONBody {
+		return nil, fmt.Errorf("Request body exceeds %d bytes", maxJSONBody)
+	}
+
+	body = bytes.TrimSpace(body)
+	if !bytes.HasPrefix(body, []byte("{")) || !json.Valid(body) {
+		return nil, ErrInvalidJSON
+	}
+
+	for _, k := range []string{"id", "uuid"} {
+		body, err = sjson.DeleteBytes(body, k)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ts := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+	for _, k := range []string{"timestamp", "updated"} {
+		body, err = sjson.SetBytes(body, k, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(body, post)
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// decodeContentForm parses the multipart/form-data body of a create or update
+// request, stores any files uploaded with it, and decodes the form values into
+// post. The timestamp and updated values are set to now. If the request can't
+// be decoded, the HTTP status code to respond with is returned with the error,
+// which is an *upload.Error if an uploaded file was rejected.
+func decodeContentForm(res http.ResponseWriter, req *http.Request, post interface{}) (int, error) {
+	err := upload.ParseForm(res, req)
+	if uerr, ok := err.(*upload.Error); ok {
+		return uerr.Status, uerr
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	ts := fmt.Sprintf("%d", int64(time.Nanosecond)*time.Now().UnixNano()/int64(time.Millisecond))
+	req.PostForm.Set("timestamp", ts)
+	req.PostForm.Set("updated", ts)
+
+	// files from external clients are served from the site, so those which
+	// could run scripts in it are rejected
+	policy := upload.PolicyFor(post)
+	policy.Public = true
+
+	urlPaths, err := upload.StoreFiles(req, policy)
+	if uerr, ok := err.(*upload.Error); ok {
+		return uerr.Status, uerr
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	for name, urlPath := range urlPaths {
+		req.PostForm.Set(name, urlPath)
+	}
+
+	// check for any multi-value fields (ex. checkbox fields)
+	// and correctly format for db storage. Essentially, we need
+	// fieldX.0: value1, fieldX.1: value2 => fieldX: []string{value1, value2}
+	fieldOrderValue := make(map[string]map[string][]string)
+	for k, v := range req.PostForm {
+		if strings.Contains(k, ".") {
+			fo := strings.Split(k, ".")
+
+			// put the order and the field value into map
+			field := string(fo[0])
+			order := string(fo[1])
+			if len(fieldOrderValue[field]) == 0 {
+				fieldOrderValue[field] = make(map[string][]string)
+			}
+
+			// orderValue is 0:[?type=Thing&id=1]
+			orderValue := fieldOrderValue[field]
+			orderValue[order] = v
+			fieldOrderValue[field] = orderValue
+
+			// discard the post form value with name.N
+			req.PostForm.Del(k)
+		}
+
+	}
+
+	// add/set the key & value to the post form in order
+	for f, ov := range fieldOrderValue {
+		for i := 0; i < len(ov); i++ {
+			position := fmt.Sprintf("%d", i)
+			fieldValue := ov[position]
+
+			if req.PostForm.Get(f) == "" {
+				for i, fv := range fieldValue {
+					if i == 0 {
+						req.PostForm.Set(f, fv)
+					} else {
+						req.PostForm.Add(f, fv)
+					}
+				}
+			} else {
+				for _, fv := range fieldValue {
+					req.PostForm.Add(f, fv)
+				}
+			}
+		}
+	}
+
+	// Let's be nice and make a proper item for the Hookable methods
+	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
+	dec.SetAliasTag("json")
+	err = dec.Decode(post, req.PostForm)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	return http.StatusOK, nil
+}
+
+// sendUploadError responds to a request with a file which was rejected, with
+// the field, file and reason so clients can show them to their users
+func sendUploadError(res http.ResponseWriter, uerr *upload.Error) {
+	j, err := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"field":    uerr.Field,
+			"filename": uerr.Filename,
+			"message":  uerr.Error(),
+		},
+	})
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(uerr.Status)
+	res.Write(j)
+}
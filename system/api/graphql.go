@@ -0,0 +1,1147 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ponzu-cms/ponzu/system/api/graphql"
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/search"
+
+	"github.com/tidwall/gjson"
+)
+
+// graphqlMaxDepth is the deepest a query may select, as each level of references
+// reads more content from the db
+const graphqlMaxDepth = 10
+
+var rxGraphQLName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// gqlType is an object type in the GraphQL schema, either a content type or the
+// page of content returned by a list query
+type gqlType struct {
+	name   string
+	new    func() interface{}
+	fields []gqlField
+	search bool
+}
+
+func (t *gqlType) field(name string) (gqlField, bool) {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f, true
+		}
+	}
+
+	return gqlField{}, false
+}
+
+// gqlField is a field of a gqlType, which is a scalar ("String", "Int", "Float",
+// "Boolean" or "JSON") or an object type, or a list of either
+type gqlField struct {
+	name   string
+	scalar string
+	object *gqlType
+	list   bool
+}
+
+func (f gqlField) typeName() string {
+	name := f.scalar
+	if f.object != nil {
+		name = f.object.name
+	}
+
+	if f.list {
+		return "[" + name + "]"
+	}
+
+	return name
+}
+
+var (
+	gqlTypesOnce = &sync.Once{}
+	gqlTypes     map[string]*gqlType
+)
+
+// graphqlTypes returns the GraphQL types of the content types in item.Types,
+// with their fields found by reflection
+func graphqlTypes() map[string]*gqlType {
+	gqlTypesOnce.Do(func() {
+		gqlTypes = make(map[string]*gqlType)
+		for name, it := range item.Types {
+			if !rxGraphQLName.MatchString(name) {
+				continue
+			}
+
+			t := &gqlType{name: name, new: it}
+			gqlFields(reflect.TypeOf(it()), t, make(map[string]bool))
+
+			if s, ok := it().(search.Searchable); ok && s.IndexContent() {
+				t.search = true
+			}
+
+			gqlTypes[name] = t
+		}
+	})
+
+	return gqlTypes
+}
+
+// gqlFields adds the fields of the struct type rt to t, by their json names,
+// including the fields of embedded structs such as item.Item
+func gqlFields(rt reflect.Type, t *gqlType, seen map[string]bool) {
+	for rt.Kind() == reflect.Ptr {
+		rt = rt.Elem()
+	}
+
+	if rt.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			gqlFields(f.Type, t, seen)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		if seen[name] || !rxGraphQLName.MatchString(name) {
+			continue
+		}
+		seen[name] = true
+
+		scalar, list := gqlScalar(f.Type)
+		t.fields = append(t.fields, gqlField{name: name, scalar: scalar, list: list})
+	}
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// gqlScalar returns the scalar type for values of rt, and whether it is a list.
+// Values which aren't a simple scalar or list of scalars are JSON.
+func gqlScalar(rt reflect.Type) (string, bool) {
+	if rt.Implements(textMarshaler) || reflect.PtrTo(rt).Implements(textMarshaler) {
+		return "String", false
+	}
+
+	if rt.Implements(jsonMarshaler) || reflect.PtrTo(rt).Implements(jsonMarshaler) {
+		return "JSON", false
+	}
+
+	switch rt.Kind() {
+	case reflect.String:
+		return "String", false
+	case reflect.Bool:
+		return "Boolean", false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "Int", false
+	case reflect.Float32, reflect.Float64:
+		return "Float", false
+	case reflect.Ptr:
+		return gqlScalar(rt.Elem())
+	case reflect.Slice, reflect.Array:
+		// []byte is encoded as a base64 string
+		if rt.Elem().Kind() == reflect.Uint8 {
+			return "String", false
+		}
+
+		elem, list := gqlScalar(rt.Elem())
+		if list || elem == "JSON" {
+			return "JSON", false
+		}
+
+		return elem, true
+	}
+
+	return "JSON", false
+}
+
+// gqlListType returns the type of a page of content of type t
+func gqlListType(t *gqlType) *gqlType {
+	return &gqlType{
+		name: t.name + "List",
+		fields: []gqlField{
+			{name: "total", scalar: "Int"},
+			{name: "count", scalar: "Int"},
+			{name: "items", object: t, list: true},
+			{name: "next", scalar: "String"},
+			{name: "prev", scalar: "String"},
+		},
+	}
+}
+
+// graphqlSchema returns the schema of the types which aren't hidden from the
+// request, in the GraphQL schema language
+func graphqlSchema(req *http.Request) []byte {
+	var names []string
+	for name, t := range graphqlTypes() {
+		if !hidden(req, t.new()) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("scalar JSON\n\n")
+	buf.WriteString("input Filter {\n  field: String!\n  op: String\n  value: String\n  values: [String!]\n}\n\n")
+
+	buf.WriteString("type Query {\n")
+	for _, name := range names {
+		t := graphqlTypes()[name]
+		fmt.Fprintf(buf, "  %s(id: Int, slug: String): %s\n", name, name)
+		fmt.Fprintf(buf, "  all%s(count: Int = 10, offset: Int = 0, order: String = \"desc\", sort: String, filter: [Filter!], after: String, before: String): %sList\n", name, name)
+		if t.search {
+			fmt.Fprintf(buf, "  search%s(q: String!, count: Int = 10, offset: Int = 0): [%s]\n", name, name)
+		}
+	}
+	buf.WriteString("}\n")
+
+	for _, name := range names {
+		t := graphqlTypes()[name]
+		omitted := omittedFields(req, t)
+		for _, t := range []*gqlType{t, gqlListType(t)} {
+			fmt.Fprintf(buf, "\ntype %s {\n", t.name)
+			for _, f := range t.fields {
+				if !omitted[f.name] {
+					fmt.Fprintf(buf, "  %s: %s\n", f.name, f.typeName())
+				}
+			}
+			buf.WriteString("}\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// gqlObject is an object in a GraphQL response, with its fields in the order
+// they were selected
+type gqlObject []gqlEntry
+
+type gqlEntry struct {
+	key   string
+	value interface{}
+}
+
+func (o gqlObject) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, e := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(e.key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// gqlExec holds the state of a query as it is executed
+type gqlExec struct {
+	res    http.ResponseWriter
+	req    *http.Request
+	doc    *graphql.Document
+	vars   map[string]interface{}
+	errors []*graphql.Error
+
+	// content read by target, so references to the same content are read once
+	content map[string]map[string]interface{}
+
+	// fields omitted from each type, as they are left out of the schema
+	omit map[string]map[string]bool
+
+	// AfterAPIResponse hooks, called once the response is sent
+	after []func()
+}
+
+// omitted returns the fields of the type which are omitted from responses to
+// the request
+func (e *gqlExec) omitted(t *gqlType) map[string]bool {
+	if fields, ok := e.omit[t.name]; ok {
+		return fields
+	}
+
+	e.omit[t.name] = omittedFields(e.req, t)
+	return e.omit[t.name]
+}
+
+// omittedFields returns the fields of the type which an item.Omittable omits
+// from responses to the request
+func omittedFields(req *http.Request, t *gqlType) map[string]bool {
+	omitted := make(map[string]bool)
+	if t.new == nil {
+		return omitted
+	}
+
+	if om, ok := t.new().(item.Omittable); ok {
+		fields, err := om.Omit(discardResponse{header: make(http.Header)}, req)
+		if err != nil {
+			log.Println("[GraphQL] error calling Omit:", err)
+		}
+
+		for _, f := range fields {
+			omitted[f] = true
+		}
+	}
+
+	return omitted
+}
+
+func (e *gqlExec) errorf(f *graphql.Field, path []interface{}, format string, args ...interface{}) {
+	err := graphql.Errorf(f.Location, format, args...)
+	err.Path = append([]interface{}{}, path...)
+	e.errors = append(e.errors, err)
+}
+
+// query executes the selections of the query operation
+func (e *gqlExec) query(op *graphql.Operation) gqlObject {
+	var data gqlObject
+	fields, keys := e.collect("Query", op.SelectionSet, nil)
+	for _, key := range keys {
+		f := fields[key][0]
+		path := []interface{}{key}
+
+		if f.Name == "__typename" {
+			data = append(data, gqlEntry{key, "Query"})
+			continue
+		}
+
+		data = append(data, gqlEntry{key, e.root(f, merged(fields[key]), path)})
+	}
+
+	return data
+}
+
+// root resolves a field of the Query type
+func (e *gqlExec) root(f *graphql.Field, set []graphql.Selection, path []interface{}) interface{} {
+	var t *gqlType
+	var kind string
+	for _, prefix := range []string{"", "all", "search"} {
+		if !strings.HasPrefix(f.Name, prefix) {
+			continue
+		}
+
+		if typ, ok := graphqlTypes()[strings.TrimPrefix(f.Name, prefix)]; ok {
+			t, kind = typ, prefix
+			break
+		}
+	}
+
+	if t == nil || hidden(e.req, t.new()) || (kind == "search" && !t.search) {
+		e.errorf(f, path, "Cannot query field %q on type %q", f.Name, "Query")
+		return nil
+	}
+
+	if len(set) == 0 {
+		e.errorf(f, path, "Field %q must have a selection of subfields", f.Name)
+		return nil
+	}
+
+	args := graphql.Arguments(f.Arguments, e.vars)
+	switch kind {
+	case "":
+		if !e.checkArgs(f, path, args, "id", "slug") {
+			return nil
+		}
+
+		return e.single(f, t, args, set, path)
+
+	case "all":
+		if !e.checkArgs(f, path, args, "count", "offset", "order", "sort", "filter", "after", "before") {
+			return nil
+		}
+
+		return e.list(f, t, args, set, path)
+
+	default:
+		if !e.checkArgs(f, path, args, "q", "count", "offset") {
+			return nil
+		}
+
+		return e.search(f, t, args, set, path)
+	}
+}
+
+func (e *gqlExec) checkArgs(f *graphql.Field, path []interface{}, args map[string]interface{}, allowed ...string) bool {
+	for name := range args {
+		ok := false
+		for _, a := range allowed {
+			ok = ok || name == a
+		}
+
+		if !ok {
+			e.errorf(f, path, "Unknown argument %q on field %q", name, f.Name)
+			return false
+		}
+	}
+
+	return true
+}
+
+// single resolves content by its id or slug
+func (e *gqlExec) single(f *graphql.Field, t *gqlType, args map[string]interface{}, set []graphql.Selection, path []interface{}) interface{} {
+	var target string
+	switch {
+	case args["id"] != nil:
+		id, ok := gqlInt(args["id"])
+		if !ok {
+			e.errorf(f, path, "Argument %q must be an Int", "id")
+			return nil
+		}
+
+		target = fmt.Sprintf("%s:%d", t.name, id)
+
+	case args["slug"] != nil:
+		slug, ok := args["slug"].(string)
+		if !ok {
+			e.errorf(f, path, "Argument %q must be a String", "slug")
+			return nil
+		}
+
+		// lookup type:id by slug key in __contentIndex
+		typ, post, err := db.ContentBySlug(slug)
+		if err != nil || typ != t.name || len(post) == 0 {
+			return nil
+		}
+
+		target = fmt.Sprintf("%s:%d", t.name, gjson.GetBytes(post, "id").Int())
+
+	default:
+		e.errorf(f, path, "Field %q requires an id or slug argument", f.Name)
+		return nil
+	}
+
+	obj, err := e.fetch(t, target)
+	if err != nil {
+		log.Println("[GraphQL] error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	if obj == nil {
+		return nil
+	}
+
+	return e.object(t, obj, set, path, 1)
+}
+
+// list resolves a page of content, with the same options as /api/contents
+func (e *gqlExec) list(f *graphql.Field, t *gqlType, args map[string]interface{}, set []graphql.Selection, path []interface{}) interface{} {
+	opts := db.QueryOptions{Count: 10, Order: "desc"}
+
+	for _, arg := range []struct {
+		name string
+		n    *int
+	}{{"count", &opts.Count}, {"offset", &opts.Offset}} {
+		if args[arg.name] == nil {
+			continue
+		}
+
+		n, ok := gqlInt(args[arg.name])
+		if !ok {
+			e.errorf(f, path, "Argument %q must be an Int", arg.name)
+			return nil
+		}
+
+		*arg.n = int(n)
+	}
+
+	if order, ok := args["order"].(string); ok && strings.ToLower(order) == "asc" {
+		opts.Order = "asc"
+	}
+
+	var err error
+	if s, ok := args["sort"].(string); ok {
+		opts.Sort, err = parseSort(s)
+		if err != nil {
+			e.errorf(f, path, "%s", err)
+			return nil
+		}
+	}
+
+	opts.Filters, err = gqlFilters(args["filter"])
+	if err != nil {
+		e.errorf(f, path, "%s", err)
+		return nil
+	}
+
+	err = checkOmittedFields(e.res, e.req, t.new(), opts.Filters, opts.Sort)
+	if err != nil {
+		e.errorf(f, path, "%s", err)
+		return nil
+	}
+
+	q := url.Values{}
+	for _, name := range []string{"after", "before"} {
+		if c, ok := args[name].(string); ok {
+			q.Set(name, c)
+		}
+	}
+
+	err = applyCursor(q, &opts)
+	if err != nil {
+		e.errorf(f, path, "%s", err)
+		return nil
+	}
+
+	page := db.QueryCursor(t.name+"__sorted", opts)
+	items, err := e.prepare(t, page.Content)
+	if err != nil {
+		log.Println("[GraphQL] error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	next, prev := pageCursors(opts, page)
+	obj := map[string]interface{}{
+		"total": page.Total,
+		"count": len(items),
+		"items": items,
+		"next":  nil,
+		"prev":  nil,
+	}
+
+	if next != "" {
+		obj["next"] = next
+	}
+
+	if prev != "" {
+		obj["prev"] = prev
+	}
+
+	return e.object(gqlListType(t), obj, set, path, 1)
+}
+
+// search resolves the content matching a search query, ordered by relevance
+func (e *gqlExec) search(f *graphql.Field, t *gqlType, args map[string]interface{}, set []graphql.Selection, path []interface{}) interface{} {
+	q, ok := args["q"].(string)
+	if !ok || q == "" {
+		e.errorf(f, path, "Field %q requires a q argument", f.Name)
+		return nil
+	}
+
+	count, offset := int64(10), int64(0)
+	if args["count"] != nil {
+		count, ok = gqlInt(args["count"])
+		if !ok {
+			e.errorf(f, path, "Argument %q must be an Int", "count")
+			return nil
+		}
+	}
+
+	if args["offset"] != nil {
+		offset, ok = gqlInt(args["offset"])
+		if !ok {
+			e.errorf(f, path, "Argument %q must be an Int", "offset")
+			return nil
+		}
+	}
+
+	matches, err := search.TypeQuery(t.name, q, int(count), int(offset))
+	if err == search.ErrNoIndex {
+		e.errorf(f, path, "Search is not enabled for type %q", t.name)
+		return nil
+	}
+	if err != nil {
+		log.Println("[GraphQL] search error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	posts, err := db.ContentMulti(matches)
+	if err != nil {
+		log.Println("[GraphQL] search error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	items, err := e.prepare(t, posts)
+	if err != nil {
+		log.Println("[GraphQL] error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	list := []interface{}{}
+	for i, it := range items {
+		list = append(list, e.object(t, it, set, append(path, i), 1))
+	}
+
+	return list
+}
+
+// fetch returns the content at the target, prepared for the response, or nil if
+// it doesn't exist or is hidden
+func (e *gqlExec) fetch(t *gqlType, target string) (map[string]interface{}, error) {
+	if obj, ok := e.content[target]; ok {
+		return obj, nil
+	}
+
+	post, err := db.Content(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]interface{}
+	items, err := e.prepare(t, [][]byte{post})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) > 0 {
+		obj = items[0]
+	}
+
+	e.content[target] = obj
+	return obj, nil
+}
+
+// prepare runs content through the same steps as responses from the content
+// API: hidden content is left out, omitted fields are removed, and the type's
+// BeforeAPIResponse hook is called
+func (e *gqlExec) prepare(t *gqlType, posts [][]byte) ([]map[string]interface{}, error) {
+	var result []json.RawMessage
+	for _, post := range posts {
+		// content which doesn't exist, or is scheduled and not public
+		if len(post) == 0 {
+			continue
+		}
+
+		p := t.new()
+		err := json.Unmarshal(post, p)
+		if err != nil {
+			return nil, err
+		}
+
+		if hidden(e.req, p) {
+			continue
+		}
+
+		result = append(result, post)
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	j, err := fmtJSON(result...)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err = omit(e.res, e.req, t.new(), j)
+	if err != nil {
+		return nil, err
+	}
+
+	if hook, ok := t.new().(item.Hookable); ok {
+		j, err = hook.BeforeAPIResponse(e.res, e.req, j)
+		if err != nil {
+			return nil, fmt.Errorf("calling BeforeAPIResponse: %v", err)
+		}
+
+		data := j
+		e.after = append(e.after, func() {
+			err := hook.AfterAPIResponse(e.res, e.req, data)
+			if err != nil {
+				log.Println("[Response] error calling AfterAPIResponse:", err)
+			}
+		})
+	}
+
+	var resp struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	err = dec.Decode(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// object resolves the selections on an object of type t
+func (e *gqlExec) object(t *gqlType, obj map[string]interface{}, set []graphql.Selection, path []interface{}, depth int) interface{} {
+	if depth > graphqlMaxDepth {
+		e.errors = append(e.errors, &graphql.Error{
+			Message: fmt.Sprintf("Query is nested more than %d levels deep", graphqlMaxDepth),
+			Path:    append([]interface{}{}, path...),
+		})
+		return nil
+	}
+
+	var result gqlObject
+	fields, keys := e.collect(t.name, set, nil)
+	for _, key := range keys {
+		f := fields[key][0]
+		sub := merged(fields[key])
+		fpath := append(append([]interface{}{}, path...), key)
+
+		if f.Name == "__typename" {
+			result = append(result, gqlEntry{key, t.name})
+			continue
+		}
+
+		def, ok := t.field(f.Name)
+		if !ok || e.omitted(t)[f.Name] {
+			e.errorf(f, fpath, "Cannot query field %q on type %q", f.Name, t.name)
+			result = append(result, gqlEntry{key, nil})
+			continue
+		}
+
+		if len(f.Arguments) > 0 {
+			e.errorf(f, fpath, "Unknown argument %q on field %q", f.Arguments[0].Name, f.Name)
+			result = append(result, gqlEntry{key, nil})
+			continue
+		}
+
+		// fields left out by BeforeAPIResponse are null
+		value := obj[def.name]
+
+		switch {
+		case def.object != nil:
+			value = e.objects(def.object, value, sub, fpath, depth)
+
+		case len(sub) > 0 && def.scalar == "String":
+			value = e.references(f, value, sub, fpath, depth)
+
+		case len(sub) > 0:
+			e.errorf(f, fpath, "Field %q of type %q must not have a selection", f.Name, def.typeName())
+			value = nil
+		}
+
+		result = append(result, gqlEntry{key, value})
+	}
+
+	return result
+}
+
+// objects resolves the selections on a value of an object type, or a list of
+// them
+func (e *gqlExec) objects(t *gqlType, value interface{}, set []graphql.Selection, path []interface{}, depth int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return e.object(t, v, set, path, depth+1)
+
+	case []map[string]interface{}:
+		list := []interface{}{}
+		for i := range v {
+			list = append(list, e.object(t, v[i], set, append(path, i), depth+1))
+		}
+
+		return list
+	}
+
+	return nil
+}
+
+// references resolves the selections on a String field (or list) holding
+// references to other content, in the form "/api/content?type=Type&id=1" saved
+// by the reference addon
+func (e *gqlExec) references(f *graphql.Field, value interface{}, set []graphql.Selection, path []interface{}, depth int) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+
+	case string:
+		return e.reference(f, v, set, path, depth)
+
+	case []interface{}:
+		list := []interface{}{}
+		for i := range v {
+			ref, _ := v[i].(string)
+			list = append(list, e.reference(f, ref, set, append(path, i), depth))
+		}
+
+		return list
+	}
+
+	e.errorf(f, path, "Field %q is not a reference to content", f.Name)
+	return nil
+}
+
+func (e *gqlExec) reference(f *graphql.Field, ref string, set []graphql.Selection, path []interface{}, depth int) interface{} {
+	if ref == "" {
+		return nil
+	}
+
+	t, target, ok := parseReference(ref)
+	if !ok {
+		e.errorf(f, path, "Field %q is not a reference to content", f.Name)
+		return nil
+	}
+
+	typ, ok := graphqlTypes()[t]
+	if !ok {
+		return nil
+	}
+
+	obj, err := e.fetch(typ, target)
+	if err != nil {
+		log.Println("[GraphQL] error:", err)
+		e.errorf(f, path, "Internal error")
+		return nil
+	}
+
+	if obj == nil {
+		return nil
+	}
+
+	return e.object(typ, obj, set, path, depth+1)
+}
+
+// parseReference returns the type and target of a reference to content
+func parseReference(ref string) (string, string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || u.Path != "/api/content" {
+		return "", "", false
+	}
+
+	t, id := u.Query().Get("type"), u.Query().Get("id")
+	if t == "" || id == "" {
+		return "", "", false
+	}
+
+	return t, t + ":" + id, true
+}
+
+// collect groups the fields selected on the type by their response key, in the
+// order they are first selected, following fragments which apply to the type
+// and the @skip and @include directives
+func (e *gqlExec) collect(t string, set []graphql.Selection, visited map[string]bool) (map[string][]*graphql.Field, []string) {
+	fields := make(map[string][]*graphql.Field)
+	var keys []string
+	if visited == nil {
+		visited = make(map[string]bool)
+	}
+
+	add := func(more map[string][]*graphql.Field, order []string) {
+		for _, k := range order {
+			if _, ok := fields[k]; !ok {
+				keys = append(keys, k)
+			}
+
+			fields[k] = append(fields[k], more[k]...)
+		}
+	}
+
+	for _, sel := range set {
+		switch s := sel.(type) {
+		case *graphql.Field:
+			if !e.include(s.Directives) {
+				continue
+			}
+
+			add(map[string][]*graphql.Field{s.Key(): {s}}, []string{s.Key()})
+
+		case *graphql.InlineFragment:
+			if !e.include(s.Directives) || (s.TypeCondition != "" && s.TypeCondition != t) {
+				continue
+			}
+
+			add(e.collect(t, s.SelectionSet, visited))
+
+		case *graphql.FragmentSpread:
+			if !e.include(s.Directives) || visited[s.Name] {
+				continue
+			}
+			visited[s.Name] = true
+
+			frag, ok := e.doc.Fragments[s.Name]
+			if !ok {
+				e.errors = append(e.errors, graphql.Errorf(s.Location, "Unknown fragment %q", s.Name))
+				continue
+			}
+
+			if frag.TypeCondition != t {
+				continue
+			}
+
+			add(e.collect(t, frag.SelectionSet, visited))
+		}
+	}
+
+	return fields, keys
+}
+
+// include evaluates the @skip and @include directives
+func (e *gqlExec) include(dirs []*graphql.Directive) bool {
+	for _, d := range dirs {
+		cond, _ := graphql.Arguments(d.Arguments, e.vars)["if"].(bool)
+		if (d.Name == "skip" && cond) || (d.Name == "include" && !cond) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// merged returns the selections of all fields with the same response key
+func merged(fields []*graphql.Field) []graphql.Selection {
+	var set []graphql.Selection
+	for _, f := range fields {
+		set = append(set, f.SelectionSet...)
+	}
+
+	return set
+}
+
+// gqlFilters reads the filter argument of a list query, a list of objects with a
+// field, an operator and a value or values
+func gqlFilters(arg interface{}) ([]db.Filter, error) {
+	if arg == nil {
+		return nil, nil
+	}
+
+	list, ok := arg.([]interface{})
+	if !ok {
+		list = []interface{}{arg}
+	}
+
+	var filters []db.Filter
+	for _, v := range list {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid filter: %v", v)
+		}
+
+		field, _ := obj["field"].(string)
+		if !rxFieldName.MatchString(field) {
+			return nil, fmt.Errorf("invalid filter field: %s", field)
+		}
+
+		op, _ := obj["op"].(string)
+		if op == "" {
+			op = db.FilterEq
+		}
+
+		if !db.IsFilterOp(op) {
+			return nil, fmt.Errorf("invalid filter operator: %s", op)
+		}
+
+		var values []string
+		if obj["value"] != nil {
+			values = append(values, fmt.Sprint(obj["value"]))
+		}
+
+		if vv, ok := obj["values"].([]interface{}); ok {
+			for _, v := range vv {
+				values = append(values, fmt.Sprint(v))
+			}
+		}
+
+		filters = append(filters, db.Filter{
+			Field:  field,
+			Op:     op,
+			Values: values,
+		})
+	}
+
+	return filters, nil
+}
+
+// gqlInt returns an Int argument, from a literal or a JSON variable
+func gqlInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), n == float64(int64(n))
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+
+	return 0, false
+}
+
+// graphqlVariables returns the values of the operation's variables, from those
+// in the request or their default values
+func graphqlVariables(op *graphql.Operation, values map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range op.Variables {
+		v, ok := values[def.Name]
+		if !ok && def.Default != nil {
+			v, ok = graphql.Resolve(def.Default, nil), true
+		}
+
+		if v == nil && def.Type.NonNull {
+			return nil, graphql.Errorf(def.Location, "Variable \"$%s\" of required type %q was not provided", def.Name, def.Type.String())
+		}
+
+		if ok {
+			vars[def.Name] = v
+		}
+	}
+
+	return vars, nil
+}
+
+// graphqlRequest is the body of a POST request to /api/graphql
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+func graphqlHandler(res http.ResponseWriter, req *http.Request) {
+	var gr graphqlRequest
+
+	switch req.Method {
+	case http.MethodGet:
+		q := req.URL.Query()
+		gr.Query = q.Get("query")
+		gr.OperationName = q.Get("operationName")
+
+		// without a query, respond with the schema
+		if gr.Query == "" {
+			res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			res.Write(graphqlSchema(req))
+			return
+		}
+
+		if v := q.Get("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &gr.Variables)
+			if err != nil {
+				sendGraphQLErrors(res, req, &graphql.Error{Message: "Invalid variables: " + err.Error()})
+				return
+			}
+		}
+
+	case http.MethodPost:
+		body := http.MaxBytesReader(res, req.Body, graphql.MaxQueryLength*2)
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/graphql") {
+			buf := &bytes.Buffer{}
+			_, err := buf.ReadFrom(body)
+			if err != nil {
+				sendGraphQLErrors(res, req, &graphql.Error{Message: "Invalid request body"})
+				return
+			}
+
+			gr.Query = buf.String()
+			break
+		}
+
+		err := json.NewDecoder(body).Decode(&gr)
+		if err != nil {
+			sendGraphQLErrors(res, req, &graphql.Error{Message: "Invalid request body: " + err.Error()})
+			return
+		}
+
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc, err := graphql.Parse(gr.Query)
+	if err != nil {
+		sendGraphQLErrors(res, req, err)
+		return
+	}
+
+	op, err := doc.Operation(gr.OperationName)
+	if err != nil {
+		sendGraphQLErrors(res, req, err)
+		return
+	}
+
+	if op.Type != "query" {
+		sendGraphQLErrors(res, req, graphql.Errorf(op.Location,
+			"Only queries are supported, use /api/content to change content and /api/changes to follow changes"))
+		return
+	}
+
+	vars, err := graphqlVariables(op, gr.Variables)
+	if err != nil {
+		sendGraphQLErrors(res, req, err)
+		return
+	}
+
+	e := &gqlExec{
+		res:     res,
+		req:     req,
+		doc:     doc,
+		vars:    vars,
+		content: make(map[string]map[string]interface{}),
+		omit:    make(map[string]map[string]bool),
+	}
+
+	resp := gqlObject{{"data", e.query(op)}}
+	if len(e.errors) > 0 {
+		resp = append(resp, gqlEntry{"errors", e.errors})
+	}
+
+	j, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("[GraphQL] error encoding response:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sendData(res, req, j)
+
+	// hook after response
+	for _, after := range e.after {
+		after()
+	}
+}
+
+// sendGraphQLErrors responds to a request which couldn't be executed
+func sendGraphQLErrors(res http.ResponseWriter, req *http.Request, err error) {
+	gqlErr, ok := err.(*graphql.Error)
+	if !ok {
+		gqlErr = &graphql.Error{Message: err.Error()}
+	}
+
+	j, err := json.Marshal(map[string]interface{}{
+		"errors": []*graphql.Error{gqlErr},
+	})
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusBadRequest)
+	res.Write(j)
+}
@@ -0,0 +1,218 @@
+// Package graphql parses GraphQL query documents, for the API to execute
+// against the content types registered with Ponzu.
+package graphql
+
+import "fmt"
+
+// Document is a parsed GraphQL query document
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+// Operation returns the operation with the name, or the only operation in the
+// document if name is empty
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) != 1 {
+			return nil, &Error{Message: "operationName is required when a document has more than one operation"}
+		}
+
+		return d.Operations[0], nil
+	}
+
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q", name)}
+}
+
+// Operation is a query, mutation or subscription
+type Operation struct {
+	Type         string // "query", "mutation" or "subscription"
+	Name         string
+	Variables    []*VariableDefinition
+	Directives   []*Directive
+	SelectionSet []Selection
+	Location
+}
+
+// VariableDefinition declares a variable of an operation
+type VariableDefinition struct {
+	Name    string
+	Type    *TypeRef
+	Default Value
+	Location
+}
+
+// TypeRef is a reference to a named, list or non-null type
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef // for list types
+	NonNull bool
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+
+	if t.NonNull {
+		s += "!"
+	}
+
+	return s
+}
+
+// Selection is a Field, FragmentSpread or InlineFragment
+type Selection interface {
+	selection()
+}
+
+// Field is a selected field, and its own selections for object types
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+	Location
+}
+
+// Key returns the name of the field in the response
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+
+	return f.Name
+}
+
+// FragmentSpread includes the selections of a named fragment
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+	Location
+}
+
+// InlineFragment includes its selections when the type matches its
+// TypeCondition, or always if it has none
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Location
+}
+
+// Fragment is a named set of selections on a type
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Location
+}
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+// Argument is a named argument of a field or directive
+type Argument struct {
+	Name  string
+	Value Value
+	Location
+}
+
+// Directive is an annotation, such as @skip(if: true)
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+	Location
+}
+
+// Value is a literal or variable in a query: nil, bool, int64, float64, string,
+// Enum, Variable, []Value or Object
+type Value interface{}
+
+// Variable is a reference to a variable of the operation
+type Variable string
+
+// Enum is an enum value
+type Enum string
+
+// Object is an input object value, with its fields in order
+type Object []*Argument
+
+// Resolve returns the Go value of v, replacing variables by their values in
+// vars. Enums become strings and Objects become maps.
+func Resolve(v Value, vars map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case Variable:
+		return vars[string(v)]
+
+	case Enum:
+		return string(v)
+
+	case []Value:
+		list := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			list = append(list, Resolve(e, vars))
+		}
+
+		return list
+
+	case Object:
+		obj := make(map[string]interface{}, len(v))
+		for _, f := range v {
+			obj[f.Name] = Resolve(f.Value, vars)
+		}
+
+		return obj
+	}
+
+	return v
+}
+
+// Arguments returns the Go values of the arguments, by name
+func Arguments(args []*Argument, vars map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		values[arg.Name] = Resolve(arg.Value, vars)
+	}
+
+	return values
+}
+
+// Location is the position in the query document of a definition or selection
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Error is a GraphQL error, as included in the "errors" of a response
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Locations) > 0 {
+		return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Locations[0].Line, e.Locations[0].Column)
+	}
+
+	return e.Message
+}
+
+// Errorf returns an Error at the location
+func Errorf(loc Location, format string, args ...interface{}) *Error {
+	return &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{loc},
+	}
+}
@@ -0,0 +1,870 @@
+package graphql
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxQueryLength is the longest query document which will be parsed
+const MaxQueryLength = 64 * 1024
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	Location
+}
+
+// lexer splits a query document into tokens, skipping whitespace, commas and
+// comments, which are insignificant
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	tok := token{Location: Location{Line: l.line, Column: l.col}}
+	if l.pos >= len(l.src) {
+		return tok, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+		tok.kind, tok.value = tokenPunct, string(c)
+		l.advance(1)
+
+	case c == '.':
+		if !strings.HasPrefix(l.src[l.pos:], "...") {
+			return tok, Errorf(tok.Location, "Syntax Error: unexpected %q", ".")
+		}
+
+		tok.kind, tok.value = tokenPunct, "..."
+		l.advance(3)
+
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance(1)
+		}
+
+		tok.kind, tok.value = tokenName, l.src[start:l.pos]
+
+	case c == '-' || isDigit(c):
+		return l.number(tok)
+
+	case c == '"':
+		return l.string(tok)
+
+	default:
+		r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+		return tok, Errorf(tok.Location, "Syntax Error: unexpected character %q", r)
+	}
+
+	return tok, nil
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.src); i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+
+		l.pos++
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case ' ', '\t', '\n', '\r', ',':
+			l.advance(1)
+
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+
+		default:
+			// skip a byte order mark
+			if strings.HasPrefix(l.src[l.pos:], "\uFEFF") {
+				l.pos += 3
+				continue
+			}
+
+			return
+		}
+	}
+}
+
+func (l *lexer) number(tok token) (token, error) {
+	start := l.pos
+	tok.kind = tokenInt
+
+	if l.src[l.pos] == '-' {
+		l.advance(1)
+	}
+
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.advance(1)
+			n++
+		}
+
+		return n
+	}
+
+	if digits() == 0 {
+		return tok, Errorf(tok.Location, "Syntax Error: invalid number")
+	}
+
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		tok.kind = tokenFloat
+		l.advance(1)
+		if digits() == 0 {
+			return tok, Errorf(tok.Location, "Syntax Error: invalid number")
+		}
+	}
+
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		tok.kind = tokenFloat
+		l.advance(1)
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.advance(1)
+		}
+
+		if digits() == 0 {
+			return tok, Errorf(tok.Location, "Syntax Error: invalid number")
+		}
+	}
+
+	tok.value = l.src[start:l.pos]
+	return tok, nil
+}
+
+func (l *lexer) string(tok token) (token, error) {
+	tok.kind = tokenString
+
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		l.advance(3)
+		end := strings.Index(l.src[l.pos:], `"""`)
+		for end > 0 && l.src[l.pos+end-1] == '\\' {
+			next := strings.Index(l.src[l.pos+end+1:], `"""`)
+			if next < 0 {
+				end = -1
+				break
+			}
+
+			end += next + 1
+		}
+
+		if end < 0 {
+			return tok, Errorf(tok.Location, "Syntax Error: unterminated string")
+		}
+
+		raw := l.src[l.pos : l.pos+end]
+		l.advance(end + 3)
+		tok.value = blockString(strings.Replace(raw, `\"""`, `"""`, -1))
+		return tok, nil
+	}
+
+	l.advance(1)
+	var s strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return tok, Errorf(tok.Location, "Syntax Error: unterminated string")
+		}
+
+		c := l.src[l.pos]
+		if c == '"' {
+			l.advance(1)
+			break
+		}
+
+		if c != '\\' {
+			s.WriteByte(c)
+			l.advance(1)
+			continue
+		}
+
+		if l.pos+1 >= len(l.src) {
+			return tok, Errorf(tok.Location, "Syntax Error: unterminated string")
+		}
+
+		esc := l.src[l.pos+1]
+		switch esc {
+		case '"', '\\', '/':
+			s.WriteByte(esc)
+		case 'b':
+			s.WriteByte('\b')
+		case 'f':
+			s.WriteByte('\f')
+		case 'n':
+			s.WriteByte('\n')
+		case 'r':
+			s.WriteByte('\r')
+		case 't':
+			s.WriteByte('\t')
+		case 'u':
+			if l.pos+6 > len(l.src) {
+				return tok, Errorf(tok.Location, "Syntax Error: invalid unicode escape")
+			}
+
+			r, err := strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 32)
+			if err != nil {
+				return tok, Errorf(tok.Location, "Syntax Error: invalid unicode escape")
+			}
+
+			s.WriteRune(rune(r))
+			l.advance(4)
+		default:
+			return tok, Errorf(tok.Location, "Syntax Error: invalid escape sequence \\%c", esc)
+		}
+
+		l.advance(2)
+	}
+
+	tok.value = s.String()
+	return tok, nil
+}
+
+// blockString removes the common indentation and leading and trailing blank
+// lines of a block string
+func blockString(raw string) string {
+	lines := strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parser builds a Document from the tokens of a query, looking one token ahead
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+// Parse parses a GraphQL query document
+func Parse(query string) (*Document, error) {
+	if len(query) > MaxQueryLength {
+		return nil, &Error{Message: "Query is too long"}
+	}
+
+	p := &parser{lex: &lexer{src: query, line: 1, col: 1}}
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: make(map[string]*Fragment)}
+	for p.tok.kind != tokenEOF {
+		if p.tok.kind == tokenName && p.tok.value == "fragment" {
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := doc.Fragments[f.Name]; ok {
+				return nil, Errorf(f.Location, "There can be only one fragment named %q", f.Name)
+			}
+
+			doc.Fragments[f.Name] = f
+			continue
+		}
+
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+
+		doc.Operations = append(doc.Operations, op)
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, &Error{Message: "Document must contain an operation"}
+	}
+
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+
+	p.tok = tok
+	return nil
+}
+
+// peek reports whether the current token is the punctuator or name s
+func (p *parser) peek(s string) bool {
+	return (p.tok.kind == tokenPunct || p.tok.kind == tokenName) && p.tok.value == s
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return Errorf(p.tok.Location, "Syntax Error: unexpected end of document")
+	}
+
+	return Errorf(p.tok.Location, "Syntax Error: unexpected %q", p.tok.value)
+}
+
+// expect consumes the punctuator or keyword s
+func (p *parser) expect(s string) error {
+	if !p.peek(s) {
+		if p.tok.kind == tokenEOF {
+			return Errorf(p.tok.Location, "Syntax Error: expected %q, found end of document", s)
+		}
+
+		return Errorf(p.tok.Location, "Syntax Error: expected %q, found %q", s, p.tok.value)
+	}
+
+	return p.advance()
+}
+
+// skip consumes the punctuator s if it is the current token
+func (p *parser) skip(s string) (bool, error) {
+	if p.tok.kind != tokenPunct || p.tok.value != s {
+		return false, nil
+	}
+
+	return true, p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: "query", Location: p.tok.Location}
+
+	// the query shorthand is a selection set alone
+	if p.peek("{") {
+		set, err := p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+
+		op.SelectionSet = set
+		return op, nil
+	}
+
+	switch {
+	case p.peek("query"), p.peek("mutation"), p.peek("subscription"):
+		op.Type = p.tok.value
+	default:
+		return nil, p.unexpected()
+	}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.Name = p.tok.value
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek("(") {
+		op.Variables, err = p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	op.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	op.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return op, nil
+}
+
+func (p *parser) variableDefinitions() ([]*VariableDefinition, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	var defs []*VariableDefinition
+	for !p.peek(")") {
+		def := &VariableDefinition{Location: p.tok.Location}
+		err := p.expect("$")
+		if err != nil {
+			return nil, err
+		}
+
+		def.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		def.Type, err = p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := p.skip("=")
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			def.Default, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+
+		defs = append(defs, def)
+	}
+
+	return defs, p.advance()
+}
+
+func (p *parser) typeRef() (*TypeRef, error) {
+	t := &TypeRef{}
+
+	ok, err := p.skip("[")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		t.Elem, err = p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect("]")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		t.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t.NonNull, err = p.skip("!")
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	var set []Selection
+	for !p.peek("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+
+		set = append(set, sel)
+	}
+
+	if len(set) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return set, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	loc := p.tok.Location
+
+	ok, err := p.skip("...")
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return p.field()
+	}
+
+	// a fragment spread is a name other than "on", an inline fragment has an
+	// optional type condition
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		spread := &FragmentSpread{Name: p.tok.value, Location: loc}
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		spread.Directives, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+
+		return spread, nil
+	}
+
+	frag := &InlineFragment{Location: loc}
+	if p.peek("on") {
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		frag.TypeCondition, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	frag.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	frag.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return frag, nil
+}
+
+func (p *parser) field() (*Field, error) {
+	f := &Field{Location: p.tok.Location}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := p.skip(":")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		f.Alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.Name = name
+
+	f.Arguments, err = p.arguments(false)
+	if err != nil {
+		return nil, err
+	}
+
+	f.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek("{") {
+		f.SelectionSet, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	f := &Fragment{Location: p.tok.Location}
+
+	err := p.expect("fragment")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek("on") {
+		return nil, p.unexpected()
+	}
+
+	f.Name, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.expect("on")
+	if err != nil {
+		return nil, err
+	}
+
+	f.TypeCondition, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	f.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	f.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// arguments parses optional arguments in parentheses. Constant arguments may
+// not use variables.
+func (p *parser) arguments(constant bool) ([]*Argument, error) {
+	ok, err := p.skip("(")
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	var args []*Argument
+	for !p.peek(")") {
+		arg := &Argument{Location: p.tok.Location}
+		arg.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		arg.Value, err = p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg)
+	}
+
+	if len(args) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var dirs []*Directive
+	for p.peek("@") {
+		d := &Directive{Location: p.tok.Location}
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		d.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		d.Arguments, err = p.arguments(false)
+		if err != nil {
+			return nil, err
+		}
+
+		dirs = append(dirs, d)
+	}
+
+	return dirs, nil
+}
+
+func (p *parser) value(constant bool) (Value, error) {
+	tok := p.tok
+
+	switch tok.kind {
+	case tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, Errorf(tok.Location, "Syntax Error: invalid integer %s", tok.value)
+		}
+
+		return n, p.advance()
+
+	case tokenFloat:
+		n, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, Errorf(tok.Location, "Syntax Error: invalid float %s", tok.value)
+		}
+
+		return n, p.advance()
+
+	case tokenString:
+		return tok.value, p.advance()
+
+	case tokenName:
+		var v Value
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = Enum(tok.value)
+		}
+
+		return v, p.advance()
+
+	case tokenPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				return nil, Errorf(tok.Location, "Variables are not allowed in default values")
+			}
+
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+
+			return Variable(name), nil
+
+		case "[":
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			list := []Value{}
+			for !p.peek("]") {
+				if p.tok.kind == tokenEOF {
+					return nil, p.unexpected()
+				}
+
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+
+				list = append(list, v)
+			}
+
+			return list, p.advance()
+
+		case "{":
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			obj := Object{}
+			for !p.peek("}") {
+				f := &Argument{Location: p.tok.Location}
+				f.Name, err = p.name()
+				if err != nil {
+					return nil, err
+				}
+
+				err = p.expect(":")
+				if err != nil {
+					return nil, err
+				}
+
+				f.Value, err = p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+
+				obj = append(obj, f)
+			}
+
+			return obj, p.advance()
+		}
+	}
+
+	return nil, p.unexpected()
+}
@@ -0,0 +1,121 @@
+package graphql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(`
+		# comments and commas are ignored
+		query Songs($count: Int = 5, $slug: String!) {
+			latest: allSong(count: $count, filter: [{field: "genre", values: ["rock", "pop"]}]) {
+				items { ...Song }
+			}
+			Song(slug: $slug) @include(if: true) {
+				... on Song { title }
+			}
+		}
+
+		fragment Song on Song { id, title, artist { name } }
+	`)
+	if err != nil {
+		t.Fatalf("Failed: %s", err.Error())
+	}
+
+	op, err := doc.Operation("")
+	if err != nil {
+		t.Fatalf("Failed: %s", err.Error())
+	}
+
+	if op.Type != "query" || op.Name != "Songs" || len(op.Variables) != 2 {
+		t.Errorf("Unexpected operation: %+v", op)
+	}
+
+	if op.Variables[1].Type.String() != "String!" || op.Variables[0].Default != int64(5) {
+		t.Errorf("Unexpected variables: %+v %+v", op.Variables[0], op.Variables[1])
+	}
+
+	latest := op.SelectionSet[0].(*Field)
+	if latest.Key() != "latest" || latest.Name != "allSong" {
+		t.Errorf("Unexpected field: %+v", latest)
+	}
+
+	args := Arguments(latest.Arguments, map[string]interface{}{"count": 3})
+	expected := map[string]interface{}{
+		"count": 3,
+		"filter": []interface{}{
+			map[string]interface{}{"field": "genre", "values": []interface{}{"rock", "pop"}},
+		},
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+
+	if _, ok := op.SelectionSet[1].(*Field).SelectionSet[0].(*InlineFragment); !ok {
+		t.Errorf("Expected inline fragment, got %T", op.SelectionSet[1].(*Field).SelectionSet[0])
+	}
+
+	if f := doc.Fragments["Song"]; f == nil || f.TypeCondition != "Song" || len(f.SelectionSet) != 3 {
+		t.Errorf("Unexpected fragment: %+v", f)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected Value
+	}{
+		{`-12`, int64(-12)},
+		{`1.5e3`, 1500.0},
+		{`"a\"bé\n"`, "a\"bé\n"},
+		{`"""
+			block
+			  string
+		"""`, "block\n  string"},
+		{`true`, true},
+		{`null`, nil},
+		{`ASC`, Enum("ASC")},
+		{`$v`, Variable("v")},
+	}
+
+	for _, c := range cases {
+		doc, err := Parse(`{ f(a: ` + c.value + `) }`)
+		if err != nil {
+			t.Errorf("Failed: %s", err.Error())
+			continue
+		}
+
+		v := doc.Operations[0].SelectionSet[0].(*Field).Arguments[0].Value
+		if !reflect.DeepEqual(v, c.expected) {
+			t.Errorf("Expected %#v, got %#v", c.expected, v)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		query        string
+		line, column int
+	}{
+		{`{ f(a: 1 }`, 1, 10},
+		{`{ }`, 1, 3},
+		{"{\n  f(a: \"x) }", 2, 8},
+		{`query ($a: Int = $b) { f }`, 1, 18},
+		{`{ f } fragment on on T { f }`, 1, 16},
+		{`{ f`, 1, 4},
+	}
+
+	for _, c := range cases {
+		_, err := Parse(c.query)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Expected error for %q, got %v", c.query, err)
+			continue
+		}
+
+		if len(e.Locations) == 0 || e.Locations[0].Line != c.line || e.Locations[0].Column != c.column {
+			t.Errorf("Expected error at %d:%d for %q, got %s", c.line, c.column, c.query, e.Error())
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ponzu-cms/ponzu/system/db"
 	"github.com/ponzu-cms/ponzu/system/item"
@@ -76,19 +77,69 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		order = "desc"
 	}
 
+	filters, err := parseFilters(q) // filter[field]=value, filter[field][op]=value
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	sorts, err := parseSort(q.Get("sort")) // string: comma-separated fields, "-" prefix for DESC
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = checkOmittedFields(res, req, it(), filters, sorts)
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	expands, err := parseExpand(q.Get("expand")) // string: comma-separated reference fields to expand
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// list responses stay valid until any content of the type changes, unless
+	// they include content of other types
+	modified, err := db.ContentModified(t)
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(expands) == 0 && db.NotModified(res, req, listETag(req, t, modified), modified) {
+		return
+	}
+
 	opts := db.QueryOptions{
-		Count:  count,
-		Offset: offset,
-		Order:  order,
+		Count:   count,
+		Offset:  offset,
+		Order:   order,
+		Filters: filters,
+		Sort:    sorts,
+	}
+
+	err = applyCursor(q, &opts) // string: opaque after/before cursor from a previous response
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	_, bb := db.Query(t+"__sorted", opts)
+	page := db.QueryCursor(t+"__sorted", opts)
 	var result = []json.RawMessage{}
-	for i := range bb {
-		result = append(result, bb[i])
+	for i := range page.Content {
+		result = append(result, page.Content[i])
 	}
 
-	j, err := fmtJSON(result...)
+	j, err := fmtJSONWithMeta(pageMeta(req, opts, page), result...)
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
 		return
@@ -100,6 +151,19 @@ func contentsHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if len(expands) > 0 {
+		j, err = expand(res, req, t, j, expands)
+		if err != nil {
+			log.Println("[Response] error expanding references:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if db.NotModified(res, req, expandedETag(j), time.Time{}) {
+			return
+		}
+	}
+
 	// assert hookable
 	get := it()
 	hook, ok := get.(item.Hookable)
@@ -133,6 +197,11 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 	t := q.Get("type")
 	slug := q.Get("slug")
 
+	if preview := q.Get("preview"); preview != "" {
+		previewHandler(res, req, preview)
+		return
+	}
+
 	if slug != "" {
 		contentHandlerBySlug(res, req)
 		return
@@ -143,6 +212,13 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	expands, err := parseExpand(q.Get("expand")) // string: comma-separated reference fields to expand
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	pt, ok := item.Types[t]
 	if !ok {
 		res.WriteHeader(http.StatusNotFound)
@@ -155,6 +231,12 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// content which doesn't exist, or is scheduled and not public
+	if len(post) == 0 {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	p := pt()
 	err = json.Unmarshal(post, p)
 	if err != nil {
@@ -168,6 +250,11 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 
 	push(res, req, p, post)
 
+	// the ETag also identifies the version of the content for conditional updates
+	if len(expands) == 0 && db.NotModified(res, req, db.ContentETag(post), itemModified(post)) {
+		return
+	}
+
 	j, err := fmtJSON(json.RawMessage(post))
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
@@ -180,6 +267,19 @@ func contentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if len(expands) > 0 {
+		j, err = expand(res, req, t, j, expands)
+		if err != nil {
+			log.Println("[Response] error expanding references:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if db.NotModified(res, req, expandedETag(j), time.Time{}) {
+			return
+		}
+	}
+
 	// assert hookable
 	get := p
 	hook, ok := get.(item.Hookable)
@@ -215,6 +315,13 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	expands, err := parseExpand(req.URL.Query().Get("expand")) // string: comma-separated reference fields to expand
+	if err != nil {
+		log.Println("[Response] error:", err)
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	// lookup type:id by slug key in __contentIndex
 	t, post, err := db.ContentBySlug(slug)
 	if err != nil {
@@ -229,6 +336,12 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// the slug of scheduled content is kept, but it isn't public yet
+	if len(post) == 0 {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
 	p := it()
 	err = json.Unmarshal(post, p)
 	if err != nil {
@@ -242,6 +355,11 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 
 	push(res, req, p, post)
 
+	// the ETag also identifies the version of the content for conditional updates
+	if len(expands) == 0 && db.NotModified(res, req, db.ContentETag(post), itemModified(post)) {
+		return
+	}
+
 	j, err := fmtJSON(json.RawMessage(post))
 	if err != nil {
 		res.WriteHeader(http.StatusInternalServerError)
@@ -254,6 +372,19 @@ func contentHandlerBySlug(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if len(expands) > 0 {
+		j, err = expand(res, req, t, j, expands)
+		if err != nil {
+			log.Println("[Response] error expanding references:", err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if db.NotModified(res, req, expandedETag(j), time.Time{}) {
+			return
+		}
+	}
+
 	// assert hookable
 	get := p
 	hook, ok := get.(item.Hookable)
@@ -307,6 +438,10 @@ func uploadsHandler(res http.ResponseWriter, req *http.Request) {
 
 	push(res, req, it(), upload)
 
+	if db.NotModified(res, req, db.ContentETag(upload), itemModified(upload)) {
+		return
+	}
+
 	j, err := fmtJSON(json.RawMessage(upload))
 	if err != nil {
 		log.Println("Error fmtJSON on upload:", err)
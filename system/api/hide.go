@@ -25,3 +25,23 @@ func hide(res http.ResponseWriter, req *http.Request, it interface{}) bool {
 
 	return false
 }
+
+// hidden reports whether the item is hidden from the request, without writing a
+// response, for responses which include items of many types
+func hidden(req *http.Request, it interface{}) bool {
+	h, ok := it.(item.Hideable)
+	if !ok {
+		return false
+	}
+
+	return h.Hide(discardResponse{header: make(http.Header)}, req) != item.ErrAllowHiddenItem
+}
+
+// discardResponse is a ResponseWriter which discards what is written to it
+type discardResponse struct {
+	header http.Header
+}
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
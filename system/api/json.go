@@ -8,15 +8,25 @@ import (
 )
 
 func fmtJSON(data ...json.RawMessage) ([]byte, error) {
+	return fmtJSONWithMeta(nil, data...)
+}
+
+// fmtJSONWithMeta adds a top-level "meta" object next to "data" in the response,
+// unless meta is nil
+func fmtJSONWithMeta(meta map[string]interface{}, data ...json.RawMessage) ([]byte, error) {
 	var msg = []json.RawMessage{}
 	for _, d := range data {
 		msg = append(msg, d)
 	}
 
-	resp := map[string][]json.RawMessage{
+	resp := map[string]interface{}{
 		"data": msg,
 	}
 
+	if meta != nil {
+		resp["meta"] = meta
+	}
+
 	var buf = &bytes.Buffer{}
 	enc := json.NewEncoder(buf)
 	err := enc.Encode(resp)
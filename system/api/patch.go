@@ -0,0 +1,466 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Media types accepted for PATCH requests to /api/content/update
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+var (
+	// ErrPatchTest is returned when a "test" operation of a JSON Patch fails
+	ErrPatchTest = errors.New("JSON Patch test operation failed")
+
+	// ErrPatchPath is returned when a JSON Patch operation's path can't be
+	// resolved in the document it's applied to
+	ErrPatchPath = errors.New("JSON Patch path not found")
+)
+
+// applyPatch applies a patch document of the media type provided to the json
+// doc, and returns the patched json
+func applyPatch(mediaType string, doc, patch []byte) ([]byte, error) {
+	var target interface{}
+	err := unmarshalNumber(doc, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mediaType {
+	case mediaTypeMergePatch:
+		var p interface{}
+		err = unmarshalNumber(patch, &p)
+		if err != nil {
+			return nil, err
+		}
+
+		target = mergePatch(target, p)
+
+	case mediaTypeJSONPatch:
+		var ops []patchOp
+		err = json.Unmarshal(patch, &ops)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, op := range ops {
+			target, err = op.apply(target)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported patch media type: %s", mediaType)
+	}
+
+	return json.Marshal(target)
+}
+
+// unmarshalNumber decodes json keeping numbers as json.Number, so values which
+// aren't changed by a patch are stored exactly as they were
+func unmarshalNumber(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return dec.Decode(v)
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to target
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+
+		t[k] = mergePatch(t[k], v)
+	}
+
+	return t
+}
+
+// patchOp is a single operation of a JSON Patch (RFC 6902)
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (op patchOp) value() (interface{}, error) {
+	if op.Value == nil {
+		return nil, fmt.Errorf("JSON Patch %s operation requires a value", op.Op)
+	}
+
+	var v interface{}
+	err := unmarshalNumber(op.Value, &v)
+
+	return v, err
+}
+
+func (op patchOp) apply(doc interface{}) (interface{}, error) {
+	switch op.Op {
+	case "add":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(doc, op.Path, v)
+
+	case "remove":
+		doc, _, err := pointerRemove(doc, op.Path)
+		return doc, err
+
+	case "replace":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+
+		doc, _, err = pointerRemove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(doc, op.Path, v)
+
+	case "move":
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("JSON Patch cannot move %s into one of its children", op.From)
+		}
+
+		doc, v, err := pointerRemove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(doc, op.Path, v)
+
+	case "copy":
+		v, err := pointerGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		// copy the value so later operations don't change both locations
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var cp interface{}
+		err = unmarshalNumber(j, &cp)
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(doc, op.Path, cp)
+
+	case "test":
+		v, err := op.value()
+		if err != nil {
+			return nil, err
+		}
+
+		cur, err := pointerGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !jsonEqual(cur, v) {
+			return nil, ErrPatchTest
+		}
+
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported JSON Patch operation: %s", op.Op)
+}
+
+// jsonEqual compares decoded json values, treating numbers as equal if they
+// have the same numeric value
+func jsonEqual(a, b interface{}) bool {
+	an, aok := a.(json.Number)
+	bn, bok := b.(json.Number)
+	if aok && bok {
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		if aerr == nil && berr == nil {
+			return af == bf
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// splitPointer splits a JSON Pointer (RFC 6901) into its unescaped tokens
+func splitPointer(ptr string) ([]string, error) {
+	if ptr == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("Invalid JSON Pointer: %s", ptr)
+	}
+
+	tokens := strings.Split(ptr[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.Replace(tokens[i], "~1", "/", -1)
+		tokens[i] = strings.Replace(tokens[i], "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex parses a JSON Pointer token as an index into an array of length n.
+// If end is true, the index may be n, or "-" to refer to the end of the array.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > n || (i == n && !end) {
+		return 0, ErrPatchPath
+	}
+
+	if len(token) > 1 && token[0] == '0' {
+		return 0, ErrPatchPath
+	}
+
+	return i, nil
+}
+
+func pointerGet(doc interface{}, ptr string) (interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := doc
+	for _, t := range tokens {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			v, ok := c[t]
+			if !ok {
+				return nil, ErrPatchPath
+			}
+			cur = v
+
+		case []interface{}:
+			i, err := arrayIndex(t, len(c), false)
+			if err != nil {
+				return nil, err
+			}
+			cur = c[i]
+
+		default:
+			return nil, ErrPatchPath
+		}
+	}
+
+	return cur, nil
+}
+
+// pointerAdd adds the value at the location in doc referenced by ptr, and
+// returns the modified doc
+func pointerAdd(doc interface{}, ptr string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return modifyParent(doc, tokens, func(parent interface{}, last string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[last] = value
+			return p, nil
+
+		case []interface{}:
+			i, err := arrayIndex(last, len(p), true)
+			if err != nil {
+				return nil, err
+			}
+
+			p = append(p, nil)
+			copy(p[i+1:], p[i:])
+			p[i] = value
+			return p, nil
+		}
+
+		return nil, ErrPatchPath
+	})
+}
+
+// pointerRemove removes the value at the location in doc referenced by ptr,
+// and returns the modified doc and the value removed
+func pointerRemove(doc interface{}, ptr string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(ptr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	var removed interface{}
+	doc, err = modifyParent(doc, tokens, func(parent interface{}, last string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			v, ok := p[last]
+			if !ok {
+				return nil, ErrPatchPath
+			}
+
+			removed = v
+			delete(p, last)
+			return p, nil
+
+		case []interface{}:
+			i, err := arrayIndex(last, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		}
+
+		return nil, ErrPatchPath
+	})
+
+	return doc, removed, err
+}
+
+// modifyParent walks doc to the parent of the location referenced by tokens
+// and replaces it with the result of fn, since modifying an array may change
+// its slice header
+func modifyParent(doc interface{}, tokens []string, fn func(parent interface{}, last string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(doc, tokens[0])
+	}
+
+	t := tokens[0]
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		child, ok := c[t]
+		if !ok {
+			return nil, ErrPatchPath
+		}
+
+		v, err := modifyParent(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		c[t] = v
+		return c, nil
+
+	case []interface{}:
+		i, err := arrayIndex(t, len(c), false)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := modifyParent(c[i], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+
+		c[i] = v
+		return c, nil
+	}
+
+	return nil, ErrPatchPath
+}
+
+// readContentPatch reads the body of a PATCH request to /api/content/update,
+// and returns its media type and the patch. If the patch can't be read, the
+// HTTP status code to respond with is returned with the error.
+func readContentPatch(req *http.Request) (string, []byte, int, error) {
+	mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (mt != mediaTypeMergePatch && mt != mediaTypeJSONPatch) {
+		return "", nil, http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported patch media type: %s", mt)
+	}
+
+	patch, err := ioutil.ReadAll(io.LimitReader(req.Body, maxJSONBody+1))
+	if err != nil {
+		return "", nil, http.StatusBadRequest, err
+	}
+
+	if len(patch) > maxJSONBody {
+		return "", nil, http.StatusRequestEntityTooLarge, fmt.Errorf("Request body exceeds %d bytes", maxJSONBody)
+	}
+
+	if !json.Valid(patch) {
+		return "", nil, http.StatusBadRequest, ErrInvalidJSON
+	}
+
+	return mt, patch, http.StatusOK, nil
+}
+
+// applyContentPatch applies a patch of the media type mt to the stored json doc
+// of the content. The Item fields which are managed by the system can't be
+// patched, and updated is set to now. If the patch can't be applied, the HTTP
+// status code to respond with is returned with the error.
+func applyContentPatch(mt string, doc, patch []byte) ([]byte, int, error) {
+	patched, err := applyPatch(mt, doc, patch)
+	if err == ErrPatchTest {
+		return nil, http.StatusConflict, err
+	}
+	if err != nil {
+		return nil, http.StatusUnprocessableEntity, err
+	}
+
+	if !bytes.HasPrefix(patched, []byte("{")) {
+		return nil, http.StatusUnprocessableEntity, ErrInvalidJSON
+	}
+
+	for _, k := range []string{"id", "uuid", "slug"} {
+		patched, err = sjson.SetRawBytes(patched, k, []byte(gjson.GetBytes(doc, k).Raw))
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+
+	ts := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+	patched, err = sjson.SetBytes(patched, "updated", ts)
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+
+	return patched, http.StatusOK, nil
+}
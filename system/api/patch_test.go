@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// examples from RFC 7396, Appendix A
+	cases := []struct {
+		doc, patch, expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+	}
+
+	for _, c := range cases {
+		out, err := applyPatch(mediaTypeMergePatch, []byte(c.doc), []byte(c.patch))
+		if err != nil {
+			t.Errorf("Failed: %s", err.Error())
+			continue
+		}
+
+		assertJSON(t, c.expected, out)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	// examples from RFC 6902, Appendix A
+	cases := []struct {
+		doc, patch, expected string
+	}{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+	}
+
+	for _, c := range cases {
+		out, err := applyPatch(mediaTypeJSONPatch, []byte(c.doc), []byte(c.patch))
+		if err != nil {
+			t.Errorf("Failed: %s, for patch %s", err.Error(), c.patch)
+			continue
+		}
+
+		assertJSON(t, c.expected, out)
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	cases := map[string]error{
+		`[{"op":"test","path":"/baz","value":"bar"}]`:    ErrPatchTest,
+		`[{"op":"add","path":"/baz/bat","value":"qux"}]`: ErrPatchPath,
+		`[{"op":"remove","path":"/foo/5"}]`:              ErrPatchPath,
+	}
+
+	for patch, expected := range cases {
+		doc := `{"baz":"qux","foo":["bar"]}`
+		_, err := applyPatch(mediaTypeJSONPatch, []byte(doc), []byte(patch))
+		if err != expected {
+			t.Errorf("Expected: %v, got: %v, for patch %s", expected, err, patch)
+		}
+	}
+}
+
+func assertJSON(t *testing.T, expected string, actual []byte) {
+	var e, a interface{}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatalf("Failed: %s", err.Error())
+	}
+
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Fatalf("Failed: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(e, a) {
+		t.Errorf("Expected: %s, got: %s", expected, actual)
+	}
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/db"
+	"github.com/ponzu-cms/ponzu/system/item"
+)
+
+// previewHandler responds with the unpublished content a preview token was
+// created for, passed through the same hooks as published content
+func previewHandler(res http.ResponseWriter, req *http.Request, token string) {
+	target, err := db.PreviewTarget(token)
+	if err != nil {
+		log.Println("[Preview] error:", err)
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// previews must never be stored by shared caches
+	res.Header().Set("Cache-Control", "no-store")
+
+	ns := strings.Split(target, ":")[0]
+	t := strings.Split(ns, "__")[0]
+
+	pt, ok := item.Types[t]
+	if !ok {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	post, err := db.Content(target)
+	if err != nil || len(post) == 0 {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	p := pt()
+	err = json.Unmarshal(post, p)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if hide(res, req, p) {
+		return
+	}
+
+	j, err := fmtJSON(json.RawMessage(post))
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	j, err = omit(res, req, p, j)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// assert hookable
+	hook, ok := p.(item.Hookable)
+	if !ok {
+		log.Println("[Preview] error: Type", t, "does not implement item.Hookable or embed item.Item.")
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// hook before response
+	j, err = hook.BeforeAPIResponse(res, req, j)
+	if err != nil {
+		log.Println("[Preview] error calling BeforeAPIResponse:", err)
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sendData(res, req, j)
+
+	// hook after response
+	err = hook.AfterAPIResponse(res, req, j)
+	if err != nil {
+		log.Println("[Preview] error calling AfterAPIResponse:", err)
+		return
+	}
+}
@@ -7,17 +7,21 @@ import "net/http"
 
 // Run adds Handlers to default http listener for API
 func Run() {
-	http.HandleFunc("/api/contents", Record(CORS(Gzip(contentsHandler))))
+	http.HandleFunc("/api/contents", Record(CORS(Auth(Gzip(contentsHandler)))))
 
-	http.HandleFunc("/api/content", Record(CORS(Gzip(contentHandler))))
+	http.HandleFunc("/api/content", Record(CORS(Auth(Gzip(contentHandler)))))
 
-	http.HandleFunc("/api/content/create", Record(CORS(createContentHandler)))
+	http.HandleFunc("/api/content/create", Record(CORS(Auth(createContentHandler))))
 
-	http.HandleFunc("/api/content/update", Record(CORS(updateContentHandler)))
+	http.HandleFunc("/api/content/update", Record(CORS(Auth(updateContentHandler))))
 
-	http.HandleFunc("/api/content/delete", Record(CORS(deleteContentHandler)))
+	http.HandleFunc("/api/content/delete", Record(CORS(Auth(deleteContentHandler))))
 
-	http.HandleFunc("/api/search", Record(CORS(Gzip(searchContentHandler))))
+	http.HandleFunc("/api/search", Record(CORS(Auth(Gzip(searchContentHandler)))))
 
-	http.HandleFunc("/api/uploads", Record(CORS(Gzip(uploadsHandler))))
+	http.HandleFunc("/api/uploads", Record(CORS(Auth(Gzip(uploadsHandler)))))
+
+	http.HandleFunc("/api/graphql", Record(CORS(Auth(Gzip(graphqlHandler)))))
+
+	http.HandleFunc("/api/changes", Record(CORS(Auth(changesHandler))))
 }
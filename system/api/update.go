@@ -7,16 +7,13 @@ import (
 	"log"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/ponzu-cms/ponzu/system/admin/upload"
 	"github.com/ponzu-cms/ponzu/system/db"
 	"github.com/ponzu-cms/ponzu/system/item"
-
-	"github.com/gorilla/schema"
 )
 
-// Updateable accepts or rejects update POST requests to endpoints such as:
+// Updateable accepts or rejects update POST or PATCH requests to endpoints such as:
 // /api/content/update?type=Review&id=1
 type Updateable interface {
 	// Update enabled external clients to update content of a specific type
@@ -24,18 +21,11 @@ type Updateable interface {
 }
 
 func updateContentHandler(res http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
+	if req.Method != http.MethodPost && req.Method != http.MethodPatch {
 		res.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	err := req.ParseMultipartForm(1024 * 1024 * 4) // maxMemory 4MB
-	if err != nil {
-		log.Println("[Update] error:", err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
 	t := req.URL.Query().Get("type")
 	if t == "" {
 		res.WriteHeader(http.StatusBadRequest)
@@ -65,6 +55,34 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// reject the update early if the client's copy of the content is stale, the
+	// check is repeated when the content is stored
+	ifMatch := req.Header.Get("If-Match")
+	if ifMatch != "" && (len(j) == 0 || !db.MatchETag(ifMatch, db.ContentETag(j), false)) {
+		log.Println("[Update] rejected stale update for type:", t, "id:", id, "from:", req.RemoteAddr)
+		res.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// PATCH requests are applied to the stored json, which is then decoded into
+	// the type in place of the stored content
+	var patchType string
+	var patch, patched []byte
+	if req.Method == http.MethodPatch {
+		var status int
+		patchType, patch, status, err = readContentPatch(req)
+		if err == nil {
+			patched, status, err = applyContentPatch(patchType, j, patch)
+		}
+		if err != nil {
+			log.Println("[Update] error applying patch for type:", t, err)
+			res.WriteHeader(status)
+			return
+		}
+
+		j = patched
+	}
+
 	err = json.Unmarshal(j, post)
 	if err != nil {
 		log.Println("[Update] error populating data in type:", t, err)
@@ -79,69 +97,6 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	ts := fmt.Sprintf("%d", int64(time.Nanosecond)*time.Now().UnixNano()/int64(time.Millisecond))
-	req.PostForm.Set("timestamp", ts)
-	req.PostForm.Set("updated", ts)
-
-	urlPaths, err := upload.StoreFiles(req)
-	if err != nil {
-		log.Println(err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	for name, urlPath := range urlPaths {
-		req.PostForm.Set(name, urlPath)
-	}
-
-	// check for any multi-value fields (ex. checkbox fields)
-	// and correctly format for db storage. Essentially, we need
-	// fieldX.0: value1, fieldX.1: value2 => fieldX: []string{value1, value2}
-	fieldOrderValue := make(map[string]map[string][]string)
-	for k, v := range req.PostForm {
-		if strings.Contains(k, ".") {
-			fo := strings.Split(k, ".")
-
-			// put the order and the field value into map
-			field := string(fo[0])
-			order := string(fo[1])
-			if len(fieldOrderValue[field]) == 0 {
-				fieldOrderValue[field] = make(map[string][]string)
-			}
-
-			// orderValue is 0:[?type=Thing&id=1]
-			orderValue := fieldOrderValue[field]
-			orderValue[order] = v
-			fieldOrderValue[field] = orderValue
-
-			// discard the post form value with name.N
-			req.PostForm.Del(k)
-		}
-
-	}
-
-	// add/set the key & value to the post form in order
-	for f, ov := range fieldOrderValue {
-		for i := 0; i < len(ov); i++ {
-			position := fmt.Sprintf("%d", i)
-			fieldValue := ov[position]
-
-			if req.PostForm.Get(f) == "" {
-				for i, fv := range fieldValue {
-					if i == 0 {
-						req.PostForm.Set(f, fv)
-					} else {
-						req.PostForm.Add(f, fv)
-					}
-				}
-			} else {
-				for _, fv := range fieldValue {
-					req.PostForm.Add(f, fv)
-				}
-			}
-		}
-	}
-
 	hook, ok := post.(item.Hookable)
 	if !ok {
 		log.Println("[Update] error: Type", t, "does not implement item.Hookable or embed item.Item.")
@@ -149,15 +104,30 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Let's be nice and make a proper item for the Hookable methods
-	dec := schema.NewDecoder()
-	dec.IgnoreUnknownKeys(true)
-	dec.SetAliasTag("json")
-	err = dec.Decode(post, req.PostForm)
-	if err != nil {
-		log.Println("Error decoding post form for edit handler:", t, err)
-		res.WriteHeader(http.StatusInternalServerError)
-		return
+	// content is decoded over the existing values from either a JSON body or
+	// multipart form values
+	var body []byte
+	if patched != nil {
+		// already decoded from the patched json above
+	} else if isJSONRequest(req) {
+		body, err = decodeContentJSON(req, post)
+		if err != nil {
+			log.Println("[Update] error decoding JSON for type:", t, err)
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		status, err := decodeContentForm(res, req, post)
+		if uerr, ok := err.(*upload.Error); ok {
+			log.Println("[Update] rejected file upload for type:", t, uerr)
+			sendUploadError(res, uerr)
+			return
+		}
+		if err != nil {
+			log.Println("[Update] error decoding form for type:", t, err)
+			res.WriteHeader(status)
+			return
+		}
 	}
 
 	err = hook.BeforeAPIUpdate(res, req)
@@ -189,13 +159,63 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 	// set specifier for db bucket in case content is/isn't Trustable
 	var spec string
 
-	_, err = db.UpdateContent(t+spec+":"+id, req.PostForm)
+	target := t + spec + ":" + id
+	author := requestAuthor(req)
+	var etag string
+	var before, after []byte
+	var patchStatus int
+	err = db.UpdateIfMatch(target, ifMatch, func() error {
+		var err error
+		before, err = db.Content(target)
+		if err != nil {
+			return err
+		}
+
+		if patch != nil {
+			// the patch is applied again to the content as it is now, so an
+			// update made since it was read isn't lost
+			patched, patchStatus, err = applyContentPatch(patchType, before, patch)
+			if err != nil {
+				return err
+			}
+
+			_, err = db.SetContentJSONBy(target, author, patched)
+		} else if body != nil {
+			_, err = db.UpdateContentJSONBy(target, author, body)
+		} else {
+			_, err = db.UpdateContentBy(target, author, req.PostForm)
+		}
+		if err != nil {
+			return err
+		}
+
+		after, err = db.Content(target)
+		if err != nil {
+			return err
+		}
+
+		etag = db.ContentETag(after)
+		return nil
+	})
+	if err == db.ErrPreconditionFailed {
+		log.Println("[Update] rejected stale update for type:", t, "id:", id, "from:", req.RemoteAddr)
+		res.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil && patchStatus != 0 && patchStatus != http.StatusOK {
+		log.Println("[Update] error applying patch for type:", t, err)
+		res.WriteHeader(patchStatus)
+		return
+	}
 	if err != nil {
 		log.Println("[Update] error calling UpdateContent:", err)
 		res.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit(req, "content.update", t+spec, id, before, after)
+	notify(db.WebhookUpdate, t+spec, id, after)
+
 	// set the target in the context so user can get saved value from db in hook
 	ctx := context.WithValue(req.Context(), "target", fmt.Sprintf("%s:%s", t, id))
 	req = req.WithContext(ctx)
@@ -243,6 +263,7 @@ func updateContentHandler(res http.ResponseWriter, req *http.Request) {
 	}
 
 	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("ETag", etag)
 	_, err = res.Write(j)
 	if err != nil {
 		log.Println("[Update] error writing response:", err)
@@ -7,12 +7,63 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
 )
 
 // ArchiveFS walks the filesystem starting from basedir writing files encountered
 // tarred and gzipped to the provided writer
 func ArchiveFS(ctx context.Context, basedir string, w io.Writer) error {
+	return archive(ctx, basedir, w, false)
+}
+
+// ArchiveDir is like ArchiveFS, but names files in the archive by their path
+// relative to basedir, so it can be extracted anywhere
+func ArchiveDir(ctx context.Context, basedir string, w io.Writer) error {
+	return archive(ctx, basedir, w, true)
+}
+
+// ArchiveStorage writes the files in s tarred and gzipped to the provided
+// writer, named by their path under dir in the archive
+func ArchiveStorage(ctx context.Context, s storage.Storage, dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tarball := tar.NewWriter(gz)
+
+	err := s.Walk(ctx, func(obj *storage.Object) error {
+		src, info, err := s.Open(ctx, obj.Name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		err = tarball.WriteHeader(&tar.Header{
+			Name:    path.Join(dir, info.Name),
+			Mode:    0644,
+			Size:    info.Size,
+			ModTime: info.Modified,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tarball, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = tarball.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+func archive(ctx context.Context, basedir string, w io.Writer, relative bool) error {
 	gz := gzip.NewWriter(w)
 	tarball := tar.NewWriter(gz)
 
@@ -47,6 +98,13 @@ func ArchiveFS(ctx context.Context, basedir string, w io.Writer) error {
 		}
 
 		hdr.Name = path
+		if relative {
+			hdr.Name, err = filepath.Rel(basedir, path)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(hdr.Name)
+		}
 
 		err = tarball.WriteHeader(hdr)
 		if err != nil {
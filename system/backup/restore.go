@@ -0,0 +1,477 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SearchDir is the directory in a staged snapshot which the search indices are
+// extracted to
+const SearchDir = "search"
+
+// WriteArchive writes the snapshot in the backup directory to w as a gzipped tar
+// archive of its manifest, its files, and its uploads in the UploadsDir by their
+// path, so it can be restored without the rest of the backup directory
+func WriteArchive(ctx context.Context, dir string, m *Manifest, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tarball := tar.NewWriter(gz)
+
+	snapshot := filepath.Join(dir, m.Name)
+	add := func(name, src string) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+
+		err = tarball.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tarball, f)
+		return err
+	}
+
+	err := add(ManifestFile, filepath.Join(snapshot, ManifestFile))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range m.Files {
+		err = add(f.Path, filepath.Join(snapshot, f.Path))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.Uploads {
+		err = add(path.Join(UploadsDir, f.Path), UploadPath(dir, f))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tarball.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// Stage copies the snapshot at src, which is either a snapshot directory in a
+// backup directory or an archive written by WriteArchive, to the directory stage
+// and verifies it against its manifest. The files of the snapshot are put in the
+// stage directory, its uploads in the UploadsDir by their path, and its search
+// indices are extracted to the SearchDir.
+func Stage(ctx context.Context, src, stage string) (*Manifest, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Join(stage, UploadsDir), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	var m *Manifest
+	if info.IsDir() {
+		m, err = stageDir(ctx, src, stage)
+	} else {
+		m, err = stageArchive(ctx, src, stage)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range m.Files {
+		err = verifyFile(filepath.Join(stage, f.Path), f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, f := range m.Uploads {
+		err = verifyFile(filepath.Join(stage, UploadsDir, filepath.FromSlash(f.Path)), f)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: %v", f.Path, err)
+		}
+
+		listed[f.Path] = true
+	}
+
+	// an archive may hold uploads which aren't in its manifest, and can't be
+	// verified
+	uploads := filepath.Join(stage, UploadsDir)
+	err = filepath.Walk(uploads, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(uploads, p)
+		if err != nil {
+			return err
+		}
+
+		if !listed[filepath.ToSlash(rel)] {
+			return os.Remove(p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range m.Files {
+		if file.Path != "search.tar.gz" {
+			continue
+		}
+
+		f, err := os.Open(filepath.Join(stage, file.Path))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		err = Extract(ctx, f, filepath.Join(stage, SearchDir))
+		if err != nil {
+			return nil, fmt.Errorf("search indices: %v", err)
+		}
+	}
+
+	return m, nil
+}
+
+// stageDir copies a snapshot directory and its uploads to the stage
+func stageDir(ctx context.Context, src, stage string) (*Manifest, error) {
+	m, err := ReadManifest(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %v", src, err)
+	}
+
+	err = checkManifest(m)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range m.Files {
+		err = copyFile(filepath.Join(src, f.Path), filepath.Join(stage, f.Path))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Dir(src)
+	for _, f := range m.Uploads {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		err = copyFile(UploadPath(dir, f), filepath.Join(stage, UploadsDir, filepath.FromSlash(f.Path)))
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: %v", f.Path, err)
+		}
+	}
+
+	return m, nil
+}
+
+// stageArchive extracts an archive written by WriteArchive to the stage
+func stageArchive(ctx context.Context, src, stage string) (*Manifest, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = Extract(ctx, f, stage)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot archive: %v", src, err)
+	}
+
+	m, err := ReadManifest(stage)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot archive: %v", src, err)
+	}
+
+	return m, checkManifest(m)
+}
+
+// checkManifest returns an error if the paths in the manifest aren't relative
+// paths within the snapshot, which could replace other files when restored
+func checkManifest(m *Manifest) error {
+	for _, f := range append(append([]File{}, m.Files...), m.Uploads...) {
+		if !safePath(f.Path) {
+			return fmt.Errorf("invalid path in manifest: %q", f.Path)
+		}
+	}
+
+	for _, f := range m.Files {
+		if strings.Contains(f.Path, "/") {
+			return fmt.Errorf("invalid path in manifest: %q", f.Path)
+		}
+	}
+
+	return nil
+}
+
+// safePath reports whether the slash-separated path p stays within the
+// directory it is relative to
+func safePath(p string) bool {
+	if p == "" || path.IsAbs(p) || strings.Contains(p, "\\") {
+		return false
+	}
+
+	clean := path.Clean(p)
+	return clean == p && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// Extract extracts the gzipped tar archive read from r to dir. Only files and
+// directories are extracted, and an error is returned for entries which would
+// be written outside of dir.
+func Extract(ctx context.Context, r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	err = os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tarball := tar.NewReader(gz)
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		hdr, err := tarball.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "./"), "/")
+		if name == "." || name == "" {
+			continue
+		}
+
+		if !safePath(name) {
+			return fmt.Errorf("invalid path in archive: %q", hdr.Name)
+		}
+
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, os.ModeDir|os.ModePerm)
+
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(tarball, dst)
+
+		default:
+			err = fmt.Errorf("unsupported entry in archive: %q", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Compatible returns an error if a snapshot made with Ponzu version can't be
+// restored by the current version. Snapshots must be from the same major
+// version, or minor version before 1.0, and can't be from a newer version. The
+// check is skipped if either version is unknown.
+func Compatible(version, current string) error {
+	if version == "" || current == "" {
+		return nil
+	}
+
+	v, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	c, err := parseVersion(current)
+	if err != nil {
+		return err
+	}
+
+	if v[0] != c[0] || (c[0] == 0 && v[1] != c[1]) {
+		return fmt.Errorf("snapshot from Ponzu v%s can't be restored by v%s", version, current)
+	}
+
+	for i := range v {
+		if v[i] != c[i] {
+			if v[i] > c[i] {
+				return fmt.Errorf("snapshot from Ponzu v%s is newer than v%s", version, current)
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// parseVersion parses a version such as "0.11.0" or "v1.2"
+func parseVersion(version string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version: %q", version)
+	}
+
+	for i, p := range parts {
+		// ignore pre-release and build suffixes, i.e. 1.0.0-beta
+		if j := strings.IndexAny(p, "-+"); j >= 0 && i == len(parts)-1 {
+			p = p[:j]
+		}
+
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, fmt.Errorf("invalid version: %q", version)
+		}
+		v[i] = n
+	}
+
+	return v, nil
+}
+
+// Replace replaces the directory target by src, which is moved next to target
+// first so the replacement is a rename. The previous target is kept until
+// commit is called, and put back by rollback.
+func Replace(src, target string) (commit, rollback func() error, err error) {
+	parent, base := filepath.Split(target)
+	next := filepath.Join(parent, "."+base+"-restore")
+	old := filepath.Join(parent, "."+base+"-old")
+
+	for _, dir := range []string{next, old} {
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = MoveDir(src, next)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = os.Stat(target)
+	exists := err == nil
+	if exists {
+		err = os.Rename(target, old)
+		if err != nil {
+			os.RemoveAll(next)
+			return nil, nil, err
+		}
+	}
+
+	err = os.Rename(next, target)
+	if err != nil {
+		os.RemoveAll(next)
+		if exists {
+			os.Rename(old, target)
+		}
+
+		return nil, nil, err
+	}
+
+	commit = func() error {
+		return os.RemoveAll(old)
+	}
+
+	rollback = func() error {
+		err := os.RemoveAll(target)
+		if err != nil || !exists {
+			return err
+		}
+
+		return os.Rename(old, target)
+	}
+
+	return commit, rollback, nil
+}
+
+// MoveDir moves the directory src to dst, copying it if they are on different
+// devices
+func MoveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	err = filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), os.ModeDir|os.ModePerm)
+		}
+
+		return copyFile(p, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+
+	return os.RemoveAll(src)
+}
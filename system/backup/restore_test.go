@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompatible(t *testing.T) {
+	cases := []struct {
+		version, current string
+		ok               bool
+	}{
+		{"0.11.0", "0.11.0", true},
+		{"0.11.0", "0.11.3", true},
+		{"v0.11.1", "0.11.2-beta", true},
+		{"", "0.11.0", true},
+		{"0.10.4", "0.11.0", false},
+		{"0.11.2", "0.11.0", false},
+		{"1.2.0", "1.4.0", true},
+		{"1.2.0", "2.0.0", false},
+		{"next", "0.11.0", false},
+	}
+
+	for _, c := range cases {
+		err := Compatible(c.version, c.current)
+		if (err == nil) != c.ok {
+			t.Errorf("Expected %s compatible with %s to be %v, got %v", c.version, c.current, c.ok, err)
+		}
+	}
+}
+
+func TestStage(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ponzu-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "backups")
+	uploadDir := filepath.Join(tmp, "uploads")
+	err = os.MkdirAll(uploadDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(uploadDir, "a.txt"), []byte("a"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := writeSnapshot(t, dir, uploadDir, time.Now())
+
+	archive := filepath.Join(tmp, "snapshot.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteArchive(context.Background(), dir, m, f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{filepath.Join(dir, m.Name), archive} {
+		stage := filepath.Join(tmp, "stage-"+filepath.Base(src))
+		staged, err := Stage(context.Background(), src, stage)
+		if err != nil {
+			t.Errorf("Failed to stage %s: %s", src, err.Error())
+			continue
+		}
+
+		if staged.Name != m.Name {
+			t.Errorf("Expected snapshot %s, got %s", m.Name, staged.Name)
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(stage, UploadsDir, "a.txt"))
+		if err != nil || string(b) != "a" {
+			t.Errorf("Expected upload in stage, got %q %v", b, err)
+		}
+	}
+
+	// entries outside of the directory extracted to are refused
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tarball := tar.NewWriter(gz)
+	tarball.WriteHeader(&tar.Header{Name: "../escape", Mode: 0666, Size: 1, Typeflag: tar.TypeReg})
+	tarball.Write([]byte("x"))
+	tarball.Close()
+	gz.Close()
+
+	err = Extract(context.Background(), buf, filepath.Join(tmp, "extract"))
+	if err == nil {
+		t.Errorf("Expected error extracting ../escape")
+	}
+
+	if _, err := os.Stat(filepath.Join(tmp, "escape")); !os.IsNotExist(err) {
+		t.Errorf("Expected ../escape not to be extracted")
+	}
+}
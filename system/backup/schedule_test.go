@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, time.May, 15, 10, 30, 45, 0, time.UTC)
+
+	cases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.May, 15, 10, 31, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2024, time.May, 15, 10, 40, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, time.May, 16, 3, 0, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2024, time.May, 19, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1,15 * 1-5", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 12 31 * *", time.Date(2024, time.May, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+	}
+
+	for _, c := range cases {
+		s, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", c.spec, err.Error())
+			continue
+		}
+
+		next := s.Next(now)
+		if !next.Equal(c.expected) {
+			t.Errorf("Expected %q to be next at %s, got %s", c.spec, c.expected, next)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
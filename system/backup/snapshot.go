@@ -0,0 +1,380 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
+)
+
+const (
+	// ManifestFile is the name of the manifest in each snapshot directory
+	ManifestFile = "manifest.json"
+
+	// UploadsDir is the directory, next to the snapshots, where the uploads of
+	// every snapshot are stored once by their checksum
+	UploadsDir = "uploads"
+
+	// SnapshotTimeFormat is the format of the time a snapshot is named by
+	SnapshotTimeFormat = "20060102-150405"
+)
+
+// Manifest describes a snapshot, with the checksums of its files
+type Manifest struct {
+	Name       string   `json:"name"`
+	Created    int64    `json:"created"` // milliseconds since Unix epoch
+	Version    string   `json:"version"`
+	Types      []string `json:"types"`
+	Files      []File   `json:"files"`
+	Uploads    []File   `json:"uploads"`
+	NewUploads int      `json:"new_uploads"`
+}
+
+// Size returns the size of the files in the snapshot, and of its uploads
+func (m *Manifest) Size() (int64, int64) {
+	var files, uploads int64
+	for _, f := range m.Files {
+		files += f.Size
+	}
+
+	for _, f := range m.Uploads {
+		uploads += f.Size
+	}
+
+	return files, uploads
+}
+
+// File is a file in a snapshot. The Path of uploads is relative to the upload
+// directory, and they are stored in the UploadsDir by their checksum.
+type File struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	Modified int64  `json:"modified,omitempty"` // nanoseconds since Unix epoch
+}
+
+// Checksum returns the size and checksum of the file at path
+func Checksum(path string) (File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Path:   filepath.Base(path),
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// UploadPath returns the path an upload is stored at, in the backup directory
+func UploadPath(dir string, f File) string {
+	return filepath.Join(dir, UploadsDir, f.SHA256[:2], f.SHA256)
+}
+
+// StoreUploads copies the uploaded files in s to the backup directory which
+// aren't already stored there, and returns the manifest entries of every file
+// and the number copied. Files which are unchanged since the previous snapshot
+// keep their checksum instead of being read again.
+func StoreUploads(ctx context.Context, dir string, s storage.Storage, prev *Manifest) ([]File, int, error) {
+	known := make(map[string]File)
+	if prev != nil {
+		for _, f := range prev.Uploads {
+			known[f.Path] = f
+		}
+	}
+
+	files := []File{}
+	copied := 0
+	err := s.Walk(ctx, func(obj *storage.Object) error {
+		f, ok := known[obj.Name]
+		if ok && f.Size == obj.Size && f.Modified == obj.Modified.UnixNano() {
+			if _, err := os.Stat(UploadPath(dir, f)); err == nil {
+				files = append(files, f)
+				return nil
+			}
+		}
+
+		f, stored, err := storeUpload(ctx, dir, s, obj)
+		if err != nil {
+			return fmt.Errorf("upload %s: %v", obj.Name, err)
+		}
+
+		if stored {
+			copied++
+		}
+
+		files = append(files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, copied, nil
+}
+
+// storeUpload reads the upload from s, computing its checksum, and stores it in
+// the backup directory unless an upload with the same checksum already is
+func storeUpload(ctx context.Context, dir string, s storage.Storage, obj *storage.Object) (File, bool, error) {
+	src, _, err := s.Open(ctx, obj.Name)
+	if err != nil {
+		return File{}, false, err
+	}
+	defer src.Close()
+
+	uploads := filepath.Join(dir, UploadsDir)
+	err = os.MkdirAll(uploads, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return File{}, false, err
+	}
+
+	tmp, err := ioutil.TempFile(uploads, ".tmp-")
+	if err != nil {
+		return File{}, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), src)
+	if err != nil {
+		tmp.Close()
+		return File{}, false, err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return File{}, false, err
+	}
+
+	f := File{
+		Path:     obj.Name,
+		Size:     n,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		Modified: obj.Modified.UnixNano(),
+	}
+
+	dst := UploadPath(dir, f)
+	if _, err := os.Stat(dst); err == nil {
+		return f, false, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return File{}, false, err
+	}
+
+	return f, true, os.Rename(tmp.Name(), dst)
+}
+
+// copyFile copies src to dst through a temporary file, so dst is never partly
+// written
+func copyFile(src, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, in)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// WriteManifest writes the manifest to the snapshot directory
+func WriteManifest(snapshot string, m *Manifest) error {
+	j, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(snapshot, ManifestFile), j, 0666)
+}
+
+// ReadManifest reads the manifest of the snapshot directory
+func ReadManifest(snapshot string) (*Manifest, error) {
+	j, err := ioutil.ReadFile(filepath.Join(snapshot, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	err = json.Unmarshal(j, m)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Snapshots returns the manifests of the snapshots in the backup directory,
+// newest first. Snapshots which are still being written are left out.
+func Snapshots(dir string) ([]*Manifest, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Manifest
+	for _, info := range infos {
+		if !info.IsDir() || info.Name() == UploadsDir || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		m, err := ReadManifest(filepath.Join(dir, info.Name()))
+		if err != nil {
+			continue
+		}
+
+		m.Name = info.Name()
+		snapshots = append(snapshots, m)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created > snapshots[j].Created
+	})
+
+	return snapshots, nil
+}
+
+// Verify checks the files and uploads of the snapshot in the backup directory
+// against the checksums in its manifest
+func Verify(dir string, m *Manifest) error {
+	snapshot := filepath.Join(dir, m.Name)
+	for _, f := range m.Files {
+		err := verifyFile(filepath.Join(snapshot, f.Path), f)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, f := range m.Uploads {
+		err := verifyFile(UploadPath(dir, f), f)
+		if err != nil {
+			return fmt.Errorf("upload %s: %v", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func verifyFile(path string, f File) error {
+	sum, err := Checksum(path)
+	if err != nil {
+		return err
+	}
+
+	if sum.Size != f.Size || sum.SHA256 != f.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s", f.Path)
+	}
+
+	return nil
+}
+
+// Prune removes the snapshots in the backup directory which aren't kept by the
+// retention rules: the newest snapshot of each of the last keepDaily days and
+// keepWeekly weeks which have one, and always the newest snapshot. Uploads
+// which are no longer in any snapshot are removed too. It returns the names of
+// the snapshots removed.
+func Prune(dir string, keepDaily, keepWeekly int) ([]string, error) {
+	snapshots, err := Snapshots(dir)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	keep := map[string]bool{snapshots[0].Name: true}
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, m := range snapshots {
+		t := time.Unix(0, m.Created*int64(time.Millisecond))
+
+		day := t.Format("2006-01-02")
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep[m.Name] = true
+		}
+
+		year, week := t.ISOWeek()
+		wk := fmt.Sprintf("%d-%d", year, week)
+		if !weeks[wk] && len(weeks) < keepWeekly {
+			weeks[wk] = true
+			keep[m.Name] = true
+		}
+	}
+
+	var removed []string
+	uploads := make(map[string]bool)
+	for _, m := range snapshots {
+		if !keep[m.Name] {
+			err := os.RemoveAll(filepath.Join(dir, m.Name))
+			if err != nil {
+				return removed, err
+			}
+
+			removed = append(removed, m.Name)
+			continue
+		}
+
+		for _, f := range m.Uploads {
+			uploads[f.SHA256] = true
+		}
+	}
+
+	err = filepath.Walk(filepath.Join(dir, UploadsDir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if info.Mode().IsRegular() && !uploads[info.Name()] {
+			return os.Remove(path)
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
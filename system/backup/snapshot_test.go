@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
+)
+
+// writeSnapshot writes a snapshot created at t with a single file, and stores
+// the uploads in uploadDir
+func writeSnapshot(t *testing.T, dir, uploadDir string, created time.Time) *Manifest {
+	snapshots, err := Snapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var prev *Manifest
+	if len(snapshots) > 0 {
+		prev = snapshots[0]
+	}
+
+	m := &Manifest{
+		Name:    created.Format(SnapshotTimeFormat),
+		Created: created.UnixNano() / int64(time.Millisecond),
+	}
+
+	snapshot := filepath.Join(dir, m.Name)
+	err = os.MkdirAll(snapshot, os.ModeDir|os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(snapshot, "system.db"), []byte(m.Name), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Checksum(filepath.Join(snapshot, "system.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Files = []File{f}
+
+	m.Uploads, m.NewUploads, err = StoreUploads(context.Background(), dir, &storage.Local{Dir: uploadDir}, prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteManifest(snapshot, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestSnapshots(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "ponzu-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, "backups")
+	uploadDir := filepath.Join(tmp, "uploads")
+
+	// no uploads yet
+	m := writeSnapshot(t, dir, uploadDir, time.Date(2024, time.May, 1, 3, 0, 0, 0, time.Local))
+	if len(m.Uploads) != 0 || m.NewUploads != 0 {
+		t.Errorf("Expected no uploads, got %+v", m.Uploads)
+	}
+
+	err = os.MkdirAll(filepath.Join(uploadDir, "2024", "05"), os.ModeDir|os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err = ioutil.WriteFile(filepath.Join(uploadDir, "2024", "05", name), []byte(name), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m = writeSnapshot(t, dir, uploadDir, time.Date(2024, time.May, 2, 3, 0, 0, 0, time.Local))
+	if len(m.Uploads) != 2 || m.NewUploads != 2 {
+		t.Errorf("Expected 2 new uploads, got %d of %d", m.NewUploads, len(m.Uploads))
+	}
+
+	// only the new upload is copied
+	err = ioutil.WriteFile(filepath.Join(uploadDir, "2024", "05", "c.txt"), []byte("c.txt"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m = writeSnapshot(t, dir, uploadDir, time.Date(2024, time.May, 2, 4, 0, 0, 0, time.Local))
+	if len(m.Uploads) != 3 || m.NewUploads != 1 {
+		t.Errorf("Expected 1 new upload, got %d of %d", m.NewUploads, len(m.Uploads))
+	}
+
+	err = Verify(dir, m)
+	if err != nil {
+		t.Errorf("Failed to verify: %s", err.Error())
+	}
+
+	// the older snapshot of May 2 isn't kept, and neither is May 1 with only
+	// one daily snapshot
+	removed, err := Prune(dir, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 2 {
+		t.Errorf("Expected 2 snapshots removed, got %v", removed)
+	}
+
+	snapshots, err := Snapshots(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 1 || snapshots[0].Name != m.Name {
+		t.Errorf("Expected only %s to be kept, got %d snapshots", m.Name, len(snapshots))
+	}
+
+	err = ioutil.WriteFile(UploadPath(dir, m.Uploads[0]), []byte("changed"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Verify(dir, m); err == nil {
+		t.Errorf("Expected changed upload to fail verification")
+	}
+}
@@ -54,3 +54,11 @@ func SearchDir() string {
 	}
 	return searchDir
 }
+
+func ImageCacheDir() string {
+	cacheDir := os.Getenv("PONZU_IMAGE_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(DataDir(), "cache", "images")
+	}
+	return cacheDir
+}
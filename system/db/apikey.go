@@ -0,0 +1,224 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// apiKeyPrefix begins every API key, to make keys easy to recognize
+const apiKeyPrefix = "ponzu_"
+
+// apiKeyUseInterval is the precision, in milliseconds, of the time an API key
+// was last used
+const apiKeyUseInterval = int64(time.Minute / time.Millisecond)
+
+// ErrInvalidAPIKey is returned for an API key which doesn't exist, or has been
+// revoked
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+
+// APIKey is a named key used to authenticate requests to the content API. Only
+// a hash of the key itself is stored.
+type APIKey struct {
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Hash     string   `json:"hash"`
+	Hint     string   `json:"hint"` // the first characters of the key
+	Scopes   []string `json:"scopes"`
+	Created  int64    `json:"created"`   // milliseconds since Unix epoch
+	LastUsed int64    `json:"last_used"` // milliseconds since Unix epoch
+	Revoked  int64    `json:"revoked"`   // milliseconds since Unix epoch
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAPIKey creates and stores an API key with the scopes provided, and returns
+// the key, which can't be recovered later
+func NewAPIKey(name string, scopes []string) (string, *APIKey, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	k := &APIKey{
+		Name:    name,
+		Hash:    hashAPIKey(key),
+		Hint:    key[:len(apiKeyPrefix)+6],
+		Scopes:  scopes,
+		Created: millis(time.Now()),
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		keys, err := tx.CreateBucketIfNotExists([]byte("__apikeys"))
+		if err != nil {
+			return err
+		}
+
+		id, err := keys.NextSequence()
+		if err != nil {
+			return err
+		}
+		k.ID = int(id)
+
+		j, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		return keys.Put([]byte(k.Hash), j)
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key, k, nil
+}
+
+// APIKeys returns all API keys, including those revoked, in order of creation
+func APIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var key APIKey
+			err := json.Unmarshal(v, &key)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+// VerifyAPIKey finds the API key matching key, records that it was used, and
+// returns it. ErrInvalidAPIKey is returned if the key doesn't exist or has been
+// revoked.
+func VerifyAPIKey(key string) (*APIKey, error) {
+	hash := []byte(hashAPIKey(key))
+
+	var k APIKey
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return ErrInvalidAPIKey
+		}
+
+		j := b.Get(hash)
+		if j == nil {
+			return ErrInvalidAPIKey
+		}
+
+		return json.Unmarshal(j, &k)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if k.Revoked != 0 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// limit writes for keys used by many requests
+	now := millis(time.Now())
+	if now-k.LastUsed < apiKeyUseInterval {
+		return &k, nil
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return ErrInvalidAPIKey
+		}
+
+		// the key may have been revoked since it was read
+		j := b.Get(hash)
+		if j == nil {
+			return ErrInvalidAPIKey
+		}
+
+		err := json.Unmarshal(j, &k)
+		if err != nil {
+			return err
+		}
+
+		if k.Revoked != 0 {
+			return ErrInvalidAPIKey
+		}
+
+		k.LastUsed = now
+		j, err = json.Marshal(k)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(hash, j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &k, nil
+}
+
+// RevokeAPIKey prevents the API key with the id from being used again
+func RevokeAPIKey(id int) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__apikeys"))
+		if b == nil {
+			return ErrInvalidAPIKey
+		}
+
+		c := b.Cursor()
+		for hash, j := c.First(); hash != nil; hash, j = c.Next() {
+			var k APIKey
+			err := json.Unmarshal(j, &k)
+			if err != nil {
+				return err
+			}
+
+			if k.ID != id {
+				continue
+			}
+
+			if k.Revoked == 0 {
+				k.Revoked = millis(time.Now())
+			}
+
+			j, err = json.Marshal(k)
+			if err != nil {
+				return err
+			}
+
+			return b.Put(hash, j)
+		}
+
+		return ErrInvalidAPIKey
+	})
+}
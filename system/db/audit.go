@@ -0,0 +1,217 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// AuditEntry records an action taken in the system, and who took it
+type AuditEntry struct {
+	ID     uint64 `json:"id"`
+	Time   int64  `json:"time"` // milliseconds since Unix epoch
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"` // ex. "Song:12" or a user's email
+	IP     string `json:"ip,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Before string `json:"before,omitempty"` // summary of what changed, before the action
+	After  string `json:"after,omitempty"`  // summary of what changed, after the action
+}
+
+// auditValueLimit is the most characters of a field's value kept in a summary
+const auditValueLimit = 80
+
+// fields which are set by the system on every save, and left out of summaries
+var auditIgnoreFields = map[string]bool{
+	"id":      true,
+	"uuid":    true,
+	"updated": true,
+	"etag":    true,
+}
+
+// fields with any of these in their name have their values masked in summaries
+var auditSecretFields = []string{"secret", "password", "hash", "salt", "token"}
+
+// AuditFilter selects entries from the audit log. Empty fields match any entry.
+type AuditFilter struct {
+	Actor  string // matches the actor exactly, ignoring case
+	Action string // matches the action, or actions prefixed by it and a "."
+	Target string // matches the target, or targets prefixed by it and a ":"
+	Since  int64  // milliseconds since Unix epoch, inclusive
+	Until  int64  // milliseconds since Unix epoch, exclusive
+}
+
+// Match reports whether the entry is selected by the filter
+func (f AuditFilter) Match(e AuditEntry) bool {
+	if f.Actor != "" && !strings.EqualFold(f.Actor, e.Actor) {
+		return false
+	}
+
+	if f.Action != "" && e.Action != f.Action && !strings.HasPrefix(e.Action, f.Action+".") {
+		return false
+	}
+
+	if f.Target != "" && e.Target != f.Target && !strings.HasPrefix(e.Target, f.Target+":") {
+		return false
+	}
+
+	if f.Since != 0 && e.Time < f.Since {
+		return false
+	}
+
+	if f.Until != 0 && e.Time >= f.Until {
+		return false
+	}
+
+	return true
+}
+
+// Audit appends the entry to the audit log. Entries can't be changed or removed
+// once they have been added.
+func Audit(e AuditEntry) error {
+	if e.Time == 0 {
+		e.Time = millis(time.Now())
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__audit"))
+		if err != nil {
+			return err
+		}
+
+		e.ID, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, e.ID)
+		return b.Put(k, j)
+	})
+}
+
+// AuditLog calls fn for each entry in the audit log matched by the filter, most
+// recent first, until fn returns false
+func AuditLog(f AuditFilter, fn func(AuditEntry) bool) error {
+	return store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__audit"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var e AuditEntry
+			err := json.Unmarshal(v, &e)
+			if err != nil {
+				return err
+			}
+
+			// entries are in the order they were added, so none are older
+			if f.Since != 0 && e.Time < f.Since {
+				return nil
+			}
+
+			if !f.Match(e) {
+				continue
+			}
+
+			if !fn(e) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+}
+
+// AuditChanges summarizes the top-level fields which differ between two json
+// objects, as "field: value" pairs before and after the change. Either may be
+// nil, such as when content is created or deleted. Values of secret fields are
+// masked.
+func AuditChanges(before, after []byte) (string, string) {
+	var b, a map[string]json.RawMessage
+	if before != nil {
+		json.Unmarshal(before, &b)
+	}
+	if after != nil {
+		json.Unmarshal(after, &a)
+	}
+
+	names := make(map[string]bool)
+	for k := range b {
+		names[k] = true
+	}
+	for k := range a {
+		names[k] = true
+	}
+
+	var changed []string
+	for name := range names {
+		if auditIgnoreFields[name] || string(b[name]) == string(a[name]) {
+			continue
+		}
+
+		if !auditEmpty(b[name]) || !auditEmpty(a[name]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	var bs, as []string
+	for _, name := range changed {
+		if _, ok := b[name]; ok {
+			bs = append(bs, name+": "+auditValue(name, b[name]))
+		}
+		if _, ok := a[name]; ok {
+			as = append(as, name+": "+auditValue(name, a[name]))
+		}
+	}
+
+	return strings.Join(bs, "; "), strings.Join(as, "; ")
+}
+
+// auditEmpty reports whether a json value is missing or a zero value
+func auditEmpty(v json.RawMessage) bool {
+	switch string(v) {
+	case "", "null", `""`, "0", "false", "[]", "{}":
+		return true
+	}
+
+	return false
+}
+
+// auditValue returns a short form of a json value for a summary
+func auditValue(name string, v json.RawMessage) string {
+	if auditEmpty(v) {
+		return string(v)
+	}
+
+	for _, s := range auditSecretFields {
+		if strings.Contains(strings.ToLower(name), s) {
+			return "********"
+		}
+	}
+
+	var str string
+	if json.Unmarshal(v, &str) != nil {
+		str = string(v)
+	}
+
+	r := []rune(str)
+	if len(r) > auditValueLimit {
+		return string(r[:auditValueLimit]) + "..."
+	}
+
+	return str
+}
@@ -1,40 +1,159 @@
 package db
 
 import (
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/boltdb/bolt"
 )
 
-// CacheControl sets the default cache policy on static asset responses
+// ErrPreconditionFailed is returned when content has changed since the version
+// identified by an If-Match ETag was read
+var ErrPreconditionFailed = errors.New("Content has been modified since it was read")
+
+// contentMu serializes updates made with UpdateIfMatch, so that the ETag check,
+// or a read of the content, and the write which follows it can't be interleaved
+// with another update
+var contentMu sync.Mutex
+
+// CacheControl sets the default cache policy on static asset responses, which
+// are validated by the system-wide Etag, changed on system start or when the
+// cache is invalidated from the admin configuration
 func CacheControl(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		cacheDisabled := ConfigCache("cache_disabled").(bool)
-		if cacheDisabled {
-			res.Header().Add("Cache-Control", "no-cache")
+		if !setCachePolicy(res) {
 			next.ServeHTTP(res, req)
-		} else {
-			age := int64(ConfigCache("cache_max_age").(float64))
-			etag := ConfigCache("etag").(string)
-			if age == 0 {
-				age = DefaultMaxAge
-			}
-			policy := fmt.Sprintf("max-age=%d, public", age)
-			res.Header().Add("ETag", etag)
-			res.Header().Add("Cache-Control", policy)
-
-			if match := req.Header.Get("If-None-Match"); match != "" {
-				if strings.Contains(match, etag) {
-					res.WriteHeader(http.StatusNotModified)
-					return
-				}
+			return
+		}
+
+		etag := ConfigCache("etag").(string)
+		res.Header().Set("ETag", etag)
+
+		if match := req.Header.Get("If-None-Match"); match != "" {
+			if strings.Contains(match, etag) {
+				res.WriteHeader(http.StatusNotModified)
+				return
 			}
+		}
 
-			next.ServeHTTP(res, req)
+		next.ServeHTTP(res, req)
+	})
+}
+
+// CachePolicy sets the default cache policy on content responses, leaving it to
+// the handler to validate the response per item or type, see NotModified
+func CachePolicy(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		setCachePolicy(res)
+		next.ServeHTTP(res, req)
+	})
+}
+
+// setCachePolicy sets the Cache-Control header from the system configuration,
+// and reports whether caching is enabled
+func setCachePolicy(res http.ResponseWriter) bool {
+	cacheDisabled := ConfigCache("cache_disabled").(bool)
+	if cacheDisabled {
+		res.Header().Set("Cache-Control", "no-cache")
+		return false
+	}
+
+	age := int64(ConfigCache("cache_max_age").(float64))
+	if age == 0 {
+		age = DefaultMaxAge
+	}
+	policy := fmt.Sprintf("max-age=%d, public", age)
+	res.Header().Set("Cache-Control", policy)
+
+	return true
+}
+
+// NotModified sets the ETag and Last-Modified headers of a response, and if the
+// If-None-Match or If-Modified-Since headers of a GET request show the client's
+// cached copy is still valid, responds with 304 Not Modified and returns true,
+// in which case the handler should return without writing a body
+func NotModified(res http.ResponseWriter, req *http.Request, etag string, modified time.Time) bool {
+	if etag != "" {
+		res.Header().Set("ETag", etag)
+	}
+
+	if !modified.IsZero() {
+		res.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		if etag == "" || !MatchETag(match, etag, true) {
+			return false
+		}
+
+		res.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+
+	// Last-Modified has a resolution of seconds
+	if modified.Truncate(time.Second).After(since) {
+		return false
+	}
+
+	res.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ContentModified returns the time content of the namespace was last created,
+// updated or deleted, or the zero time if it hasn't changed since this was first
+// recorded
+func ContentModified(namespace string) (time.Time, error) {
+	var modified time.Time
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__modified"))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		v := b.Get([]byte(namespace))
+		if v == nil {
+			return nil
+		}
+
+		ns, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return err
 		}
+
+		modified = time.Unix(0, ns)
+		return nil
 	})
+
+	return modified, err
+}
+
+// setModified records now as the time content of the namespace last changed
+func setModified(tx *bolt.Tx, namespace string) error {
+	b := tx.Bucket([]byte("__modified"))
+	if b == nil {
+		return bolt.ErrBucketNotFound
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return b.Put([]byte(namespace), []byte(now))
 }
 
 // NewEtag generates a new Etag for response caching
@@ -45,7 +164,7 @@ func NewEtag() string {
 	return etag
 }
 
-// InvalidateCache sets a new Etag for http responses
+// InvalidateCache sets a new Etag for static asset responses
 func InvalidateCache() error {
 	err := PutConfig("etag", NewEtag())
 	if err != nil {
@@ -54,3 +173,68 @@ func InvalidateCache() error {
 
 	return nil
 }
+
+// ContentETag creates a strong ETag from the stored json of a content item,
+// which changes every time the item is saved
+func ContentETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// MatchETag checks if an If-Match or If-None-Match header value is "*" or lists
+// the etag. Weak ETags (W/"...") in either only match if weak comparison is
+// requested, as is used for If-None-Match.
+func MatchETag(header, etag string, weak bool) bool {
+	weakETag := strings.HasPrefix(etag, "W/")
+	etag = strings.TrimPrefix(etag, "W/")
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		if weakETag && !weak {
+			continue
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateIfMatch calls update only if the content at target is unchanged since
+// the version identified by ifMatch, the value of an If-Match header. If the
+// content has changed, ErrPreconditionFailed is returned. An empty ifMatch
+// calls update unconditionally. Updates are made one at a time, so update can
+// read the content and store a change to it without losing another update.
+// The `target` argument is a string made up of namespace:id (string:int)
+func UpdateIfMatch(target, ifMatch string, update func() error) error {
+	contentMu.Lock()
+	defer contentMu.Unlock()
+
+	if ifMatch == "" {
+		return update()
+	}
+
+	current, err := Content(target)
+	if err != nil {
+		return err
+	}
+
+	if len(current) == 0 || !MatchETag(ifMatch, ContentETag(current), false) {
+		return ErrPreconditionFailed
+	}
+
+	return update()
+}
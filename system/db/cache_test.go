@@ -0,0 +1,35 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateIfMatchSerializes(t *testing.T) {
+	// each update reads a value and writes it back changed, as a PATCH does,
+	// which loses updates unless they are made one at a time
+	var value int
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := UpdateIfMatch("Song:1", "", func() error {
+				read := value
+				time.Sleep(time.Millisecond)
+				value = read + 1
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if value != 20 {
+		t.Errorf("expected 20 updates, got %d", value)
+	}
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+)
+
+// Change feed events
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+
+	// ChangeReset is recorded when the database is restored from a snapshot,
+	// after which clients must fetch the content they need again
+	ChangeReset = "reset"
+)
+
+// ChangeLogRetention is how long changes are kept in the change log, for clients
+// to resume the change feed from
+const ChangeLogRetention = time.Hour * 24 * 7
+
+// changeEvents maps webhook events to the change to public content they make
+var changeEvents = map[string]string{
+	WebhookCreate:  ChangeCreate,
+	WebhookApprove: ChangeCreate,
+	WebhookPublish: ChangeCreate,
+	WebhookUpdate:  ChangeUpdate,
+	WebhookDelete:  ChangeDelete,
+	WebhookExpire:  ChangeDelete,
+}
+
+var (
+	changeSubsMu = &sync.Mutex{}
+	changeSubs   = make(map[chan struct{}]bool)
+)
+
+// Change is an entry in the change log, recording that public content was
+// created, updated or deleted
+type Change struct {
+	ID        uint64 `json:"id"`
+	Event     string `json:"event"`
+	Type      string `json:"type"`
+	Target    string `json:"target"` // as "Type:id"
+	Slug      string `json:"slug,omitempty"`
+	Timestamp int64  `json:"timestamp"` // milliseconds since Unix epoch
+}
+
+// ContentChanged records the event for the content with the id and json data in
+// the change log, and queues the webhooks subscribed to it. The type t may
+// include a bucket specifier, such as "Song__pending", in which case nothing is
+// recorded, as the public content didn't change.
+func ContentChanged(event, t, id string, data []byte) error {
+	if strings.Contains(t, "__") {
+		return nil
+	}
+
+	if change, ok := changeEvents[event]; ok {
+		err := recordChange(Change{
+			Event:     change,
+			Type:      t,
+			Target:    t + ":" + id,
+			Slug:      gjson.GetBytes(data, "slug").String(),
+			Timestamp: millis(time.Now()),
+		})
+		if err != nil {
+			log.Println("Error recording change:", err)
+		}
+	}
+
+	return QueueWebhooks(event, t, id, data)
+}
+
+// recordChange appends the change to the change log, and wakes the change
+// feed's subscribers
+func recordChange(c Change) error {
+	err := store.Update(func(tx *bolt.Tx) error {
+		return appendChange(tx, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyChanges()
+
+	return nil
+}
+
+// appendChange appends the change to the change log in tx, and removes changes
+// older than ChangeLogRetention
+func appendChange(tx *bolt.Tx, c Change) error {
+	cutoff := millis(time.Now().Add(-ChangeLogRetention))
+	b, err := tx.CreateBucketIfNotExists([]byte("__changes"))
+	if err != nil {
+		return err
+	}
+
+	c.ID, err = b.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	err = b.Put(changeKey(c.ID), j)
+	if err != nil {
+		return err
+	}
+
+	// changes are in the order they were made, so stop at the first which is
+	// still retained
+	var old [][]byte
+	cur := b.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		if gjson.GetBytes(v, "timestamp").Int() >= cutoff {
+			break
+		}
+
+		old = append(old, append([]byte{}, k...))
+	}
+
+	for _, k := range old {
+		err := b.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notifyChanges wakes the change feed's subscribers after changes are recorded
+func notifyChanges() {
+	changeSubsMu.Lock()
+	for ch := range changeSubs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	changeSubsMu.Unlock()
+}
+
+func changeKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// Changes returns up to limit changes made after the change with the id, oldest
+// first. It also reports whether the log is complete, or if changes after the
+// id have been removed since they were made. The log is also incomplete for an
+// id after the most recent change, such as one from before a restore, as the
+// next changes are given ids which have already been seen.
+func Changes(after uint64, limit int) ([]Change, bool, error) {
+	var changes []Change
+	complete := true
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		if k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) > after+1 {
+			complete = false
+		} else if k == nil && b.Sequence() > after {
+			complete = false
+		} else if after > b.Sequence() {
+			complete = false
+		}
+
+		for k, v := c.Seek(changeKey(after + 1)); k != nil && len(changes) < limit; k, v = c.Next() {
+			var ch Change
+			err := json.Unmarshal(v, &ch)
+			if err != nil {
+				return err
+			}
+
+			changes = append(changes, ch)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return changes, complete, nil
+}
+
+// LastChange returns the id of the most recent change
+func LastChange() (uint64, error) {
+	var id uint64
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__changes"))
+		if b != nil {
+			id = b.Sequence()
+		}
+
+		return nil
+	})
+
+	return id, err
+}
+
+// SubscribeChanges returns a channel which receives a value when changes are
+// made, and a func to call when it is no longer needed
+func SubscribeChanges() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	changeSubsMu.Lock()
+	changeSubs[ch] = true
+	changeSubsMu.Unlock()
+
+	return ch, func() {
+		changeSubsMu.Lock()
+		delete(changeSubs, ch)
+		changeSubsMu.Unlock()
+	}
+}
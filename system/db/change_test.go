@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// logChanges adds n changes to the change log, and removes those up to and
+// including the id removed, as if they were no longer kept
+func logChanges(t *testing.T, n int, removed uint64) {
+	err := store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__changes"))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < n; i++ {
+			c := Change{Event: ChangeUpdate, Type: "Song"}
+			c.ID, err = b.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			j, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(changeKey(c.ID), j)
+			if err != nil {
+				return err
+			}
+		}
+
+		for id := uint64(1); id <= removed; id++ {
+			err := b.Delete(changeKey(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	defer openTestStore(t)()
+
+	logChanges(t, 10, 3)
+
+	cases := []struct {
+		after    uint64
+		changes  int
+		complete bool
+	}{
+		{3, 7, true},
+		{8, 2, true},
+		{10, 0, true},
+		{2, 7, false},
+		{0, 7, false},
+		{11, 0, false},
+		{42, 0, false},
+	}
+
+	for _, c := range cases {
+		changes, complete, err := Changes(c.after, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(changes) != c.changes || complete != c.complete {
+			t.Errorf("after %d: expected %d changes, complete %v, got %d, %v", c.after, c.changes, c.complete, len(changes), complete)
+		}
+	}
+
+	// every change is removed once it is no longer kept
+	logChanges(t, 0, 10)
+	for after, complete := range map[uint64]bool{10: true, 9: false, 11: false} {
+		_, got, err := Changes(after, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != complete {
+			t.Errorf("empty log after %d: expected complete %v", after, complete)
+		}
+	}
+}
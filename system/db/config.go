@@ -17,6 +17,10 @@ import (
 const (
 	// DefaultMaxAge provides a 2592000 second (30-day) cache max-age setting
 	DefaultMaxAge = int64(60 * 60 * 24 * 30)
+
+	// DefaultTrashRetention provides a 30 day period before deleted content is
+	// purged from the trash
+	DefaultTrashRetention = 30
 )
 
 var mu = &sync.Mutex{}
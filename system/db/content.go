@@ -18,6 +18,7 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/gofrs/uuid"
 	"github.com/gorilla/schema"
+	"github.com/tidwall/sjson"
 )
 
 // IsValidID checks that an ID from a DB target is valid.
@@ -34,6 +35,12 @@ func IsValidID(id string) bool {
 // SetContent inserts/replaces values in the database.
 // The `target` argument is a string made up of namespace:id (string:int)
 func SetContent(target string, data url.Values) (int, error) {
+	return SetContentBy(target, "", data)
+}
+
+// SetContentBy is the same as SetContent, and records author as the author of
+// the saved revision in the content's history
+func SetContentBy(target, author string, data url.Values) (int, error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
@@ -44,15 +51,21 @@ func SetContent(target string, data url.Values) (int, error) {
 	// this is a problem when the original first post (with auto ID = 0) gets
 	// overwritten by any new post, originally having no ID, defauting to 0.
 	if id == "-1" {
-		return insert(ns, data)
+		return insert(ns, author, data)
 	}
 
-	return update(ns, id, data, nil)
+	return update(ns, id, author, data, nil)
 }
 
 // UpdateContent updates/merges values in the database.
 // The `target` argument is a string made up of namespace:id (string:int)
 func UpdateContent(target string, data url.Values) (int, error) {
+	return UpdateContentBy(target, "", data)
+}
+
+// UpdateContentBy is the same as UpdateContent, and records author as the
+// author of the saved revision in the content's history
+func UpdateContentBy(target, author string, data url.Values) (int, error) {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
 
@@ -65,13 +78,80 @@ func UpdateContent(target string, data url.Values) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return update(ns, id, data, &existingContent)
+	return update(ns, id, author, data, &existingContent)
+}
+
+// SetContentJSON inserts/replaces content in the database from json data, such
+// as the body of an application/json API request.
+// The `target` argument is a string made up of namespace:id (string:int)
+func SetContentJSON(target string, data []byte) (int, error) {
+	return SetContentJSONBy(target, "", data)
+}
+
+// SetContentJSONBy is the same as SetContentJSON, and records author as the
+// author of the saved revision in the content's history
+func SetContentJSONBy(target, author string, data []byte) (int, error) {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	// see SetContent for why -1 indicates a new post
+	if id == "-1" {
+		return insertWith(ns, author, func(ns, cid, uid, specifier string) ([]byte, string, error) {
+			return jsonToPost(ns, data, cid, uid, specifier)
+		})
+	}
+
+	return updateWith(ns, id, author, func(ns string) ([]byte, error) {
+		j, _, err := jsonToPost(ns, data, id, "", "")
+		return j, err
+	})
+}
+
+// UpdateContentJSON updates/merges json data into existing content in the
+// database. Fields missing from data are left unchanged.
+// The `target` argument is a string made up of namespace:id (string:int)
+func UpdateContentJSON(target string, data []byte) (int, error) {
+	return UpdateContentJSONBy(target, "", data)
+}
+
+// UpdateContentJSONBy is the same as UpdateContentJSON, and records author as
+// the author of the saved revision in the content's history
+func UpdateContentJSONBy(target, author string, data []byte) (int, error) {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	if !IsValidID(id) {
+		return 0, fmt.Errorf("Invalid ID in target for UpdateContentJSON: %s", target)
+	}
+
+	// retrieve existing content from the database
+	existingContent, err := Content(target)
+	if err != nil {
+		return 0, err
+	}
+
+	return updateWith(ns, id, author, func(ns string) ([]byte, error) {
+		return mergeJSON(ns, data, existingContent)
+	})
 }
 
 // update can support merge or replace behavior depending on existingContent.
 // if existingContent is non-nil, we merge field values. empty/missing fields are ignored.
 // if existingContent is nil, we replace field values. empty/missing fields are reset.
-func update(ns, id string, data url.Values, existingContent *[]byte) (int, error) {
+func update(ns, id, author string, data url.Values, existingContent *[]byte) (int, error) {
+	return updateWith(ns, id, author, func(ns string) ([]byte, error) {
+		if existingContent == nil {
+			return postToJSON(ns, data)
+		}
+
+		return mergeData(ns, data, *existingContent)
+	})
+}
+
+// updateWith stores the json returned by encode at the id within the namespace,
+// and updates the sorted content, history, caches and search index to match.
+// encode is called with the namespace stripped of any specifier.
+func updateWith(ns, id, author string, encode func(ns string) ([]byte, error)) (int, error) {
 	var specifier string // i.e. __pending, __sorted, etc.
 	if strings.Contains(ns, "__") {
 		spec := strings.Split(ns, "__")
@@ -84,50 +164,60 @@ func update(ns, id string, data url.Values, existingContent *[]byte) (int, error
 		return 0, err
 	}
 
-	var j []byte
-	if existingContent == nil {
-		j, err = postToJSON(ns, data)
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		j, err = mergeData(ns, data, *existingContent)
-		if err != nil {
-			return 0, err
-		}
+	j, err := encode(ns)
+	if err != nil {
+		return 0, err
 	}
 
+	// public and scheduled content is stored in whichever of the two buckets
+	// matches its publish and expire times
+	scheduled := specifier == "" || specifier == "__scheduled"
+
+	var public, changed bool
 	err = store.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
-		if err != nil {
-			return err
+		key := []byte(fmt.Sprintf("%d", cid))
+
+		if scheduled {
+			prev, p, c, err := schedule(tx, ns, string(key), j)
+			if err != nil {
+				return err
+			}
+			public, changed = p, c
+
+			return addRevision(tx, ns, string(key), author, prev, j)
 		}
 
-		err = b.Put([]byte(fmt.Sprintf("%d", cid)), j)
+		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
 		if err != nil {
 			return err
 		}
 
-		return nil
+		return b.Put(key, j)
 	})
 	if err != nil {
-		return 0, nil
+		return 0, err
 	}
 
-	if specifier == "" {
+	if changed {
 		go SortContent(ns)
 	}
 
-	// update changes data, so invalidate client caching
-	err = InvalidateCache()
-	if err != nil {
-		return 0, err
-	}
-
 	go func() {
-		// update data in search index
+		// update data in search index, or remove content which is no longer
+		// public from it
 		target := fmt.Sprintf("%s:%s", ns, id)
-		err = search.UpdateIndex(target, j)
+		if scheduled && !public {
+			if changed {
+				err := search.DeleteIndex(target)
+				if err != nil {
+					log.Println("[search] DeleteIndex Error:", err)
+				}
+			}
+
+			return
+		}
+
+		err := search.UpdateIndex(target, j)
 		if err != nil {
 			log.Println("[search] UpdateIndex Error:", err)
 		}
@@ -172,7 +262,124 @@ func mergeData(ns string, data url.Values, existingContent []byte) ([]byte, erro
 	return j, nil
 }
 
-func insert(ns string, data url.Values) (int, error) {
+// mergeJSON decodes json data over the existing content, so that only the fields
+// present in data are changed
+func mergeJSON(ns string, data, existingContent []byte) ([]byte, error) {
+	t, ok := item.Types[ns]
+	if !ok {
+		return nil, fmt.Errorf("Namespace type not found: %s", ns)
+	}
+
+	// Unmarsal the existing values
+	s := t()
+	err := json.Unmarshal(existingContent, &s)
+	if err != nil {
+		log.Println("Error decoding json while updating", ns, ":", err)
+		return nil, err
+	}
+
+	// Don't allow the Item fields to be updated from json values
+	for _, k := range []string{"id", "uuid", "slug"} {
+		data, err = sjson.DeleteBytes(data, k)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(s)
+}
+
+// jsonToPost decodes json data into the content type for the namespace with the
+// id and uuid provided (if non-empty), creating a slug for public content which
+// has none, and returns the json to store along with the slug
+func jsonToPost(ns string, data []byte, id, uid, specifier string) ([]byte, string, error) {
+	t, ok := item.Types[ns]
+	if !ok {
+		return nil, "", fmt.Errorf(item.ErrTypeNotRegistered.Error(), ns)
+	}
+	post := t()
+
+	cid, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, err = sjson.SetBytes(data, "id", cid)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uid != "" {
+		data, err = sjson.SetBytes(data, "uuid", uid)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	err = json.Unmarshal(data, post)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sl, ok := post.(item.Sluggable)
+	if !ok {
+		return nil, "", fmt.Errorf("Type %s does not implement item.Sluggable or embed item.Item", ns)
+	}
+
+	// if the content has no slug, and has no specifier, create a slug, check it
+	// for duplicates, and set it on the content
+	if sl.ItemSlug() == "" && specifier == "" {
+		slug, err := item.Slug(post.(item.Identifiable))
+		if err != nil {
+			return nil, "", err
+		}
+
+		slug, err = checkSlugForDuplicate(slug)
+		if err != nil {
+			return nil, "", err
+		}
+
+		sl.SetSlug(slug)
+	}
+
+	j, err := json.Marshal(post)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return j, sl.ItemSlug(), nil
+}
+
+func insert(ns, author string, data url.Values) (int, error) {
+	return insertWith(ns, author, func(ns, cid, uid, specifier string) ([]byte, string, error) {
+		data.Set("id", cid)
+
+		// add UUID to data for use in embedded Item
+		data.Set("uuid", uid)
+
+		// if type has a specifier, add it to data for downstream processing
+		if specifier != "" {
+			data.Set("__specifier", specifier)
+		}
+
+		j, err := postToJSON(ns, data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return j, data.Get("slug"), nil
+	})
+}
+
+// insertWith stores the json returned by encode at the next available id within
+// the namespace. encode is called with the namespace stripped of any specifier,
+// the new id and uuid, and must return the json to store and the content's slug.
+func insertWith(ns, author string, encode func(ns, cid, uid, specifier string) ([]byte, string, error)) (int, error) {
 	var effectedID int
 	var specifier string // i.e. __pending, __sorted, etc.
 	if strings.Contains(ns, "__") {
@@ -181,17 +388,34 @@ func insert(ns string, data url.Values) (int, error) {
 		specifier = "__" + spec[1]
 	}
 
+	// new public content may be scheduled, but takes its id from the public
+	// bucket so it keeps it when published
+	if specifier == "__scheduled" {
+		specifier = ""
+	}
+
 	var j []byte
-	var cid string
+	var cid, slug string
+	var public bool
 	err := store.Update(func(tx *bolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(ns + specifier))
 		if err != nil {
 			return err
 		}
 
+		// drafts take their id from the public bucket too, so they keep it
+		// when published
+		seq := b
+		if specifier == "__draft" {
+			seq, err = tx.CreateBucketIfNotExists([]byte(ns))
+			if err != nil {
+				return err
+			}
+		}
+
 		// get the next available ID and convert to string
 		// also set effectedID to int of ID
-		id, err := b.NextSequence()
+		id, err := seq.NextSequence()
 		if err != nil {
 			return err
 		}
@@ -200,7 +424,6 @@ func insert(ns string, data url.Values) (int, error) {
 		if err != nil {
 			return err
 		}
-		data.Set("id", cid)
 
 		// add UUID to data for use in embedded Item
 		uid, err := uuid.NewV4()
@@ -208,56 +431,50 @@ func insert(ns string, data url.Values) (int, error) {
 			return err
 		}
 
-		data.Set("uuid", uid.String())
+		j, slug, err = encode(ns, cid, uid.String(), specifier)
+		if err != nil {
+			return err
+		}
 
-		// if type has a specifier, add it to data for downstream processing
 		if specifier != "" {
-			data.Set("__specifier", specifier)
+			return b.Put([]byte(cid), j)
 		}
 
-		j, err = postToJSON(ns, data)
+		_, public, _, err = schedule(tx, ns, cid, j)
 		if err != nil {
 			return err
 		}
 
-		err = b.Put([]byte(cid), j)
+		err = addRevision(tx, ns, cid, author, nil, j)
 		if err != nil {
 			return err
 		}
 
-		// store the slug,type:id in contentIndex if public content
-		if specifier == "" {
-			ci := tx.Bucket([]byte("__contentIndex"))
-			if ci == nil {
-				return bolt.ErrBucketNotFound
-			}
-
-			k := []byte(data.Get("slug"))
-			v := []byte(fmt.Sprintf("%s:%d", ns, effectedID))
-			err := ci.Put(k, v)
-			if err != nil {
-				return err
-			}
+		// store the slug,type:id in contentIndex for public content, and for
+		// scheduled content so its slug is kept until it is published
+		ci := tx.Bucket([]byte("__contentIndex"))
+		if ci == nil {
+			return bolt.ErrBucketNotFound
 		}
 
-		return nil
+		k := []byte(slug)
+		v := []byte(fmt.Sprintf("%s:%d", ns, effectedID))
+		return ci.Put(k, v)
 	})
 	if err != nil {
 		return 0, err
 	}
 
-	if specifier == "" {
+	if public {
 		go SortContent(ns)
 	}
 
-	// insert changes data, so invalidate client caching
-	err = InvalidateCache()
-	if err != nil {
-		return 0, err
-	}
-
 	go func() {
-		// add data to search index
+		// add data to search index, unless it is scheduled
+		if specifier == "" && !public {
+			return
+		}
+
 		target := fmt.Sprintf("%s:%s", ns, cid)
 		err = search.UpdateIndex(target, j)
 		if err != nil {
@@ -269,7 +486,9 @@ func insert(ns string, data url.Values) (int, error) {
 }
 
 // DeleteContent removes an item from the database. Deleting a non-existent item
-// will return a nil error.
+// will return a nil error. Public and scheduled content is moved to the __trash
+// bucket of its type, from where it can be restored with RestoreContent, and
+// deleting content from __trash removes it permanently.
 func DeleteContent(target string) error {
 	t := strings.Split(target, ":")
 	ns, id := t[0], t[1]
@@ -288,19 +507,59 @@ func DeleteContent(target string) error {
 		return err
 	}
 
+	public := !strings.Contains(ns, "__")
+	scheduled := strings.HasSuffix(ns, "__scheduled")
+	pt := strings.TrimSuffix(ns, "__scheduled")
+
 	err = store.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(ns))
 		if b == nil {
 			return bolt.ErrBucketNotFound
 		}
 
+		if public || scheduled {
+			prev := append([]byte(nil), b.Get([]byte(id))...)
+			if public {
+				err := setFieldIndex(tx, ns, id, prev, nil)
+				if err != nil {
+					return err
+				}
+
+				err = setModified(tx, ns)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := clearSchedule(tx, pt, id)
+			if err != nil {
+				return err
+			}
+
+			// keep deleted content in the trash until it's restored or purged
+			if len(prev) > 0 {
+				err = moveToTrash(tx, pt, id, prev)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if strings.HasSuffix(ns, "__trash") {
+			err := purgeTrashed(tx, strings.TrimSuffix(ns, "__trash"), id)
+			if err != nil {
+				return err
+			}
+		}
+
 		err := b.Delete([]byte(id))
 		if err != nil {
 			return err
 		}
 
-		// if content has a slug, also delete it from __contentIndex
-		if itm.Slug != "" {
+		// if public or scheduled content has a slug, also delete it from
+		// __contentIndex
+		if itm.Slug != "" && (public || scheduled) {
 			ci := tx.Bucket([]byte("__contentIndex"))
 			if ci == nil {
 				return bolt.ErrBucketNotFound
@@ -318,12 +577,6 @@ func DeleteContent(target string) error {
 		return err
 	}
 
-	// delete changes data, so invalidate client caching
-	err = InvalidateCache()
-	if err != nil {
-		return err
-	}
-
 	go func() {
 		// delete indexed data from search index
 		if !strings.Contains(ns, "__") {
@@ -454,21 +707,46 @@ func ContentAll(namespace string) [][]byte {
 	return posts
 }
 
-// QueryOptions holds options for a query
+// QueryOptions holds options for a query. After and Before are keys from a
+// namespace's __sorted bucket, as returned in a QueryPage, and are used in place
+// of Offset to return the content following or preceding the key in the order
+// requested. Cursors are not used when Filters or Sort are set.
 type QueryOptions struct {
-	Count  int
-	Offset int
-	Order  string
+	Count   int
+	Offset  int
+	Order   string
+	Filters []Filter
+	Sort    []SortBy
+	After   string
+	Before  string
+}
+
+// QueryPage holds a page of content returned by QueryCursor, the total number
+// of content in the namespace (or matching the query filters), and the keys of
+// the first and last content in the page to be used as cursors in QueryOptions
+type QueryPage struct {
+	Total   int
+	Content [][]byte
+	First   string
+	Last    string
+	HasPrev bool
+	HasNext bool
 }
 
 // Query retrieves a set of content from the db based on options
 // and returns the total number of content in the namespace and the content
 func Query(namespace string, opts QueryOptions) (int, [][]byte) {
-	var posts [][]byte
-	var total int
+	page := QueryCursor(namespace, opts)
+	return page.Total, page.Content
+}
+
+// QueryCursor retrieves a page of content from the db based on options, along
+// with the information needed to request the adjacent pages
+func QueryCursor(namespace string, opts QueryOptions) QueryPage {
+	var page QueryPage
 
 	// correct bad input rather than return nil or error
-	// similar to default case for opts.Order switch below
+	// similar to the default DESC order for anything but opts.Order "asc"
 	if opts.Count < 0 {
 		opts.Count = -1
 	}
@@ -477,6 +755,12 @@ func Query(namespace string, opts QueryOptions) (int, [][]byte) {
 		opts.Offset = 0
 	}
 
+	if len(opts.Filters) > 0 || len(opts.Sort) > 0 {
+		return queryFiltered(namespace, opts)
+	}
+
+	ascending := opts.Order == "asc"
+
 	store.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(namespace))
 		if b == nil {
@@ -485,13 +769,59 @@ func Query(namespace string, opts QueryOptions) (int, [][]byte) {
 
 		c := b.Cursor()
 		n := b.Stats().KeyN
-		total = n
+		page.Total = n
 
 		// return nil if no content
 		if n == 0 {
 			return nil
 		}
 
+		add := func(k, v []byte) {
+			if page.First == "" {
+				page.First = string(k)
+			}
+			page.Last = string(k)
+			page.Content = append(page.Content, v)
+		}
+
+		if opts.After != "" || opts.Before != "" {
+			// walk away from the cursor key, in the query order for After,
+			// or against it for Before
+			cursor, forward := opts.After, ascending
+			if cursor == "" {
+				cursor, forward = opts.Before, !ascending
+			}
+
+			var keys, values [][]byte
+			k, v := seekPast(c, []byte(cursor), forward)
+			for ; k != nil; k, v = step(c, forward) {
+				if opts.Count != -1 && len(keys) >= opts.Count {
+					break
+				}
+
+				keys = append(keys, k)
+				values = append(values, v)
+			}
+
+			if opts.After != "" {
+				page.HasPrev = true
+				page.HasNext = k != nil
+				for i := range keys {
+					add(keys[i], values[i])
+				}
+
+				return nil
+			}
+
+			page.HasNext = true
+			page.HasPrev = k != nil
+			for i := len(keys) - 1; i >= 0; i-- {
+				add(keys[i], values[i])
+			}
+
+			return nil
+		}
+
 		var start, end int
 		switch opts.Count {
 		case -1:
@@ -511,63 +841,62 @@ func Query(namespace string, opts QueryOptions) (int, [][]byte) {
 			end = n
 		}
 
-		i := 0   // count of num posts added
-		cur := 0 // count of num cursor moves
-		switch opts.Order {
-		case "desc", "":
-			for k, v := c.Last(); k != nil; k, v = c.Prev() {
-				if cur < start {
-					cur++
-					continue
-				}
+		page.HasPrev = start > 0
+		page.HasNext = end < n
 
-				if cur >= end {
-					break
-				}
+		cur := 0 // count of num cursor moves
+		var k, v []byte
+		if ascending {
+			k, v = c.First()
+		} else {
+			k, v = c.Last()
+		}
 
-				posts = append(posts, v)
-				i++
+		for ; k != nil; k, v = step(c, ascending) {
+			if cur < start {
 				cur++
+				continue
 			}
 
-		case "asc":
-			for k, v := c.First(); k != nil; k, v = c.Next() {
-				if cur < start {
-					cur++
-					continue
-				}
-
-				if cur >= end {
-					break
-				}
-
-				posts = append(posts, v)
-				i++
-				cur++
+			if cur >= end {
+				break
 			}
 
-		default:
-			// results for DESC order
-			for k, v := c.Last(); k != nil; k, v = c.Prev() {
-				if cur < start {
-					cur++
-					continue
-				}
-
-				if cur >= end {
-					break
-				}
-
-				posts = append(posts, v)
-				i++
-				cur++
-			}
+			add(k, v)
+			cur++
 		}
 
 		return nil
 	})
 
-	return total, posts
+	return page
+}
+
+// seekPast positions the cursor at the first key beyond key, moving towards
+// larger keys if forward is true, or smaller keys if not
+func seekPast(c *bolt.Cursor, key []byte, forward bool) ([]byte, []byte) {
+	k, v := c.Seek(key)
+	if forward {
+		if k != nil && bytes.Equal(k, key) {
+			return c.Next()
+		}
+
+		return k, v
+	}
+
+	if k == nil {
+		return c.Last()
+	}
+
+	return c.Prev()
+}
+
+func step(c *bolt.Cursor, forward bool) ([]byte, []byte) {
+	if forward {
+		return c.Next()
+	}
+
+	return c.Prev()
 }
 
 var sortContentCalls = make(map[string]time.Time)
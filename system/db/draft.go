@@ -0,0 +1,157 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/search"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// PublishDraft replaces the content at target with its draft, and removes the
+// draft. A draft of new content is given a slug when it is published. Content
+// with a publish time in the future, or which has expired, is published to the
+// __scheduled bucket of its type.
+// The `target` argument is a string made up of namespace:id (string:int)
+func PublishDraft(target, author string) error {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	it, ok := item.Types[ns]
+	if !ok {
+		return fmt.Errorf(item.ErrTypeNotRegistered.Error(), ns)
+	}
+
+	draft, err := Content(ns + "__draft:" + id)
+	if err != nil {
+		return err
+	}
+
+	if len(draft) == 0 {
+		return fmt.Errorf("Draft not found for %s", target)
+	}
+
+	// keep the uuid and slug of content which has already been published,
+	// otherwise create a slug as for new content
+	var slug string
+	current, err := Content(target)
+	if err != nil {
+		return err
+	}
+	if len(current) == 0 {
+		current, err = Content(ns + "__scheduled:" + id)
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+
+	var isNew bool
+	if len(current) > 0 {
+		slug = gjson.GetBytes(current, "slug").String()
+
+		if uid := gjson.GetBytes(current, "uuid"); uid.Exists() {
+			draft, err = sjson.SetRawBytes(draft, "uuid", []byte(uid.Raw))
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		isNew = true
+
+		post := it()
+		err = json.Unmarshal(draft, post)
+		if err != nil {
+			return err
+		}
+
+		slug, err = item.Slug(post.(item.Identifiable))
+		if err != nil {
+			return err
+		}
+
+		slug, err = checkSlugForDuplicate(slug)
+		if err != nil {
+			return err
+		}
+	}
+
+	j, err := sjson.SetBytes(draft, "slug", slug)
+	if err != nil {
+		return err
+	}
+
+	ts := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+	j, err = sjson.SetBytes(j, "updated", ts)
+	if err != nil {
+		return err
+	}
+
+	var public, changed bool
+	err = store.Update(func(tx *bolt.Tx) error {
+		prev, p, c, err := schedule(tx, ns, id, j)
+		if err != nil {
+			return err
+		}
+		public, changed = p, c
+
+		err = addRevision(tx, ns, id, author, prev, j)
+		if err != nil {
+			return err
+		}
+
+		if isNew && slug != "" {
+			ci := tx.Bucket([]byte("__contentIndex"))
+			if ci == nil {
+				return bolt.ErrBucketNotFound
+			}
+
+			err = ci.Put([]byte(slug), []byte(target))
+			if err != nil {
+				return err
+			}
+		}
+
+		d := tx.Bucket([]byte(ns + "__draft"))
+		if d == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return d.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		go SortContent(ns)
+	}
+
+	go func() {
+		// update data in search index, or remove content which is no longer
+		// public from it
+		if !public {
+			if changed {
+				err := search.DeleteIndex(target)
+				if err != nil {
+					log.Println("[search] DeleteIndex Error:", err)
+				}
+			}
+
+			return
+		}
+
+		err := search.UpdateIndex(target, j)
+		if err != nil {
+			log.Println("[search] UpdateIndex Error:", err)
+		}
+	}()
+
+	return nil
+}
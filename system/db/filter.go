@@ -0,0 +1,463 @@
+package db
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ponzu-cms/ponzu/system/item"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+)
+
+// Operators which can be used in a Filter
+const (
+	FilterEq  = "eq"
+	FilterNe  = "ne"
+	FilterGt  = "gt"
+	FilterGte = "gte"
+	FilterLt  = "lt"
+	FilterLte = "lte"
+	FilterIn  = "in"
+)
+
+// Filter restricts the results of a Query to content where the value of Field
+// (a json tag name, or a dot-separated path for nested values) compares to any
+// of the Values using the Op operator
+type Filter struct {
+	Field  string
+	Op     string
+	Values []string
+}
+
+// SortBy orders the results of a Query by the value of Field (a json tag name)
+type SortBy struct {
+	Field string
+	Desc  bool
+}
+
+// IsFilterOp checks if op is one of the operators supported by Filter
+func IsFilterOp(op string) bool {
+	switch op {
+	case FilterEq, FilterNe, FilterGt, FilterGte, FilterLt, FilterLte, FilterIn:
+		return true
+	}
+
+	return false
+}
+
+// queryFiltered is used by QueryCursor when opts contains any Filters or Sort
+// fields. All content in the namespace is matched against the filters (or only
+// the candidates found in a field index), then sorted and paginated in memory
+func queryFiltered(namespace string, opts QueryOptions) QueryPage {
+	var posts [][]byte
+
+	ns := strings.TrimSuffix(namespace, "__sorted")
+	store.View(func(tx *bolt.Tx) error {
+		var ids [][]byte
+		var indexed bool
+		if ns != namespace {
+			ids, indexed = fieldIndexLookup(tx, ns, opts.Filters)
+		}
+
+		if indexed {
+			b := tx.Bucket([]byte(ns))
+			if b == nil {
+				return bolt.ErrBucketNotFound
+			}
+
+			for i := range ids {
+				v := b.Get(ids[i])
+				if v == nil {
+					continue
+				}
+
+				posts = append(posts, append([]byte(nil), v...))
+			}
+
+			return nil
+		}
+
+		b := tx.Bucket([]byte(namespace))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			posts = append(posts, append([]byte(nil), v...))
+			return nil
+		})
+	})
+
+	var matched [][]byte
+	for i := range posts {
+		if matchFilters(posts[i], opts.Filters) {
+			matched = append(matched, posts[i])
+		}
+	}
+
+	// content is ordered by timestamp after any requested sort fields
+	sorts := append(append([]SortBy{}, opts.Sort...), SortBy{Field: "timestamp", Desc: opts.Order != "asc"})
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, s := range sorts {
+			a := gjson.GetBytes(matched[i], s.Field)
+			b := gjson.GetBytes(matched[j], s.Field)
+
+			c := compareResults(a, b)
+			if c == 0 {
+				continue
+			}
+
+			if s.Desc {
+				return c > 0
+			}
+
+			return c < 0
+		}
+
+		return false
+	})
+
+	total := len(matched)
+
+	start, end := 0, total
+	if opts.Count != -1 {
+		start = opts.Count * opts.Offset
+		end = start + opts.Count
+	}
+
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	return QueryPage{
+		Total:   total,
+		Content: matched[start:end],
+		HasPrev: start > 0,
+		HasNext: end < total,
+	}
+}
+
+// matchFilters reports whether the json data satisfies all of the filters
+func matchFilters(data []byte, filters []Filter) bool {
+	for _, f := range filters {
+		if !matchFilter(data, f) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchFilter(data []byte, f Filter) bool {
+	res := gjson.GetBytes(data, f.Field)
+	if !res.Exists() {
+		return f.Op == FilterNe
+	}
+
+	// array fields match if any of their elements match
+	vals := []gjson.Result{res}
+	if res.Type == gjson.JSON && strings.HasPrefix(res.Raw, "[") {
+		vals = res.Array()
+	}
+
+	if f.Op == FilterNe {
+		for _, v := range vals {
+			for _, fv := range f.Values {
+				if compareValue(v, fv) == 0 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	for _, v := range vals {
+		for _, fv := range f.Values {
+			c := compareValue(v, fv)
+
+			switch f.Op {
+			case FilterEq, FilterIn, "":
+				if c == 0 {
+					return true
+				}
+			case FilterGt:
+				if c > 0 {
+					return true
+				}
+			case FilterGte:
+				if c >= 0 {
+					return true
+				}
+			case FilterLt:
+				if c < 0 {
+					return true
+				}
+			case FilterLte:
+				if c <= 0 {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// compareValue compares a json value with a value from a Filter, numerically
+// if both are numbers, otherwise as strings
+func compareValue(v gjson.Result, s string) int {
+	if v.Type == gjson.Number {
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return compareFloat(v.Num, n)
+		}
+	}
+
+	return strings.Compare(v.String(), s)
+}
+
+// compareResults compares two json values, numerically if both are numbers,
+// otherwise as strings. Missing values sort before any other value.
+func compareResults(a, b gjson.Result) int {
+	switch {
+	case !a.Exists() && !b.Exists():
+		return 0
+	case !a.Exists():
+		return -1
+	case !b.Exists():
+		return 1
+	}
+
+	if a.Type == gjson.Number && b.Type == gjson.Number {
+		return compareFloat(a.Num, b.Num)
+	}
+
+	return strings.Compare(a.String(), b.String())
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// indexFields returns the fields a type has declared to be indexed by
+// implementing item.Indexable
+func indexFields(ns string) []string {
+	t, ok := item.Types[ns]
+	if !ok {
+		return nil
+	}
+
+	idx, ok := t().(item.Indexable)
+	if !ok {
+		return nil
+	}
+
+	return idx.IndexFields()
+}
+
+// fieldIndexValues returns the values for a field in json data which should
+// be stored in a field index. Array fields contribute one value per element.
+func fieldIndexValues(data []byte, field string) []string {
+	if data == nil {
+		return nil
+	}
+
+	res := gjson.GetBytes(data, field)
+	if !res.Exists() {
+		return nil
+	}
+
+	vals := []gjson.Result{res}
+	if res.Type == gjson.JSON && strings.HasPrefix(res.Raw, "[") {
+		vals = res.Array()
+	}
+
+	var values []string
+	for _, v := range vals {
+		if v.Type == gjson.JSON {
+			continue
+		}
+
+		values = append(values, fieldIndexValue(v))
+	}
+
+	return values
+}
+
+// fieldIndexValue returns the string a json value is kept under in a field
+// index. Numbers are formatted the same way whatever their json form, since
+// filters compare them numerically, so 10, 10.0 and 1e1 are all kept as "10".
+func fieldIndexValue(v gjson.Result) string {
+	if v.Type == gjson.Number {
+		return formatNumber(v.Num)
+	}
+
+	return v.String()
+}
+
+func formatNumber(n float64) string {
+	if n == 0 {
+		n = 0 // -0 compares equal to 0
+	}
+
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+// fieldIndexKeys returns the values to look up in a field index for the values
+// of an equality filter: each value as it is, and as a number if it parses as
+// one. If a value can't be looked up, such as NaN, which compares equal to any
+// number, the returned bool is false.
+func fieldIndexKeys(values []string) ([]string, bool) {
+	var keys []string
+	for _, v := range values {
+		keys = append(keys, v)
+
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			continue
+		}
+
+		if math.IsNaN(n) {
+			return nil, false
+		}
+
+		if num := formatNumber(n); num != v {
+			keys = append(keys, num)
+		}
+	}
+
+	return keys, true
+}
+
+func fieldIndexKey(value, id string) []byte {
+	return []byte(value + "\x00" + id)
+}
+
+func fieldIndexBucket(ns string) []byte {
+	return []byte(ns + "__fieldIndex")
+}
+
+// setFieldIndex replaces the field index entries for content at id within ns,
+// removing those from the prev json data and adding those from next. Either
+// may be nil, as in the case of an insert or delete.
+func setFieldIndex(tx *bolt.Tx, ns, id string, prev, next []byte) error {
+	fields := indexFields(ns)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists(fieldIndexBucket(ns))
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fb, err := b.CreateBucketIfNotExists([]byte(field))
+		if err != nil {
+			return err
+		}
+
+		for _, v := range fieldIndexValues(prev, field) {
+			err = fb.Delete(fieldIndexKey(v, id))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, v := range fieldIndexValues(next, field) {
+			err = fb.Put(fieldIndexKey(v, id), []byte{})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldIndexLookup finds the IDs of content in ns matching the first equality
+// filter on an indexed field. If no filter can be answered by an index, the
+// returned bool is false. The IDs may include content which doesn't match, so
+// the content is still matched against the filters.
+func fieldIndexLookup(tx *bolt.Tx, ns string, filters []Filter) ([][]byte, bool) {
+	b := tx.Bucket(fieldIndexBucket(ns))
+	if b == nil {
+		return nil, false
+	}
+
+	for _, f := range filters {
+		if f.Op != FilterEq && f.Op != FilterIn {
+			continue
+		}
+
+		fb := b.Bucket([]byte(f.Field))
+		if fb == nil {
+			continue
+		}
+
+		keys, ok := fieldIndexKeys(f.Values)
+		if !ok {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var ids [][]byte
+		for _, v := range keys {
+			prefix := fieldIndexKey(v, "")
+			c := fb.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+				id := string(k[len(prefix):])
+				if seen[id] {
+					continue
+				}
+
+				seen[id] = true
+				ids = append(ids, []byte(id))
+			}
+		}
+
+		return ids, true
+	}
+
+	return nil, false
+}
+
+// ReindexFields rebuilds the field index for a content type from all of its
+// stored content. Types which don't implement item.Indexable are skipped.
+func ReindexFields(namespace string) error {
+	fields := indexFields(namespace)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(fieldIndexBucket(namespace))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b := tx.Bucket([]byte(namespace))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			return setFieldIndex(tx, namespace, string(k), nil, v)
+		})
+	})
+}
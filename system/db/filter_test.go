@@ -0,0 +1,241 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ponzu-cms/ponzu/system/item"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+)
+
+type filterProduct struct {
+	item.Item
+}
+
+func (p *filterProduct) IndexFields() []string {
+	return []string{"name", "price", "featured", "tags"}
+}
+
+var filterProducts = map[string]string{
+	"1": `{"id":1,"timestamp":100,"name":"apple","price":10,"featured":true,"tags":["fruit","red"]}`,
+	"2": `{"id":2,"timestamp":200,"name":"banana","price":2.5,"featured":false,"tags":["fruit"]}`,
+	"3": `{"id":3,"timestamp":300,"name":"carrot","price":10.0,"featured":false,"tags":["vegetable"]}`,
+	"4": `{"id":4,"timestamp":400,"name":"date","price":1e1,"featured":true}`,
+	"5": `{"id":5,"timestamp":500,"name":"eggplant","featured":true,"tags":["vegetable","purple"]}`,
+}
+
+// openTestStore opens an empty database in a temporary directory, and returns a
+// func to close and remove it
+func openTestStore(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "ponzu-db-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = bolt.Open(filepath.Join(dir, "system.db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		store.Close()
+		store = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// setupFilterStore opens a database holding filterProducts in an indexed type,
+// in both its bucket and its sorted bucket as SortContent would
+func setupFilterStore(t *testing.T) func() {
+	closeStore := openTestStore(t)
+	item.Types["FilterProduct"] = func() interface{} { return new(filterProduct) }
+
+	err := store.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"FilterProduct", "FilterProduct__sorted"} {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+
+			for id, j := range filterProducts {
+				err = b.Put([]byte(id), []byte(j))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for id, j := range filterProducts {
+			err := setFieldIndex(tx, "FilterProduct", id, nil, []byte(j))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return func() {
+		delete(item.Types, "FilterProduct")
+		closeStore()
+	}
+}
+
+// ids returns the ids of the content in a page, in order
+func ids(page QueryPage) string {
+	var ids []string
+	for _, j := range page.Content {
+		ids = append(ids, gjson.GetBytes(j, "id").String())
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestQueryFilters(t *testing.T) {
+	defer setupFilterStore(t)()
+
+	cases := []struct {
+		filters  []Filter
+		expected string
+	}{
+		{[]Filter{{"name", FilterEq, []string{"apple"}}}, "1"},
+		{[]Filter{{"name", FilterNe, []string{"apple"}}}, "5,4,3,2"},
+		{[]Filter{{"name", FilterGt, []string{"banana"}}}, "5,4,3"},
+		{[]Filter{{"name", FilterGte, []string{"banana"}}}, "5,4,3,2"},
+		{[]Filter{{"name", FilterLt, []string{"carrot"}}}, "2,1"},
+		{[]Filter{{"name", FilterLte, []string{"carrot"}}}, "3,2,1"},
+		{[]Filter{{"name", FilterIn, []string{"apple", "date"}}}, "4,1"},
+		{[]Filter{{"name", FilterEq, []string{"fig"}}}, ""},
+
+		{[]Filter{{"price", FilterEq, []string{"10"}}}, "4,3,1"},
+		{[]Filter{{"price", FilterEq, []string{"10.0"}}}, "4,3,1"},
+		{[]Filter{{"price", FilterEq, []string{"1e1"}}}, "4,3,1"},
+		{[]Filter{{"price", FilterNe, []string{"10"}}}, "5,2"},
+		{[]Filter{{"price", FilterGt, []string{"2.5"}}}, "4,3,1"},
+		{[]Filter{{"price", FilterGte, []string{"2.5"}}}, "4,3,2,1"},
+		{[]Filter{{"price", FilterLt, []string{"10"}}}, "2"},
+		{[]Filter{{"price", FilterLte, []string{"10"}}}, "4,3,2,1"},
+		{[]Filter{{"price", FilterIn, []string{"2.50", "10"}}}, "4,3,2,1"},
+		{[]Filter{{"price", FilterEq, []string{"NaN"}}}, "4,3,2,1"},
+
+		{[]Filter{{"featured", FilterEq, []string{"true"}}}, "5,4,1"},
+		{[]Filter{{"featured", FilterEq, []string{"false"}}}, "3,2"},
+		{[]Filter{{"featured", FilterNe, []string{"true"}}}, "3,2"},
+
+		{[]Filter{{"tags", FilterEq, []string{"fruit"}}}, "2,1"},
+		{[]Filter{{"tags", FilterIn, []string{"red", "purple"}}}, "5,1"},
+		{[]Filter{{"tags", FilterNe, []string{"fruit"}}}, "5,4,3"},
+
+		{[]Filter{{"tags", FilterEq, []string{"vegetable"}}, {"featured", FilterEq, []string{"true"}}}, "5"},
+		{[]Filter{{"featured", FilterEq, []string{"true"}}, {"price", FilterLt, []string{"100"}}}, "4,1"},
+	}
+
+	for _, c := range cases {
+		opts := QueryOptions{Count: -1, Filters: c.filters}
+
+		// the sorted bucket is answered from the field index, and the type's
+		// bucket by matching every item, which must give the same results
+		indexed := ids(queryFiltered("FilterProduct__sorted", opts))
+		scanned := ids(queryFiltered("FilterProduct", opts))
+
+		if scanned != c.expected {
+			t.Errorf("%v: expected %q, scan returned %q", c.filters, c.expected, scanned)
+		}
+
+		if indexed != c.expected {
+			t.Errorf("%v: expected %q, index returned %q", c.filters, c.expected, indexed)
+		}
+	}
+}
+
+func TestFieldIndexLookup(t *testing.T) {
+	defer setupFilterStore(t)()
+
+	cases := []struct {
+		filters []Filter
+		indexed bool
+	}{
+		{[]Filter{{"name", FilterEq, []string{"apple"}}}, true},
+		{[]Filter{{"price", FilterIn, []string{"10.0"}}}, true},
+		{[]Filter{{"price", FilterGt, []string{"10"}}}, false},
+		{[]Filter{{"price", FilterEq, []string{"NaN"}}}, false},
+		{[]Filter{{"timestamp", FilterEq, []string{"100"}}}, false},
+	}
+
+	for _, c := range cases {
+		store.View(func(tx *bolt.Tx) error {
+			_, indexed := fieldIndexLookup(tx, "FilterProduct", c.filters)
+			if indexed != c.indexed {
+				t.Errorf("%v: expected indexed %v", c.filters, c.indexed)
+			}
+
+			return nil
+		})
+	}
+}
+
+func TestQuerySort(t *testing.T) {
+	defer setupFilterStore(t)()
+
+	// apple and date are both featured and cost 10, so they are ordered by
+	// their timestamps, and eggplant has no price so it sorts before any price
+	cases := []struct {
+		sort     []SortBy
+		order    string
+		expected string
+	}{
+		{[]SortBy{{Field: "name"}}, "", "1,2,3,4,5"},
+		{[]SortBy{{Field: "name", Desc: true}}, "", "5,4,3,2,1"},
+		{[]SortBy{{Field: "price"}}, "", "5,2,4,3,1"},
+		{[]SortBy{{Field: "price"}}, "asc", "5,2,1,3,4"},
+		{[]SortBy{{Field: "featured"}, {Field: "price", Desc: true}}, "", "3,2,4,1,5"},
+		{[]SortBy{{Field: "featured"}, {Field: "price", Desc: true}}, "asc", "3,2,1,4,5"},
+	}
+
+	for _, c := range cases {
+		page := queryFiltered("FilterProduct", QueryOptions{Count: -1, Order: c.order, Sort: c.sort})
+		if got := ids(page); got != c.expected {
+			t.Errorf("%v %s: expected %q, got %q", c.sort, c.order, c.expected, got)
+		}
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	defer setupFilterStore(t)()
+
+	cases := []struct {
+		count, offset    int
+		expected         string
+		hasPrev, hasNext bool
+	}{
+		{2, 0, "1,2", false, true},
+		{2, 1, "3,4", true, true},
+		{2, 2, "5", true, false},
+		{2, 3, "", true, false},
+		{5, 0, "1,2,3,4,5", false, false},
+		{-1, 0, "1,2,3,4,5", false, false},
+	}
+
+	for _, c := range cases {
+		page := QueryCursor("FilterProduct", QueryOptions{
+			Count:  c.count,
+			Offset: c.offset,
+			Sort:   []SortBy{{Field: "name"}},
+		})
+
+		got := []interface{}{ids(page), page.HasPrev, page.HasNext, page.Total}
+		expected := []interface{}{c.expected, c.hasPrev, c.hasNext, 5}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("count %d, offset %d: expected %v, got %v", c.count, c.offset, expected, got)
+		}
+	}
+}
@@ -22,7 +22,12 @@ var (
 	buckets = []string{
 		"__config", "__users",
 		"__addons", "__uploads",
-		"__contentIndex",
+		"__contentIndex", "__modified",
+		"__schedule", "__apikeys",
+		"__lockouts", "__audit",
+		"__sessions", "__webhooks",
+		"__webhook_deliveries", "__changes",
+		"__backups",
 	}
 
 	bucketsToAdd []string
@@ -101,6 +106,12 @@ func Init() {
 	if err != nil {
 		log.Fatalln("Failed to invalidate cache.", err)
 	}
+
+	go purgeTrash()
+	go purgeLockouts()
+	go publishScheduled()
+	go deliverWebhooks()
+	go scheduleBackups()
 }
 
 // AddBucket adds a bucket to be created if it doesn't already exist
@@ -121,6 +132,11 @@ func InitSearchIndex() {
 			return
 		}
 		SortContent(t)
+
+		err = ReindexFields(t)
+		if err != nil {
+			log.Println("Error rebuilding field index for", t, err)
+		}
 	}
 }
 
@@ -0,0 +1,388 @@
+package db
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Login throttling policy. After each failed attempt, the IP address and account
+// must wait before trying again, for a delay which doubles with each failure up
+// to MaxBackoff. After LockoutThreshold failures (LockoutThresholdIP for an IP
+// address) they are locked out for LockoutDuration, which also doubles with each
+// further failure, up to MaxLockoutDuration. Failures are forgotten after LockoutWindow without
+// another, and an account's failures when a login to it succeeds.
+//
+// Each attempt is counted from when it starts, so attempts made at once are
+// limited as if they were made one after another. One which hasn't ended after
+// MaxPendingAttempt is no longer counted.
+const (
+	MaxBackoff         = time.Minute
+	LockoutThreshold   = 5
+	LockoutThresholdIP = 20
+	LockoutDuration    = time.Minute * 15
+	MaxLockoutDuration = time.Hour * 24
+	LockoutWindow      = time.Hour * 24
+	MaxPendingAttempt  = time.Minute
+)
+
+// Lockout is the record of recent failed attempts to log in, or recover an
+// account, from an IP address or for an account
+type Lockout struct {
+	Key      string `json:"key"`
+	Failures int    `json:"failures"`
+	Last     int64  `json:"last"`  // milliseconds since Unix epoch
+	Until    int64  `json:"until"` // milliseconds since Unix epoch
+	Pending  int    `json:"pending"`
+	Started  int64  `json:"started"` // milliseconds since Unix epoch
+}
+
+// LockoutIP returns the lockout key for an IP address
+func LockoutIP(ip string) string {
+	return "ip:" + ip
+}
+
+// LockoutAccount returns the lockout key for an account's email address
+func LockoutAccount(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+// expired reports whether the failures are no longer recent enough to count,
+// by which time any lockout after them has also ended
+func (l Lockout) expired(now time.Time) bool {
+	return millis(now)-l.Last > int64(LockoutWindow/time.Millisecond)
+}
+
+// IsLocked reports whether the lockout prevents another attempt now, in which
+// case it is locked out for more than the backoff delay after a failure
+func (l Lockout) IsLocked() bool {
+	return l.Failures >= l.threshold() && l.Until > millis(time.Now())
+}
+
+func (l Lockout) threshold() int {
+	if strings.HasPrefix(l.Key, "ip:") {
+		return LockoutThresholdIP
+	}
+
+	return LockoutThreshold
+}
+
+// wait returns how long after the last failure another attempt is blocked
+func (l Lockout) wait() time.Duration {
+	threshold := l.threshold()
+	if l.Failures < threshold {
+		d := time.Second * time.Duration(math.Pow(2, float64(l.Failures-1)))
+		if d > MaxBackoff {
+			d = MaxBackoff
+		}
+
+		return d
+	}
+
+	d := LockoutDuration * time.Duration(math.Pow(2, float64(l.Failures-threshold)))
+	if d > MaxLockoutDuration || d <= 0 {
+		d = MaxLockoutDuration
+	}
+
+	return d
+}
+
+// blocked returns how long before another attempt can start, which is after the
+// delay following the last failure and, while attempts are pending, after the
+// delay which would follow them if they all failed
+func (l Lockout) blocked(now time.Time) time.Duration {
+	wait := l.Until - millis(now)
+	if l.Pending > 0 {
+		next := l
+		next.Failures += l.Pending
+		if until := l.Started + int64(next.wait()/time.Millisecond); until-millis(now) > wait {
+			wait = until - millis(now)
+		}
+	}
+
+	if wait < 0 {
+		return 0
+	}
+
+	return time.Duration(wait) * time.Millisecond
+}
+
+// getLockout returns the lockout for the key from the bucket, without failures
+// which are no longer recent or attempts which have been pending for too long
+func getLockout(b *bolt.Bucket, k string, now time.Time) (Lockout, error) {
+	l := Lockout{Key: k}
+	if j := b.Get([]byte(k)); j != nil {
+		err := json.Unmarshal(j, &l)
+		if err != nil {
+			return l, err
+		}
+	}
+
+	if l.expired(now) {
+		l.Failures = 0
+	}
+
+	if millis(now)-l.Started > int64(MaxPendingAttempt/time.Millisecond) {
+		l.Pending = 0
+	}
+
+	return l, nil
+}
+
+// putLockout saves the lockout in the bucket, or deletes it once it has neither
+// failures nor pending attempts
+func putLockout(b *bolt.Bucket, l Lockout) error {
+	if l.Failures == 0 && l.Pending == 0 {
+		return b.Delete([]byte(l.Key))
+	}
+
+	j, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(l.Key), j)
+}
+
+// LoginAttempt starts an attempt for each of the lockout keys, or returns how
+// long before one can start if any of them is blocked. The check and the start
+// are made together, so the attempt is counted before a concurrent one is
+// checked. It is ended by LoginFailed, LoginSucceeded or LoginReleased.
+func LoginAttempt(keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+	err := store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__lockouts"))
+		if err != nil {
+			return err
+		}
+
+		lockouts := make([]Lockout, 0, len(keys))
+		for _, k := range keys {
+			l, err := getLockout(b, k, now)
+			if err != nil {
+				return err
+			}
+
+			if d := l.blocked(now); d > wait {
+				wait = d
+			}
+
+			lockouts = append(lockouts, l)
+		}
+
+		if wait > 0 {
+			return nil
+		}
+
+		for _, l := range lockouts {
+			l.Pending++
+			l.Started = millis(now)
+
+			err := putLockout(b, l)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return wait, nil
+}
+
+// endAttempt ends an attempt for each of the lockout keys, updated by end, and
+// returns the lockouts after it
+func endAttempt(keys []string, end func(l *Lockout, now time.Time)) ([]Lockout, error) {
+	var lockouts []Lockout
+	now := time.Now()
+	err := store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__lockouts"))
+		if err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			l, err := getLockout(b, k, now)
+			if err != nil {
+				return err
+			}
+
+			if l.Pending > 0 {
+				l.Pending--
+			}
+			end(&l, now)
+
+			err = putLockout(b, l)
+			if err != nil {
+				return err
+			}
+
+			lockouts = append(lockouts, l)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+// LoginFailed ends an attempt for each of the lockout keys with a failure, and
+// returns the lockouts after it
+func LoginFailed(keys ...string) ([]Lockout, error) {
+	return endAttempt(keys, func(l *Lockout, now time.Time) {
+		l.Failures++
+		l.Last = millis(now)
+		l.Until = millis(now.Add(l.wait()))
+	})
+}
+
+// LoginSucceeded ends an attempt for each of the lockout keys, and forgets the
+// failed attempts for accounts. Those from IP addresses are kept, so logging in
+// to one account doesn't allow more attempts against others.
+func LoginSucceeded(keys ...string) error {
+	_, err := endAttempt(keys, func(l *Lockout, now time.Time) {
+		if !strings.HasPrefix(l.Key, "ip:") {
+			l.Failures = 0
+		}
+	})
+
+	return err
+}
+
+// LoginReleased ends an attempt for each of the lockout keys without a failure
+// or success, such as when it couldn't be completed
+func LoginReleased(keys ...string) error {
+	_, err := endAttempt(keys, func(l *Lockout, now time.Time) {})
+
+	return err
+}
+
+// ClearLockout forgets the failed attempts for each of the lockout keys, when
+// cleared by an admin
+func ClearLockout(keys ...string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__lockouts"))
+		if b == nil {
+			return nil
+		}
+
+		for _, k := range keys {
+			err := b.Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Lockouts returns the IP addresses and accounts with recent failures, most
+// recent first
+func Lockouts() ([]Lockout, error) {
+	var lockouts []Lockout
+	now := time.Now()
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__lockouts"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var l Lockout
+			err := json.Unmarshal(v, &l)
+			if err != nil {
+				return err
+			}
+
+			if !l.expired(now) {
+				lockouts = append(lockouts, l)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(lockouts, func(i, j int) bool {
+		return lockouts[i].Last > lockouts[j].Last
+	})
+
+	return lockouts, nil
+}
+
+// PurgeLockouts deletes the records of failures which are no longer recent, so
+// attempts to log in to any number of unknown accounts don't grow the database,
+// and returns the number of records deleted
+func PurgeLockouts() (int, error) {
+	var n int
+	now := time.Now()
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__lockouts"))
+		if b == nil {
+			return nil
+		}
+
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var l Lockout
+			err := json.Unmarshal(v, &l)
+			if err != nil {
+				return err
+			}
+
+			// keep records of attempts which are still pending
+			pending := l.Pending > 0 && millis(now)-l.Started <= int64(MaxPendingAttempt/time.Millisecond)
+			if l.expired(now) && !pending {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		n = len(expired)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// purgeLockouts periodically deletes the records of failures which are no
+// longer recent
+func purgeLockouts() {
+	for {
+		_, err := PurgeLockouts()
+		if err != nil {
+			log.Println("Error purging login lockouts:", err)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,270 @@
+package db
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// rewind moves the times of the lockouts for the keys back by d, as if d had
+// passed since they were recorded
+func rewind(t *testing.T, d time.Duration, keys ...string) {
+	ms := int64(d / time.Millisecond)
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__lockouts"))
+		for _, k := range keys {
+			j := b.Get([]byte(k))
+			if j == nil {
+				continue
+			}
+
+			var l Lockout
+			err := json.Unmarshal(j, &l)
+			if err != nil {
+				return err
+			}
+
+			l.Last -= ms
+			l.Until -= ms
+			l.Started -= ms
+
+			err = putLockout(b, l)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lockout returns the stored lockout for the key
+func lockout(t *testing.T, key string) Lockout {
+	l := Lockout{Key: key}
+	err := store.View(func(tx *bolt.Tx) error {
+		j := tx.Bucket([]byte("__lockouts")).Get([]byte(key))
+		if j == nil {
+			return nil
+		}
+
+		return json.Unmarshal(j, &l)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+func TestLoginThresholds(t *testing.T) {
+	defer openTestStore(t)()
+
+	// the failures go on until the longest lockout, after which they are no
+	// longer recent
+	cases := []struct {
+		key      string
+		waits    map[int]time.Duration
+		locked   int
+		failures int
+	}{
+		{
+			key: LockoutAccount("admin@example.com"),
+			waits: map[int]time.Duration{
+				1:  time.Second,
+				2:  time.Second * 2,
+				4:  time.Second * 8,
+				5:  LockoutDuration,
+				6:  LockoutDuration * 2,
+				9:  LockoutDuration * 16,
+				12: MaxLockoutDuration,
+			},
+			locked:   LockoutThreshold,
+			failures: 12,
+		},
+		{
+			key: LockoutIP("192.0.2.1"),
+			waits: map[int]time.Duration{
+				1:  time.Second,
+				7:  MaxBackoff,
+				19: MaxBackoff,
+				20: LockoutDuration,
+				27: MaxLockoutDuration,
+			},
+			locked:   LockoutThresholdIP,
+			failures: 27,
+		},
+	}
+
+	for _, c := range cases {
+		for n := 1; n <= c.failures; n++ {
+			wait, err := LoginAttempt(c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if wait != 0 {
+				t.Fatalf("%s failure %d: expected an attempt, blocked for %s", c.key, n, wait)
+			}
+
+			lockouts, err := LoginFailed(c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			l := lockouts[0]
+			if l.Failures != n || l.Pending != 0 {
+				t.Errorf("%s failure %d: recorded %d failures, %d pending", c.key, n, l.Failures, l.Pending)
+			}
+
+			if l.IsLocked() != (n >= c.locked) {
+				t.Errorf("%s failure %d: expected locked %v", c.key, n, n >= c.locked)
+			}
+
+			d := time.Duration(l.Until-l.Last) * time.Millisecond
+			if expected, ok := c.waits[n]; ok && d != expected {
+				t.Errorf("%s failure %d: expected a wait of %s, got %s", c.key, n, expected, d)
+			}
+
+			// another attempt is blocked until the wait has passed
+			wait, err = LoginAttempt(c.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if wait <= d-time.Second || wait > d {
+				t.Errorf("%s failure %d: expected to be blocked for %s, got %s", c.key, n, d, wait)
+			}
+
+			rewind(t, d, c.key)
+		}
+	}
+}
+
+func TestLoginSucceeded(t *testing.T) {
+	defer openTestStore(t)()
+
+	keys := []string{LockoutIP("192.0.2.1"), LockoutAccount("admin@example.com")}
+	for i := 0; i < 3; i++ {
+		LoginAttempt(keys...)
+		LoginFailed(keys...)
+		rewind(t, MaxBackoff, keys...)
+	}
+
+	wait, err := LoginAttempt(keys...)
+	if err != nil || wait != 0 {
+		t.Fatalf("expected an attempt, blocked for %s (%v)", wait, err)
+	}
+
+	err = LoginSucceeded(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the account's failures are forgotten, but not those from the IP address
+	if l := lockout(t, keys[0]); l.Failures != 3 || l.Pending != 0 {
+		t.Errorf("expected the IP address to keep 3 failures, got %d, %d pending", l.Failures, l.Pending)
+	}
+
+	if l := lockout(t, keys[1]); l.Failures != 0 || l.Pending != 0 {
+		t.Errorf("expected the account's failures to be forgotten, got %d, %d pending", l.Failures, l.Pending)
+	}
+}
+
+func TestLoginReleased(t *testing.T) {
+	defer openTestStore(t)()
+
+	key := LockoutAccount("admin@example.com")
+	for _, stale := range []bool{false, true} {
+		wait, err := LoginAttempt(key)
+		if err != nil || wait != 0 {
+			t.Fatalf("expected an attempt, blocked for %s (%v)", wait, err)
+		}
+
+		// an attempt which never ends is counted until it is too old
+		if stale {
+			rewind(t, MaxPendingAttempt+time.Second, key)
+		} else {
+			err = LoginReleased(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		wait, err = LoginAttempt(key)
+		if err != nil || wait != 0 {
+			t.Errorf("stale %v: expected an attempt, blocked for %s (%v)", stale, wait, err)
+		}
+		LoginReleased(key)
+
+		if l := lockout(t, key); l.Failures != 0 || l.Pending != 0 {
+			t.Errorf("stale %v: expected no failures, got %d, %d pending", stale, l.Failures, l.Pending)
+		}
+	}
+}
+
+func TestConcurrentLoginAttempts(t *testing.T) {
+	defer openTestStore(t)()
+
+	keys := []string{LockoutIP("192.0.2.1"), LockoutAccount("admin@example.com")}
+
+	// attempts made at once are counted as if they were made one after another,
+	// so each round allows a single attempt until the account is locked out
+	for round := 1; round <= LockoutThreshold+2; round++ {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		var attempts int
+
+		start := make(chan struct{})
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				wait, err := LoginAttempt(keys...)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if wait > 0 {
+					return
+				}
+
+				// fail slowly, as a password would be checked
+				time.Sleep(time.Millisecond * 10)
+				_, err = LoginFailed(keys...)
+				if err != nil {
+					t.Error(err)
+				}
+
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		expected := 1
+		if round > LockoutThreshold {
+			expected = 0
+		}
+
+		if attempts != expected {
+			t.Errorf("round %d: expected %d attempts, got %d", round, expected, attempts)
+		}
+
+		rewind(t, MaxBackoff, keys...)
+	}
+
+	if l := lockout(t, keys[1]); l.Failures != LockoutThreshold || !l.IsLocked() {
+		t.Errorf("expected the account to be locked after %d failures, got %d", LockoutThreshold, l.Failures)
+	}
+}
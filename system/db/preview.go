@@ -0,0 +1,96 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultPreviewTTL is how long a preview token is valid for
+const DefaultPreviewTTL = time.Hour * 24
+
+// ErrInvalidPreviewToken is returned for a preview token which is malformed,
+// or wasn't signed by this system
+var ErrInvalidPreviewToken = errors.New("Invalid preview token")
+
+// ErrPreviewExpired is returned for a preview token which has expired
+var ErrPreviewExpired = errors.New("Preview token has expired")
+
+type previewClaims struct {
+	Target string `json:"target"`
+	Exp    int64  `json:"exp"`
+}
+
+// previewSignature signs the payload of a preview token with a key derived from
+// the client secret, so that preview tokens can't be used as user tokens
+func previewSignature(payload string) []byte {
+	secret, _ := ConfigCache("client_secret").(string)
+
+	mac := hmac.New(sha256.New, []byte("preview:"+secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// CanPreview reports whether the content at target is unpublished content which
+// a preview token can be created for: a draft, or scheduled content
+func CanPreview(target string) bool {
+	ns := strings.Split(target, ":")[0]
+	return strings.HasSuffix(ns, "__draft") || strings.HasSuffix(ns, "__scheduled")
+}
+
+// PreviewToken creates a signed token which allows the unpublished content at
+// target to be read from the content API until it expires after ttl.
+// The `target` argument is a string made up of namespace:id (string:int)
+func PreviewToken(target string, ttl time.Duration) (string, error) {
+	if !CanPreview(target) {
+		return "", ErrInvalidPreviewToken
+	}
+
+	claims, err := json.Marshal(previewClaims{
+		Target: target,
+		Exp:    time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	sig := base64.RawURLEncoding.EncodeToString(previewSignature(payload))
+
+	return payload + "." + sig, nil
+}
+
+// PreviewTarget verifies a preview token, and returns the target of the content
+// it allows to be read
+func PreviewTarget(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidPreviewToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, previewSignature(parts[0])) {
+		return "", ErrInvalidPreviewToken
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidPreviewToken
+	}
+
+	var c previewClaims
+	err = json.Unmarshal(claims, &c)
+	if err != nil || !CanPreview(c.Target) {
+		return "", ErrInvalidPreviewToken
+	}
+
+	if time.Now().After(time.Unix(c.Exp, 0)) {
+		return "", ErrPreviewExpired
+	}
+
+	return c.Target, nil
+}
@@ -0,0 +1,382 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/backup"
+	"github.com/ponzu-cms/ponzu/system/cfg"
+	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/search"
+	"github.com/ponzu-cms/ponzu/system/storage"
+
+	"github.com/boltdb/bolt"
+	"github.com/nilslice/jwt"
+	"github.com/tidwall/gjson"
+)
+
+// restoreKeep are the buckets which are kept when a snapshot is restored, so the
+// audit log, the status of the local backups and the change log aren't rewound,
+// and sessions and API keys revoked since the snapshot aren't brought back
+var restoreKeep = map[string]bool{
+	"__audit":    true,
+	"__backups":  true,
+	"__changes":  true,
+	"__sessions": true,
+	"__apikeys":  true,
+}
+
+// Restore replaces the database, uploads and search indices by those of the
+// snapshot at src, a snapshot directory or an archive of one. The snapshot is
+// verified against its manifest, and refused if it is from an incompatible
+// version of Ponzu. Writes to the database wait while it and the uploads are
+// replaced, together, unless the uploads aren't stored locally, when they are
+// synced with the snapshot after the database is restored. The sorted content, content index and field indices are
+// rebuilt from the restored content, as are the search indices which weren't in
+// the snapshot.
+func Restore(ctx context.Context, src string) (*backup.Manifest, error) {
+	if !startBackup() {
+		return nil, ErrBackupRunning
+	}
+	defer endBackup()
+
+	stage := filepath.Join(cfg.DataDir(), fmt.Sprintf(".restore-%d", time.Now().UnixNano()))
+	defer os.RemoveAll(stage)
+
+	m, err := backup.Stage(ctx, src, stage)
+	if err != nil {
+		return nil, err
+	}
+
+	err = backup.Compatible(m.Version, ponzuVersion())
+	if err != nil {
+		return m, err
+	}
+
+	archive, err := bolt.Open(filepath.Join(stage, "system.db"), 0666, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		return m, fmt.Errorf("snapshot database: %v", err)
+	}
+	defer archive.Close()
+
+	// uploads in a local directory are replaced with the database, other
+	// storage is synced once the database is restored
+	staged := filepath.Join(stage, backup.UploadsDir)
+	uploads := storage.Uploads()
+	local, isLocal := uploads.(*storage.Local)
+
+	var commit, rollback func() error
+	err = store.Update(func(tx *bolt.Tx) error {
+		err := archive.View(func(atx *bolt.Tx) error {
+			return restoreBuckets(tx, atx)
+		})
+		if err != nil {
+			return err
+		}
+
+		for t := range item.Types {
+			for _, name := range []string{t, t + "__sorted"} {
+				_, err := tx.CreateBucketIfNotExists([]byte(name))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, name := range buckets {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = rebuildContentIndex(tx)
+		if err != nil {
+			return err
+		}
+
+		// sessions are kept only for the users in the snapshot
+		users := tx.Bucket([]byte("__users"))
+		err = deleteSessions(tx.Bucket([]byte("__sessions")), func(s Session) bool {
+			return users.Get([]byte(s.Email)) == nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the content changed in ways the change log doesn't record, so clients
+		// of the change feed are told to fetch it again
+		err = appendChange(tx, Change{
+			Event:     ChangeReset,
+			Timestamp: millis(time.Now()),
+		})
+		if err != nil {
+			return err
+		}
+
+		if !isLocal {
+			return nil
+		}
+
+		// uploads are replaced last, while writes still wait, so they can be
+		// put back if the transaction fails
+		commit, rollback, err = backup.Replace(staged, local.Dir)
+		return err
+	})
+	if err != nil {
+		if rollback != nil {
+			if rerr := rollback(); rerr != nil {
+				log.Println("Error putting back uploads after failed restore:", rerr)
+			}
+		}
+
+		return m, err
+	}
+
+	if isLocal {
+		err = commit()
+		if err != nil {
+			log.Println("Error removing previous uploads after restore:", err)
+		}
+	} else {
+		err = syncUploads(ctx, uploads, staged)
+		if err != nil {
+			return m, fmt.Errorf("Restored the database, but failed to restore uploads: %v", err)
+		}
+	}
+
+	notifyChanges()
+
+	err = LoadCacheConfig()
+	if err != nil {
+		return m, err
+	}
+
+	if secret, _ := ConfigCache("client_secret").(string); secret != "" {
+		jwt.Secret([]byte(secret))
+	}
+
+	err = InvalidateCache()
+	if err != nil {
+		log.Println("Error invalidating cache after restore:", err)
+	}
+
+	for t := range item.Types {
+		SortContent(t)
+
+		err = ReindexFields(t)
+		if err != nil {
+			log.Println("Error rebuilding field index for", t, err)
+		}
+	}
+
+	// the search indices are derived from the content, so they are rebuilt
+	// rather than failing the restore
+	missing, err := search.Restore(filepath.Join(stage, backup.SearchDir))
+	if err != nil {
+		log.Println("Error restoring search indices, rebuilding them:", err)
+
+		missing = nil
+		for t := range item.Types {
+			missing = append(missing, t)
+		}
+	}
+
+	for _, t := range missing {
+		err = reindexSearch(t)
+		if err != nil {
+			log.Println("Error rebuilding search index for", t, err)
+		}
+	}
+
+	return m, nil
+}
+
+// syncUploads makes the uploads in storage match those staged in dir: files
+// which are missing or differ in size are stored, and the others removed
+func syncUploads(ctx context.Context, uploads storage.Storage, dir string) error {
+	staged := &storage.Local{Dir: dir}
+	keep := make(map[string]bool)
+	err := storage.Copy(ctx, uploads, staged, func(obj *storage.Object, copied bool) {
+		keep[obj.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+
+	var remove []string
+	err = uploads.Walk(ctx, func(obj *storage.Object) error {
+		if !keep[obj.Name] {
+			remove = append(remove, obj.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range remove {
+		err = uploads.Delete(ctx, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreBuckets replaces the buckets of tx by those of the snapshot's atx,
+// except the buckets which are kept
+func restoreBuckets(tx, atx *bolt.Tx) error {
+	var names [][]byte
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if !restoreKeep[string(name)] {
+			names = append(names, append([]byte(nil), name...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = tx.DeleteBucket(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return atx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if restoreKeep[string(name)] {
+			return nil
+		}
+
+		nb, err := tx.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(nb, b)
+	})
+}
+
+// copyBucket copies the keys, nested buckets and sequence of src to dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nb, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+
+			return copyBucket(nb, src.Bucket(k))
+		}
+
+		// values of the snapshot's transaction aren't valid once it ends
+		return dst.Put(append([]byte(nil), k...), append([]byte(nil), v...))
+	})
+}
+
+// rebuildContentIndex rebuilds the __contentIndex of slugs from the public and
+// scheduled content and the uploads, if it doesn't match them
+func rebuildContentIndex(tx *bolt.Tx) error {
+	slugs := make(map[string]string)
+	add := func(bucket, ns string) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return nil
+			}
+
+			slug := gjson.GetBytes(v, "slug").String()
+			if slug != "" {
+				slugs[slug] = fmt.Sprintf("%s:%d", ns, gjson.GetBytes(v, "id").Int())
+			}
+
+			return nil
+		})
+	}
+
+	for t := range item.Types {
+		for _, bucket := range []string{t, t + "__scheduled"} {
+			err := add(bucket, t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := add("__uploads", "__uploads")
+	if err != nil {
+		return err
+	}
+
+	ci := tx.Bucket([]byte("__contentIndex"))
+	if ci != nil && ci.Stats().KeyN == len(slugs) {
+		match := true
+		ci.ForEach(func(k, v []byte) error {
+			if !bytes.Equal(v, []byte(slugs[string(k)])) {
+				match = false
+			}
+
+			return nil
+		})
+
+		if match {
+			return nil
+		}
+	}
+
+	log.Println("Rebuilding content index of", len(slugs), "slugs")
+
+	err = tx.DeleteBucket([]byte("__contentIndex"))
+	if err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	ci, err = tx.CreateBucket([]byte("__contentIndex"))
+	if err != nil {
+		return err
+	}
+
+	for slug, target := range slugs {
+		err = ci.Put([]byte(slug), []byte(target))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reindexSearch adds all public content of the type to its search index
+func reindexSearch(namespace string) error {
+	for _, j := range ContentAll(namespace) {
+		target := fmt.Sprintf("%s:%d", namespace, gjson.GetBytes(j, "id").Int())
+		err := search.UpdateIndex(target, j)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestRestoreBuckets(t *testing.T) {
+	defer openTestStore(t)()
+
+	dir, err := ioutil.TempDir("", "ponzu-snapshot-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive, err := bolt.Open(filepath.Join(dir, "system.db"), 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	// put a key in each bucket, with the bucket's sequence at n
+	fill := func(db *bolt.DB, value string, n uint64, names ...string) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			for _, name := range names {
+				b, err := tx.CreateBucketIfNotExists([]byte(name))
+				if err != nil {
+					return err
+				}
+
+				err = b.Put([]byte("key"), []byte(value))
+				if err != nil {
+					return err
+				}
+
+				err = b.SetSequence(n)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := []string{"Song", "__users", "__changes", "__sessions", "__apikeys", "__audit"}
+	fill(store, "current", 10, names...)
+	fill(store, "current", 10, "Song__sorted")
+	fill(archive, "snapshot", 5, names...)
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		return archive.View(func(atx *bolt.Tx) error {
+			return restoreBuckets(tx, atx)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"Song":         "snapshot",
+		"Song__sorted": "",
+		"__users":      "snapshot",
+		"__changes":    "current",
+		"__sessions":   "current",
+		"__apikeys":    "current",
+		"__audit":      "current",
+	}
+
+	store.View(func(tx *bolt.Tx) error {
+		for name, value := range expected {
+			var got string
+			var seq uint64
+			if b := tx.Bucket([]byte(name)); b != nil {
+				got = string(b.Get([]byte("key")))
+				seq = b.Sequence()
+			}
+
+			if got != value {
+				t.Errorf("%s: expected %q, got %q", name, value, got)
+			}
+
+			// the change log keeps its sequence, so ids aren't given again
+			if name == "__changes" && seq != 10 {
+				t.Errorf("%s: expected sequence 10, got %d", name, seq)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/item"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// DefaultRevisionLimit is the number of revisions kept for each item of content
+// types which don't implement item.Revisable
+const DefaultRevisionLimit = 20
+
+// Revision is a saved version of content, kept in the history of the item
+type Revision struct {
+	ID        int             `json:"id"`
+	Timestamp int64           `json:"timestamp"` // milliseconds since Unix epoch
+	Author    string          `json:"author"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func revisionBucket(ns string) []byte {
+	return []byte(ns + "__revisions")
+}
+
+func revisionKey(rev uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, rev)
+	return k
+}
+
+func revisionLimit(ns string) int {
+	t, ok := item.Types[ns]
+	if !ok {
+		return DefaultRevisionLimit
+	}
+
+	if r, ok := t().(item.Revisable); ok {
+		return r.RevisionLimit()
+	}
+
+	return DefaultRevisionLimit
+}
+
+// addRevision records data as the latest revision of the item with id in the
+// namespace, and removes the oldest revisions past the type's limit. If the item
+// has no history yet, prev (the version being replaced) is recorded first so
+// that content saved before history was kept can be restored too.
+func addRevision(tx *bolt.Tx, ns, id, author string, prev, data []byte) error {
+	limit := revisionLimit(ns)
+	if limit == 0 {
+		return nil
+	}
+
+	b, err := tx.CreateBucketIfNotExists(revisionBucket(ns))
+	if err != nil {
+		return err
+	}
+
+	hist, err := b.CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return err
+	}
+
+	now := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+	add := func(ts int64, author string, data []byte) error {
+		seq, err := hist.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(Revision{
+			ID:        int(seq),
+			Timestamp: ts,
+			Author:    author,
+			Data:      json.RawMessage(data),
+		})
+		if err != nil {
+			return err
+		}
+
+		return hist.Put(revisionKey(seq), j)
+	}
+
+	if len(prev) > 0 && hist.Stats().KeyN == 0 {
+		ts := gjson.GetBytes(prev, "updated").Int()
+		if ts == 0 {
+			ts = now
+		}
+
+		err = add(ts, "", prev)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = add(now, author, data)
+	if err != nil {
+		return err
+	}
+
+	if limit < 0 {
+		return nil
+	}
+
+	// remove the oldest revisions past the limit
+	var keys [][]byte
+	c := hist.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+
+	for i := 0; i < len(keys)-limit; i++ {
+		err = hist.Delete(keys[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteRevisions removes the history of the item with id in the namespace
+func deleteRevisions(tx *bolt.Tx, ns, id string) error {
+	b := tx.Bucket(revisionBucket(ns))
+	if b == nil || b.Bucket([]byte(id)) == nil {
+		return nil
+	}
+
+	return b.DeleteBucket([]byte(id))
+}
+
+// Revisions returns the history of the content at target, newest first.
+// The `target` argument is a string made up of namespace:id (string:int)
+func Revisions(target string) ([]Revision, error) {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	var revs []Revision
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revisionBucket(ns))
+		if b == nil {
+			return nil
+		}
+
+		hist := b.Bucket([]byte(id))
+		if hist == nil {
+			return nil
+		}
+
+		return hist.ForEach(func(k, v []byte) error {
+			var rev Revision
+			err := json.Unmarshal(v, &rev)
+			if err != nil {
+				return err
+			}
+
+			revs = append(revs, rev)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(revs, func(i, j int) bool {
+		return revs[i].ID > revs[j].ID
+	})
+
+	return revs, nil
+}
+
+// ContentRevision returns a single revision from the history of the content at
+// target. The `target` argument is a string made up of namespace:id (string:int)
+func ContentRevision(target string, rev int) (Revision, error) {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	var r Revision
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(revisionBucket(ns))
+		if b == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		hist := b.Bucket([]byte(id))
+		if hist == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		v := hist.Get(revisionKey(uint64(rev)))
+		if v == nil {
+			return fmt.Errorf("Revision %d not found for %s", rev, target)
+		}
+
+		return json.Unmarshal(v, &r)
+	})
+
+	return r, err
+}
+
+// RestoreRevision saves a revision from the history of the content at target as
+// its current version, recorded as a new revision by author. The id, uuid and
+// slug of the current content are kept.
+// The `target` argument is a string made up of namespace:id (string:int)
+func RestoreRevision(target string, rev int, author string) (int, error) {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	r, err := ContentRevision(target, rev)
+	if err != nil {
+		return 0, err
+	}
+
+	current, err := Content(target)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(current) == 0 {
+		return 0, fmt.Errorf("Content not found for %s", target)
+	}
+
+	return updateWith(ns, id, author, func(ns string) ([]byte, error) {
+		j := []byte(r.Data)
+		for _, k := range []string{"id", "uuid", "slug"} {
+			v := gjson.GetBytes(current, k)
+			if !v.Exists() {
+				continue
+			}
+
+			j, err = sjson.SetRawBytes(j, k, []byte(v.Raw))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ts := int64(time.Nanosecond) * time.Now().UnixNano() / int64(time.Millisecond)
+		return sjson.SetBytes(j, "updated", ts)
+	})
+}
@@ -0,0 +1,259 @@
+package db
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/search"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+)
+
+// scheduleInterval is how often scheduled content is checked for publishing
+// and expiry
+var scheduleInterval = time.Minute
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// scheduledSpecifier returns the specifier of the bucket content with the json
+// data belongs in at now: "" if it is public, or "__scheduled" if it is not yet
+// published or has expired
+func scheduledSpecifier(j []byte, now time.Time) string {
+	ms := millis(now)
+
+	publish := gjson.GetBytes(j, "publish_at").Int()
+	if publish > ms {
+		return "__scheduled"
+	}
+
+	expire := gjson.GetBytes(j, "expire_at").Int()
+	if expire > 0 && expire <= ms {
+		return "__scheduled"
+	}
+
+	return ""
+}
+
+// schedule stores the json data of public or scheduled content in the bucket
+// matching its publish and expire times, removes it from the other, and keeps
+// the field index, modified time and __schedule bucket in sync. It returns the
+// content it replaced, whether it is now public, and whether the public content
+// of the namespace changed.
+func schedule(tx *bolt.Tx, ns, id string, j []byte) (prev []byte, public, changed bool, err error) {
+	pb, err := tx.CreateBucketIfNotExists([]byte(ns))
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	sb, err := tx.CreateBucketIfNotExists([]byte(ns + "__scheduled"))
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	key := []byte(id)
+	published := append([]byte(nil), pb.Get(key)...)
+	prev = published
+	if len(prev) == 0 {
+		prev = append([]byte(nil), sb.Get(key)...)
+	}
+
+	spec := scheduledSpecifier(j, time.Now())
+	if spec == "" {
+		err = pb.Put(key, j)
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		err = sb.Delete(key)
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		err = setFieldIndex(tx, ns, id, published, j)
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		public, changed = true, true
+	} else {
+		err = sb.Put(key, j)
+		if err != nil {
+			return nil, false, false, err
+		}
+
+		if len(published) > 0 {
+			err = pb.Delete(key)
+			if err != nil {
+				return nil, false, false, err
+			}
+
+			err = setFieldIndex(tx, ns, id, published, nil)
+			if err != nil {
+				return nil, false, false, err
+			}
+
+			changed = true
+		}
+	}
+
+	if changed {
+		err = setModified(tx, ns)
+		if err != nil {
+			return nil, false, false, err
+		}
+	}
+
+	err = setSchedule(tx, ns, id, j, spec)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	return prev, public, changed, nil
+}
+
+// setSchedule records the next time content stored in the bucket with the
+// specifier needs to be moved, if ever: when scheduled content is published,
+// or when public content expires
+func setSchedule(tx *bolt.Tx, ns, id string, j []byte, specifier string) error {
+	var next int64
+	if specifier == "" {
+		next = gjson.GetBytes(j, "expire_at").Int()
+	} else if publish := gjson.GetBytes(j, "publish_at").Int(); publish > millis(time.Now()) {
+		next = publish
+	}
+
+	if next <= 0 {
+		return clearSchedule(tx, ns, id)
+	}
+
+	b, err := tx.CreateBucketIfNotExists([]byte("__schedule"))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(ns+":"+id), []byte(strconv.FormatInt(next, 10)))
+}
+
+// clearSchedule removes content from the __schedule bucket
+func clearSchedule(tx *bolt.Tx, ns, id string) error {
+	b := tx.Bucket([]byte("__schedule"))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete([]byte(ns + ":" + id))
+}
+
+// PublishScheduled moves scheduled content which is due to be published into
+// its public bucket, and expired public content into its __scheduled bucket,
+// then re-sorts and re-indexes the types which changed. It returns the number
+// of items moved.
+func PublishScheduled() (int, error) {
+	var targets []string
+	now := millis(time.Now())
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__schedule"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			next, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if next <= now {
+				targets = append(targets, string(k))
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sorts := make(map[string]bool)
+	for i, target := range targets {
+		t := strings.Split(target, ":")
+		ns, id := t[0], t[1]
+
+		var j []byte
+		var public, changed bool
+		err := store.Update(func(tx *bolt.Tx) error {
+			var data []byte
+			if b := tx.Bucket([]byte(ns)); b != nil {
+				data = b.Get([]byte(id))
+			}
+			if data == nil {
+				if b := tx.Bucket([]byte(ns + "__scheduled")); b != nil {
+					data = b.Get([]byte(id))
+				}
+			}
+
+			// content deleted since it was scheduled
+			if data == nil {
+				return clearSchedule(tx, ns, id)
+			}
+
+			j = append([]byte(nil), data...)
+
+			var err error
+			_, public, changed, err = schedule(tx, ns, id, j)
+			return err
+		})
+		if err != nil {
+			return i, err
+		}
+
+		if !changed {
+			continue
+		}
+		sorts[ns] = true
+
+		event := WebhookPublish
+		if public {
+			err = search.UpdateIndex(target, j)
+			if err != nil {
+				log.Println("[search] UpdateIndex Error:", err)
+			}
+		} else {
+			event = WebhookExpire
+			err = search.DeleteIndex(target)
+			if err != nil {
+				log.Println("[search] DeleteIndex Error:", err)
+			}
+		}
+
+		err = ContentChanged(event, ns, id, j)
+		if err != nil {
+			log.Println("Error queueing webhooks:", err)
+		}
+	}
+
+	for ns := range sorts {
+		SortContent(ns)
+	}
+
+	return len(targets), nil
+}
+
+// publishScheduled periodically publishes and expires scheduled content
+func publishScheduled() {
+	for {
+		n, err := PublishScheduled()
+		if err != nil {
+			log.Println("Error publishing scheduled content:", err)
+		} else if n > 0 {
+			log.Println("Published or expired", n, "scheduled items")
+		}
+
+		time.Sleep(scheduleInterval)
+	}
+}
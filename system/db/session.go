@@ -0,0 +1,243 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// sessionSeenInterval is the precision, in milliseconds, of the time a session
+// was last seen
+const sessionSeenInterval = int64(time.Minute / time.Millisecond)
+
+// ErrNoSession is returned for a session which doesn't exist, has expired, or
+// has been revoked
+var ErrNoSession = errors.New("No session found")
+
+// Session is a login of an admin user, which is valid until it expires or is
+// revoked. The session ID is included in the user's token.
+type Session struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	IP        string `json:"ip"` // the last IP address the session was used from
+	UserAgent string `json:"user_agent"`
+	Created   int64  `json:"created"`   // milliseconds since Unix epoch
+	LastSeen  int64  `json:"last_seen"` // milliseconds since Unix epoch
+	Expires   int64  `json:"expires"`   // milliseconds since Unix epoch
+}
+
+// NewSession creates a session for the user with the email address, which is
+// valid until expires, and removes any other sessions which have expired
+func NewSession(email, ip, userAgent string, expires time.Time) (*Session, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	now := millis(time.Now())
+	s := &Session{
+		ID:        base64.RawURLEncoding.EncodeToString(b),
+		Email:     email,
+		IP:        ip,
+		UserAgent: userAgent,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   millis(expires),
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		sessions, err := tx.CreateBucketIfNotExists([]byte("__sessions"))
+		if err != nil {
+			return err
+		}
+
+		err = deleteSessions(sessions, func(old Session) bool {
+			return old.Expires < now
+		})
+		if err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		return sessions.Put([]byte(s.ID), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// TouchSession checks that the session is valid, and records that it was seen
+// from the IP address
+func TouchSession(id, ip string) (*Session, error) {
+	var s Session
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__sessions"))
+		if b == nil {
+			return ErrNoSession
+		}
+
+		j := b.Get([]byte(id))
+		if j == nil {
+			return ErrNoSession
+		}
+
+		return json.Unmarshal(j, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := millis(time.Now())
+	if s.Expires < now {
+		return nil, ErrNoSession
+	}
+
+	// limit writes for sessions used by many requests
+	if now-s.LastSeen < sessionSeenInterval && s.IP == ip {
+		return &s, nil
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__sessions"))
+		if b == nil {
+			return ErrNoSession
+		}
+
+		// the session may have been revoked since it was read
+		if b.Get([]byte(id)) == nil {
+			return ErrNoSession
+		}
+
+		s.LastSeen = now
+		s.IP = ip
+		j, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(id), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Sessions returns the unexpired sessions of the user with the email address,
+// most recently seen first
+func Sessions(email string) ([]Session, error) {
+	var sessions []Session
+	now := millis(time.Now())
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__sessions"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var s Session
+			err := json.Unmarshal(v, &s)
+			if err != nil {
+				return err
+			}
+
+			if s.Email == email && s.Expires >= now {
+				sessions = append(sessions, s)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen > sessions[j].LastSeen
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession ends the session of the user with the email address, so its
+// token can no longer be used
+func RevokeSession(email, id string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__sessions"))
+		if b == nil {
+			return nil
+		}
+
+		return deleteSessions(b, func(s Session) bool {
+			return s.ID == id && s.Email == email
+		})
+	})
+}
+
+// RevokeSessions ends all sessions of the user with the email address, except
+// those with an ID in keep
+func RevokeSessions(email string, keep ...string) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__sessions"))
+		if b == nil {
+			return nil
+		}
+
+		return deleteSessions(b, func(s Session) bool {
+			if s.Email != email {
+				return false
+			}
+
+			for _, id := range keep {
+				if s.ID == id {
+					return false
+				}
+			}
+
+			return true
+		})
+	})
+}
+
+// deleteSessions removes the sessions in the bucket which match
+func deleteSessions(b *bolt.Bucket, match func(Session) bool) error {
+	var ids [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var s Session
+		err := json.Unmarshal(v, &s)
+		if err != nil {
+			return err
+		}
+
+		if match(s) {
+			ids = append(ids, append([]byte{}, k...))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		err := b.Delete(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/backup"
+	"github.com/ponzu-cms/ponzu/system/cfg"
+	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/search"
+	"github.com/ponzu-cms/ponzu/system/storage"
+
+	"github.com/boltdb/bolt"
+)
+
+// backupCheckInterval is how often the backup schedule is checked
+const backupCheckInterval = time.Second * 30
+
+// ErrBackupRunning is returned when a backup or restore is started while another
+// is running
+var ErrBackupRunning = errors.New("A backup or restore is already running")
+
+var (
+	backupMu      = &sync.Mutex{}
+	backupRunning bool
+)
+
+// BackupStatus is the result of the most recent backup
+type BackupStatus struct {
+	Running    bool   `json:"-"`
+	Started    int64  `json:"started"`  // milliseconds since Unix epoch
+	Duration   int64  `json:"duration"` // milliseconds
+	Snapshot   string `json:"snapshot"`
+	NewUploads int    `json:"new_uploads"`
+	Removed    int    `json:"removed"`
+	Error      string `json:"error"`
+}
+
+// BackupDir returns the directory scheduled backups are written to, which is
+// "backups" in the data directory unless set in the configuration
+func BackupDir() string {
+	dir, _ := ConfigCache("backup_dir").(string)
+	if dir == "" {
+		dir = filepath.Join(cfg.DataDir(), "backups")
+	}
+
+	return dir
+}
+
+// NextBackup returns the time of the next scheduled backup, or the zero time if
+// backups aren't scheduled
+func NextBackup(now time.Time) (time.Time, error) {
+	spec, _ := ConfigCache("backup_schedule").(string)
+	if spec == "" {
+		return time.Time{}, nil
+	}
+
+	s, err := backup.ParseSchedule(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return s.Next(now), nil
+}
+
+// Snapshot writes a snapshot of the system.db database, the search indices and
+// the uploads to a new directory in the BackupDir. Only uploads which aren't in
+// an earlier snapshot are copied. Snapshots which are no longer kept by the
+// retention settings are then removed.
+func Snapshot(ctx context.Context) (*backup.Manifest, error) {
+	if !startBackup() {
+		return nil, ErrBackupRunning
+	}
+	defer endBackup()
+
+	start := time.Now()
+	status := BackupStatus{Started: millis(start)}
+	m, removed, err := snapshot(ctx, start)
+	status.Duration = millis(time.Now()) - status.Started
+	if m != nil {
+		status.Snapshot = m.Name
+		status.NewUploads = m.NewUploads
+		status.Removed = len(removed)
+	}
+
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	serr := setBackupStatus(status)
+	if serr != nil {
+		log.Println("Error saving backup status:", serr)
+	}
+
+	return m, err
+}
+
+// startBackup reports whether a backup or restore can start, as none is running
+func startBackup() bool {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	if backupRunning {
+		return false
+	}
+
+	backupRunning = true
+	return true
+}
+
+func endBackup() {
+	backupMu.Lock()
+	backupRunning = false
+	backupMu.Unlock()
+}
+
+func snapshot(ctx context.Context, start time.Time) (*backup.Manifest, []string, error) {
+	dir := BackupDir()
+	err := os.MkdirAll(dir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshots, err := backup.Snapshots(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var prev *backup.Manifest
+	if len(snapshots) > 0 {
+		prev = snapshots[0]
+	}
+
+	m := &backup.Manifest{
+		Name:    start.UTC().Format(backup.SnapshotTimeFormat),
+		Created: millis(start),
+		Version: ponzuVersion(),
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, m.Name)); err == nil {
+		return nil, nil, fmt.Errorf("snapshot %s already exists", m.Name)
+	}
+
+	for t := range item.Types {
+		m.Types = append(m.Types, t)
+	}
+	sort.Strings(m.Types)
+
+	// write to a hidden directory, renamed once it is complete
+	tmp := filepath.Join(dir, "."+m.Name)
+	err = os.MkdirAll(tmp, os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	err = writeSnapshotFile(filepath.Join(tmp, "system.db"), func(f *os.File) error {
+		return store.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteTo(f)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := os.Stat(cfg.SearchDir()); err == nil {
+		err = writeSnapshotFile(filepath.Join(tmp, "search.tar.gz"), func(f *os.File) error {
+			return search.Archive(ctx, f)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, name := range []string{"system.db", "search.tar.gz"} {
+		f, err := backup.Checksum(filepath.Join(tmp, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		m.Files = append(m.Files, f)
+	}
+
+	m.Uploads, m.NewUploads, err = backup.StoreUploads(ctx, dir, storage.Uploads(), prev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = backup.WriteManifest(tmp, m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = os.Rename(tmp, filepath.Join(dir, m.Name))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	daily, _ := ConfigCache("backup_keep_daily").(float64)
+	if daily < 1 {
+		daily = 7
+	}
+
+	weekly, _ := ConfigCache("backup_keep_weekly").(float64)
+	if weekly < 1 {
+		weekly = 4
+	}
+
+	removed, err := backup.Prune(dir, int(daily), int(weekly))
+	if err != nil {
+		return m, removed, fmt.Errorf("snapshot %s was written, but removing old snapshots failed: %v", m.Name, err)
+	}
+
+	return m, removed, nil
+}
+
+// writeSnapshotFile creates the file at path, writes it with write and syncs it
+// to disk
+func writeSnapshotFile(path string, write func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = write(f)
+	if err != nil {
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// ponzuVersion returns the version of Ponzu the project is using, from the
+// ponzu.json file created by the CLI
+func ponzuVersion() string {
+	b, err := ioutil.ReadFile(filepath.Join("cmd", "ponzu", "ponzu.json"))
+	if err != nil {
+		return ""
+	}
+
+	var info struct {
+		Version string `json:"version"`
+	}
+
+	json.Unmarshal(b, &info)
+	return info.Version
+}
+
+func setBackupStatus(status BackupStatus) error {
+	j, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	return store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__backups"))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte("status"), j)
+	})
+}
+
+// LastBackup returns the status of the most recent backup, which is Running if
+// a backup is in progress
+func LastBackup() (BackupStatus, error) {
+	var status BackupStatus
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__backups"))
+		if b == nil {
+			return nil
+		}
+
+		j := b.Get([]byte("status"))
+		if j == nil {
+			return nil
+		}
+
+		return json.Unmarshal(j, &status)
+	})
+
+	backupMu.Lock()
+	status.Running = backupRunning
+	backupMu.Unlock()
+
+	return status, err
+}
+
+// scheduleBackups runs backups on the schedule in the configuration
+func scheduleBackups() {
+	var spec string
+	var next time.Time
+	for {
+		s, _ := ConfigCache("backup_schedule").(string)
+		if s != spec {
+			spec = s
+
+			var err error
+			next, err = NextBackup(time.Now())
+			if err != nil {
+				log.Println("Error in backup schedule, scheduled backups will not run:", err)
+			}
+		}
+
+		if !next.IsZero() && !time.Now().Before(next) {
+			m, err := Snapshot(context.Background())
+			if err != nil {
+				log.Println("Error running scheduled backup:", err)
+			} else {
+				log.Println("Backed up to", filepath.Join(BackupDir(), m.Name))
+			}
+
+			next, _ = NextBackup(time.Now())
+		}
+
+		time.Sleep(backupCheckInterval)
+	}
+}
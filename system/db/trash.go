@@ -0,0 +1,267 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/search"
+
+	"github.com/boltdb/bolt"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Trashed is deleted content held in the trash of its type
+type Trashed struct {
+	ID      string
+	Deleted time.Time
+	Data    []byte
+}
+
+func trashKey(ns, id string) []byte {
+	return []byte(ns + ":" + id)
+}
+
+// moveToTrash stores the data of deleted content in the __trash bucket of its
+// type, and records when it was deleted
+func moveToTrash(tx *bolt.Tx, ns, id string, data []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(ns + "__trash"))
+	if err != nil {
+		return err
+	}
+
+	err = b.Put([]byte(id), data)
+	if err != nil {
+		return err
+	}
+
+	t, err := tx.CreateBucketIfNotExists([]byte("__trashed"))
+	if err != nil {
+		return err
+	}
+
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return t.Put(trashKey(ns, id), []byte(now))
+}
+
+// purgeTrashed removes everything kept for content which is permanently deleted
+// from the trash, aside from the content itself
+func purgeTrashed(tx *bolt.Tx, ns, id string) error {
+	err := deleteRevisions(tx, ns, id)
+	if err != nil {
+		return err
+	}
+
+	t := tx.Bucket([]byte("__trashed"))
+	if t == nil {
+		return nil
+	}
+
+	return t.Delete(trashKey(ns, id))
+}
+
+// Trash returns the deleted content of the namespace, most recently deleted first
+func Trash(namespace string) ([]Trashed, error) {
+	var trash []Trashed
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(namespace + "__trash"))
+		if b == nil {
+			return nil
+		}
+
+		t := tx.Bucket([]byte("__trashed"))
+
+		return b.ForEach(func(k, v []byte) error {
+			item := Trashed{
+				ID:   string(k),
+				Data: append([]byte(nil), v...),
+			}
+
+			if t != nil {
+				ns, err := strconv.ParseInt(string(t.Get(trashKey(namespace, string(k)))), 10, 64)
+				if err == nil {
+					item.Deleted = time.Unix(0, ns)
+				}
+			}
+
+			trash = append(trash, item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(trash, func(i, j int) bool {
+		return trash[i].Deleted.After(trash[j].Deleted)
+	})
+
+	return trash, nil
+}
+
+// RestoreContent moves deleted content from the trash back to its type, with
+// the id it had before it was deleted. Content which is not yet published, or
+// has expired, is restored to the __scheduled bucket of its type. If its slug has been taken by other
+// content in the meantime, a number is added to make it unique.
+// The `target` argument is a string made up of namespace:id (string:int)
+func RestoreContent(target string) error {
+	t := strings.Split(target, ":")
+	ns, id := t[0], t[1]
+
+	var j []byte
+	var public bool
+	err := store.Update(func(tx *bolt.Tx) error {
+		tb := tx.Bucket([]byte(ns + "__trash"))
+		if tb == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		data := tb.Get([]byte(id))
+		if data == nil {
+			return fmt.Errorf("Content not found in trash: %s", target)
+		}
+		j = append([]byte(nil), data...)
+
+		// restore the slug to __contentIndex, unless it's been taken
+		if slug := gjson.GetBytes(j, "slug").String(); slug != "" {
+			ci := tx.Bucket([]byte("__contentIndex"))
+			if ci == nil {
+				return bolt.ErrBucketNotFound
+			}
+
+			unique := slug
+			for i := 1; ci.Get([]byte(unique)) != nil; i++ {
+				unique = fmt.Sprintf("%s-%d", slug, i)
+			}
+
+			if unique != slug {
+				var err error
+				j, err = sjson.SetBytes(j, "slug", unique)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := ci.Put([]byte(unique), []byte(target))
+			if err != nil {
+				return err
+			}
+		}
+
+		// content is restored as public or scheduled to match its publish and
+		// expire times
+		var err error
+		_, public, _, err = schedule(tx, ns, id, j)
+		if err != nil {
+			return err
+		}
+
+		err = tb.Delete([]byte(id))
+		if err != nil {
+			return err
+		}
+
+		tr := tx.Bucket([]byte("__trashed"))
+		if tr == nil {
+			return nil
+		}
+
+		return tr.Delete(trashKey(ns, id))
+	})
+	if err != nil {
+		return err
+	}
+
+	if !public {
+		return nil
+	}
+
+	go func() {
+		// add data back to search index
+		err := search.UpdateIndex(target, j)
+		if err != nil {
+			log.Println("[search] UpdateIndex Error:", err)
+		}
+	}()
+
+	// sort now so restored content is listed in the admin right away, as with
+	// DeleteContent
+	SortContent(ns)
+
+	return nil
+}
+
+// PurgeTrash permanently deletes content which has been in the trash longer
+// than the retention period, and returns the number of items deleted
+func PurgeTrash(retention time.Duration) (int, error) {
+	var targets []string
+	cutoff := time.Now().Add(-retention)
+	err := store.View(func(tx *bolt.Tx) error {
+		t := tx.Bucket([]byte("__trashed"))
+		if t == nil {
+			return nil
+		}
+
+		return t.ForEach(func(k, v []byte) error {
+			ns, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if time.Unix(0, ns).Before(cutoff) {
+				target := strings.Split(string(k), ":")
+				targets = append(targets, target[0]+"__trash:"+target[1])
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, target := range targets {
+		err := DeleteContent(target)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(targets), nil
+}
+
+// trashRetention returns how long deleted content is kept in the trash, from
+// the system configuration. Zero means deleted content is kept forever.
+func trashRetention() time.Duration {
+	days, _ := ConfigCache("trash_retention_days").(float64)
+	if days == 0 {
+		days = DefaultTrashRetention
+	}
+
+	if days < 0 {
+		return 0
+	}
+
+	return time.Duration(days*24) * time.Hour
+}
+
+// purgeTrash periodically deletes content from the trash once it is older than
+// the configured retention period
+func purgeTrash() {
+	for {
+		if retention := trashRetention(); retention > 0 {
+			n, err := PurgeTrash(retention)
+			if err != nil {
+				log.Println("Error purging trash:", err)
+			} else if n > 0 {
+				log.Println("Purged", n, "items from trash")
+			}
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
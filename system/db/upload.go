@@ -2,6 +2,7 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -11,7 +12,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ponzu-cms/ponzu/system/cfg"
+	"github.com/ponzu-cms/ponzu/system/imaging"
 	"github.com/ponzu-cms/ponzu/system/item"
+	"github.com/ponzu-cms/ponzu/system/storage"
 
 	"github.com/boltdb/bolt"
 	"github.com/gofrs/uuid"
@@ -207,7 +211,7 @@ func UploadAll() [][]byte {
 }
 
 // DeleteUpload removes the value for an upload at its key id, based on the
-// target provided i.e. __uploads:{id}
+// target provided i.e. __uploads:{id}, and the uploaded file from storage
 func DeleteUpload(target string) error {
 	parts := strings.Split(target, ":")
 	if len(parts) < 2 {
@@ -218,6 +222,32 @@ func DeleteUpload(target string) error {
 		return err
 	}
 
+	// delete the stored file first, so a failure leaves the record to retry
+	data, err := Upload(target)
+	if err != nil {
+		return err
+	}
+
+	if len(data) > 0 {
+		upload := item.FileUpload{}
+		err = json.Unmarshal(data, &upload)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(upload.Path, "/api/uploads/")
+		err = storage.Uploads().Delete(context.Background(), name)
+		if err != nil && err != storage.ErrNotExist {
+			return err
+		}
+
+		cache := &imaging.Cache{Dir: cfg.ImageCacheDir()}
+		err = cache.Purge(name)
+		if err != nil {
+			log.Println("Error removing resized images of upload:", name, err)
+		}
+	}
+
 	return store.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(parts[0]))
 		if b == nil {
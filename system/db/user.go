@@ -21,6 +21,30 @@ var ErrUserExists = errors.New("Error. User exists.")
 // ErrNoUserExists is used for the db to report to admin user of non-existing user
 var ErrNoUserExists = errors.New("Error. No user exists.")
 
+func init() {
+	// let user.Auth find the user making a request, and check their role
+	user.SetLookup(func(email string) (*user.User, error) {
+		j, err := User(email)
+		if err != nil {
+			return nil, err
+		}
+
+		usr := &user.User{}
+		err = json.Unmarshal(j, usr)
+		if err != nil {
+			return nil, err
+		}
+
+		return usr, nil
+	})
+
+	// let user.IsValid reject tokens from sessions which have been revoked
+	user.SetSessionCheck(func(sid, ip string) bool {
+		_, err := TouchSession(sid, ip)
+		return err == nil
+	})
+}
+
 // SetUser sets key:value pairs in the db for user settings
 func SetUser(usr *user.User) (int, error) {
 	err := store.Update(func(tx *bolt.Tx) error {
@@ -112,6 +136,49 @@ func UpdateUser(usr, updatedUsr *user.User) error {
 	return nil
 }
 
+// UpdateUserWith reads the user by email and saves the changes made to it by
+// update, in the same transaction, so they can't be interleaved with another
+// change to the user. Nothing is saved if update returns an error, which is
+// returned. The user's ID and email address can't be changed.
+func UpdateUserWith(email string, update func(usr *user.User) error) (*user.User, error) {
+	usr := &user.User{}
+	err := store.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("__users"))
+		if users == nil {
+			return bolt.ErrBucketNotFound
+		}
+
+		j := users.Get([]byte(email))
+		if j == nil {
+			return ErrNoUserExists
+		}
+
+		err := json.Unmarshal(j, usr)
+		if err != nil {
+			return err
+		}
+
+		id := usr.ID
+		err = update(usr)
+		if err != nil {
+			return err
+		}
+		usr.ID, usr.Email = id, email
+
+		j, err = json.Marshal(usr)
+		if err != nil {
+			return err
+		}
+
+		return users.Put([]byte(email), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usr, nil
+}
+
 // DeleteUser deletes a user from the db by email
 func DeleteUser(email string) error {
 	err := store.Update(func(tx *bolt.Tx) error {
@@ -125,7 +192,15 @@ func DeleteUser(email string) error {
 			return err
 		}
 
-		return nil
+		// end the deleted user's sessions
+		sessions := tx.Bucket([]byte("__sessions"))
+		if sessions == nil {
+			return nil
+		}
+
+		return deleteSessions(sessions, func(s Session) bool {
+			return s.Email == email
+		})
 	})
 	if err != nil {
 		return err
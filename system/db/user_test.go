@@ -0,0 +1,93 @@
+package db
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/ponzu-cms/ponzu/system/admin/user"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestUpdateUserWith(t *testing.T) {
+	defer openTestStore(t)()
+
+	secret, err := user.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usr := &user.User{ID: 1, Email: "admin@example.com", TOTPSecret: secret}
+	codes, err := usr.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__users"))
+		if err != nil {
+			return err
+		}
+
+		j, err := json.Marshal(usr)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(usr.Email), j)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a recovery code used by many requests at once is only accepted once
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var accepted int
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := UpdateUserWith(usr.Email, func(u *user.User) error {
+				if !u.VerifyTwoFactor(codes[0]) {
+					return user.ErrInvalidCode
+				}
+
+				return nil
+			})
+			if err == user.ErrInvalidCode {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("expected the recovery code to be accepted once, got %d", accepted)
+	}
+
+	j, err := User(usr.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved user.User
+	err = json.Unmarshal(j, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(saved.RecoveryCodes) != user.RecoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes left, got %d", user.RecoveryCodeCount-1, len(saved.RecoveryCodes))
+	}
+}
@@ -0,0 +1,561 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Webhook events, sent when content of a type is changed
+const (
+	WebhookCreate  = "create"
+	WebhookUpdate  = "update"
+	WebhookDelete  = "delete"
+	WebhookApprove = "approve"
+	WebhookReject  = "reject"
+	WebhookPublish = "publish" // scheduled content reaches its publish time
+	WebhookExpire  = "expire"  // public content reaches its expire time
+)
+
+// WebhookEvents are the events a webhook can subscribe to, in display order
+var WebhookEvents = []string{
+	WebhookCreate, WebhookUpdate, WebhookDelete, WebhookApprove, WebhookReject,
+	WebhookPublish, WebhookExpire,
+}
+
+// Webhook delivery policy. A delivery which fails is retried after a delay
+// which doubles with each attempt, starting at WebhookRetryDelay, until it has
+// been attempted MaxWebhookAttempts times. Deliveries are kept in the log for
+// WebhookDeliveryRetention after their last attempt.
+const (
+	MaxWebhookAttempts       = 6
+	WebhookRetryDelay        = time.Second * 30
+	WebhookTimeout           = time.Second * 10
+	WebhookDeliveryRetention = time.Hour * 24 * 7
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSignatureHeader holds the HMAC-SHA256 signature of a delivery's body,
+// keyed with the webhook's secret, as "sha256=<hex>"
+const WebhookSignatureHeader = "X-Ponzu-Signature"
+
+// ErrNoWebhook is returned for a webhook or delivery which doesn't exist
+var ErrNoWebhook = errors.New("No webhook found")
+
+// webhookInterval is how often pending deliveries are checked when none have
+// been queued
+var webhookInterval = time.Second * 10
+
+// webhookQueued wakes the delivery loop when a delivery is queued
+var webhookQueued = make(chan struct{}, 1)
+
+var webhookClient = &http.Client{Timeout: WebhookTimeout}
+
+// Webhook is a URL which is sent a signed POST request when content changes,
+// for each of its Events, given as "Type:event" e.g. "Song:update". An event
+// for all types is given as "*:event".
+type Webhook struct {
+	ID      int      `json:"id"`
+	URL     string   `json:"url"`
+	Secret  string   `json:"secret"`
+	Events  []string `json:"events"`
+	Created int64    `json:"created"` // milliseconds since Unix epoch
+}
+
+// Subscribed reports whether the webhook is sent the event for content of type t
+func (w Webhook) Subscribed(t, event string) bool {
+	for _, e := range w.Events {
+		if e == t+":"+event || e == "*:"+event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookPayload is the json body of a webhook delivery
+type WebhookPayload struct {
+	Event     string          `json:"event"`
+	Type      string          `json:"type"`
+	ID        string          `json:"id"`
+	Timestamp int64           `json:"timestamp"` // milliseconds since Unix epoch
+	Data      json.RawMessage `json:"data"`      // the content after the event, or before it was deleted
+}
+
+// WebhookDelivery is an attempt to send an event to a webhook, and its result
+type WebhookDelivery struct {
+	ID        uint64 `json:"id"`
+	Hook      int    `json:"hook"`
+	Event     string `json:"event"` // as "Type:event"
+	Payload   string `json:"payload"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	Response  int    `json:"response,omitempty"` // HTTP status code of the last attempt
+	Error     string `json:"error,omitempty"`
+	Created   int64  `json:"created"`             // milliseconds since Unix epoch
+	Next      int64  `json:"next"`                // milliseconds since Unix epoch
+	LastTried int64  `json:"last_tried"`          // milliseconds since Unix epoch
+	Redeliver uint64 `json:"redeliver,omitempty"` // the ID of the delivery this resends
+}
+
+func webhookKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
+
+// NewWebhook stores a webhook for the URL and events. A secret is generated
+// if one isn't provided.
+func NewWebhook(url, secret string, events []string) (*Webhook, error) {
+	if secret == "" {
+		b := make([]byte, 24)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		secret = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	w := &Webhook{
+		URL:     url,
+		Secret:  secret,
+		Events:  events,
+		Created: millis(time.Now()),
+	}
+
+	err := store.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("__webhooks"))
+		if err != nil {
+			return err
+		}
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		w.ID = int(id)
+
+		j, err := json.Marshal(w)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(webhookKey(id), j)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Webhooks returns all webhooks, oldest first
+func Webhooks() ([]Webhook, error) {
+	var hooks []Webhook
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhooks"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var w Webhook
+			err := json.Unmarshal(v, &w)
+			if err != nil {
+				return err
+			}
+
+			hooks = append(hooks, w)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
+
+// DeleteWebhook removes the webhook. Its pending deliveries fail when they are
+// next attempted.
+func DeleteWebhook(id int) error {
+	return store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhooks"))
+		if b == nil || b.Get(webhookKey(uint64(id))) == nil {
+			return ErrNoWebhook
+		}
+
+		return b.Delete(webhookKey(uint64(id)))
+	})
+}
+
+// webhook returns the webhook with the id, or nil if it doesn't exist
+func webhook(tx *bolt.Tx, id int) (*Webhook, error) {
+	b := tx.Bucket([]byte("__webhooks"))
+	if b == nil {
+		return nil, nil
+	}
+
+	j := b.Get(webhookKey(uint64(id)))
+	if j == nil {
+		return nil, nil
+	}
+
+	var w Webhook
+	err := json.Unmarshal(j, &w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+// putDelivery stores the delivery, assigning it an ID if it doesn't have one
+func putDelivery(tx *bolt.Tx, d *WebhookDelivery) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("__webhook_deliveries"))
+	if err != nil {
+		return err
+	}
+
+	if d.ID == 0 {
+		d.ID, err = b.NextSequence()
+		if err != nil {
+			return err
+		}
+	}
+
+	j, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(webhookKey(d.ID), j)
+}
+
+// wakeWebhooks starts delivering queued deliveries, if they aren't already
+// being delivered
+func wakeWebhooks() {
+	select {
+	case webhookQueued <- struct{}{}:
+	default:
+	}
+}
+
+// QueueWebhooks queues a delivery of the event for the content with the id and
+// json data to each webhook subscribed to it. The type t may include a bucket
+// specifier, such as "Song__pending", in which case no webhooks are sent, as
+// the public content didn't change.
+func QueueWebhooks(event, t, id string, data []byte) error {
+	if strings.Contains(t, "__") {
+		return nil
+	}
+
+	now := millis(time.Now())
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+
+	payload, err := json.Marshal(WebhookPayload{
+		Event:     event,
+		Type:      t,
+		ID:        id,
+		Timestamp: now,
+		Data:      json.RawMessage(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	hooks, err := Webhooks()
+	if err != nil {
+		return err
+	}
+
+	var queued bool
+	err = store.Update(func(tx *bolt.Tx) error {
+		for _, w := range hooks {
+			if !w.Subscribed(t, event) {
+				continue
+			}
+
+			err := putDelivery(tx, &WebhookDelivery{
+				Hook:    w.ID,
+				Event:   t + ":" + event,
+				Payload: string(payload),
+				Status:  DeliveryPending,
+				Created: now,
+				Next:    now,
+			})
+			if err != nil {
+				return err
+			}
+
+			queued = true
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if queued {
+		wakeWebhooks()
+	}
+
+	return nil
+}
+
+// Redeliver queues a new delivery of the same payload as the delivery with the
+// id, to the same webhook. The original is no longer retried.
+func Redeliver(id uint64) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhook_deliveries"))
+		if b == nil {
+			return ErrNoWebhook
+		}
+
+		j := b.Get(webhookKey(id))
+		if j == nil {
+			return ErrNoWebhook
+		}
+
+		var orig WebhookDelivery
+		err := json.Unmarshal(j, &orig)
+		if err != nil {
+			return err
+		}
+
+		now := millis(time.Now())
+		d = WebhookDelivery{
+			Hook:      orig.Hook,
+			Event:     orig.Event,
+			Payload:   orig.Payload,
+			Status:    DeliveryPending,
+			Created:   now,
+			Next:      now,
+			Redeliver: orig.ID,
+		}
+
+		err = putDelivery(tx, &d)
+		if err != nil {
+			return err
+		}
+
+		// stop retrying the original, so the payload isn't sent twice
+		if orig.Status == DeliveryPending {
+			orig.Status = DeliveryFailed
+			orig.Error = fmt.Sprintf("Redelivered as #%d", d.ID)
+			return putDelivery(tx, &orig)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	wakeWebhooks()
+	return &d, nil
+}
+
+// WebhookDeliveries returns up to limit deliveries to the webhook with the id,
+// or to any webhook if the id is 0, most recent first
+func WebhookDeliveries(hook, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := store.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhook_deliveries"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(deliveries) < limit; k, v = c.Prev() {
+			var d WebhookDelivery
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+
+			if hook == 0 || d.Hook == hook {
+				deliveries = append(deliveries, d)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// SignWebhook returns the signature of a delivery's body for the secret, as sent
+// in the WebhookSignatureHeader
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver sends the delivery to the webhook, and returns the response's status
+// code
+func deliver(w *Webhook, d *WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Ponzu-Webhook")
+	req.Header.Set("X-Ponzu-Event", d.Event)
+	req.Header.Set("X-Ponzu-Delivery", strconv.FormatUint(d.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, []byte(d.Payload)))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// read some of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 1024*64))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("Webhook responded with %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
+
+// DeliverWebhooks attempts each pending delivery which is due, and removes old
+// deliveries from the log. It returns the number of deliveries attempted.
+func DeliverWebhooks() (int, error) {
+	var due []WebhookDelivery
+	now := time.Now()
+	retention := millis(now.Add(-WebhookDeliveryRetention))
+	err := store.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("__webhook_deliveries"))
+		if b == nil {
+			return nil
+		}
+
+		var old [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var d WebhookDelivery
+			err := json.Unmarshal(v, &d)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case d.Status == DeliveryPending && d.Next <= millis(now):
+				due = append(due, d)
+			case d.Status != DeliveryPending && d.LastTried < retention:
+				old = append(old, append([]byte{}, k...))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range old {
+			err := b.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range due {
+		d := &due[i]
+
+		var w *Webhook
+		err := store.View(func(tx *bolt.Tx) error {
+			var err error
+			w, err = webhook(tx, d.Hook)
+			return err
+		})
+		if err != nil {
+			return i, err
+		}
+
+		d.Attempts++
+		d.LastTried = millis(time.Now())
+		if w == nil {
+			d.Response, err = 0, ErrNoWebhook
+		} else {
+			d.Response, err = deliver(w, d)
+		}
+
+		switch {
+		case err == nil:
+			d.Status = DeliveryDelivered
+			d.Error = ""
+		case w == nil || d.Attempts >= MaxWebhookAttempts:
+			d.Status = DeliveryFailed
+			d.Error = err.Error()
+		default:
+			delay := WebhookRetryDelay * time.Duration(math.Pow(2, float64(d.Attempts-1)))
+			d.Next = millis(time.Now().Add(delay))
+			d.Error = err.Error()
+		}
+
+		err = store.Update(func(tx *bolt.Tx) error {
+			return putDelivery(tx, d)
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// deliverWebhooks delivers webhooks as they are queued, and retries those which
+// failed when they are due
+func deliverWebhooks() {
+	for {
+		_, err := DeliverWebhooks()
+		if err != nil {
+			log.Println("Error delivering webhooks:", err)
+		}
+
+		select {
+		case <-webhookQueued:
+		case <-time.After(webhookInterval):
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package imaging
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash returns the BlurHash of the image (https://blurha.sh), a short string
+// which frontends decode to a blurred placeholder while the image loads. It has
+// 4 by 3 components, or 3 by 4 for portrait images.
+func Blurhash(img image.Image) string {
+	nx, ny := 4, 3
+	if img.Bounds().Dy() > img.Bounds().Dx() {
+		nx, ny = 3, 4
+	}
+
+	// the placeholder is blurred anyway, so it is computed from a small copy
+	small := Transform(img, Options{Width: 32, Height: 32})
+	w, h := small.Bounds().Dx(), small.Bounds().Dy()
+
+	linear := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := small.Pix[small.PixOffset(x, y):]
+			a := float64(p[3])
+
+			// the pixels are premultiplied, and are put on white
+			for c := 0; c < 3; c++ {
+				v := float64(p[c]) + 255 - a
+				linear[y*w+x][c] = sRGBToLinear(v)
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+
+					for c := 0; c < 3; c++ {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+
+			for c := 0; c < 3; c++ {
+				f[c] /= float64(w * h)
+			}
+
+			factors = append(factors, f)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(encode83((nx-1)+(ny-1)*9, 1))
+
+	maxAC := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			for _, v := range f {
+				actual = math.Max(actual, math.Abs(v))
+			}
+		}
+
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(quantised+1) / 166
+		b.WriteString(encode83(quantised, 1))
+	} else {
+		b.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	b.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, f := range factors[1:] {
+		var q [3]int
+		for c, v := range f {
+			q[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+		}
+
+		b.WriteString(encode83(q[0]*19*19+q[1]*19+q[2], 2))
+	}
+
+	return b.String()
+}
+
+func encode83(v, length int) string {
+	s := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		s[i] = base83[v%83]
+		v /= 83
+	}
+
+	return string(s)
+}
+
+func sRGBToLinear(v float64) float64 {
+	v /= 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,116 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Info describes an image
+type Info struct {
+	Width    int
+	Height   int
+	Format   string
+	Blurhash string
+}
+
+// Decode decodes a JPEG, PNG or GIF image, or returns image.ErrFormat. Images
+// with more than MaxPixels aren't decoded, and ErrTooLarge is returned.
+func Decode(r io.ReadSeeker) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, format, ErrTooLarge
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return image.Decode(r)
+}
+
+// Describe returns the dimensions and format of the image, and its blurhash
+// unless it is larger than MaxPixels
+func Describe(r io.ReadSeeker) (*Info, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Width: config.Width, Height: config.Height, Format: format}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := Decode(r)
+	if err == ErrTooLarge {
+		return info, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info.Blurhash = Blurhash(img)
+	return info, nil
+}
+
+// Encode writes the image in the format, jpeg, png, gif or webp. JPEGs have no
+// transparency, so transparent images are put on white.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+
+	case "gif":
+		return gif.Encode(w, img, nil)
+
+	case "webp":
+		return EncodeWebP(w, img)
+	}
+
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+
+	if !opaque(img) {
+		bg := image.NewRGBA(img.Bounds())
+		draw.Draw(bg, bg.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(bg, bg.Bounds(), img, img.Bounds().Min, draw.Over)
+		img = bg
+	}
+
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+
+	return false
+}
+
+// ContentType returns the media type of the format
+func ContentType(format string) string {
+	return "image/" + format
+}
+
+// Extension returns the file extension of the format
+func Extension(format string) string {
+	if format == "jpeg" {
+		return ".jpg"
+	}
+
+	return "." + format
+}
@@ -0,0 +1,333 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
+)
+
+// work limits how many images are decoded and resized at once
+var work = make(chan struct{}, runtime.NumCPU())
+
+// Cache stores the variants of images on disk, in a directory for each image
+// named by its path, so they are only resized once
+type Cache struct {
+	Dir string
+
+	// MaxBytes returns the most the variants may take up on disk, or 0 if there
+	// is no limit. Once they take up more, the least recently used are removed.
+	MaxBytes func() int64
+
+	locks [64]sync.Mutex
+
+	mu    sync.Mutex // guards size and sized, and is held while evicting
+	size  int64
+	sized bool
+}
+
+// path returns the path of the variant, which is named by the options and the
+// size and modification time of the original, so it is remade if the original
+// is replaced
+func (c *Cache) path(obj *storage.Object, o Options, format string) string {
+	key := fmt.Sprintf("%s?%s&size=%d&modified=%d", obj.Name, o, obj.Size, obj.Modified.UnixNano())
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(c.Dir, filepath.FromSlash(obj.Name), hex.EncodeToString(sum[:16])+Extension(format))
+}
+
+func (c *Cache) lock(file string) *sync.Mutex {
+	sum := sha256.Sum256([]byte(file))
+	return &c.locks[int(sum[0])%len(c.locks)]
+}
+
+// Purge removes the cached variants of the image
+func (c *Cache) Purge(name string) error {
+	if name == "" || path.Clean("/"+name) != "/"+name {
+		return nil
+	}
+
+	c.mu.Lock()
+	c.sized = false
+	c.mu.Unlock()
+
+	return os.RemoveAll(filepath.Join(c.Dir, filepath.FromSlash(name)))
+}
+
+// variant is a file in the cache
+type variant struct {
+	path     string
+	size     int64
+	modified time.Time
+}
+
+// variants lists the files in the cache, and returns their total size
+func (c *Cache) variants() ([]variant, int64, error) {
+	var files []variant
+	var total int64
+	err := filepath.Walk(c.Dir, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".variant-") {
+			return nil
+		}
+
+		files = append(files, variant{path: p, size: info.Size(), modified: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+
+	return files, total, err
+}
+
+// added counts a new variant towards the size of the cache, and removes the
+// least recently used variants if the cache is larger than its limit, down to
+// nine tenths of the limit so that it isn't walked for every new variant
+func (c *Cache) added(size int64) error {
+	if c.MaxBytes == nil {
+		return nil
+	}
+
+	max := c.MaxBytes()
+	if max <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.sized {
+		_, total, err := c.variants()
+		if err != nil {
+			return err
+		}
+
+		c.size, c.sized = total, true
+	} else {
+		c.size += size
+	}
+
+	if c.size <= max {
+		return nil
+	}
+
+	files, total, err := c.variants()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modified.Before(files[j].modified)
+	})
+
+	for _, f := range files {
+		if total <= max/10*9 {
+			break
+		}
+
+		err := os.Remove(f.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		total -= f.size
+	}
+
+	c.size = total
+	return nil
+}
+
+// Handler serves the files in s like storage.FileServer, and images transformed
+// by the options in the query of their URL, or the named size in its "size"
+// parameter. Only named sizes may be requested unless anySize reports that the
+// request may ask for any size. Files which aren't JPEG, PNG or GIF images are
+// always served as they are stored.
+func Handler(s storage.Storage, cache *Cache, sizes func() []Size, anySize func(*http.Request) bool) http.Handler {
+	files := storage.FileServer(s)
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		o, err := ParseOptions(query)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if name := query.Get("size"); name != "" {
+			found := false
+			for _, size := range sizes() {
+				if size.Name == name {
+					o, found = size.Options, true
+					break
+				}
+			}
+
+			if !found {
+				http.Error(res, fmt.Sprintf("size %s is not defined", name), http.StatusBadRequest)
+				return
+			}
+		} else if !o.IsZero() && !anySize(req) {
+			// every other size would be resized and cached, so they aren't
+			// left to anyone to request
+			http.Error(res, "only the named sizes of images may be requested", http.StatusForbidden)
+			return
+		}
+
+		if o.IsZero() || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+			files.ServeHTTP(res, req)
+			return
+		}
+
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		obj, err := s.Stat(req.Context(), name)
+		if err == storage.ErrNotExist {
+			http.NotFound(res, req)
+			return
+		}
+		if err != nil {
+			log.Println("Error finding upload:", name, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		format := formatOf(obj)
+		if format == "" {
+			files.ServeHTTP(res, req)
+			return
+		}
+
+		if o.Format != "" {
+			format = o.Format
+		}
+
+		f, err := cache.open(req, s, obj, o, format)
+		if err == ErrTooLarge || err == image.ErrFormat {
+			http.Error(res, fmt.Sprintf("%s: %v", name, err), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			log.Println("Error transforming image:", name, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		res.Header().Set("Content-Type", ContentType(format))
+		http.ServeContent(res, req, path.Base(name), obj.Modified, f)
+	})
+}
+
+// formatOf returns the format of the stored image, if it can be transformed
+func formatOf(obj *storage.Object) string {
+	switch obj.ContentType {
+	case "image/jpeg":
+		return "jpeg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	}
+
+	switch strings.ToLower(path.Ext(obj.Name)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".png":
+		return "png"
+	case ".gif":
+		return "gif"
+	}
+
+	return ""
+}
+
+// open opens the variant of the image, which is made if it isn't cached. The
+// variant is opened before it can be evicted from the cache, so it can always
+// be served once it is open.
+func (c *Cache) open(req *http.Request, s storage.Storage, obj *storage.Object, o Options, format string) (*os.File, error) {
+	file := c.path(obj, o, format)
+	mu := c.lock(file)
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.Open(file)
+	if err == nil {
+		// the modification time of variants records when they were last
+		// used, to find those least recently used
+		now := time.Now()
+		os.Chtimes(file, now, now)
+		return f, nil
+	}
+
+	select {
+	case work <- struct{}{}:
+		defer func() { <-work }()
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+
+	src, _, err := s.Open(req.Context(), obj.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	img, _, err := Decode(src)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".variant-")
+	if err != nil {
+		return nil, err
+	}
+
+	err = Encode(tmp, Transform(img, o), format, o.Quality)
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	info, err := tmp.Stat()
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	err = c.added(info.Size())
+	if err != nil {
+		log.Println("Error evicting image variants:", err)
+	}
+
+	return tmp, nil
+}
@@ -0,0 +1,187 @@
+// Package imaging resizes, crops and converts uploaded images on demand, by
+// options in the query of their URL such as "?w=400&h=300&fit=cover&fm=webp",
+// and describes images with their dimensions and a blurhash placeholder.
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxDimension is the largest width or height an image is resized to
+	MaxDimension = 4096
+
+	// MaxPixels is the largest number of pixels of an image which is decoded
+	MaxPixels = 40 * 1000 * 1000
+
+	// DefaultQuality is the quality JPEGs are encoded with
+	DefaultQuality = 85
+
+	// DefaultCacheSize is the most the cached variants of images take up on
+	// disk, in bytes, unless another limit is set in the system configuration
+	DefaultCacheSize = 1024 * 1024 * 1024
+)
+
+// Ways an image is fit into the width and height
+const (
+	FitContain = "contain" // scale to fit within them, keeping the aspect ratio
+	FitCover   = "cover"   // scale to cover them and crop the rest, from the center
+	FitFill    = "fill"    // stretch to them, ignoring the aspect ratio
+)
+
+var (
+	// ErrTooLarge is returned for images with more than MaxPixels
+	ErrTooLarge = errors.New("image is too large to process")
+
+	sizeName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+)
+
+// Options describe how an image is transformed. A zero Width or Height is
+// derived from the other by the aspect ratio of the image, and the image keeps
+// its format if Format is empty.
+type Options struct {
+	Width   int
+	Height  int
+	Fit     string // FitContain if empty
+	Format  string // jpeg, png, gif or webp
+	Quality int    // of JPEGs, from 1 to 100, DefaultQuality if zero
+}
+
+// Size is a named set of options, such as "thumbnail"
+type Size struct {
+	Name string
+	Options
+}
+
+// IsZero reports whether the options leave the image as it is
+func (o Options) IsZero() bool {
+	return o == Options{}
+}
+
+// String returns the options as a query, with keys in a fixed order
+func (o Options) String() string {
+	var q []string
+	if o.Width > 0 {
+		q = append(q, fmt.Sprintf("w=%d", o.Width))
+	}
+
+	if o.Height > 0 {
+		q = append(q, fmt.Sprintf("h=%d", o.Height))
+	}
+
+	if o.Fit != "" {
+		q = append(q, "fit="+o.Fit)
+	}
+
+	if o.Format != "" {
+		q = append(q, "fm="+o.Format)
+	}
+
+	if o.Quality > 0 {
+		q = append(q, fmt.Sprintf("q=%d", o.Quality))
+	}
+
+	return strings.Join(q, "&")
+}
+
+// ParseOptions parses the options from the query: w and h in pixels, fit as
+// contain, cover or fill, fm as jpg, png, gif or webp, and q from 1 to 100
+func ParseOptions(query url.Values) (Options, error) {
+	var o Options
+	var err error
+
+	o.Width, err = parseInt(query, "w", MaxDimension)
+	if err != nil {
+		return o, err
+	}
+
+	o.Height, err = parseInt(query, "h", MaxDimension)
+	if err != nil {
+		return o, err
+	}
+
+	o.Quality, err = parseInt(query, "q", 100)
+	if err != nil {
+		return o, err
+	}
+
+	switch fit := query.Get("fit"); fit {
+	case "", FitContain, FitCover, FitFill:
+		o.Fit = fit
+	default:
+		return o, fmt.Errorf("fit must be %s, %s or %s", FitContain, FitCover, FitFill)
+	}
+
+	switch fm := strings.ToLower(query.Get("fm")); fm {
+	case "":
+	case "jpg", "jpeg":
+		o.Format = "jpeg"
+	case "png", "gif", "webp":
+		o.Format = fm
+	default:
+		return o, errors.New("fm must be jpg, png, gif or webp")
+	}
+
+	return o, nil
+}
+
+func parseInt(query url.Values, key string, max int) (int, error) {
+	v := query.Get(key)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("%s must be a number from 1 to %d", key, max)
+	}
+
+	return n, nil
+}
+
+// ParseSizes parses named sizes, one per line, as a name followed by a colon and
+// its options as a query, i.e. "thumbnail: w=200&h=200&fit=cover"
+func ParseSizes(text string) ([]Size, error) {
+	var sizes []Size
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !sizeName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: expected a lowercase name, a colon and options, i.e. thumbnail: w=200&h=200", i+1)
+		}
+
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: size %s is named twice", i+1, name)
+		}
+		seen[name] = true
+
+		query, err := url.ParseQuery(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		o, err := ParseOptions(query)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		if o.IsZero() {
+			return nil, fmt.Errorf("line %d: size %s has no options", i+1, name)
+		}
+
+		sizes = append(sizes, Size{Name: name, Options: o})
+	}
+
+	return sizes, nil
+}
@@ -0,0 +1,326 @@
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ponzu-cms/ponzu/system/storage"
+	"golang.org/x/image/webp"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+
+	return img
+}
+
+func TestParseOptions(t *testing.T) {
+	cases := map[string]string{
+		"w=400&h=300&fit=cover&fm=webp": "w=400&h=300&fit=cover&fm=webp",
+		"fm=JPG&q=70&w=10":              "w=10&fm=jpeg&q=70",
+		"":                              "",
+		"w=0":                           "error",
+		"w=5000":                        "error",
+		"h=abc":                         "error",
+		"fit=stretch":                   "error",
+		"fm=bmp":                        "error",
+		"q=101":                         "error",
+	}
+
+	for query, expected := range cases {
+		values, _ := url.ParseQuery(query)
+		o, err := ParseOptions(values)
+		got := o.String()
+		if err != nil {
+			got = "error"
+		}
+
+		if got != expected {
+			t.Errorf("%q: expected %q, got %q (%v)", query, expected, got, err)
+		}
+	}
+}
+
+func TestParseSizes(t *testing.T) {
+	sizes, err := ParseSizes("thumbnail: w=200&h=200&fit=cover\n\n  hero : w=1600&fm=webp \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sizes) != 2 || sizes[0].Name != "thumbnail" || sizes[1].Name != "hero" ||
+		sizes[0].String() != "w=200&h=200&fit=cover" || sizes[1].String() != "w=1600&fm=webp" {
+		t.Errorf("parsed %+v", sizes)
+	}
+
+	for _, text := range []string{"thumbnail", "Thumb: w=10", "a: w=10\na: h=10", "a: w=x", "a: "} {
+		_, err = ParseSizes(text)
+		if err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	img := solid(800, 400, color.NRGBA{200, 100, 50, 255})
+	cases := []struct {
+		o    Options
+		w, h int
+	}{
+		{Options{Width: 400}, 400, 200},
+		{Options{Height: 100}, 200, 100},
+		{Options{Width: 400, Height: 400}, 400, 200},
+		{Options{Width: 400, Height: 400, Fit: FitCover}, 400, 400},
+		{Options{Width: 400, Height: 400, Fit: FitFill}, 400, 400},
+		{Options{Width: 1600}, 800, 400},
+		{Options{Width: 1000, Height: 1000, Fit: FitCover}, 400, 400},
+		{Options{Width: 1600, Fit: FitFill}, 1600, 800},
+		{Options{Format: "png"}, 800, 400},
+	}
+
+	for _, c := range cases {
+		out := Transform(img, c.o)
+		if out.Bounds().Dx() != c.w || out.Bounds().Dy() != c.h {
+			t.Errorf("%s: expected %dx%d, got %v", c.o, c.w, c.h, out.Bounds())
+		}
+
+		// resampling keeps a solid color
+		got := out.RGBAAt(out.Bounds().Dx()/2, out.Bounds().Dy()/2)
+		if got != (color.RGBA{200, 100, 50, 255}) {
+			t.Errorf("%s: expected the color to be kept, got %v", c.o, got)
+		}
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	// the size flag of 4 by 3 components, the maximum AC component, the average
+	// color and 11 AC components
+	hash := Blurhash(solid(64, 48, color.NRGBA{255, 0, 0, 255}))
+	if hash[0] != 'L' || hash[2:6] != encode83(255<<16, 4) || len(hash) != 28 {
+		t.Errorf("unexpected hash for a red image %s", hash)
+	}
+
+	// transparent pixels are white
+	hash = Blurhash(solid(64, 48, color.NRGBA{0, 0, 0, 0}))
+	if hash[2:6] != encode83(0xffffff, 4) {
+		t.Errorf("unexpected hash for a transparent image %s", hash)
+	}
+
+	// portrait images have 3 by 4 components
+	hash = Blurhash(solid(48, 64, color.NRGBA{0, 0, 255, 255}))
+	if hash[0] != base83[2+3*9] || len(hash) != 28 {
+		t.Errorf("unexpected hash for a portrait image %s", hash)
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	random := image.NewNRGBA(image.Rect(0, 0, 97, 61))
+	rnd.Read(random.Pix)
+	for i := 3; i < len(random.Pix); i += 4 {
+		random.Pix[i] = 0xff
+	}
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	alpha := image.NewNRGBA(image.Rect(0, 0, 65, 33))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x + y), 0xff})
+			if x < 65 && y < 33 {
+				alpha.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), 0x80, uint8(y * 8), uint8(x * y)})
+			}
+		}
+	}
+
+	cases := []struct {
+		name string
+		img  *image.NRGBA
+		max  int
+	}{
+		{"solid", solid(300, 200, color.NRGBA{10, 200, 30, 255}), 200},
+		{"translucent", solid(33, 17, color.NRGBA{1, 2, 3, 128}), 200},
+		{"random", random, len(random.Pix) * 2},
+		{"gradient", gradient, len(gradient.Pix) / 4},
+		{"alpha", alpha, len(alpha.Pix)},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		err := EncodeWebP(&buf, c.img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.Len() > c.max {
+			t.Errorf("%s: encoded to %d bytes, expected at most %d", c.name, buf.Len(), c.max)
+		}
+
+		b := buf.Bytes()
+		if size := binary.LittleEndian.Uint32(b[4:8]); int(size) != len(b)-8 {
+			t.Errorf("%s: RIFF size %d for %d bytes", c.name, size, len(b))
+		}
+
+		img, err := webp.Decode(&buf)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		decoded, ok := img.(*image.NRGBA)
+		if !ok || decoded.Rect != c.img.Rect {
+			t.Errorf("%s: decoded to a %T of %v", c.name, img, img.Bounds())
+			continue
+		}
+
+		if !bytes.Equal(decoded.Pix, c.img.Pix) {
+			t.Errorf("%s: decoded pixels differ", c.name)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ponzu-imaging-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	err = png.Encode(&buf, solid(200, 100, color.NRGBA{0, 128, 0, 255}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &storage.Local{Dir: filepath.Join(dir, "uploads")}
+	ctx := context.Background()
+	err = s.Put(ctx, "2024/05/photo.png", &buf, int64(buf.Len()), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Put(ctx, "2024/05/notes.txt", strings.NewReader("notes"), 5, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &Cache{Dir: filepath.Join(dir, "cache")}
+	sizes := func() []Size {
+		return []Size{{Name: "thumbnail", Options: Options{Width: 50, Height: 50, Fit: FitCover}}}
+	}
+
+	// requests with an X-Any-Size header may ask for any size
+	anySize := func(req *http.Request) bool {
+		return req.Header.Get("X-Any-Size") != ""
+	}
+	srv := httptest.NewServer(http.StripPrefix("/api/uploads/", Handler(s, cache, sizes, anySize)))
+	defer srv.Close()
+
+	get := func(query string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/uploads/"+strings.TrimPrefix(query, "!"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(query, "!") {
+			req.Header.Set("X-Any-Size", "true")
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		b, _ := ioutil.ReadAll(res.Body)
+		return res, b
+	}
+
+	cases := []struct {
+		query       string
+		status      int
+		contentType string
+		w, h        int
+	}{
+		{"2024/05/photo.png", 200, "image/png", 200, 100},
+		{"2024/05/photo.png?w=100", 200, "image/png", 100, 50},
+		{"2024/05/photo.png?size=thumbnail", 200, "image/png", 50, 50},
+		{"2024/05/photo.png?w=20&fm=jpg", 200, "image/jpeg", 20, 10},
+		{"2024/05/photo.png?w=20&fm=webp", 200, "image/webp", 0, 0},
+		{"2024/05/photo.png?w=100", 200, "image/png", 100, 50},
+		{"2024/05/photo.png?size=huge", 400, "", 0, 0},
+		{"2024/05/photo.png?w=-1", 400, "", 0, 0},
+		{"2024/05/missing.png?w=100", 404, "", 0, 0},
+		{"2024/05/notes.txt?w=100", 200, "text/plain", 0, 0},
+
+		// requests which may not ask for any size, marked with a "!"
+		{"!2024/05/photo.png?size=thumbnail", 200, "image/png", 50, 50},
+		{"!2024/05/photo.png", 200, "image/png", 200, 100},
+		{"!2024/05/photo.png?w=30", 403, "", 0, 0},
+	}
+
+	for _, c := range cases {
+		res, b := get(c.query)
+		if res.StatusCode != c.status {
+			t.Errorf("%s: expected %d, got %s: %s", c.query, c.status, res.Status, b)
+			continue
+		}
+
+		if c.contentType != "" && !strings.HasPrefix(res.Header.Get("Content-Type"), c.contentType) {
+			t.Errorf("%s: expected %s, got %s", c.query, c.contentType, res.Header.Get("Content-Type"))
+		}
+
+		if c.w > 0 {
+			config, _, err := image.DecodeConfig(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(c.query, err)
+			}
+
+			if config.Width != c.w || config.Height != c.h {
+				t.Errorf("%s: expected %dx%d, got %dx%d", c.query, c.w, c.h, config.Width, config.Height)
+			}
+		}
+	}
+
+	variants, _ := filepath.Glob(filepath.Join(cache.Dir, "2024", "05", "photo.png", "*"))
+	if len(variants) != 4 {
+		t.Errorf("expected 4 cached variants, got %v", variants)
+	}
+
+	// variants are removed beyond the limit of the cache, which is smaller
+	// than any of them, while the one just made is still served
+	cache.MaxBytes = func() int64 { return 1 }
+	res, _ := get("2024/05/photo.png?w=60")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected a variant, got %s", res.Status)
+	}
+
+	variants, _ = filepath.Glob(filepath.Join(cache.Dir, "2024", "05", "photo.png", "*"))
+	if len(variants) != 0 {
+		t.Errorf("expected the variants to be evicted, got %v", variants)
+	}
+
+	err = cache.Purge("2024/05/photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(filepath.Join(cache.Dir, "2024", "05", "photo.png")); !os.IsNotExist(err) {
+		t.Errorf("expected the variants to be purged, got %v", err)
+	}
+}
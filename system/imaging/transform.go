@@ -0,0 +1,194 @@
+package imaging
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Transform returns the image resized and cropped by the options. Images are
+// only enlarged to fill the width and height exactly.
+func Transform(img image.Image, o Options) *image.RGBA {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	crop := b
+	w, h := o.Width, o.Height
+
+	switch {
+	case w == 0 && h == 0:
+		w, h = srcW, srcH
+
+	case w == 0 || h == 0 || o.Fit == "" || o.Fit == FitContain:
+		scale := 1.0
+		if w > 0 {
+			scale = float64(w) / float64(srcW)
+		}
+
+		if h > 0 && (w == 0 || float64(h)/float64(srcH) < scale) {
+			scale = float64(h) / float64(srcH)
+		}
+
+		if scale > 1 && o.Fit != FitFill {
+			scale = 1
+		}
+
+		w, h = scaled(srcW, scale), scaled(srcH, scale)
+
+	case o.Fit == FitCover:
+		// crop the source to the aspect ratio, around its center
+		aspect := float64(w) / float64(h)
+		cropW, cropH := srcW, srcH
+		if float64(srcW)/float64(srcH) > aspect {
+			cropW = scaled(srcH, aspect)
+		} else {
+			cropH = scaled(srcW, 1/aspect)
+		}
+
+		x := b.Min.X + (srcW-cropW)/2
+		y := b.Min.Y + (srcH-cropH)/2
+		crop = image.Rect(x, y, x+cropW, y+cropH)
+
+		if w > cropW {
+			w, h = cropW, cropH
+		}
+	}
+
+	return resize(img, crop, w, h)
+}
+
+func scaled(n int, scale float64) int {
+	s := int(math.Round(float64(n) * scale))
+	if s < 1 {
+		return 1
+	}
+
+	return s
+}
+
+// resize resamples the rectangle r of img to w by h pixels with a Catmull-Rom
+// filter, widened when shrinking so every source pixel is taken into account
+func resize(img image.Image, r image.Rectangle, w, h int) *image.RGBA {
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(r)
+		draw.Draw(src, r, img, r.Min, draw.Src)
+	}
+
+	if r.Dx() == w && r.Dy() == h {
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), src, r.Min, draw.Src)
+		return dst
+	}
+
+	// resample the rows, then the columns, in premultiplied color
+	xw := weights(r.Dx(), w)
+	tmp := make([]float32, w*r.Dy()*4)
+	for y := 0; y < r.Dy(); y++ {
+		row := src.Pix[src.PixOffset(r.Min.X, r.Min.Y+y):]
+		for x, wt := range xw {
+			var c [4]float32
+			for i, f := range wt.w {
+				p := row[(wt.start+i)*4:]
+				c[0] += f * float32(p[0])
+				c[1] += f * float32(p[1])
+				c[2] += f * float32(p[2])
+				c[3] += f * float32(p[3])
+			}
+
+			copy(tmp[(y*w+x)*4:], c[:])
+		}
+	}
+
+	yw := weights(r.Dy(), h)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, wt := range yw {
+		for x := 0; x < w; x++ {
+			var c [4]float32
+			for i, f := range wt.w {
+				p := tmp[((wt.start+i)*w+x)*4:]
+				c[0] += f * p[0]
+				c[1] += f * p[1]
+				c[2] += f * p[2]
+				c[3] += f * p[3]
+			}
+
+			// the negative lobes of the filter can overshoot
+			a := clamp(c[3], 255)
+			d := dst.Pix[dst.PixOffset(x, y):]
+			d[0] = uint8(clamp(c[0], a) + 0.5)
+			d[1] = uint8(clamp(c[1], a) + 0.5)
+			d[2] = uint8(clamp(c[2], a) + 0.5)
+			d[3] = uint8(a + 0.5)
+		}
+	}
+
+	return dst
+}
+
+func clamp(v, max float32) float32 {
+	if v < 0 {
+		return 0
+	}
+
+	if v > max {
+		return max
+	}
+
+	return v
+}
+
+type weight struct {
+	start int
+	w     []float32
+}
+
+// weights returns the source pixels and their weights for each of the size
+// pixels a row or column of src pixels is resampled to
+func weights(src, size int) []weight {
+	scale := float64(src) / float64(size)
+	support := 2.0 // of the filter, in destination pixels
+	if scale > 1 {
+		support *= scale
+	}
+
+	ws := make([]weight, size)
+	for i := range ws {
+		center := (float64(i)+0.5)*scale - 0.5
+		start := int(math.Ceil(center - support))
+		end := int(math.Floor(center + support))
+		if start < 0 {
+			start = 0
+		}
+		if end > src-1 {
+			end = src - 1
+		}
+
+		var sum float64
+		w := make([]float64, end-start+1)
+		for j := range w {
+			x := (float64(start+j) - center) / math.Max(scale, 1)
+			w[j] = catmullRom(x)
+			sum += w[j]
+		}
+
+		ws[i].start = start
+		ws[i].w = make([]float32, len(w))
+		for j := range w {
+			ws[i].w[j] = float32(w[j] / sum)
+		}
+	}
+
+	return ws
+}
+
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return 1.5*x*x*x - 2.5*x*x + 1
+	case x < 2:
+		return -0.5*x*x*x + 2.5*x*x - 4*x + 2
+	}
+
+	return 0
+}
@@ -0,0 +1,556 @@
+package imaging
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// The lossless WebP (VP8L) bitstream, as specified at
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+const (
+	vp8lSignature     = 0x2f
+	vp8lMaxDimension  = 1 << 14
+	vp8lMaxCodeLength = 15
+
+	predictorTransform     = 0
+	subtractGreenTransform = 2
+
+	// tiles of the predictor transform are 1<<predictorBits pixels wide
+	predictorBits = 5
+
+	// copies are at most vp8lMaxCopyLength pixels, and runs shorter than
+	// minCopyLength are written as literals
+	vp8lMaxCopyLength = 4096
+	minCopyLength     = 4
+
+	// the distance code of the pixel to the left, the second of the codes for
+	// the pixels nearest the one being decoded
+	copyDistance = 2
+
+	// the predictor mode used for every tile, ClampAddSubtractFull(L, T, TL),
+	// which predicts gradients like the PNG Paeth filter
+	predictorMode = 12
+)
+
+// codeLengthOrder is the order the lengths of the code length code are written in
+var codeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes the image as a lossless WebP. The pixels are predicted from
+// their neighbours and entropy coded, with runs of a repeated pixel copied from
+// the one before but no other backward references, which keeps the encoder
+// simple at the cost of some compression.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.New("webp: image size is out of range")
+	}
+
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Rect.Min != (image.Point{}) {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	}
+
+	// pixels are 32-bit ARGB
+	argb := make([]uint32, width*height)
+	alpha := false
+	for y := 0; y < height; y++ {
+		row := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4:]
+			if p[3] != 0xff {
+				alpha = true
+			}
+
+			argb[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.writeBits(vp8lSignature, 8)
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	if alpha {
+		bw.writeBits(1, 1)
+	} else {
+		bw.writeBits(0, 1)
+	}
+	bw.writeBits(0, 3) // version
+
+	// the decoder undoes the transforms in the reverse order they're written
+	bw.writeBits(1, 1)
+	bw.writeBits(subtractGreenTransform, 2)
+	subtractGreen(argb)
+
+	bw.writeBits(1, 1)
+	bw.writeBits(predictorTransform, 2)
+	bw.writeBits(predictorBits-2, 3)
+	tilesX := subSampleSize(width, predictorBits)
+	tilesY := subSampleSize(height, predictorBits)
+	modes := make([]uint32, tilesX*tilesY)
+	for i := range modes {
+		modes[i] = 0xff000000 | predictorMode<<8
+	}
+	writeImageData(bw, modes, false)
+	argb = predict(argb, width, height)
+
+	bw.writeBits(0, 1) // no more transforms
+	writeImageData(bw, argb, true)
+
+	data := bw.bytes()
+	size := len(data)
+	padded := size + size&1
+
+	out := bufio.NewWriter(w)
+	out.WriteString("RIFF")
+	binary.Write(out, binary.LittleEndian, uint32(4+8+padded))
+	out.WriteString("WEBPVP8L")
+	binary.Write(out, binary.LittleEndian, uint32(size))
+	out.Write(data)
+	if size&1 == 1 {
+		out.WriteByte(0)
+	}
+
+	return out.Flush()
+}
+
+func subSampleSize(size, bits int) int {
+	return (size + 1<<uint(bits) - 1) >> uint(bits)
+}
+
+// subtractGreen subtracts the green of each pixel from its red and blue
+func subtractGreen(argb []uint32) {
+	for i, p := range argb {
+		g := (p >> 8) & 0xff
+		r := ((p >> 16) - g) & 0xff
+		b := (p - g) & 0xff
+		argb[i] = p&0xff00ff00 | r<<16 | b
+	}
+}
+
+// predict returns the residuals of the pixels from their prediction, which is
+// opaque black for the first pixel, the left pixel for the top row, the top
+// pixel for the left column and the predictorMode for the others
+func predict(argb []uint32, width, height int) []uint32 {
+	res := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+
+			var pred uint32
+			switch {
+			case x == 0 && y == 0:
+				pred = 0xff000000
+			case y == 0:
+				pred = argb[i-1]
+			case x == 0:
+				pred = argb[i-width]
+			default:
+				pred = clampAddSubtractFull(argb[i-1], argb[i-width], argb[i-width-1])
+			}
+
+			res[i] = subPixels(argb[i], pred)
+		}
+	}
+
+	return res
+}
+
+func clampAddSubtractFull(a, b, c uint32) uint32 {
+	var p uint32
+	for shift := uint(0); shift < 32; shift += 8 {
+		v := int((a>>shift)&0xff) + int((b>>shift)&0xff) - int((c>>shift)&0xff)
+		if v < 0 {
+			v = 0
+		} else if v > 255 {
+			v = 255
+		}
+
+		p |= uint32(v) << shift
+	}
+
+	return p
+}
+
+// subPixels subtracts each channel of b from a, modulo 256
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	rb := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return (ag & 0xff00ff00) | (rb & 0x00ff00ff)
+}
+
+// writeImageData writes the pixels entropy coded with a prefix code for each
+// channel, with runs of a repeated pixel copied from the pixel before. Only the
+// main image may have meta prefix codes, which aren't used.
+func writeImageData(bw *bitWriter, argb []uint32, main bool) {
+	bw.writeBits(0, 1) // no color cache
+	if main {
+		bw.writeBits(0, 1) // no meta prefix codes
+	}
+
+	// a copy is a run of pixels the same as the one before, and any other pixel
+	// is a literal
+	type token struct {
+		pixel uint32
+		run   int
+	}
+
+	var tokens []token
+	for i := 0; i < len(argb); {
+		run := 0
+		for i > 0 && i+run < len(argb) && run < vp8lMaxCopyLength && argb[i+run] == argb[i-1] {
+			run++
+		}
+
+		if run < minCopyLength {
+			tokens = append(tokens, token{pixel: argb[i]})
+			i++
+			continue
+		}
+
+		tokens = append(tokens, token{run: run})
+		i += run
+	}
+
+	// green and copy lengths, red, blue, alpha, distance
+	var hist [5][]int
+	hist[0] = make([]int, 256+24)
+	for i := 1; i < 4; i++ {
+		hist[i] = make([]int, 256)
+	}
+	hist[4] = make([]int, 40)
+
+	dist, _, _ := prefixEncode(copyDistance)
+	for _, t := range tokens {
+		if t.run > 0 {
+			length, _, _ := prefixEncode(t.run)
+			hist[0][256+length]++
+			hist[4][dist]++
+			continue
+		}
+
+		p := t.pixel
+		hist[0][(p>>8)&0xff]++
+		hist[1][(p>>16)&0xff]++
+		hist[2][p&0xff]++
+		hist[3][p>>24]++
+	}
+
+	var codes [5]prefixCode
+	for i := range hist {
+		codes[i] = writePrefixCode(bw, hist[i])
+	}
+
+	for _, t := range tokens {
+		if t.run > 0 {
+			length, extraBits, extra := prefixEncode(t.run)
+			codes[0].write(bw, 256+length)
+			bw.writeBits(uint32(extra), uint(extraBits))
+
+			dist, extraBits, extra := prefixEncode(copyDistance)
+			codes[4].write(bw, dist)
+			bw.writeBits(uint32(extra), uint(extraBits))
+			continue
+		}
+
+		p := t.pixel
+		codes[0].write(bw, int((p>>8)&0xff))
+		codes[1].write(bw, int((p>>16)&0xff))
+		codes[2].write(bw, int(p&0xff))
+		codes[3].write(bw, int(p>>24))
+	}
+}
+
+// prefixEncode returns the prefix symbol of a copy length or distance code of
+// at least one, and the extra bits that follow it
+func prefixEncode(n int) (symbol, extraBits, extra int) {
+	v := n - 1
+	if v < 4 {
+		return v, 0, 0
+	}
+
+	h := bits.Len(uint(v)) - 1
+	extraBits = h - 1
+	return 2*h + (v>>uint(h-1))&1, extraBits, v & (1<<uint(extraBits) - 1)
+}
+
+// prefixCode is a canonical prefix code, with the codes bit-reversed as they
+// are read from the least significant bit first
+type prefixCode struct {
+	lengths []int
+	codes   []uint32
+}
+
+func (c prefixCode) write(bw *bitWriter, symbol int) {
+	bw.writeBits(c.codes[symbol], uint(c.lengths[symbol]))
+}
+
+// writePrefixCode writes the prefix code for the histogram of symbols, and
+// returns it
+func writePrefixCode(bw *bitWriter, hist []int) prefixCode {
+	var used []int
+	for s, n := range hist {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+
+	// a simple code of one or two 8-bit symbols, which are read with no bits or
+	// one bit each
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		c := prefixCode{lengths: make([]int, len(hist)), codes: make([]uint32, len(hist))}
+
+		bw.writeBits(1, 1)
+		if len(used) < 2 {
+			symbol := 0
+			if len(used) == 1 {
+				symbol = used[0]
+			}
+
+			bw.writeBits(0, 1)
+			bw.writeBits(1, 1)
+			bw.writeBits(uint32(symbol), 8)
+			return c
+		}
+
+		bw.writeBits(1, 1)
+		bw.writeBits(1, 1)
+		bw.writeBits(uint32(used[0]), 8)
+		bw.writeBits(uint32(used[1]), 8)
+		c.lengths[used[0]], c.lengths[used[1]] = 1, 1
+		c.codes[used[1]] = 1
+		return c
+	}
+
+	lengths := codeLengths(hist, vp8lMaxCodeLength)
+	bw.writeBits(0, 1)
+	writeCodeLengths(bw, lengths)
+	return canonicalCode(lengths)
+}
+
+// writeCodeLengths writes the code lengths of a normal prefix code, themselves
+// prefix coded, with runs of zeros and repeated lengths
+func writeCodeLengths(bw *bitWriter, lengths []int) {
+	type token struct{ symbol, extra, extraBits int }
+
+	var tokens []token
+	prev := 8
+	for i := 0; i < len(lengths); {
+		l := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == l {
+			run++
+		}
+		i += run
+
+		for run > 0 {
+			switch {
+			case l == 0 && run >= 11:
+				n := minInt(run, 138)
+				tokens = append(tokens, token{18, n - 11, 7})
+				run -= n
+			case l == 0 && run >= 3:
+				n := minInt(run, 10)
+				tokens = append(tokens, token{17, n - 3, 3})
+				run -= n
+			case l != 0 && l == prev && run >= 3:
+				n := minInt(run, 6)
+				tokens = append(tokens, token{16, n - 3, 2})
+				run -= n
+			default:
+				tokens = append(tokens, token{l, 0, 0})
+				run--
+				if l != 0 {
+					prev = l
+				}
+			}
+		}
+	}
+
+	hist := make([]int, 19)
+	for _, t := range tokens {
+		hist[t.symbol]++
+	}
+
+	lengthLengths := codeLengths(hist, 7)
+	n := 19
+	for n > 4 && lengthLengths[codeLengthOrder[n-1]] == 0 {
+		n--
+	}
+
+	bw.writeBits(uint32(n-4), 4)
+	for _, s := range codeLengthOrder[:n] {
+		bw.writeBits(uint32(lengthLengths[s]), 3)
+	}
+
+	bw.writeBits(0, 1) // the lengths of all symbols are written
+
+	code := canonicalCode(lengthLengths)
+	for _, t := range tokens {
+		code.write(bw, t.symbol)
+		bw.writeBits(uint32(t.extra), uint(t.extraBits))
+	}
+}
+
+// codeLengths returns the lengths of a complete Huffman code for the histogram,
+// of at most max bits. At least two symbols are given a length, so no symbol is
+// coded with zero bits.
+func codeLengths(hist []int, max int) []int {
+	counts := make([]int, len(hist))
+	copy(counts, hist)
+
+	used := 0
+	for _, n := range counts {
+		if n > 0 {
+			used++
+		}
+	}
+
+	for s := 0; used < 2; s++ {
+		if counts[s] == 0 {
+			counts[s] = 1
+			used++
+		}
+	}
+
+	// flatten the histogram until the longest code is short enough
+	for minCount := 1; ; minCount *= 2 {
+		lengths := huffmanLengths(counts, minCount)
+
+		longest := 0
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+
+		if longest <= max {
+			return lengths
+		}
+	}
+}
+
+// huffmanLengths returns the code lengths of a Huffman code for the symbols
+// with counts, where each count is at least minCount
+func huffmanLengths(counts []int, minCount int) []int {
+	type node struct {
+		count       int
+		symbol      int
+		left, right *node
+	}
+
+	var nodes []*node
+	for s, n := range counts {
+		if n > 0 {
+			if n < minCount {
+				n = minCount
+			}
+
+			nodes = append(nodes, &node{count: n, symbol: s})
+		}
+	}
+
+	for len(nodes) > 1 {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].count < nodes[j].count
+		})
+
+		merged := &node{count: nodes[0].count + nodes[1].count, symbol: -1, left: nodes[0], right: nodes[1]}
+		nodes = append([]*node{merged}, nodes[2:]...)
+	}
+
+	lengths := make([]int, len(counts))
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if n.symbol >= 0 {
+			lengths[n.symbol] = depth
+			return
+		}
+
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(nodes[0], 0)
+
+	return lengths
+}
+
+// canonicalCode assigns codes to the symbols by their lengths, shorter codes
+// and lower symbols first
+func canonicalCode(lengths []int) prefixCode {
+	var count [vp8lMaxCodeLength + 1]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+
+	var next [vp8lMaxCodeLength + 2]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + uint32(count[l-1])) << 1
+		next[l] = code
+	}
+
+	c := prefixCode{lengths: lengths, codes: make([]uint32, len(lengths))}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+
+		c.codes[s] = reverseBits(next[l], uint(l))
+		next[l]++
+	}
+
+	return c
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func reverseBits(v uint32, n uint) uint32 {
+	var r uint32
+	for i := uint(0); i < n; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+
+	return r
+}
+
+// bitWriter writes bits from the least significant bit of each byte first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (bw *bitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nbits
+	bw.nbits += n
+	for bw.nbits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nbits -= 8
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.nbits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nbits = 0, 0
+	}
+
+	return bw.buf
+}
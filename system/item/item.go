@@ -127,6 +127,37 @@ type Omittable interface {
 	Omit(http.ResponseWriter, *http.Request) ([]string, error)
 }
 
+// Indexable lets a user declare fields of a content type which should be kept
+// in a secondary index, so that equality filters on those fields from the
+// content API don't need to scan the whole type bucket. All items in the slice
+// should be the json tag names of the struct fields to which they correspond.
+// Indexed values are matched exactly as they are stored.
+type Indexable interface {
+	IndexFields() []string
+}
+
+// Revisable lets a user limit how many revisions of each item of a content type
+// are kept in its history. A limit of 0 disables history for the type, and a
+// negative limit keeps every revision.
+type Revisable interface {
+	RevisionLimit() int
+}
+
+// Schedulable lets content be published and expired at a later time. Both times
+// are in milliseconds since the Unix epoch, and zero means the content is
+// published immediately, or never expires.
+type Schedulable interface {
+	PublishTime() int64
+	ExpireTime() int64
+}
+
+// Uploadable lets a user declare rules for the files uploaded to fields of a
+// content type, by the json tag names of the fields. The rules apply in
+// addition to the upload limits of the system configuration.
+type Uploadable interface {
+	UploadRules() map[string]UploadRule
+}
+
 // Item should only be embedded into content type structs.
 type Item struct {
 	UUID      uuid.UUID `json:"uuid"`
@@ -134,6 +165,8 @@ type Item struct {
 	Slug      string    `json:"slug"`
 	Timestamp int64     `json:"timestamp"`
 	Updated   int64     `json:"updated"`
+	PublishAt int64     `json:"publish_at,omitempty"`
+	ExpireAt  int64     `json:"expire_at,omitempty"`
 }
 
 // Time partially implements the Sortable interface
@@ -146,6 +179,16 @@ func (i Item) Touch() int64 {
 	return i.Updated
 }
 
+// PublishTime partially implements the Schedulable interface
+func (i Item) PublishTime() int64 {
+	return i.PublishAt
+}
+
+// ExpireTime partially implements the Schedulable interface
+func (i Item) ExpireTime() int64 {
+	return i.ExpireAt
+}
+
 // SetSlug sets the item's slug for its URL
 func (i *Item) SetSlug(slug string) {
 	i.Slug = slug
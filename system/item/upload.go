@@ -15,11 +15,31 @@ type FileUpload struct {
 	Path          string `json:"path"`
 	ContentLength int64  `json:"content_length"`
 	ContentType   string `json:"content_type"`
+	Width         int    `json:"width"`    // of images, in pixels
+	Height        int    `json:"height"`   // of images, in pixels
+	Blurhash      string `json:"blurhash"` // placeholder of images, see https://blurha.sh
+}
+
+// UploadRule limits the files uploaded to a field to a size in bytes, and to
+// media types such as "application/pdf" or "image/*". A zero MaxSize or empty
+// Types leave the field to the limits of the system configuration.
+type UploadRule struct {
+	MaxSize int64
+	Types   []string
 }
 
 // String partially implements item.Identifiable and overrides Item's String()
 func (f *FileUpload) String() string { return f.Name }
 
+// dimensions lists the width and height of images in the editor
+func (f *FileUpload) dimensions() string {
+	if f.Width == 0 || f.Height == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(`<li><span class="grey-text text-lighten-1">Dimensions:</span> %d &times; %d</li>`, f.Width, f.Height)
+}
+
 // MarshalEditor writes a buffer of html to edit a Post and partially implements editor.Editable
 func (f *FileUpload) MarshalEditor() ([]byte, error) {
 	view, err := editor.Form(f,
@@ -36,6 +56,7 @@ func (f *FileUpload) MarshalEditor() ([]byte, error) {
 				<ul>
 					<li><span class="grey-text text-lighten-1">Content-Length:</span> ` + fmt.Sprintf("%s", FmtBytes(float64(f.ContentLength))) + `</li>
 					<li><span class="grey-text text-lighten-1">Content-Type:</span> ` + f.ContentType + `</li>
+					` + f.dimensions() + `
 					<li><span class="grey-text text-lighten-1">Uploaded:</span> ` + FmtTime(f.Timestamp) + `</li>
 				</ul>
             </div>
@@ -9,9 +9,11 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/ponzu-cms/ponzu/system/backup"
 	"github.com/ponzu-cms/ponzu/system/cfg"
+	"github.com/ponzu-cms/ponzu/system/item"
 
-	"github.com/ponzu-cms/ponzu/system/backup"
+	"github.com/blevesearch/bleve"
 )
 
 // Backup creates an archive of a project's search index and writes it
@@ -28,7 +30,9 @@ func Backup(ctx context.Context, res http.ResponseWriter) error {
 		return err
 	}
 
+	indexMu.Lock()
 	err = backup.ArchiveFS(ctx, cfg.SearchDir(), f)
+	indexMu.Unlock()
 	if err != nil {
 		return err
 	}
@@ -60,3 +64,67 @@ func Backup(ctx context.Context, res http.ResponseWriter) error {
 
 	return err
 }
+
+// Archive writes an archive of the search indices to w, with paths relative to
+// the search directory. Changes to the indices wait until it is written, so the
+// archive is consistent.
+func Archive(ctx context.Context, w io.Writer) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	return backup.ArchiveDir(ctx, cfg.SearchDir(), w)
+}
+
+// Restore replaces the search indices by those in dir, which is moved to the
+// search directory. The indices are closed while they are replaced, and changes
+// to them wait until they are reopened. It returns the types whose indices
+// weren't in dir, which are empty and need to be rebuilt.
+func Restore(dir string) ([]string, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	for t, idx := range Search {
+		err := idx.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		delete(Search, t)
+	}
+
+	commit, rollback, err := backup.Replace(dir, cfg.SearchDir())
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for t, it := range item.Types {
+		if s, ok := it().(Searchable); !ok || !s.IndexContent() {
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(cfg.SearchDir(), t+".index")); os.IsNotExist(err) {
+			missing = append(missing, t)
+		}
+
+		err = MapIndex(t)
+		if err != nil {
+			for _, idx := range Search {
+				idx.Close()
+			}
+			Search = make(map[string]bleve.Index)
+
+			if rerr := rollback(); rerr != nil {
+				return nil, fmt.Errorf("%v, and the previous indices couldn't be put back: %v", err, rerr)
+			}
+
+			for t := range item.Types {
+				MapIndex(t)
+			}
+
+			return nil, err
+		}
+	}
+
+	return missing, commit()
+}
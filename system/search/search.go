@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/ponzu-cms/ponzu/system/cfg"
 
@@ -25,6 +26,9 @@ var (
 
 	// ErrNoIndex is for failed checks for an index in Search map
 	ErrNoIndex = errors.New("No search index found for type provided")
+
+	// indexMu stops changes to the indices while they are archived or restored
+	indexMu = &sync.RWMutex{}
 )
 
 // Searchable ...
@@ -98,6 +102,9 @@ func UpdateIndex(id string, data interface{}) error {
 	target := strings.Split(id, ":")
 	ns := target[0]
 
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+
 	idx, ok := Search[ns]
 	if ok {
 		// unmarshal json to struct, error if not registered
@@ -126,6 +133,9 @@ func DeleteIndex(id string) error {
 	target := strings.Split(id, ":")
 	ns := target[0]
 
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+
 	idx, ok := Search[ns]
 	if ok {
 		// add data to search index
@@ -139,6 +149,9 @@ func DeleteIndex(id string) error {
 // and an error. If there is no search index for the typeName (Type) provided,
 // db.ErrNoIndex will be returned as the error
 func TypeQuery(typeName, query string, count, offset int) ([]string, error) {
+	indexMu.RLock()
+	defer indexMu.RUnlock()
+
 	idx, ok := Search[typeName]
 	if !ok {
 		return nil, ErrNoIndex
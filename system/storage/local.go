@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on disk
+type Local struct {
+	Dir string
+}
+
+func (l *Local) path(name string) (string, error) {
+	if !validName(name) {
+		return "", ErrNotExist
+	}
+
+	return filepath.Join(l.Dir, filepath.FromSlash(name)), nil
+}
+
+func (l *Local) object(name string, info os.FileInfo) *Object {
+	return &Object{
+		Name:        name,
+		Size:        info.Size(),
+		Modified:    info.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+	}
+}
+
+// Put writes the file through a temporary file, so it is never partly written
+func (l *Local) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	p, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), os.ModeDir|os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	// temporary files are only readable by their owner
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// Open opens the file on disk
+func (l *Local) Open(ctx context.Context, name string) (File, *Object, error) {
+	p, err := l.path(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	if info.IsDir() {
+		f.Close()
+		return nil, nil, ErrNotExist
+	}
+
+	return f, l.object(name, info), nil
+}
+
+// Stat describes the file on disk
+func (l *Local) Stat(ctx context.Context, name string) (*Object, error) {
+	p, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return l.object(name, info), nil
+}
+
+// Delete removes the file from disk
+func (l *Local) Delete(ctx context.Context, name string) error {
+	p, err := l.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Walk walks the upload directory. Temporary files which are being written by
+// Put are skipped.
+func (l *Local) Walk(ctx context.Context, fn func(*Object) error) error {
+	err := filepath.Walk(l.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// there is nothing to walk before the first upload
+			if os.IsNotExist(err) && p == l.Dir {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+
+		return fn(l.object(filepath.ToSlash(rel), info))
+	})
+
+	return err
+}
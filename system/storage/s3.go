@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the checksum of an empty request body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 stores files in a bucket of an S3-compatible object store, such as AWS S3
+// or MinIO. Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	Endpoint  string // i.e. "http://localhost:9000", AWS for the Region if empty
+	Region    string // "us-east-1" if empty
+	Bucket    string
+	Prefix    string // prepended to the names of files to get their keys
+	PathStyle bool   // put the bucket in the path rather than the host name
+	AccessKey string
+	SecretKey string
+	Client    *http.Client // http.DefaultClient if nil
+}
+
+// s3Error is the body of an error response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// listBucketResult is the body of a ListObjectsV2 response
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+
+	return s.Region
+}
+
+// url returns the URL of the object with key, or of the bucket if it is empty
+func (s *S3) url(key string, query url.Values) (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s.region())
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if s.PathStyle {
+		u.Path += "/" + s.Bucket
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+
+	u.Path += "/" + key
+	u.RawPath = u.Path[:len(u.Path)-len(key)] + encodePath(key)
+	if u.RawPath == u.Path {
+		u.RawPath = ""
+	}
+
+	u.RawQuery = encodeQuery(query)
+	return u, nil
+}
+
+// do sends a signed request for the object with key, or the bucket if it is
+// empty, and returns the response if its status is 2xx
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u, err := s.url(key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	payload := emptySHA256
+	if body != nil {
+		req.ContentLength = size
+		payload = "UNSIGNED-PAYLOAD"
+	}
+
+	s.sign(req, payload, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+
+	var e s3Error
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if xml.Unmarshal(b, &e) != nil || e.Code == "" {
+		return nil, fmt.Errorf("S3 %s %s: %s", method, key, res.Status)
+	}
+
+	return nil, fmt.Errorf("S3 %s %s: %s: %s", method, key, e.Code, e.Message)
+}
+
+// sign adds the AWS Signature Version 4 authorization headers to the request
+func (s *S3) sign(req *http.Request, payload string, now time.Time) {
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payload + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		strings.Join(signed, ";"),
+		payload,
+	}, "\n")
+
+	scope := date + "/" + s.region() + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	for _, part := range []string{s.region(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, strings.Join(signed, ";"), signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// encodePath encodes each segment of a slash-separated path as S3 expects
+func encodePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		parts[i] = encode(parts[i])
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// encodeQuery encodes the query sorted by key, as signed requests must be
+func encodeQuery(query url.Values) string {
+	var keys []string
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, encode(k)+"="+encode(v))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// encode percent-encodes everything but the unreserved characters of RFC 3986
+func encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
+
+func (s *S3) key(name string) (string, error) {
+	if !validName(name) {
+		return "", ErrNotExist
+	}
+
+	return s.Prefix + name, nil
+}
+
+func (s *S3) object(name string, header http.Header) *Object {
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	modified, _ := http.ParseTime(header.Get("Last-Modified"))
+
+	return &Object{
+		Name:        name,
+		Size:        size,
+		Modified:    modified,
+		ContentType: header.Get("Content-Type"),
+		ETag:        header.Get("ETag"),
+	}
+}
+
+// Put uploads the file. Files of unknown size are written to a temporary file
+// first, as the size of the request must be known.
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error {
+	key, err := s.key(name)
+	if err != nil {
+		return err
+	}
+
+	if size < 0 {
+		tmp, err := ioutil.TempFile("", "ponzu-upload-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		size, err = io.Copy(tmp, r)
+		if err != nil {
+			return err
+		}
+
+		_, err = tmp.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		r = tmp
+	}
+
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(ctx, http.MethodPut, key, nil, header, ioutil.NopCloser(r), size)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Open describes the object, and returns a File which downloads the object from
+// the offset it is read at
+func (s *S3) Open(ctx context.Context, name string) (File, *Object, error) {
+	obj, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &s3File{ctx: ctx, s: s, obj: obj}, obj, nil
+}
+
+// Stat describes the object
+func (s *S3) Stat(ctx context.Context, name string) (*Object, error) {
+	key, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	return s.object(name, res.Header), nil
+}
+
+// Delete deletes the object
+func (s *S3) Delete(ctx context.Context, name string) error {
+	key, err := s.key(name)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if err == ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// Walk lists the objects with the Prefix
+func (s *S3) Walk(ctx context.Context, fn func(*Object) error) error {
+	var token string
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {s.Prefix},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+
+		var list listBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&list)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, c := range list.Contents {
+			name := strings.TrimPrefix(c.Key, s.Prefix)
+			if !validName(name) {
+				continue
+			}
+
+			err = fn(&Object{
+				Name:     name,
+				Size:     c.Size,
+				Modified: c.LastModified,
+				ETag:     c.ETag,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+// s3File reads an object with a ranged request from the offset it is read at,
+// so http.ServeContent can seek in it without downloading all of it
+type s3File struct {
+	ctx    context.Context
+	s      *S3
+	obj    *Object
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.obj.Size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		key, err := f.s.key(f.obj.Name)
+		if err != nil {
+			return 0, err
+		}
+
+		header := http.Header{}
+		header.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+
+		res, err := f.s.do(f.ctx, http.MethodGet, key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		f.body = res.Body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.obj.Size
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("S3 seek to negative offset %d", offset)
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+
+	return f.body.Close()
+}
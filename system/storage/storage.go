@@ -0,0 +1,225 @@
+// Package storage provides the backends which uploaded files are stored in: the
+// local upload directory, or an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ponzu-cms/ponzu/system/cfg"
+)
+
+// ErrNotExist is returned for files which aren't stored
+var ErrNotExist = errors.New("storage: file does not exist")
+
+// Object describes a stored file, by its slash-separated name such as
+// "2024/05/photo.jpg"
+type Object struct {
+	Name        string
+	Size        int64
+	Modified    time.Time
+	ContentType string
+	ETag        string
+}
+
+// File is the contents of a stored file
+type File interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+}
+
+// Storage stores uploaded files by name
+type Storage interface {
+	// Put stores the size bytes read from r as the file name, replacing it if
+	// it exists. The size is -1 if it isn't known.
+	Put(ctx context.Context, name string, r io.Reader, size int64, contentType string) error
+
+	// Open returns the contents of the file and its description
+	Open(ctx context.Context, name string) (File, *Object, error)
+
+	// Stat returns the description of the file
+	Stat(ctx context.Context, name string) (*Object, error)
+
+	// Delete removes the file. It isn't an error if it doesn't exist.
+	Delete(ctx context.Context, name string) error
+
+	// Walk calls fn for every stored file, stopping at the first error
+	Walk(ctx context.Context, fn func(*Object) error) error
+}
+
+var (
+	uploads   Storage
+	uploadsMu = &sync.Mutex{}
+)
+
+// Uploads returns the storage for uploaded files, which is set up from the
+// environment when it is first used, unless it was set by SetUploads
+func Uploads() Storage {
+	uploadsMu.Lock()
+	defer uploadsMu.Unlock()
+
+	if uploads == nil {
+		s, err := New(os.Getenv("PONZU_UPLOAD_STORAGE"))
+		if err != nil {
+			log.Fatalln("Failed to set up upload storage:", err)
+		}
+
+		uploads = s
+	}
+
+	return uploads
+}
+
+// SetUploads sets the storage for uploaded files, to use a custom Storage
+func SetUploads(s Storage) {
+	uploadsMu.Lock()
+	uploads = s
+	uploadsMu.Unlock()
+}
+
+// New returns the storage of the kind, "local" (or "") for the upload directory
+// or "s3" for an object store, configured by the environment:
+//
+//	PONZU_S3_BUCKET                 bucket to store uploads in (required)
+//	PONZU_S3_REGION                 region of the bucket, "us-east-1" by default
+//	PONZU_S3_ENDPOINT               URL of the S3-compatible server, AWS by default
+//	PONZU_S3_PREFIX                 prefix of the uploads' keys, i.e. "uploads/"
+//	PONZU_S3_PATH_STYLE             "true" to put the bucket in the URL's path
+//	PONZU_S3_ACCESS_KEY_ID          or AWS_ACCESS_KEY_ID
+//	PONZU_S3_SECRET_ACCESS_KEY      or AWS_SECRET_ACCESS_KEY
+func New(kind string) (Storage, error) {
+	switch kind {
+	case "", "local":
+		return &Local{Dir: cfg.UploadDir()}, nil
+
+	case "s3":
+		s := &S3{
+			Endpoint:  os.Getenv("PONZU_S3_ENDPOINT"),
+			Region:    os.Getenv("PONZU_S3_REGION"),
+			Bucket:    os.Getenv("PONZU_S3_BUCKET"),
+			Prefix:    os.Getenv("PONZU_S3_PREFIX"),
+			PathStyle: os.Getenv("PONZU_S3_PATH_STYLE") == "true",
+			AccessKey: env("PONZU_S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+			SecretKey: env("PONZU_S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+		}
+
+		if s.Bucket == "" {
+			return nil, errors.New("PONZU_S3_BUCKET must be set to store uploads in S3")
+		}
+
+		if s.AccessKey == "" || s.SecretKey == "" {
+			return nil, errors.New("PONZU_S3_ACCESS_KEY_ID and PONZU_S3_SECRET_ACCESS_KEY must be set to store uploads in S3")
+		}
+
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unknown upload storage %q, expected local or s3", kind)
+}
+
+// env returns the value of the first of the environment variables which is set
+func env(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// validName reports whether name is a relative, slash-separated path to a file,
+// which doesn't leave the storage
+func validName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return false
+	}
+
+	clean := path.Clean(name)
+	return clean == name && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// FileServer returns a handler which serves the stored files by the path of the
+// request URL, supporting range and conditional requests
+func FileServer(s Storage) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			res.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		if !validName(name) {
+			http.NotFound(res, req)
+			return
+		}
+
+		f, obj, err := s.Open(req.Context(), name)
+		if err == ErrNotExist {
+			http.NotFound(res, req)
+			return
+		}
+		if err != nil {
+			log.Println("Error opening upload:", name, err)
+			res.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		if obj.ContentType != "" {
+			res.Header().Set("Content-Type", obj.ContentType)
+		}
+
+		if obj.ETag != "" && res.Header().Get("ETag") == "" {
+			res.Header().Set("ETag", obj.ETag)
+		}
+
+		http.ServeContent(res, req, path.Base(name), obj.Modified, f)
+	})
+}
+
+// Copy copies the files in src to dst, skipping files which dst has with the
+// same size. It calls fn, if not nil, with each file and whether it was copied.
+func Copy(ctx context.Context, dst, src Storage, fn func(obj *Object, copied bool)) error {
+	return src.Walk(ctx, func(obj *Object) error {
+		existing, err := dst.Stat(ctx, obj.Name)
+		if err != nil && err != ErrNotExist {
+			return err
+		}
+
+		if err == nil && existing.Size == obj.Size {
+			if fn != nil {
+				fn(obj, false)
+			}
+
+			return nil
+		}
+
+		f, _, err := src.Open(ctx, obj.Name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = dst.Put(ctx, obj.Name, f, obj.Size, obj.ContentType)
+		if err != nil {
+			return fmt.Errorf("%s: %v", obj.Name, err)
+		}
+
+		if fn != nil {
+			fn(obj, true)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,356 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is an in-memory S3 server for a single bucket, which checks the
+// signatures of requests and lists objects two at a time
+type fakeS3 struct {
+	t       *testing.T
+	s       *S3
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(t *testing.T) (*S3, func()) {
+	f := &fakeS3{t: t, objects: map[string][]byte{}, types: map[string]string{}}
+	srv := httptest.NewServer(f)
+
+	f.s = &S3{
+		Endpoint:  srv.URL,
+		Bucket:    "uploads",
+		Prefix:    "site/",
+		PathStyle: true,
+		AccessKey: "key",
+		SecretKey: "secret",
+	}
+
+	return f.s, srv.Close
+}
+
+func (f *fakeS3) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	now, err := time.Parse("20060102T150405Z", req.Header.Get("X-Amz-Date"))
+	if err != nil {
+		f.t.Errorf("%s %s: bad X-Amz-Date: %v", req.Method, req.URL, err)
+	}
+
+	signed := &http.Request{
+		Method: req.Method,
+		URL: &url.URL{
+			Host:     req.Host,
+			Path:     req.URL.Path,
+			RawPath:  req.URL.RawPath,
+			RawQuery: req.URL.RawQuery,
+		},
+		Header: http.Header{},
+	}
+	f.s.sign(signed, req.Header.Get("X-Amz-Content-Sha256"), now)
+	if signed.Header.Get("Authorization") != req.Header.Get("Authorization") {
+		res.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(res, "<Error><Code>SignatureDoesNotMatch</Code><Message>bad signature</Message></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(req.URL.Path, "/uploads/")
+	if req.URL.Path == "/uploads/" {
+		f.list(res, req.URL.Query())
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(req.Body)
+		if int64(len(b)) != req.ContentLength {
+			f.t.Errorf("PUT %s: read %d bytes, expected %d", key, len(b), req.ContentLength)
+		}
+		f.objects[key] = b
+		f.types[key] = req.Header.Get("Content-Type")
+
+	case http.MethodDelete:
+		delete(f.objects, key)
+		res.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		res.Header().Set("Content-Type", f.types[key])
+		res.Header().Set("ETag", fmt.Sprintf(`"%x"`, len(b)))
+		res.Header().Set("Last-Modified", time.Unix(0, 0).UTC().Format(http.TimeFormat))
+
+		if r := req.Header.Get("Range"); r != "" {
+			start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r, "bytes="), "-"))
+			b = b[start:]
+			res.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+len(b)-1, start+len(b)))
+			res.Header().Set("Content-Length", strconv.Itoa(len(b)))
+			res.WriteHeader(http.StatusPartialContent)
+		} else {
+			res.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		}
+
+		if req.Method == http.MethodGet {
+			res.Write(b)
+		}
+	}
+}
+
+func (f *fakeS3) list(res http.ResponseWriter, query url.Values) {
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, query.Get("prefix")) && k > query.Get("continuation-token") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var list listBucketResult
+	if len(keys) > 2 {
+		list.IsTruncated = true
+		list.NextContinuationToken = keys[1]
+		keys = keys[:2]
+	}
+
+	for _, k := range keys {
+		list.Contents = append(list.Contents, struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			ETag         string    `xml:"ETag"`
+			Size         int64     `xml:"Size"`
+		}{Key: k, Size: int64(len(f.objects[k]))})
+	}
+
+	xml.NewEncoder(res).Encode(list)
+}
+
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	files := map[string]string{
+		"2024/05/photo one.jpg": "jpeg",
+		"2024/05/notes.txt":     "some notes",
+		"2024/06/a+b.txt":       "a plus b",
+	}
+
+	for name, content := range files {
+		err := s.Put(ctx, name, strings.NewReader(content), int64(len(content)), "text/plain")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+	}
+
+	// files of unknown size are stored too
+	err := s.Put(ctx, "2024/06/unknown.txt", strings.NewReader("unknown"), -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["2024/06/unknown.txt"] = "unknown"
+
+	for _, name := range []string{"../escape.txt", "/abs.txt", "2024/./x", ""} {
+		err = s.Put(ctx, name, strings.NewReader("x"), 1, "")
+		if err != ErrNotExist {
+			t.Errorf("Put(%q): expected ErrNotExist, got %v", name, err)
+		}
+	}
+
+	walked := map[string]int64{}
+	err = s.Walk(ctx, func(obj *Object) error {
+		walked[obj.Name] = obj.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(walked) != len(files) {
+		t.Errorf("walked %v, expected %d files", walked, len(files))
+	}
+
+	for name, content := range files {
+		if walked[name] != int64(len(content)) {
+			t.Errorf("walked %s with size %d, expected %d", name, walked[name], len(content))
+		}
+
+		f, obj, err := s.Open(ctx, name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		// read the end of the file, then all of it
+		_, err = f.Seek(-2, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		end, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.Seek(0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		all, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		if string(all) != content || string(end) != content[len(content)-2:] || obj.Size != int64(len(content)) {
+			t.Errorf("read %s as %q, ending %q, with size %d", name, all, end, obj.Size)
+		}
+	}
+
+	err = s.Delete(ctx, "2024/05/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deleting a file which doesn't exist isn't an error
+	err = s.Delete(ctx, "2024/05/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Stat(ctx, "2024/05/notes.txt")
+	if err != ErrNotExist {
+		t.Errorf("Stat of deleted file: expected ErrNotExist, got %v", err)
+	}
+
+	_, _, err = s.Open(ctx, "2024/05/notes.txt")
+	if err != ErrNotExist {
+		t.Errorf("Open of deleted file: expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ponzu-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testStorage(t, &Local{Dir: dir + "/uploads"})
+}
+
+func TestS3(t *testing.T) {
+	s, stop := newFakeS3(t)
+	defer stop()
+
+	testStorage(t, s)
+
+	wrong := *s
+	wrong.SecretKey = "wrong"
+	_, err := wrong.Stat(context.Background(), "2024/05/photo one.jpg")
+	if err == nil {
+		t.Error("expected an error with the wrong secret key")
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	s, stop := newFakeS3(t)
+	defer stop()
+
+	ctx := context.Background()
+	err := s.Put(ctx, "2024/05/photo.jpg", strings.NewReader("0123456789"), 10, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.StripPrefix("/api/uploads/", FileServer(s)))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/uploads/2024/05/photo.jpg", nil)
+	req.Header.Set("Range", "bytes=2-5")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent || string(b) != "2345" || res.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("got %s %q of type %s", res.Status, b, res.Header.Get("Content-Type"))
+	}
+
+	for _, p := range []string{"2024/05/missing.jpg", "2024/../2024/05/photo.jpg"} {
+		res, err = http.Get(srv.URL + "/api/uploads/" + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %s", p, res.Status)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ponzu-storage-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	local := &Local{Dir: dir}
+	for _, name := range []string{"2024/05/a.txt", "2024/05/b.txt", "2024/06/c.txt"} {
+		err = local.Put(ctx, name, strings.NewReader(name), -1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, stop := newFakeS3(t)
+	defer stop()
+
+	err = s.Put(ctx, "2024/05/a.txt", strings.NewReader("2024/05/a.txt"), 13, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var copied []string
+	err = Copy(ctx, s, local, func(obj *Object, ok bool) {
+		if ok {
+			copied = append(copied, obj.Name)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(copied, ",") != "2024/05/b.txt,2024/06/c.txt" {
+		t.Errorf("copied %v, expected the files which weren't stored", copied)
+	}
+
+	f, _, err := s.Open(ctx, "2024/06/c.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	b, _ := ioutil.ReadAll(f)
+	if !bytes.Equal(b, []byte("2024/06/c.txt")) {
+		t.Errorf("copied %q", b)
+	}
+}